@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Annotation modes for -annotations.
+const (
+	AnnotationsAuto      = "auto"
+	AnnotationsGitHub    = "github"
+	AnnotationsAzure     = "azure"
+	AnnotationsTeamCity  = "teamcity"
+	AnnotationsBuildkite = "buildkite"
+	AnnotationsNone      = "none"
+)
+
+// maxAnnotationsPerType mirrors the GitHub Actions limit on annotations of
+// each type shown per step; anything beyond it would be dropped silently.
+const maxAnnotationsPerType = 10
+
+// Annotator reports a run through a CI system's own facilities: an inline
+// issue for each annotation, where the system has them, and a summary of the
+// run. Everything meant for the build log is written to w.
+type Annotator interface {
+	Annotate(w io.Writer, r *Report, anns []Annotation) error
+}
+
+// Annotation is a finding with a location that exists in the checkout.
+type Annotation struct {
+	Finding  Finding
+	Location Location
+}
+
+// NewAnnotator resolves -annotations, detecting the CI system from its
+// well-known environment variables in auto mode. It returns nil when
+// annotations are off.
+func NewAnnotator(mode string) (Annotator, error) {
+	if mode == AnnotationsAuto || mode == "" {
+		mode = detectCI()
+	}
+	switch mode {
+	case AnnotationsGitHub:
+		return &GitHubAnnotator{SummaryPath: os.Getenv("GITHUB_STEP_SUMMARY")}, nil
+	case AnnotationsAzure:
+		dir := os.Getenv("AGENT_TEMPDIRECTORY")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		return &AzureAnnotator{TempDir: dir}, nil
+	case AnnotationsTeamCity:
+		return &TeamCityAnnotator{}, nil
+	case AnnotationsBuildkite:
+		return &BuildkiteAnnotator{Run: runBuildkiteAgent}, nil
+	case AnnotationsNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown annotations mode '%s', expected auto, github, azure, teamcity, buildkite or none", mode)
+}
+
+func detectCI() string {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return AnnotationsGitHub
+	case strings.EqualFold(os.Getenv("TF_BUILD"), "true"):
+		return AnnotationsAzure
+	case os.Getenv("TEAMCITY_VERSION") != "":
+		return AnnotationsTeamCity
+	case os.Getenv("BUILDKITE") == "true":
+		return AnnotationsBuildkite
+	}
+	return AnnotationsNone
+}
+
+// Annotations returns the unsuppressed findings of r that have a location
+// under root, so annotations never point at files Greptile made up.
+func Annotations(root string, r *Report) []Annotation {
+	var anns []Annotation
+	for _, f := range r.Findings {
+		if !f.HasResult() || f.Suppressed {
+			continue
+		}
+		if loc, ok := verifiedLocation(root, f); ok {
+			anns = append(anns, Annotation{Finding: f, Location: loc})
+		}
+	}
+	return anns
+}
+
+// verifiedLocation returns the first location of f that exists under root.
+func verifiedLocation(root string, f Finding) (Location, bool) {
+	for _, loc := range f.Locations {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(loc.Path)))
+		if err == nil && !info.IsDir() {
+			return loc, true
+		}
+	}
+	return Location{}, false
+}
+
+// annotationLevel is the GitHub level of a severity; the other adapters map
+// it onto their own.
+func annotationLevel(s Severity) string {
+	switch s {
+	case SeverityCritical, SeverityHigh:
+		return "error"
+	case SeverityMedium, SeverityLow:
+		return "warning"
+	}
+	return "notice"
+}
+
+func annotationTitle(f Finding) string {
+	return fmt.Sprintf("%s: %s", f.Audit, f.Prompt)
+}
+
+// writeMarkdownSummary writes the run summary shared by the adapters that
+// accept Markdown. notes are appended as paragraphs.
+func writeMarkdownSummary(w io.Writer, r *Report, notes []string) {
+	fmt.Fprintf(w, "## treeko audit of %s\n\n", r.Metadata.Codebase)
+	fmt.Fprintf(w, "%d prompts, %d results, %d errors.\n\n", r.Summary.Prompts, r.Summary.Results, r.Summary.Errors)
+	counts := make(map[Severity]int)
+	for _, fd := range r.Findings {
+		if fd.HasResult() && !fd.Suppressed {
+			counts[fd.Severity]++
+		}
+	}
+	fmt.Fprintln(w, "| Severity | Findings |")
+	fmt.Fprintln(w, "|----------|----------|")
+	for _, s := range Severities {
+		fmt.Fprintf(w, "| %s | %d |\n", s, counts[s])
+	}
+	if r.Summary.Suppressed > 0 {
+		fmt.Fprintf(w, "\n%d findings are suppressed by %s.\n", r.Summary.Suppressed, IgnoreFileName)
+	}
+	for _, note := range notes {
+		fmt.Fprintf(w, "\n%s\n", note)
+	}
+	if r.Policy != nil {
+		status := "passed"
+		if !r.Policy.Passed {
+			status = "failed"
+		}
+		fmt.Fprintf(w, "\nPolicy %s.\n", status)
+	}
+	fmt.Fprintln(w)
+}
+
+// GitHubAnnotator prints workflow commands, up to the per-type limit, and
+// appends the summary to SummaryPath when it is set.
+type GitHubAnnotator struct {
+	SummaryPath string
+}
+
+func (a *GitHubAnnotator) Annotate(w io.Writer, r *Report, anns []Annotation) error {
+	written := make(map[string]int)
+	dropped := make(map[string]int)
+	for _, ann := range anns {
+		level := annotationLevel(ann.Finding.Severity)
+		if written[level] == maxAnnotationsPerType {
+			dropped[level]++
+			continue
+		}
+		written[level]++
+		props := "file=" + githubEscapeProperty(ann.Location.Path)
+		if ann.Location.Line > 0 {
+			props += fmt.Sprintf(",line=%d", ann.Location.Line)
+		}
+		props += ",title=" + githubEscapeProperty(annotationTitle(ann.Finding))
+		fmt.Fprintf(w, "::%s %s::%s\n", level, props, githubEscapeData(resultWithNotes(ann.Finding)))
+	}
+	if a.SummaryPath == "" {
+		return nil
+	}
+	var notes []string
+	for _, level := range []string{"error", "warning", "notice"} {
+		if n := dropped[level]; n > 0 {
+			notes = append(notes, fmt.Sprintf("%d more %s annotations were not shown (GitHub shows at most %d per type); see the full report.", n, level, maxAnnotationsPerType))
+		}
+	}
+	f, err := os.OpenFile(a.SummaryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	writeMarkdownSummary(f, r, notes)
+	return f.Close()
+}
+
+func githubEscapeData(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	return strings.ReplaceAll(s, "\n", "%0A")
+}
+
+func githubEscapeProperty(s string) string {
+	s = githubEscapeData(s)
+	s = strings.ReplaceAll(s, ":", "%3A")
+	return strings.ReplaceAll(s, ",", "%2C")
+}
+
+// AzureAnnotator prints Azure Pipelines logging commands. The summary is
+// written as Markdown to a file in TempDir and attached to the build with
+// task.uploadsummary.
+type AzureAnnotator struct {
+	TempDir string
+}
+
+func (a *AzureAnnotator) Annotate(w io.Writer, r *Report, anns []Annotation) error {
+	for _, ann := range anns {
+		// logissue only knows errors and warnings.
+		level := "warning"
+		if annotationLevel(ann.Finding.Severity) == "error" {
+			level = "error"
+		}
+		props := fmt.Sprintf("type=%s;sourcepath=%s;", level, azureEscapeProperty(ann.Location.Path))
+		if ann.Location.Line > 0 {
+			props += fmt.Sprintf("linenumber=%d;", ann.Location.Line)
+		}
+		fmt.Fprintf(w, "##vso[task.logissue %s]%s\n", props,
+			azureEscapeData(annotationTitle(ann.Finding)+": "+resultWithNotes(ann.Finding)))
+	}
+	var buf bytes.Buffer
+	writeMarkdownSummary(&buf, r, nil)
+	path := filepath.Join(a.TempDir, "treeko-summary-"+r.Metadata.RunID+".md")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "##vso[task.uploadsummary]%s\n", path)
+	return nil
+}
+
+func azureEscapeData(s string) string {
+	s = strings.ReplaceAll(s, "%", "%AZP25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	return strings.ReplaceAll(s, "\n", "%0A")
+}
+
+func azureEscapeProperty(s string) string {
+	s = azureEscapeData(s)
+	s = strings.ReplaceAll(s, ";", "%3B")
+	return strings.ReplaceAll(s, "]", "%5D")
+}
+
+// TeamCityAnnotator prints service messages: an inspection for each
+// annotation, so findings appear on the build's Inspections tab, and build
+// statistics plus a status text for the summary.
+type TeamCityAnnotator struct{}
+
+func (a *TeamCityAnnotator) Annotate(w io.Writer, r *Report, anns []Annotation) error {
+	declared := make(map[string]bool)
+	for _, ann := range anns {
+		f := ann.Finding
+		typeID := f.AuditID + "." + f.PromptID
+		if !declared[typeID] {
+			declared[typeID] = true
+			fmt.Fprintf(w, "##teamcity[inspectionType id='%s' name='%s' category='treeko: %s' description='%s']\n",
+				teamCityEscape(typeID), teamCityEscape(annotationTitle(f)), teamCityEscape(f.Audit), teamCityEscape(f.Prompt))
+		}
+		attrs := fmt.Sprintf("typeId='%s' message='%s' file='%s'", teamCityEscape(typeID), teamCityEscape(resultWithNotes(f)), teamCityEscape(ann.Location.Path))
+		if ann.Location.Line > 0 {
+			attrs += fmt.Sprintf(" line='%d'", ann.Location.Line)
+		}
+		fmt.Fprintf(w, "##teamcity[inspection %s SEVERITY='%s']\n", attrs, teamCitySeverity(f.Severity))
+	}
+	counts := make(map[Severity]int)
+	total := 0
+	for _, f := range r.Findings {
+		if f.HasResult() && !f.Suppressed {
+			counts[f.Severity]++
+			total++
+		}
+	}
+	for _, s := range Severities {
+		fmt.Fprintf(w, "##teamcity[buildStatisticValue key='treeko.findings.%s' value='%d']\n", s, counts[s])
+	}
+	fmt.Fprintf(w, "##teamcity[buildStatisticValue key='treeko.errors' value='%d']\n", r.Summary.Errors)
+	status := fmt.Sprintf("treeko: %d findings, %d errors", total, r.Summary.Errors)
+	if r.Policy != nil && !r.Policy.Passed {
+		status += ", policy failed"
+	}
+	fmt.Fprintf(w, "##teamcity[buildStatus text='{build.status.text}; %s']\n", teamCityEscape(status))
+	return nil
+}
+
+func teamCitySeverity(s Severity) string {
+	switch annotationLevel(s) {
+	case "error":
+		return "ERROR"
+	case "warning":
+		return "WARNING"
+	}
+	return "INFO"
+}
+
+var teamCityReplacer = strings.NewReplacer(
+	"|", "||", "'", "|'", "\n", "|n", "\r", "|r", "[", "|[", "]", "|]")
+
+func teamCityEscape(s string) string {
+	return teamCityReplacer.Replace(s)
+}
+
+// BuildkiteAnnotator creates a build annotation with the summary and the
+// located findings; Buildkite has no inline issues. Run is called with the
+// annotation's style and Markdown body.
+type BuildkiteAnnotator struct {
+	Run func(style string, body []byte) error
+}
+
+func (a *BuildkiteAnnotator) Annotate(w io.Writer, r *Report, anns []Annotation) error {
+	var buf bytes.Buffer
+	writeMarkdownSummary(&buf, r, nil)
+	style := "success"
+	if len(anns) > 0 {
+		style = "info"
+		fmt.Fprintln(&buf, "| Severity | Location | Finding |")
+		fmt.Fprintln(&buf, "|----------|----------|---------|")
+	}
+	for _, ann := range anns {
+		f := ann.Finding
+		switch annotationLevel(f.Severity) {
+		case "error":
+			style = "error"
+		case "warning":
+			if style != "error" {
+				style = "warning"
+			}
+		}
+		loc := ann.Location.Path
+		if ann.Location.Line > 0 {
+			loc += fmt.Sprintf(":%d", ann.Location.Line)
+		}
+		fmt.Fprintf(&buf, "| %s | `%s` | %s |\n", f.Severity, loc,
+			markdownCell(annotationTitle(f)+": "+resultWithNotes(f)))
+	}
+	return a.Run(style, buf.Bytes())
+}
+
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+// runBuildkiteAgent replaces the run's annotation through the agent, which
+// is on the PATH of every Buildkite job.
+func runBuildkiteAgent(style string, body []byte) error {
+	cmd := exec.Command("buildkite-agent", "annotate", "--style", style, "--context", "treeko")
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("buildkite-agent annotate: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// annotatedFixture is the fixture report with a note on its first finding,
+// and the annotations of a checkout holding the files its findings point
+// at, bar legacy/db/report.go.
+func annotatedFixture(t *testing.T) (*Report, []Annotation) {
+	t.Helper()
+	r := loadFixtureReport(t, fixtureReport)
+	r.Findings[0].Notes = []string{"Tracked in SEC-7."}
+	root := t.TempDir()
+	for _, path := range []string{"web/login.py", "internal/db/query.go", "legacy/config/prod.env", "tools/fetch.go"} {
+		file := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r, Annotations(root, r)
+}
+
+const fixtureSummary = `## treeko audit of acme/payments
+
+6 prompts, 5 results, 1 errors.
+
+| Severity | Findings |
+|----------|----------|
+| critical | 2 |
+| high | 1 |
+| medium | 1 |
+| low | 0 |
+| info | 0 |
+
+1 findings are suppressed by .treekoignore.
+
+`
+
+func TestAnnotations(t *testing.T) {
+	_, anns := annotatedFixture(t)
+	// The LLM finding has no location, the TLS one is suppressed and the
+	// failed prompt has no result; the SQL finding's first location is
+	// used.
+	want := []string{"auth-hash web/login.py:42", "sql-concat internal/db/query.go:17", "aws-access-key legacy/config/prod.env:12"}
+	var got []string
+	for _, ann := range anns {
+		got = append(got, fmt.Sprintf("%s %s:%d", ann.Finding.PromptID, ann.Location.Path, ann.Location.Line))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("annotations = %q, want %q", got, want)
+	}
+
+	r, _ := annotatedFixture(t)
+	if anns := Annotations(t.TempDir(), r); len(anns) != 0 {
+		t.Errorf("an empty checkout has %d annotations, want none", len(anns))
+	}
+}
+
+func TestGitHubAnnotator(t *testing.T) {
+	r, anns := annotatedFixture(t)
+	summary := filepath.Join(t.TempDir(), "summary.md")
+	if err := os.WriteFile(summary, []byte("# Earlier step\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := (&GitHubAnnotator{SummaryPath: summary}).Annotate(&out, r, anns); err != nil {
+		t.Fatal(err)
+	}
+	want := `::error file=web/login.py,line=42,title=Authentication%3A Are passwords hashed with a slow%2C salted algorithm?::Passwords are hashed with unsalted SHA-1 in web/login.py:42 before being stored.%0A%0ANote: Tracked in SEC-7.
+::error file=internal/db/query.go,line=17,title=SQL Injection%3A Is user input concatenated into SQL queries?::internal/db/query.go:17 builds the lookup with fmt.Sprintf, and legacy/db/report.go:3 does the same.
+::error file=legacy/config/prod.env,line=12,title=Secrets%3A AWS access key ID committed to the repository.::AWS access key ID committed to the repository.
+`
+	if out.String() != want {
+		t.Errorf("commands:\n%s\nwant:\n%s", out.String(), want)
+	}
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "# Earlier step\n\n"+fixtureSummary {
+		t.Errorf("step summary:\n%s\nwant it appended:\n%s", got, fixtureSummary)
+	}
+}
+
+func TestGitHubAnnotatorCap(t *testing.T) {
+	r := NewReport(RunMetadata{Codebase: "acme/payments"})
+	var anns []Annotation
+	for s, n := range map[Severity]int{SeverityCritical: 8, SeverityHigh: 5, SeverityMedium: 11, SeverityInfo: 2} {
+		for j := 0; j < n; j++ {
+			f := Finding{Audit: "Audit", Prompt: fmt.Sprintf("%s %d", s, j), Severity: s, Result: "result"}
+			anns = append(anns, Annotation{Finding: f, Location: Location{Path: "main.go", Line: j + 1}})
+		}
+	}
+	summary := filepath.Join(t.TempDir(), "summary.md")
+	var out bytes.Buffer
+	if err := (&GitHubAnnotator{SummaryPath: summary}).Annotate(&out, r, anns); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		level, _, _ := strings.Cut(strings.TrimPrefix(line, "::"), " ")
+		counts[level]++
+	}
+	if counts["error"] != maxAnnotationsPerType || counts["warning"] != maxAnnotationsPerType || counts["notice"] != 2 || len(counts) != 3 {
+		t.Errorf("annotations by level = %v, want %d errors and warnings and 2 notices", counts, maxAnnotationsPerType)
+	}
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\n3 more error annotations were not shown (GitHub shows at most 10 per type); see the full report.\n",
+		"\n1 more warning annotations were not shown (GitHub shows at most 10 per type); see the full report.\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("step summary lacks %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "notice annotations") {
+		t.Errorf("step summary reports dropped notices:\n%s", data)
+	}
+}
+
+func TestGitHubAnnotatorWithoutSummary(t *testing.T) {
+	r, anns := annotatedFixture(t)
+	var out bytes.Buffer
+	if err := (&GitHubAnnotator{}).Annotate(&out, r, anns); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != len(anns) {
+		t.Errorf("wrote %d lines, want one command per annotation:\n%s", n, out.String())
+	}
+}
+
+func TestAzureAnnotator(t *testing.T) {
+	r, anns := annotatedFixture(t)
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := (&AzureAnnotator{TempDir: dir}).Annotate(&out, r, anns); err != nil {
+		t.Fatal(err)
+	}
+	summary := filepath.Join(dir, "treeko-summary-20261014T090000Z-3f2c1a9.md")
+	want := `##vso[task.logissue type=error;sourcepath=web/login.py;linenumber=42;]Authentication: Are passwords hashed with a slow, salted algorithm?: Passwords are hashed with unsalted SHA-1 in web/login.py:42 before being stored.%0A%0ANote: Tracked in SEC-7.
+##vso[task.logissue type=error;sourcepath=internal/db/query.go;linenumber=17;]SQL Injection: Is user input concatenated into SQL queries?: internal/db/query.go:17 builds the lookup with fmt.Sprintf, and legacy/db/report.go:3 does the same.
+##vso[task.logissue type=error;sourcepath=legacy/config/prod.env;linenumber=12;]Secrets: AWS access key ID committed to the repository.: AWS access key ID committed to the repository.
+##vso[task.uploadsummary]` + summary + "\n"
+	if out.String() != want {
+		t.Errorf("commands:\n%s\nwant:\n%s", out.String(), want)
+	}
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != fixtureSummary {
+		t.Errorf("summary:\n%s\nwant:\n%s", data, fixtureSummary)
+	}
+}
+
+func TestTeamCityAnnotator(t *testing.T) {
+	r, anns := annotatedFixture(t)
+	var out bytes.Buffer
+	if err := (&TeamCityAnnotator{}).Annotate(&out, r, anns); err != nil {
+		t.Fatal(err)
+	}
+	want := `##teamcity[inspectionType id='auth.auth-hash' name='Authentication: Are passwords hashed with a slow, salted algorithm?' category='treeko: Authentication' description='Are passwords hashed with a slow, salted algorithm?']
+##teamcity[inspection typeId='auth.auth-hash' message='Passwords are hashed with unsalted SHA-1 in web/login.py:42 before being stored.|n|nNote: Tracked in SEC-7.' file='web/login.py' line='42' SEVERITY='ERROR']
+##teamcity[inspectionType id='sql.sql-concat' name='SQL Injection: Is user input concatenated into SQL queries?' category='treeko: SQL Injection' description='Is user input concatenated into SQL queries?']
+##teamcity[inspection typeId='sql.sql-concat' message='internal/db/query.go:17 builds the lookup with fmt.Sprintf, and legacy/db/report.go:3 does the same.' file='internal/db/query.go' line='17' SEVERITY='ERROR']
+##teamcity[inspectionType id='secrets.aws-access-key' name='Secrets: AWS access key ID committed to the repository.' category='treeko: Secrets' description='AWS access key ID committed to the repository.']
+##teamcity[inspection typeId='secrets.aws-access-key' message='AWS access key ID committed to the repository.' file='legacy/config/prod.env' line='12' SEVERITY='ERROR']
+##teamcity[buildStatisticValue key='treeko.findings.critical' value='2']
+##teamcity[buildStatisticValue key='treeko.findings.high' value='1']
+##teamcity[buildStatisticValue key='treeko.findings.medium' value='1']
+##teamcity[buildStatisticValue key='treeko.findings.low' value='0']
+##teamcity[buildStatisticValue key='treeko.findings.info' value='0']
+##teamcity[buildStatisticValue key='treeko.errors' value='1']
+##teamcity[buildStatus text='{build.status.text}; treeko: 4 findings, 1 errors']
+`
+	if out.String() != want {
+		t.Errorf("service messages:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestBuildkiteAnnotator(t *testing.T) {
+	r, anns := annotatedFixture(t)
+	var style string
+	var body []byte
+	a := &BuildkiteAnnotator{Run: func(s string, b []byte) error {
+		style, body = s, b
+		return nil
+	}}
+	var out bytes.Buffer
+	if err := a.Annotate(&out, r, anns); err != nil {
+		t.Fatal(err)
+	}
+	want := fixtureSummary + "| Severity | Location | Finding |\n|----------|----------|---------|\n" +
+		"| critical | `web/login.py:42` | Authentication: Are passwords hashed with a slow, salted algorithm?: Passwords are hashed with unsalted SHA-1 in web/login.py:42 before being stored.<br><br>Note: Tracked in SEC-7. |\n" +
+		"| high | `internal/db/query.go:17` | SQL Injection: Is user input concatenated into SQL queries?: internal/db/query.go:17 builds the lookup with fmt.Sprintf, and legacy/db/report.go:3 does the same. |\n" +
+		"| critical | `legacy/config/prod.env:12` | Secrets: AWS access key ID committed to the repository.: AWS access key ID committed to the repository. |\n"
+	if style != "error" || string(body) != want {
+		t.Errorf("annotated with style %q:\n%s\nwant style error:\n%s", style, body, want)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote to the build log: %s", out.String())
+	}
+
+	if err := a.Annotate(&out, r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if style != "success" || string(body) != fixtureSummary {
+		t.Errorf("without annotations: style %q, body:\n%s", style, body)
+	}
+}
+
+func TestAnnotationEscaping(t *testing.T) {
+	f := Finding{Audit: "A,B", Prompt: "x:y", Severity: SeverityLow, Result: "100% [done]; a|b\r\nnext 'line'"}
+	anns := []Annotation{{Finding: f, Location: Location{Path: "dir;x]/a,b.go"}}}
+	r := NewReport(RunMetadata{RunID: "run"})
+	var gh, az, tc bytes.Buffer
+	(&GitHubAnnotator{}).Annotate(&gh, r, anns)
+	(&AzureAnnotator{TempDir: t.TempDir()}).Annotate(&az, r, anns)
+	(&TeamCityAnnotator{}).Annotate(&tc, r, anns)
+
+	if gh.String() != "::warning file=dir;x]/a%2Cb.go,title=A%2CB%3A x%3Ay::100%25 [done]; a|b%0D%0Anext 'line'\n" {
+		t.Errorf("GitHub: %q", gh.String())
+	}
+	if line, _, _ := strings.Cut(az.String(), "\n"); line != "##vso[task.logissue type=warning;sourcepath=dir%3Bx%5D/a,b.go;]A,B: x:y: 100%AZP25 [done]; a|b%0D%0Anext 'line'" {
+		t.Errorf("Azure: %q", line)
+	}
+	if !strings.Contains(tc.String(), "message='100% |[done|]; a||b|r|nnext |'line|'' file='dir;x|]/a,b.go' SEVERITY='WARNING'") {
+		t.Errorf("TeamCity: %q", tc.String())
+	}
+}
+
+func TestNewAnnotator(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		mode string
+		want string
+	}{
+		{map[string]string{"GITHUB_ACTIONS": "true"}, AnnotationsAuto, "*main.GitHubAnnotator"},
+		{map[string]string{"TF_BUILD": "True"}, AnnotationsAuto, "*main.AzureAnnotator"},
+		{map[string]string{"TEAMCITY_VERSION": "2024.1"}, "", "*main.TeamCityAnnotator"},
+		{map[string]string{"BUILDKITE": "true"}, AnnotationsAuto, "*main.BuildkiteAnnotator"},
+		{nil, AnnotationsAuto, "<nil>"},
+		{map[string]string{"GITHUB_ACTIONS": "true"}, AnnotationsNone, "<nil>"},
+		{nil, AnnotationsTeamCity, "*main.TeamCityAnnotator"},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"GITHUB_ACTIONS", "TF_BUILD", "TEAMCITY_VERSION", "BUILDKITE"} {
+			t.Setenv(name, tt.env[name])
+		}
+		a, err := NewAnnotator(tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%T", a); got != tt.want {
+			t.Errorf("NewAnnotator(%q) with %v = %s, want %s", tt.mode, tt.env, got, tt.want)
+		}
+	}
+	if _, err := NewAnnotator("jenkins"); err == nil {
+		t.Error("NewAnnotator(jenkins) succeeded, want an error")
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Standard names of a signed report's files in a run directory.
+const (
+	RunDirSignature   = "report.sig"
+	RunDirAttestation = "attestation.json"
+)
+
+// CanonicalizationJCS names the canonical form reports are signed in.
+const CanonicalizationJCS = "RFC 8785"
+
+// CanonicalJSON re-encodes the JSON document data in the canonical form of
+// RFC 8785, the JSON Canonicalization Scheme, so the same document always
+// comes out as the same bytes however it was formatted:
+//
+//   - no whitespace between tokens;
+//   - object members sorted by their names compared as UTF-16 code units,
+//     and a name appearing twice in an object is an error;
+//   - strings with only ", \ and control characters escaped, the short
+//     forms \b \t \n \f \r where they exist and \u00xx in lowercase hex
+//     otherwise, and everything else, non-ASCII included, as UTF-8;
+//   - numbers as IEEE 754 doubles written the way ECMAScript does: integers
+//     below 1e21 without a fraction or exponent, other numbers from 1e-6
+//     on in the shortest decimal that reads back the same, and the rest in
+//     that shortest form with an exponent, e.g. 1e+21 and 1.5e-7. -0 is 0.
+//
+// Every step is defined by the specification rather than by
+// encoding/json's output, which may change between Go versions; only
+// encoding/json's tokenizer is used to read the input.
+func CanonicalJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	if err := canonicalValue(dec, &buf); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON document")
+	}
+	return buf.Bytes(), nil
+}
+
+func canonicalValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			buf.WriteByte('[')
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := canonicalValue(dec, buf); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			buf.WriteByte(']')
+			return err
+		}
+		return canonicalObject(dec, buf)
+	case string:
+		canonicalString(buf, tok)
+	case json.Number:
+		s, err := canonicalNumber(tok)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case bool:
+		buf.WriteString(strconv.FormatBool(tok))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func canonicalObject(dec *json.Decoder, buf *bytes.Buffer) error {
+	members := make(map[string][]byte)
+	var names []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name := tok.(string)
+		if _, ok := members[name]; ok {
+			return fmt.Errorf("object has member '%s' twice", name)
+		}
+		var value bytes.Buffer
+		if err := canonicalValue(dec, &value); err != nil {
+			return err
+		}
+		members[name] = value.Bytes()
+		names = append(names, name)
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	sort.Slice(names, func(i, j int) bool { return lessUTF16(names[i], names[j]) })
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		canonicalString(buf, name)
+		buf.WriteByte(':')
+		buf.Write(members[name])
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// lessUTF16 orders strings by their UTF-16 code units, as RFC 8785 sorts
+// member names.
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+func canonicalString(buf *bytes.Buffer, s string) {
+	const hexDigits = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hexDigits[r>>4])
+				buf.WriteByte(hexDigits[r&0xf])
+			} else {
+				// The decoder has already replaced invalid UTF-8 with
+				// U+FFFD.
+				var b [utf8.UTFMax]byte
+				buf.Write(b[:utf8.EncodeRune(b[:], r)])
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber writes n as ECMAScript's Number.prototype.toString would.
+func canonicalNumber(n json.Number) (string, error) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", fmt.Errorf("number %s can't be represented as a double", n)
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	if abs := math.Abs(f); abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	// Go writes the exponent with at least two digits, e.g. 1.5e-07;
+	// ECMAScript with as few as it needs and always a sign.
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	i := strings.IndexByte(s, 'e')
+	exp, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return "", err
+	}
+	sign := "+"
+	if exp < 0 {
+		sign, exp = "-", -exp
+	}
+	return s[:i] + "e" + sign + strconv.Itoa(exp), nil
+}
+
+// Attestation describes a report's signature, for attestation.json next to
+// report.sig. Only the report is signed: the attestation says how and by
+// whom, and verification checks it against the report and the key.
+type Attestation struct {
+	Algorithm        string `json:"algorithm"`
+	Canonicalization string `json:"canonicalization"`
+	// KeyFingerprint identifies the signing key, see KeyFingerprint.
+	KeyFingerprint string `json:"keyFingerprint"`
+	// ReportDigest is the SHA-256 of the canonical report, as
+	// sha256:<hex>.
+	ReportDigest  string    `json:"reportDigest"`
+	RunID         string    `json:"runId"`
+	SchemaVersion string    `json:"schemaVersion"`
+	SignedAt      time.Time `json:"signedAt"`
+}
+
+// KeyFingerprint is SHA256:<base64> of the public key in PKIX DER, as
+// openssl pkey -pubout -outform DER | openssl sha256 -binary | base64
+// computes it, less the padding.
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// LoadSigningKey reads an Ed25519 private key from a PKCS #8 PEM file, such
+// as openssl genpkey -algorithm ed25519 writes. Errors never quote the
+// file's content.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM file with a PRIVATE KEY block", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a PKCS #8 private key", path)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return ed, nil
+}
+
+// LoadVerifyKey reads an Ed25519 public key from a PKIX PEM file, such as
+// openssl pkey -pubout writes.
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s is not a PEM file with a PUBLIC KEY block", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	ed, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return ed, nil
+}
+
+// SignReport signs the canonical form of the JSON report data, returning
+// the signature and its attestation.
+func SignReport(data []byte, key ed25519.PrivateKey) ([]byte, *Attestation, error) {
+	canonical, err := CanonicalJSON(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("canonicalizing report: %v", err)
+	}
+	var head struct {
+		SchemaVersion string `json:"schemaVersion"`
+		Metadata      struct {
+			RunID string `json:"runId"`
+		} `json:"metadata"`
+	}
+	json.Unmarshal(data, &head)
+	sum := sha256.Sum256(canonical)
+	return ed25519.Sign(key, canonical), &Attestation{
+		Algorithm:        "ed25519",
+		Canonicalization: CanonicalizationJCS,
+		KeyFingerprint:   KeyFingerprint(key.Public().(ed25519.PublicKey)),
+		ReportDigest:     "sha256:" + hex.EncodeToString(sum[:]),
+		RunID:            head.Metadata.RunID,
+		SchemaVersion:    head.SchemaVersion,
+		SignedAt:         time.Now().UTC(),
+	}, nil
+}
+
+// VerifyReport checks sig against the canonical form of the JSON report
+// data, and, given an attestation, that it names the key and the report.
+func VerifyReport(data, sig []byte, pub ed25519.PublicKey, att *Attestation) error {
+	canonical, err := CanonicalJSON(data)
+	if err != nil {
+		return fmt.Errorf("canonicalizing report: %v", err)
+	}
+	if !ed25519.Verify(pub, canonical, sig) {
+		return errors.New("signature doesn't match the report and key")
+	}
+	if att != nil {
+		sum := sha256.Sum256(canonical)
+		if fp := KeyFingerprint(pub); att.KeyFingerprint != fp {
+			return fmt.Errorf("attestation names key %s, not %s", att.KeyFingerprint, fp)
+		}
+		if digest := "sha256:" + hex.EncodeToString(sum[:]); att.ReportDigest != digest {
+			return fmt.Errorf("attestation names report %s, not %s", att.ReportDigest, digest)
+		}
+	}
+	return nil
+}
+
+// WriteSignature signs the report of the run directory and writes
+// report.sig, the base64 signature, and attestation.json. They are written
+// as they are even with -encrypt-reports, whose report is signed before it
+// is encrypted; they hold nothing secret.
+func (d *RunDir) WriteSignature(r *Report, key ed25519.PrivateKey) error {
+	var buf bytes.Buffer
+	if err := WriteJSONReport(&buf, r); err != nil {
+		return err
+	}
+	sig, att, err := SignReport(buf.Bytes(), key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(d.File(RunDirSignature), []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0o644); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(att, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.File(RunDirAttestation), append(data, '\n'), 0o644)
+}
+
+// parseFlagsAnywhere parses flags given before, between or after the
+// positional arguments, which it returns.
+func parseFlagsAnywhere(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// runReportVerifyCommand checks a report signed with -sign-key. The
+// attestation next to the signature is checked too when there is one.
+func runReportVerifyCommand(args []string) int {
+	flags := flag.NewFlagSet("report verify", flag.ExitOnError)
+	pubPath := flags.String("pub", "", "PEM file with the Ed25519 public key of the signing key")
+	attPath := flags.String("attestation", "", "The signature's attestation (default: attestation.json next to the signature, if there is one)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko report verify <report.json> <report.sig> -pub <key.pub>")
+		flags.PrintDefaults()
+	}
+	positional := parseFlagsAnywhere(flags, args)
+	if len(positional) != 2 || *pubPath == "" {
+		flags.Usage()
+		return ExitUsage
+	}
+	reportPath, sigPath := positional[0], positional[1]
+	pub, err := LoadVerifyKey(*pubPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading public key: %v\n", err)
+		return ExitUsage
+	}
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitUsage
+	}
+	encoded, err := os.ReadFile(sigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitUsage
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		fmt.Fprintf(os.Stderr, "%s is not a treeko report signature\n", sigPath)
+		return 1
+	}
+	if *attPath == "" {
+		if path := filepath.Join(filepath.Dir(sigPath), RunDirAttestation); fileExists(path) {
+			*attPath = path
+		}
+	}
+	var att *Attestation
+	if *attPath != "" {
+		data, err := os.ReadFile(*attPath)
+		if err == nil {
+			att = &Attestation{}
+			err = json.Unmarshal(data, att)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading attestation %s: %v\n", *attPath, err)
+			return 1
+		}
+	}
+	if err := VerifyReport(data, sig, pub, att); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: verification failed: %v\n", reportPath, err)
+		return 1
+	}
+	if att != nil {
+		fmt.Printf("OK: %s signed by %s at %s\n", reportPath, att.KeyFingerprint, att.SignedAt.Format(time.RFC3339))
+	} else {
+		fmt.Printf("OK: %s signed by %s\n", reportPath, KeyFingerprint(pub))
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalJSONRFC8785(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		// RFC 8785 section 3.2.2.
+		{
+			"sample",
+			`{
+  "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+  "literals": [null, true, false]
+}`,
+			`{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		// RFC 8785 section 3.2.3: names sort by UTF-16 code units, so the
+		// emoji's surrogate pair comes before U+FB33.
+		{
+			"property sorting",
+			`{
+  "\u20ac": "Euro Sign",
+  "\r": "Carriage Return",
+  "\ufb33": "Hebrew Letter Dalet With Dagesh",
+  "1": "One",
+  "\ud83d\ude00": "Emoji: Grinning Face",
+  "\u0080": "Control",
+  "\u00f6": "Latin Small Letter O With Diaeresis"
+}`,
+			"{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"ö\":\"Latin Small Letter O With Diaeresis\",\"€\":\"Euro Sign\",\"😀\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		{"nested objects sort", `{"b": {"z": 1, "a": [{"y": 2, "x": 3}]}, "a": {}}`, `{"a":{},"b":{"a":[{"x":3,"y":2}],"z":1}}`},
+		{"control characters", `"\u0000\u0001\b\t\n\u000b\f\r\u001f\u007f"`, "\"\\u0000\\u0001\\b\\t\\n\\u000b\\f\\r\\u001f\u007f\""},
+		{"no escaping of solidus or non-ASCII", `"\/\u00e9\u2028<>&"`, "\"/é\u2028<>&\""},
+		{"whitespace removed", " [ 1 , { \"a\" : \"b\" } , [ ] ] ", `[1,{"a":"b"},[]]`},
+		{"scalars", `true`, `true`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CanonicalJSON([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("CanonicalJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalJSONNumbers(t *testing.T) {
+	// RFC 8785 appendix B: IEEE 754 doubles by their bits, and how
+	// ECMAScript writes them.
+	tests := []struct {
+		bits uint64
+		want string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0xffefffffffffffff, "-1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0xc340000000000000, "-9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+		{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x41b3de4355555553, "333333333.3333332"},
+		{0x41b3de4355555554, "333333333.33333325"},
+		{0x41b3de4355555555, "333333333.3333333"},
+		{0x41b3de4355555556, "333333333.3333334"},
+		{0x41b3de4355555557, "333333333.33333343"},
+		{0xbecbf647612f3696, "-0.0000033333333333333333"},
+		{0x43143ff3c1cb0959, "1424953923781206.2"},
+	}
+	for _, tt := range tests {
+		in := strconv.FormatFloat(math.Float64frombits(tt.bits), 'e', -1, 64)
+		got, err := CanonicalJSON([]byte(in))
+		if err != nil {
+			t.Errorf("%016x (%s): %v", tt.bits, in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%016x (%s) = %s, want %s", tt.bits, in, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalJSONErrors(t *testing.T) {
+	for _, in := range []string{
+		`{"a": 1, "a": 2}`,
+		`{"a": 1} {"b": 2}`,
+		`[1e400]`,
+		`[1, 2`,
+		``,
+	} {
+		if got, err := CanonicalJSON([]byte(in)); err == nil {
+			t.Errorf("CanonicalJSON(%q) = %s, want an error", in, got)
+		}
+	}
+}
+
+func TestSignReportRoundTrip(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", fixtureReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, att, err := SignReport(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.Algorithm != "ed25519" || att.Canonicalization != CanonicalizationJCS || att.KeyFingerprint != KeyFingerprint(pub) {
+		t.Errorf("attestation = %+v", att)
+	}
+	if att.RunID != "20261014T090000Z-3f2c1a9" || att.SchemaVersion != "1.39.0" || !strings.HasPrefix(att.ReportDigest, "sha256:") {
+		t.Errorf("attestation doesn't describe the report: %+v", att)
+	}
+	if err := VerifyReport(data, sig, pub, att); err != nil {
+		t.Fatalf("verifying the signed report: %v", err)
+	}
+
+	// Formatting isn't signed: the same report compacted still verifies.
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyReport(compact.Bytes(), sig, pub, att); err != nil {
+		t.Errorf("verifying the compacted report: %v", err)
+	}
+	// Nor is member order.
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	reordered, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyReport(reordered, sig, pub, nil); err != nil {
+		t.Errorf("verifying the re-encoded report: %v", err)
+	}
+}
+
+func TestVerifyReportTampered(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", fixtureReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, att, err := SignReport(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte(`"severity": "critical"`), []byte(`"severity": "low"`), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("fixture has no critical finding to tamper with")
+	}
+	otherSig, otherAtt, err := SignReport(tampered, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := *att
+	digest.ReportDigest = otherAtt.ReportDigest
+	flipped := append([]byte(nil), sig...)
+	flipped[0] ^= 1
+
+	tests := []struct {
+		name string
+		data []byte
+		sig  []byte
+		pub  ed25519.PublicKey
+		att  *Attestation
+		want string
+	}{
+		{"tampered report", tampered, sig, pub, att, "signature doesn't match"},
+		{"tampered signature", data, flipped, pub, att, "signature doesn't match"},
+		{"wrong key", data, sig, otherPub, nil, "signature doesn't match"},
+		{"attestation of another key", tampered, otherSig, otherPub, att, "attestation names key"},
+		{"attestation of another report", data, sig, pub, &digest, "attestation names report"},
+		{"not JSON", []byte("not json"), sig, pub, nil, "canonicalizing report"},
+	}
+	for _, tt := range tests {
+		if err := VerifyReport(tt.data, tt.sig, tt.pub, tt.att); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// AuthGuard aborts the run once the backend has rejected the credentials a
+// number of times in a row, instead of sending every remaining request with
+// a key that doesn't work.
+type AuthGuard struct {
+	limit int
+	abort context.CancelFunc
+
+	mu          sync.Mutex
+	consecutive int
+	tripped     bool
+}
+
+// authGuard is nil when -max-auth-failures is 0.
+var authGuard *AuthGuard
+
+func NewAuthGuard(limit int, abort context.CancelFunc) *AuthGuard {
+	return &AuthGuard{limit: limit, abort: abort}
+}
+
+// Record notes the status of a backend response. Any response other than
+// 401 or 403 resets the count.
+func (g *AuthGuard) Record(status int) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if status != http.StatusUnauthorized && status != http.StatusForbidden {
+		g.consecutive = 0
+		return
+	}
+	g.consecutive++
+	if g.consecutive >= g.limit && !g.tripped {
+		g.tripped = true
+		log.Printf("Authentication failing: %d consecutive %d responses; check the backend's API key. Aborting the run.\n", g.consecutive, status)
+		g.abort()
+	}
+}
+
+// Tripped reports whether the guard aborted the run.
+func (g *AuthGuard) Tripped() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.tripped
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Backends.
+const (
+	BackendGreptile = "greptile"
+	BackendOpenAI   = "openai"
+	// BackendSourcegraph only answers prompts that carry a sourcegraphQuery.
+	BackendSourcegraph = "sourcegraph"
+)
+
+// Answer is a backend's reply to one prompt. Score is nil when the backend
+// doesn't rate its answers. Locations, when set, replace those extracted
+// from Result. Raw is the response body the answer was decoded from.
+type Answer struct {
+	Result    string
+	Score     *float64
+	Locations []Location
+	Raw       json.RawMessage
+}
+
+// Backend answers prompts about a codebase. Caching and the auth guard are
+// applied by the caller, and authentication, rate limiting and retries by
+// the client a backend is given, so implementations only build the request
+// and decode the response. Failed responses are returned as *APIError, and
+// bodies that aren't JSON as *BadResponseError.
+type Backend interface {
+	// Name is recorded as the source of the backend's findings.
+	Name() string
+	Query(ctx context.Context, prompt string, target Target) (Answer, error)
+}
+
+// GreptileBackend queries Greptile's search API, which answers from its own
+// index of the codebase.
+type GreptileBackend struct {
+	URL string
+	// Client authenticates the requests; see NewBackendClient.
+	Client *http.Client
+}
+
+// defaultBackend is used for targets that don't name one.
+var defaultBackend Backend = &GreptileBackend{URL: GreptileAPIUrl, Client: NewBackendClient(WithAuth("Authorization", "Bearer "+APIKey))}
+
+func (g *GreptileBackend) Name() string { return BackendGreptile }
+
+func (g *GreptileBackend) Query(ctx context.Context, prompt string, target Target) (Answer, error) {
+	body, err := json.Marshal(GreptileRequest{Prompt: prompt, Codebase: target.Codebase, Branch: target.Branch, SessionID: target.Session})
+	if err != nil {
+		return Answer{}, fmt.Errorf("marshaling JSON payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", g.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return Answer{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return Answer{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Answer{}, fmt.Errorf("reading response: %w", err)
+	}
+	if err := checkJSONBody(BackendGreptile, resp, data); err != nil {
+		return Answer{}, err
+	}
+
+	var greptileResponse GreptileResponse
+	if err := decodeResponse(data, &greptileResponse); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return Answer{}, &APIError{StatusCode: resp.StatusCode}
+		}
+		return Answer{}, fmt.Errorf("parsing JSON response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Answer{}, &APIError{StatusCode: resp.StatusCode, Message: greptileResponse.Error}
+	}
+	return Answer{Result: string(greptileResponse.Result), Score: greptileResponse.Score, Raw: data}, nil
+}
+
+// cacheQuery is the cache key for prompt. Greptile's is the prompt itself,
+// so caches written before backends existed stay valid; other backends are
+// keyed by backend and model as well.
+func cacheQuery(b Backend, prompt string) string {
+	switch b := b.(type) {
+	case *GreptileBackend:
+		return prompt
+	case *OpenAIBackend:
+		return b.Name() + " " + b.Model + "\n" + prompt
+	case *SourcegraphBackend:
+		return b.Name() + " " + b.URL + "\n" + prompt
+	case offlineBackend:
+		return cacheQuery(b.backend, prompt)
+	default:
+		return b.Name() + "\n" + prompt
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchPrompt is asked by treeko bench unless -prompt replaces it. It is
+// cheap to answer and touches nothing sensitive.
+const benchPrompt = "List the top-level directories of the repository."
+
+// BenchLevel is the outcome of treeko bench at one concurrency level.
+type BenchLevel struct {
+	Concurrency int     `json:"concurrency"`
+	Requests    int     `json:"requests"`
+	Errors      int     `json:"errors"`
+	RateLimited int     `json:"rateLimited"`
+	DurationMs  int64   `json:"durationMs"`
+	Throughput  float64 `json:"throughput"`
+	// Latency covers every request, failed ones included.
+	Latency *LatencyStats `json:"latency"`
+}
+
+// BenchReport is what treeko bench writes with -json-out.
+type BenchReport struct {
+	Codebase  string       `json:"codebase"`
+	Prompt    string       `json:"prompt"`
+	StartedAt time.Time    `json:"startedAt"`
+	Levels    []BenchLevel `json:"levels"`
+}
+
+// parseConcurrencyLevels parses a comma-separated list of positive counts.
+func parseConcurrencyLevels(s string) ([]int, error) {
+	var levels []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid concurrency level '%s'", strings.TrimSpace(field))
+		}
+		levels = append(levels, n)
+	}
+	return levels, nil
+}
+
+// benchLevel sends requests copies of prompt about target, concurrency at a
+// time, and measures them. It stops early if ctx is cancelled.
+func benchLevel(ctx context.Context, backend Backend, target Target, prompt string, requests, concurrency int) BenchLevel {
+	level := BenchLevel{Concurrency: concurrency}
+	jobs := make(chan struct{})
+	go func() {
+		defer close(jobs)
+		for i := 0; i < requests; i++ {
+			select {
+			case jobs <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var mu sync.Mutex
+	var latency Summary
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				sent := time.Now()
+				_, err := backend.Query(ctx, prompt, target)
+				ms := time.Since(sent).Milliseconds()
+				if ctx.Err() != nil {
+					return
+				}
+				mu.Lock()
+				level.Requests++
+				latency.durations = append(latency.durations, ms)
+				var apiErr *APIError
+				if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+					level.RateLimited++
+				}
+				if err != nil {
+					level.Errors++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	level.DurationMs = elapsed.Milliseconds()
+	if elapsed > 0 {
+		level.Throughput = float64(level.Requests) / elapsed.Seconds()
+	}
+	latency.finish()
+	level.Latency = latency.Latency
+	return level
+}
+
+// writeBenchTable prints one row per concurrency level.
+func writeBenchTable(w *tabwriter.Writer, levels []BenchLevel) {
+	fmt.Fprintln(w, "CONCURRENCY\tREQUESTS\tTHROUGHPUT\tP50\tP90\tP99\tERRORS\t429s")
+	for _, l := range levels {
+		p50, p90, p99 := "-", "-", "-"
+		if l.Latency != nil {
+			p50, p90, p99 = formatDurationMs(l.Latency.P50Ms), formatDurationMs(l.Latency.P90Ms), formatDurationMs(l.Latency.P99Ms)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%.2f/s\t%s\t%s\t%s\t%s\t%s\n", l.Concurrency, l.Requests, l.Throughput, p50, p90, p99,
+			benchRate(l.Errors, l.Requests), benchRate(l.RateLimited, l.Requests))
+	}
+	w.Flush()
+}
+
+// benchRate is n of total as a percentage.
+func benchRate(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", 100*float64(n)/float64(total))
+}
+
+// runBenchCommand measures the backend's throughput and latency at each
+// -concurrency level by asking a fixed prompt -requests times. It spends
+// quota on purpose, so it only runs with -yes.
+func runBenchCommand(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	opts := addQueryFlags(flags)
+	requests := flags.Int("requests", 50, "Requests to send at each concurrency level")
+	concurrency := flags.String("concurrency", "5,10,20", "Comma-separated concurrency levels to measure")
+	prompt := flags.String("prompt", benchPrompt, "Prompt to send")
+	maxCost := flags.Int("max-cost", 0, "Refuse to run if the benchmark would send more than this many requests in total (0 for no limit)")
+	yes := flags.Bool("yes", false, "Confirm that the benchmark may consume API quota")
+	jsonOut := flags.String("json-out", "", "Also write the results as JSON to this file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko bench -yes [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	levels, err := parseConcurrencyLevels(*concurrency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -concurrency: %v\n", err)
+		return ExitUsage
+	}
+	if *requests < 1 {
+		fmt.Fprintf(os.Stderr, "Error: -requests must be at least 1, got %d\n", *requests)
+		return ExitUsage
+	}
+	total := *requests * len(levels)
+	if *maxCost < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -max-cost must not be negative, got %d\n", *maxCost)
+		return ExitUsage
+	}
+	if *maxCost > 0 && total > *maxCost {
+		fmt.Fprintf(os.Stderr, "Error: the benchmark would send %d requests, more than -max-cost %d\n", total, *maxCost)
+		return ExitUsage
+	}
+	if !*yes {
+		fmt.Fprintf(os.Stderr, "The benchmark sends %d requests and consumes API quota; pass -yes to run it.\n", total)
+		return ExitUsage
+	}
+	target, backend, err := opts.setup(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report := BenchReport{Codebase: target.Codebase, Prompt: *prompt, StartedAt: time.Now().UTC()}
+	for _, n := range levels {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Sending %d requests at concurrency %d...\n", *requests, n)
+		report.Levels = append(report.Levels, benchLevel(ctx, backend, target, *prompt, *requests, n))
+	}
+	writeBenchTable(tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0), report.Levels)
+	if *jsonOut != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*jsonOut, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *jsonOut, err)
+			return ExitErrors
+		}
+	}
+	return ExitOK
+}
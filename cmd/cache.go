@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ResultCache stores successful Greptile results on disk. Entries are keyed
+// by codebase, codebase revision and prompt, so a new commit never serves a
+// result computed against older code.
+type ResultCache struct {
+	Dir string
+}
+
+type cacheEntry struct {
+	Prompt   string    `json:"prompt"`
+	Codebase string    `json:"codebase"`
+	Revision string    `json:"revision"`
+	Result   string    `json:"result"`
+	Score    *float64  `json:"score,omitempty"`
+	CachedAt time.Time `json:"cachedAt"`
+}
+
+// errNoRevision is returned by Put when the codebase revision is unknown;
+// caching without one could serve results computed against other code.
+var errNoRevision = errors.New("codebase revision is unknown; pass -codebase-rev or run inside a git checkout")
+
+func NewResultCache(dir string) (*ResultCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &ResultCache{Dir: dir}, nil
+}
+
+func (c *ResultCache) key(codebase, revision, prompt string) string {
+	sum := sha256.Sum256([]byte(codebase + "\x00" + revision + "\x00" + prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *ResultCache) path(codebase, revision, prompt string) string {
+	return filepath.Join(c.Dir, c.key(codebase, revision, prompt)+".json")
+}
+
+// Get returns the cached result and score for prompt, if one exists for
+// revision. An unknown revision is always a miss.
+func (c *ResultCache) Get(codebase, revision, prompt string) (string, *float64, bool) {
+	if revision == "" {
+		return "", nil, false
+	}
+	data, err := os.ReadFile(c.path(codebase, revision, prompt))
+	if err != nil {
+		return "", nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", nil, false
+	}
+	if entry.Revision != revision || entry.Codebase != codebase || entry.Prompt != prompt {
+		return "", nil, false
+	}
+	return entry.Result, entry.Score, true
+}
+
+func (c *ResultCache) Put(codebase, revision, prompt, result string, score *float64) error {
+	if revision == "" {
+		return errNoRevision
+	}
+	entry := cacheEntry{
+		Prompt:   prompt,
+		Codebase: codebase,
+		Revision: revision,
+		Result:   result,
+		Score:    score,
+		CachedAt: time.Now().UTC(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Write to a temp file first so a concurrent reader never sees a
+	// partially written entry.
+	tmp, err := os.CreateTemp(c.Dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(codebase, revision, prompt))
+}
+
+func shortRev(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
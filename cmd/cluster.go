@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// clusterLineSpan is how many lines apart two references to a file may be
+// and still count as the same code. A reference without a line covers the
+// whole file.
+const clusterLineSpan = 10
+
+// IssueCluster groups findings of a codebase that point at the same code,
+// typically raised by different audits about one handler.
+type IssueCluster struct {
+	ID       string `json:"id"`
+	Codebase string `json:"codebase"`
+	Alias    string `json:"alias,omitempty"`
+	// Severity is the most serious of the findings'.
+	Severity Severity `json:"severity"`
+	// Findings are the fingerprints of the contributing findings, in report
+	// order, and Audits the names of the audits that raised them.
+	Findings  []string   `json:"findings"`
+	Audits    []string   `json:"audits"`
+	Locations []Location `json:"locations"`
+}
+
+// linesOverlap reports whether two references to the same file are close
+// enough to be about the same code.
+func linesOverlap(a, b int) bool {
+	if a == 0 || b == 0 {
+		return true
+	}
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	return d <= clusterLineSpan
+}
+
+// Correlate groups the findings whose locations overlap into issue
+// clusters and sets each member's ClusterID. Overlap is transitive, so a
+// finding about lines 10 and 40 joins the clusters of both. Findings
+// without locations, and clusters of one, stay unclustered. Findings must
+// already be sorted; the result then only depends on the findings.
+func (r *Report) Correlate() {
+	r.Clusters = nil
+	parent := make([]int, len(r.Findings))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(a, b int) {
+		a, b = find(a), find(b)
+		if a > b {
+			a, b = b, a
+		}
+		parent[b] = a
+	}
+
+	type fileKey struct{ codebase, path string }
+	type reference struct{ finding, line int }
+	references := make(map[fileKey][]reference)
+	for i := range r.Findings {
+		f := &r.Findings[i]
+		f.ClusterID = ""
+		if !f.HasResult() {
+			continue
+		}
+		for _, loc := range f.Locations {
+			key := fileKey{f.Codebase, NormalizePath(loc.Path)}
+			for _, ref := range references[key] {
+				if ref.finding != i && linesOverlap(ref.line, loc.Line) {
+					union(ref.finding, i)
+				}
+			}
+			references[key] = append(references[key], reference{i, loc.Line})
+		}
+	}
+
+	members := make(map[int][]int)
+	var roots []int
+	for i, f := range r.Findings {
+		if !f.HasResult() || len(f.Locations) == 0 {
+			continue
+		}
+		root := find(i)
+		if members[root] == nil {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+	for _, root := range roots {
+		if len(members[root]) < 2 {
+			continue
+		}
+		c := IssueCluster{Codebase: r.Findings[root].Codebase, Alias: r.Findings[root].CodebaseAlias, Severity: r.Findings[root].Severity}
+		audits := make(map[string]bool)
+		locations := make(map[Location]bool)
+		for _, i := range members[root] {
+			f := r.Findings[i]
+			c.Findings = append(c.Findings, f.Fingerprint)
+			if f.Severity.Rank() < c.Severity.Rank() {
+				c.Severity = f.Severity
+			}
+			if !audits[f.Audit] {
+				audits[f.Audit] = true
+				c.Audits = append(c.Audits, f.Audit)
+			}
+			for _, loc := range f.Locations {
+				loc.Path = NormalizePath(loc.Path)
+				if !locations[loc] {
+					locations[loc] = true
+					c.Locations = append(c.Locations, loc)
+				}
+			}
+		}
+		sort.Strings(c.Audits)
+		sort.Slice(c.Locations, func(i, j int) bool {
+			a, b := c.Locations[i], c.Locations[j]
+			if a.Path != b.Path {
+				return a.Path < b.Path
+			}
+			return a.Line < b.Line
+		})
+		fingerprints := append([]string(nil), c.Findings...)
+		sort.Strings(fingerprints)
+		sum := sha256.Sum256([]byte(c.Codebase + "\x00" + strings.Join(fingerprints, "\n")))
+		c.ID = hex.EncodeToString(sum[:6])
+		for _, i := range members[root] {
+			r.Findings[i].ClusterID = c.ID
+		}
+		r.Clusters = append(r.Clusters, c)
+	}
+}
+
+// writeClusters lists the issue clusters in the text summary.
+func writeClusters(w io.Writer, r *Report) {
+	if len(r.Clusters) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Clusters: %d issues raised by several findings:\n", len(r.Clusters))
+	for _, c := range r.Clusters {
+		fmt.Fprintf(w, "  [%s] %s %s: %s\n", c.Severity, c.ID, codebaseLabel(c.Codebase, c.Alias), c.describe())
+	}
+}
+
+// describe lists the first few locations of the cluster and where its
+// findings came from.
+func (c IssueCluster) describe() string {
+	var locations []string
+	for i, loc := range c.Locations {
+		if i == 3 {
+			locations = append(locations, fmt.Sprintf("+%d more", len(c.Locations)-i))
+			break
+		}
+		if loc.Line > 0 {
+			locations = append(locations, fmt.Sprintf("%s:%d", loc.Path, loc.Line))
+		} else {
+			locations = append(locations, loc.Path)
+		}
+	}
+	return fmt.Sprintf("%s (%d findings from %s)", strings.Join(locations, ", "), len(c.Findings), strings.Join(c.Audits, ", "))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// compactPromptWidth bounds the prompt in compact lines, and half the width
+// does on narrow terminals, so that the result stays visible when lines are
+// cut.
+const compactPromptWidth = 48
+
+// WriteCompact writes one line per finding, "[HIGH][sql] prompt → first line
+// of result", in the report's order. Lines longer than width are cut; a
+// width of zero leaves them whole. With -print-prompt each line follows the
+// whole prompt, which is never cut.
+func WriteCompact(w io.Writer, r *Report, width int) {
+	promptWidth := compactPromptWidth
+	if width > 0 && width/2 < promptWidth {
+		promptWidth = width / 2
+	}
+	for _, f := range r.Findings {
+		audit := auditKey(f)
+		var outcome string
+		switch {
+		case f.Error != "":
+			outcome = "error: " + firstLine(f.Error)
+		case !f.HasResult():
+			outcome = "(no result)"
+		default:
+			outcome = firstLine(f.Result)
+		}
+		if f.Suppressed {
+			outcome += " (suppressed)"
+		}
+		for _, note := range f.Notes {
+			outcome += " (note: " + note + ")"
+		}
+		line := fmt.Sprintf("[%s][%s] %s → %s", strings.ToUpper(string(f.Severity)), audit, truncateRunes(f.Prompt, promptWidth), outcome)
+		if width > 0 {
+			line = truncateRunes(line, width)
+		}
+		if printPrompt && f.query != "" {
+			fmt.Fprintf(w, "Prompt: %s\n", f.query)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// firstLine is the first non-blank line of s.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// truncateRunes cuts s to n runes, ending it with an ellipsis if anything
+// was left out.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// outputWidth is the width to cut compact lines to on f: $COLUMNS if set,
+// otherwise the terminal's, or zero when f isn't a terminal.
+func outputWidth(f *os.File) int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if !isTerminal(f) {
+		return 0
+	}
+	if n := terminalWidth(f); n > 0 {
+		return n
+	}
+	return 80
+}
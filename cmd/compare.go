@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Outcomes of a prompt in a codebase, besides the severity of its finding.
+const (
+	CompareClean  = "clean"
+	CompareError  = "error"
+	CompareNotRun = "not run"
+)
+
+// How widely a prompt's finding spreads across the compared codebases.
+const (
+	// SpreadSystemic findings appear in every codebase the prompt ran in.
+	SpreadSystemic = "systemic"
+	// SpreadPartial findings appear in some.
+	SpreadPartial = "partial"
+	// SpreadUnique findings appear in a single codebase.
+	SpreadUnique = "unique"
+)
+
+// ComparisonRow is one prompt of -compare-codebases and what it found in
+// each codebase.
+type ComparisonRow struct {
+	AuditID  string `json:"auditId"`
+	PromptID string `json:"promptId,omitempty"`
+	Prompt   string `json:"prompt"`
+	// Codebases maps each compared codebase, by label, to the severity of
+	// the prompt's most severe finding there, or to clean, error or not
+	// run.
+	Codebases map[string]string `json:"codebases"`
+	// Found lists the codebases with a finding, in run order.
+	Found  []string `json:"found"`
+	Spread string   `json:"spread"`
+}
+
+// Comparison is the matrix of -compare-codebases: the prompts that found
+// something in at least one codebase, by audit and prompt ID.
+type Comparison struct {
+	Codebases []string
+	Rows      []ComparisonRow
+	// Unfound counts the prompts that found nothing anywhere.
+	Unfound int
+}
+
+// CompareCodebases builds the matrix of a summarized report. Filtered and
+// low-confidence results count as clean; suppressed findings still count
+// as found, since the issue is there.
+func CompareCodebases(r *Report) *Comparison {
+	c := &Comparison{Codebases: []string{}, Rows: []ComparisonRow{}}
+	for _, cb := range r.Codebases {
+		c.Codebases = append(c.Codebases, codebaseLabel(cb.Codebase, cb.Alias))
+	}
+	var rows []*ComparisonRow
+	index := make(map[string]*ComparisonRow)
+	for _, f := range r.Findings {
+		key := auditKey(f) + "\x00" + f.PromptID + "\x00" + f.Prompt
+		row := index[key]
+		if row == nil {
+			row = &ComparisonRow{AuditID: auditKey(f), PromptID: f.PromptID, Prompt: f.Prompt, Codebases: make(map[string]string)}
+			index[key] = row
+			rows = append(rows, row)
+		}
+		outcome := CompareClean
+		switch {
+		case f.HasResult():
+			outcome = string(f.Severity)
+		case f.Error != "":
+			outcome = CompareError
+		}
+		label := f.CodebaseLabel()
+		if previous, ok := row.Codebases[label]; !ok || compareRank(outcome) < compareRank(previous) {
+			row.Codebases[label] = outcome
+		}
+	}
+	for _, row := range rows {
+		ran := 0
+		for _, label := range c.Codebases {
+			outcome, ok := row.Codebases[label]
+			if !ok {
+				row.Codebases[label] = CompareNotRun
+				continue
+			}
+			ran++
+			if Severity(outcome).Valid() {
+				row.Found = append(row.Found, label)
+			}
+		}
+		switch {
+		case len(row.Found) == 0:
+			c.Unfound++
+			continue
+		case len(row.Found) == 1:
+			row.Spread = SpreadUnique
+		case len(row.Found) == ran:
+			row.Spread = SpreadSystemic
+		default:
+			row.Spread = SpreadPartial
+		}
+		c.Rows = append(c.Rows, *row)
+	}
+	// Findings arrive in whatever order the prompts complete.
+	sort.SliceStable(c.Rows, func(i, j int) bool {
+		a, b := c.Rows[i], c.Rows[j]
+		if a.AuditID != b.AuditID {
+			return a.AuditID < b.AuditID
+		}
+		if a.PromptID != b.PromptID {
+			return a.PromptID < b.PromptID
+		}
+		return a.Prompt < b.Prompt
+	})
+	return c
+}
+
+// compareRank orders outcomes so the most telling one of several findings
+// for the same prompt and codebase is kept: severities first, then errors.
+func compareRank(outcome string) int {
+	if sev := Severity(outcome); sev.Valid() {
+		return sev.Rank()
+	}
+	if outcome == CompareError {
+		return len(Severities)
+	}
+	return len(Severities) + 1
+}
+
+// forAudit is the part of c for one audit.
+func (c *Comparison) forAudit(auditID string) []ComparisonRow {
+	var rows []ComparisonRow
+	for _, row := range c.Rows {
+		if row.AuditID == auditID {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// compareCells abbreviate outcomes in the text table.
+var compareCells = map[string]string{
+	string(SeverityCritical): "CRIT",
+	string(SeverityHigh):     "HIGH",
+	string(SeverityMedium):   "MED",
+	string(SeverityLow):      "LOW",
+	string(SeverityInfo):     "INFO",
+	CompareClean:             "-",
+	CompareError:             "ERR",
+	CompareNotRun:            "n/a",
+}
+
+// WriteComparison writes c as a table with a column per codebase, naming
+// the codebase of each finding unique to one.
+func WriteComparison(w io.Writer, c *Comparison) {
+	fmt.Fprintf(w, "Codebase comparison (%d codebases):\n", len(c.Codebases))
+	if len(c.Rows) == 0 {
+		fmt.Fprintf(w, "  No prompt found anything in any codebase.\n")
+		return
+	}
+	labels := make([]string, len(c.Rows))
+	labelWidth := len("Prompt")
+	for i, row := range c.Rows {
+		labels[i] = row.AuditID + "/" + row.PromptID
+		if row.PromptID == "" {
+			labels[i] = row.AuditID + ": " + truncateRunes(row.Prompt, compactPromptWidth)
+		}
+		if n := len([]rune(labels[i])); n > labelWidth {
+			labelWidth = n
+		}
+	}
+	widths := make([]int, len(c.Codebases))
+	header := fmt.Sprintf("  %-*s", labelWidth, "Prompt")
+	for i, cb := range c.Codebases {
+		widths[i] = len([]rune(cb))
+		if widths[i] < 4 {
+			widths[i] = 4
+		}
+		header += fmt.Sprintf("  %-*s", widths[i], cb)
+	}
+	fmt.Fprintln(w, strings.TrimRight(header+"  Spread", " "))
+	for i, row := range c.Rows {
+		line := fmt.Sprintf("  %-*s", labelWidth, labels[i])
+		for j, cb := range c.Codebases {
+			line += fmt.Sprintf("  %-*s", widths[j], compareCells[row.Codebases[cb]])
+		}
+		spread := fmt.Sprintf("%s (%d of %d)", row.Spread, len(row.Found), len(c.Codebases))
+		if row.Spread == SpreadUnique {
+			spread = "unique to " + row.Found[0]
+		}
+		fmt.Fprintf(w, "%s  %s\n", line, spread)
+	}
+	var systemic, unique int
+	for _, row := range c.Rows {
+		switch row.Spread {
+		case SpreadSystemic:
+			systemic++
+		case SpreadUnique:
+			unique++
+		}
+	}
+	fmt.Fprintf(w, "  %d systemic, %d partial, %d unique; prompts finding nothing anywhere: %d\n", systemic, len(c.Rows)-systemic-unique, unique, c.Unfound)
+}
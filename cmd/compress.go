@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxResponseBytes caps how much of a backend response is read, counted
+// after decompression so a small gzip body can't expand without bound.
+var maxResponseBytes int64 = 32 << 20
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// compressTransport asks for gzip responses and decompresses them itself:
+// setting Accept-Encoding turns off the decompression http.Transport would
+// otherwise do. With compressRequests it also gzips request bodies.
+type compressTransport struct {
+	next             http.RoundTripper
+	compressRequests bool
+}
+
+func (t *compressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip")
+	if t.compressRequests && req.Body != nil && req.Body != http.NoBody && req.Header.Get("Content-Encoding") == "" {
+		body, err := gzipBody(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("compressing request: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	encoding := resp.Header.Get("Content-Encoding")
+	if encoding != "" && encoding != "gzip" && encoding != "identity" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: response has unsupported Content-Encoding %q", req.Method, req.URL.Redacted(), encoding)
+	}
+	resp.Body = decompressBody(resp.Body, encoding == "gzip")
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+func gzipBody(r io.ReadCloser) ([]byte, error) {
+	defer r.Close()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.Copy(zw, r); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// responseBody reads a response, gunzipping it if it starts like a gzip
+// stream, and fails once more than maxResponseBytes come out of it.
+type responseBody struct {
+	body     io.ReadCloser
+	header   bool
+	r        io.Reader
+	n        int64
+	inflated bool
+	err      error
+}
+
+// decompressBody wraps body; header is whether the response claimed to be
+// gzip. Servers that get it wrong either way are believed by their bytes,
+// not their header: JSON never starts with the gzip magic number.
+func decompressBody(body io.ReadCloser, header bool) io.ReadCloser {
+	return &responseBody{body: body, header: header}
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.r == nil {
+		buffered := bufio.NewReader(b.body)
+		b.r = buffered
+		if magic, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+			zr, err := gzip.NewReader(buffered)
+			if err != nil {
+				b.err = fmt.Errorf("decompressing response: %w", err)
+				return 0, b.err
+			}
+			b.r, b.inflated = zr, true
+		}
+		switch {
+		case b.header && !b.inflated:
+			debugf("response claims gzip Content-Encoding but isn't compressed; reading it as is")
+		case !b.header && b.inflated:
+			debugf("response is gzip-compressed without saying so; decompressing it")
+		}
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.n > maxResponseBytes {
+		b.err = fmt.Errorf("response is larger than %d bytes", maxResponseBytes)
+		return n, b.err
+	}
+	if err != nil && err != io.EOF && b.inflated {
+		b.err = fmt.Errorf("decompressing response: %w", err)
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	if zr, ok := b.r.(*gzip.Reader); ok {
+		zr.Close()
+	}
+	return b.body.Close()
+}
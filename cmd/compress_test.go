@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const compressBody = `{"message":"Passwords are hashed with SHA-1 in web/login.py."}`
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compressGet fetches url through a compressTransport and returns the body
+// as the backends read it.
+func compressGet(t *testing.T, url string) (*http.Response, string, error) {
+	t.Helper()
+	client := &http.Client{Transport: &compressTransport{next: http.DefaultTransport}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, string(data), err
+}
+
+func TestCompressTransportGzipResponse(t *testing.T) {
+	body := gzipped(t, compressBody)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("Accept-Encoding = %q, want gzip", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	resp, got, err := compressGet(t, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != compressBody {
+		t.Errorf("body = %q, want %q", got, compressBody)
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding = %q after decompressing, want none", enc)
+	}
+	if resp.ContentLength != -1 || !resp.Uncompressed {
+		t.Errorf("ContentLength = %d, Uncompressed = %v; want -1 and true", resp.ContentLength, resp.Uncompressed)
+	}
+}
+
+func TestCompressTransportLyingContentEncoding(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Claims gzip but sends the JSON as is.
+		w.Header().Set("Content-Encoding", "gzip")
+		io.WriteString(w, compressBody)
+	}))
+	defer plain.Close()
+	body := gzipped(t, compressBody)
+	unlabelled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Compresses without saying so.
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer unlabelled.Close()
+
+	for name, url := range map[string]string{"claims gzip": plain.URL, "unlabelled gzip": unlabelled.URL} {
+		_, got, err := compressGet(t, url)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got != compressBody {
+			t.Errorf("%s: body = %q, want %q", name, got, compressBody)
+		}
+	}
+}
+
+func TestCompressTransportCorruptGzip(t *testing.T) {
+	body := gzipped(t, compressBody)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body[:len(body)-6])
+	}))
+	defer srv.Close()
+
+	if _, _, err := compressGet(t, srv.URL); err == nil || !strings.Contains(err.Error(), "decompressing response") {
+		t.Errorf("reading a truncated gzip body: err = %v, want a decompression error", err)
+	}
+}
+
+func TestCompressTransportUnsupportedEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, compressBody)
+	}))
+	defer srv.Close()
+
+	if _, _, err := compressGet(t, srv.URL); err == nil || !strings.Contains(err.Error(), `unsupported Content-Encoding "br"`) {
+		t.Errorf("err = %v, want an unsupported Content-Encoding error", err)
+	}
+}
+
+func TestCompressTransportResponseCap(t *testing.T) {
+	saved := maxResponseBytes
+	t.Cleanup(func() { maxResponseBytes = saved })
+	maxResponseBytes = 1 << 10
+
+	// Well under the cap compressed, far over it inflated.
+	body := gzipped(t, strings.Repeat("a", 64<<10))
+	if int64(len(body)) >= maxResponseBytes {
+		t.Fatalf("compressed body is %d bytes, want it under the cap", len(body))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	if _, _, err := compressGet(t, srv.URL); err == nil || !strings.Contains(err.Error(), "larger than 1024 bytes") {
+		t.Errorf("err = %v, want the response cap error", err)
+	}
+}
+
+func TestCompressTransportRequestBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("request Content-Encoding = %q, want gzip", r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("request body isn't gzip: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(zr)
+		if string(data) != compressBody {
+			t.Errorf("request body = %q, want %q", data, compressBody)
+		}
+		io.WriteString(w, "{}")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &compressTransport{next: http.DefaultTransport, compressRequests: true}}
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader(compressBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// aimdCooldown groups the 429s of requests that were in flight together, so
+// one burst halves the concurrency once instead of once per response.
+var aimdCooldown = time.Second
+
+// ConcurrencyPool bounds how many prompts run at once. Its limit can move
+// while prompts are queued: operators resize it with SIGUSR1 and SIGUSR2, and
+// with -concurrency-auto it tunes itself from rate-limit feedback, allowing
+// one more prompt after a full round of healthy responses and halving the
+// limit when the backend answers 429. Lowering the limit doesn't interrupt
+// running prompts; queued ones wait until enough of them have finished.
+type ConcurrencyPool struct {
+	min, max int
+	adaptive bool
+
+	mu           sync.Mutex
+	limit        int
+	inUse        int
+	waiters      []chan struct{}
+	successes    int
+	lastDecrease time.Time
+}
+
+// NewConcurrencyPool returns a pool that starts at limit and stays between
+// min and max.
+func NewConcurrencyPool(min, max, limit int) *ConcurrencyPool {
+	return &ConcurrencyPool{min: min, max: max, limit: limit}
+}
+
+// NewAdaptiveConcurrency returns a pool that starts at min and adapts to the
+// responses passed to Record.
+func NewAdaptiveConcurrency(min, max int) *ConcurrencyPool {
+	return &ConcurrencyPool{min: min, max: max, limit: min, adaptive: true}
+}
+
+// Acquire takes a slot, giving up if ctx is cancelled first so that queued
+// prompts never block a cancelled run. It never grants a slot under a
+// context that is already done, even one free at that moment, so a
+// cancelled prompt isn't started only to fail. Slots are granted in the
+// order they were asked for.
+func (p *ConcurrencyPool) Acquire(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	p.mu.Lock()
+	if p.inUse < p.limit && len(p.waiters) == 0 {
+		p.inUse++
+		p.mu.Unlock()
+		return true
+	}
+	ready := make(chan struct{})
+	p.waiters = append(p.waiters, ready)
+	p.mu.Unlock()
+
+	select {
+	case <-ready:
+		// select picks at random when ctx was cancelled as the slot was
+		// granted.
+		if ctx.Err() != nil {
+			p.Release()
+			return false
+		}
+		return true
+	case <-ctx.Done():
+		p.mu.Lock()
+		for i, w := range p.waiters {
+			if w == ready {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				p.mu.Unlock()
+				return false
+			}
+		}
+		p.mu.Unlock()
+		// The slot was granted as ctx was cancelled; pass it on.
+		p.Release()
+		return false
+	}
+}
+
+// Release frees a slot taken with Acquire.
+func (p *ConcurrencyPool) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inUse--
+	p.grant()
+}
+
+// grant hands free slots to the longest-waiting prompts. p.mu must be held.
+func (p *ConcurrencyPool) grant() {
+	for p.inUse < p.limit && len(p.waiters) > 0 {
+		close(p.waiters[0])
+		p.waiters = p.waiters[1:]
+		p.inUse++
+	}
+}
+
+// Record adjusts an adaptive pool's limit for the status of a response.
+func (p *ConcurrencyPool) Record(status int) {
+	if p == nil || !p.adaptive {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case status == http.StatusTooManyRequests:
+		p.successes = 0
+		if time.Since(p.lastDecrease) < aimdCooldown {
+			return
+		}
+		p.lastDecrease = time.Now()
+		limit := p.limit / 2
+		if limit < p.min {
+			limit = p.min
+		}
+		if limit != p.limit {
+			debugf("concurrency %d -> %d after a 429", p.limit, limit)
+			p.limit = limit
+		}
+	case status < 300:
+		p.successes++
+		if p.successes >= p.limit && p.limit < p.max {
+			p.successes = 0
+			p.limit++
+			debugf("concurrency %d -> %d", p.limit-1, p.limit)
+			p.grant()
+		}
+	}
+}
+
+// Resize moves the limit by delta within the bounds and returns the limits
+// before and after.
+func (p *ConcurrencyPool) Resize(delta int) (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.limit
+	p.limit += delta
+	if p.limit < p.min {
+		p.limit = p.min
+	}
+	if p.limit > p.max {
+		p.limit = p.max
+	}
+	if p.limit != old {
+		p.successes = 0
+		p.grant()
+	}
+	return old, p.limit
+}
+
+// Limit is the current concurrency.
+func (p *ConcurrencyPool) Limit() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.limit
+}
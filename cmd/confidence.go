@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// ConfidenceThreshold is -min-confidence. Results scored below Min are
+// reported apart from the rest, as low confidence, and never fail a run.
+type ConfidenceThreshold struct {
+	Min float64
+	// Unscored counts results without a score as below the threshold; by
+	// default they are kept with the confident ones.
+	Unscored bool
+	// Hide leaves low-confidence results out of text output. JSON reports
+	// still list them.
+	Hide bool
+}
+
+// lowConfidence is the -min-confidence threshold; nil when there is none.
+var lowConfidence *ConfidenceThreshold
+
+// Below reports whether f is a result under the threshold. Local checks and
+// plugins report exact matches, so they never are.
+func (t *ConfidenceThreshold) Below(f Finding) bool {
+	if t == nil || !f.HasResult() || f.Source == SourceLocal || f.Source == SourcePlugin {
+		return false
+	}
+	if f.Score == nil {
+		return t.Unscored
+	}
+	return *f.Score < t.Min
+}
+
+// hidden reports whether f is left out of text output.
+func (t *ConfidenceThreshold) hidden(f Finding) bool {
+	return t != nil && t.Hide && t.Below(f)
+}
+
+// writeLowConfidence lists the low-confidence results at the end of the
+// text summary, or only counts them with -show-low-confidence=false.
+func writeLowConfidence(w io.Writer, r *Report) {
+	t := lowConfidence
+	if t == nil {
+		return
+	}
+	below := fmt.Sprintf("scored below %.2f", t.Min)
+	if t.Unscored {
+		below += " or unscored"
+	}
+	if r.Summary.LowConfidence == 0 || t.Hide {
+		fmt.Fprintf(w, "Low confidence: %d results %s\n", r.Summary.LowConfidence, below)
+		return
+	}
+	fmt.Fprintf(w, "Low confidence: %d results %s, not counted as findings:\n", r.Summary.LowConfidence, below)
+	for _, f := range r.LowConfidence {
+		score := "unscored"
+		if f.Score != nil {
+			score = fmt.Sprintf("%.2f", *f.Score)
+		}
+		fmt.Fprintf(w, "  [%s] %s %s: %s\n", score, f.CodebaseLabel(), f.Audit, f.Prompt)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional treeko.yaml configuration file. JSON is accepted as
+// well since it is a subset of YAML.
+type Config struct {
+	Codebases []CodebaseConfig `yaml:"codebases"`
+	Plugins   []PluginConfig   `yaml:"plugins"`
+	Hooks     HooksConfig      `yaml:"hooks"`
+	Filters   []FilterRule     `yaml:"filters"`
+	// SeverityOverrides refit the severities of matching findings, before
+	// filters, policies and the exit code see them.
+	SeverityOverrides []SeverityOverride `yaml:"severityOverrides"`
+	// Redact adds patterns to the built-in ones secrets are redacted from
+	// findings with.
+	Redact []string `yaml:"redact"`
+	// Audits switches audits on or off by ID. Audits it doesn't list run.
+	Audits map[string]bool `yaml:"audits"`
+	// Aliases map friendly names to codebase IDs. An alias can stand in for
+	// the ID wherever a codebase is named, and labels its findings.
+	Aliases map[string]string `yaml:"aliases"`
+	// Outputs select the finding fields of each output, by output name.
+	Outputs map[string]OutputConfig `yaml:"outputs"`
+	// Sourcegraph is the instance prompts with a sourcegraphQuery search.
+	Sourcegraph struct {
+		URL string `yaml:"url"`
+	} `yaml:"sourcegraph"`
+}
+
+// CodebaseConfig describes one codebase to audit. Audits, when set, limits
+// the run to those audit IDs for this codebase only. Backend overrides
+// -backend for the codebase.
+type CodebaseConfig struct {
+	ID       string   `yaml:"id" json:"id"`
+	Branch   string   `yaml:"branch" json:"branch,omitempty"`
+	Revision string   `yaml:"revision" json:"revision,omitempty"`
+	Audits   []string `yaml:"audits" json:"audits,omitempty"`
+	Backend  string   `yaml:"backend" json:"backend,omitempty"`
+	// Alias is the friendly name findings are labeled with, from the
+	// config's aliases.
+	Alias string `yaml:"-" json:"-"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, cb := range cfg.Codebases {
+		if cb.ID == "" {
+			return nil, fmt.Errorf("%s: codebases[%d] has no id", path, i)
+		}
+		if seen[cb.ID] {
+			return nil, fmt.Errorf("%s: codebase '%s' is listed twice", path, cb.ID)
+		}
+		if cb.Backend != "" && cb.Backend != BackendGreptile && cb.Backend != BackendOpenAI {
+			return nil, fmt.Errorf("%s: codebase '%s' has unknown backend '%s'", path, cb.ID, cb.Backend)
+		}
+		seen[cb.ID] = true
+	}
+	names := make(map[string]bool)
+	for i, p := range cfg.Plugins {
+		if p.Name == "" || p.Command == "" {
+			return nil, fmt.Errorf("%s: plugins[%d] needs a name and a command", path, i)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("%s: plugin '%s' is listed twice", path, p.Name)
+		}
+		names[p.Name] = true
+	}
+	for alias, id := range cfg.Aliases {
+		if alias == "" || id == "" {
+			return nil, fmt.Errorf("%s: aliases need a name and a codebase ID", path)
+		}
+		if _, ok := cfg.Aliases[id]; ok {
+			return nil, fmt.Errorf("%s: alias '%s' points at the alias '%s'", path, alias, id)
+		}
+	}
+	if err := cfg.Hooks.validate(path); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ResolveCodebase returns the codebase ID name stands for and the alias to
+// label it with. An alias resolves to its ID; an ID with aliases is labeled
+// by the first of them in sorted order. A nil config resolves nothing.
+func (c *Config) ResolveCodebase(name string) (id, alias string) {
+	if c == nil {
+		return name, ""
+	}
+	if id, ok := c.Aliases[name]; ok {
+		return id, name
+	}
+	for a, id := range c.Aliases {
+		if id == name && (alias == "" || a < alias) {
+			alias = a
+		}
+	}
+	return name, alias
+}
+
+// ResolveAliases replaces the aliases among the IDs of codebases and labels
+// each codebase with its alias. Two entries for the same codebase are an
+// error.
+func (c *Config) ResolveAliases(codebases []CodebaseConfig) error {
+	seen := make(map[string]string)
+	for i := range codebases {
+		cb := &codebases[i]
+		name := cb.ID
+		cb.ID, cb.Alias = c.ResolveCodebase(name)
+		if other, ok := seen[cb.ID]; ok {
+			return fmt.Errorf("codebases '%s' and '%s' are the same codebase", other, name)
+		}
+		seen[cb.ID] = name
+	}
+	return nil
+}
+
+// codebaseLabel is how text output names a codebase: by its alias if it has
+// one.
+func codebaseLabel(id, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return id
+}
+
+// Validate checks the configuration against the audits available for the
+// run, which may include custom prompt files.
+func (c *Config) Validate(path string, audits []Audit) error {
+	for id := range c.Audits {
+		if findAudit(audits, id) == nil {
+			return fmt.Errorf("%s: audits references unknown audit '%s'", path, id)
+		}
+	}
+	for _, cb := range c.Codebases {
+		for _, id := range cb.Audits {
+			if findAudit(audits, id) == nil {
+				return fmt.Errorf("%s: codebase '%s' references unknown audit '%s'", path, cb.ID, id)
+			}
+		}
+	}
+	if err := CompileFilters(c.Filters, audits); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := CompileSeverityOverrides(c.SeverityOverrides, audits); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, p := range c.Redact {
+		if _, err := compileRedactPattern(p); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := validateOutputs(c.Outputs); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func hasCodebase(codebases []CodebaseConfig, id string) bool {
+	for _, cb := range codebases {
+		if cb.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func findAudit(audits []Audit, id string) *Audit {
+	for i := range audits {
+		if audits[i].ID == id {
+			return &audits[i]
+		}
+	}
+	return nil
+}
+
+// selectAudits returns the audits named by ids, or all of them when ids is
+// empty.
+func selectAudits(audits []Audit, ids []string) []Audit {
+	if len(ids) == 0 {
+		return audits
+	}
+	var selected []Audit
+	for _, id := range ids {
+		if a := findAudit(audits, id); a != nil {
+			selected = append(selected, *a)
+		}
+	}
+	return selected
+}
+
+// EnabledAudits returns the audits the run should use: those named by ids
+// (from -audits) when it is set, otherwise every audit not switched off in
+// the config file.
+func EnabledAudits(audits []Audit, switches map[string]bool, ids []string) ([]Audit, error) {
+	if len(ids) > 0 {
+		for _, id := range ids {
+			if findAudit(audits, id) == nil {
+				return nil, fmt.Errorf("unknown audit '%s'", id)
+			}
+		}
+		return selectAudits(audits, ids), nil
+	}
+	var enabled []Audit
+	for _, a := range audits {
+		if on, ok := switches[a.ID]; !ok || on {
+			enabled = append(enabled, a)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil, fmt.Errorf("every audit is switched off")
+	}
+	return enabled, nil
+}
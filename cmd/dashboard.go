@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboardRefresh is how often the dashboard is redrawn while nothing
+// happens, to keep the elapsed time moving.
+const dashboardRefresh = 250 * time.Millisecond
+
+// dashboardBarWidth is the width of an audit's progress bar.
+const dashboardBarWidth = 20
+
+// ANSI sequences the dashboard draws with.
+const (
+	escAltScreen    = "\x1b[?1049h\x1b[?25l"
+	escMainScreen   = "\x1b[?25h\x1b[?1049l"
+	escHome         = "\x1b[H"
+	escClearLine    = "\x1b[K"
+	escClearBelow   = "\x1b[J"
+	styleBoldRed    = "\x1b[1;31m"
+	styleRed        = "\x1b[31m"
+	styleYellow     = "\x1b[33m"
+	styleCyan       = "\x1b[36m"
+	styleBoldYellow = "\x1b[1;33m"
+)
+
+// severityStyles color the dashboard's severities.
+var severityStyles = map[Severity]string{
+	SeverityCritical: styleBoldRed,
+	SeverityHigh:     styleRed,
+	SeverityMedium:   styleYellow,
+	SeverityLow:      styleCyan,
+	SeverityInfo:     styleGray,
+}
+
+// Dashboard draws a run on the terminal for -tui: a progress bar for each
+// audit, the findings so far by severity, and a pane listing the findings
+// as they arrive, which follows the newest unless scrolled with the arrow
+// keys, j and k, PgUp and PgDn, g (top) and G (follow again). It takes
+// over the terminal's alternate screen until Close, and holds back what is
+// logged meanwhile so it doesn't tear the screen, showing only the last
+// line at the bottom.
+type Dashboard struct {
+	out     *os.File
+	color   bool
+	started time.Time
+	restore func()
+	redraw  chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu       sync.Mutex
+	audits   []*dashboardAudit
+	counts   map[Severity]int
+	errors   int
+	findings []dashboardLine
+	// offset is the first finding shown while not following.
+	offset int
+	follow bool
+	// pane is how many findings the last frame had room for.
+	pane    int
+	logs    bytes.Buffer
+	lastLog string
+}
+
+type dashboardAudit struct {
+	codebase, name string
+	total, done    int
+}
+
+// dashboardLine is one finding in the pane: its tag, e.g. "[HIGH]", and
+// what follows it.
+type dashboardLine struct {
+	severity Severity
+	tag      string
+	text     string
+}
+
+// dashboard is drawn with -tui; nil otherwise.
+var dashboard *Dashboard
+
+// StartDashboard takes over the terminal out is attached to. Keys are read
+// from stdin when it is a terminal too.
+func StartDashboard(out *os.File) *Dashboard {
+	d := &Dashboard{
+		out:     out,
+		color:   colorEnabled(out, false),
+		started: time.Now(),
+		redraw:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		counts:  make(map[Severity]int),
+		follow:  true,
+	}
+	log.SetOutput(dashboardLog{d})
+	io.WriteString(out, escAltScreen)
+	if isTerminal(os.Stdin) {
+		if restore, err := rawTerminal(os.Stdin); err == nil {
+			d.restore = restore
+			go d.readKeys(os.Stdin)
+		} else {
+			d.setLastLog("Scrolling is off: " + err.Error())
+		}
+	}
+	go d.loop()
+	return d
+}
+
+// Close gives the terminal back and writes what was logged while the
+// dashboard was up to stderr.
+func (d *Dashboard) Close() {
+	if d == nil {
+		return
+	}
+	close(d.done)
+	<-d.stopped
+	io.WriteString(d.out, escMainScreen)
+	if d.restore != nil {
+		d.restore()
+	}
+	log.SetOutput(os.Stderr)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	os.Stderr.Write(d.logs.Bytes())
+}
+
+// StartAudit adds a progress bar for an audit of codebase with total
+// prompts.
+func (d *Dashboard) StartAudit(codebase, name string, total int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.audits = append(d.audits, &dashboardAudit{codebase: codebase, name: name, total: total})
+	d.mu.Unlock()
+	d.changed()
+}
+
+// PromptDone advances the progress bar of an audit by one prompt, whether
+// it was answered, failed or skipped.
+func (d *Dashboard) PromptDone(codebase, name string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	for _, a := range d.audits {
+		if a.codebase == codebase && a.name == name && a.done < a.total {
+			a.done++
+			break
+		}
+	}
+	d.mu.Unlock()
+	d.changed()
+}
+
+// Record counts a finding and lists it in the pane if it has a result or
+// an error.
+func (d *Dashboard) Record(f Finding) {
+	if d == nil {
+		return
+	}
+	line := dashboardLine{severity: f.Severity}
+	switch {
+	case f.Error != "":
+		line.tag = "[ERROR]"
+		line.text = fmt.Sprintf("[%s] %s: %s", auditKey(f), f.Prompt, firstLine(f.Error))
+	case f.HasResult():
+		line.tag = "[" + strings.ToUpper(string(f.Severity)) + "]"
+		line.text = fmt.Sprintf("[%s] %s", auditKey(f), firstLine(f.Result))
+		if f.Suppressed {
+			line.text += " (suppressed)"
+		}
+	default:
+		return
+	}
+	d.mu.Lock()
+	if f.Error != "" {
+		d.errors++
+	} else if !f.Suppressed {
+		d.counts[f.Severity]++
+	}
+	d.findings = append(d.findings, line)
+	d.mu.Unlock()
+	d.changed()
+}
+
+// changed asks for a redraw without waiting for the next refresh.
+func (d *Dashboard) changed() {
+	select {
+	case d.redraw <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dashboard) loop() {
+	defer close(d.stopped)
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-ticker.C:
+		case <-d.redraw:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// readKeys scrolls the pane by the keys read from in.
+func (d *Dashboard) readKeys(in io.Reader) {
+	buf := make([]byte, 16)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		switch key := string(buf[:n]); key {
+		case "\x1b[A", "\x1bOA", "k":
+			d.scroll(-1)
+		case "\x1b[B", "\x1bOB", "j":
+			d.scroll(1)
+		case "\x1b[5~", "b":
+			d.scroll(-d.pageSize())
+		case "\x1b[6~", " ":
+			d.scroll(d.pageSize())
+		case "\x1b[H", "\x1bOH", "\x1b[1~", "g":
+			d.mu.Lock()
+			d.follow, d.offset = false, 0
+			d.mu.Unlock()
+			d.changed()
+		case "\x1b[F", "\x1bOF", "\x1b[4~", "G":
+			d.mu.Lock()
+			d.follow = true
+			d.mu.Unlock()
+			d.changed()
+		}
+	}
+}
+
+func (d *Dashboard) pageSize() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pane > 1 {
+		return d.pane - 1
+	}
+	return 1
+}
+
+// scroll moves the pane by delta findings. Scrolling to the bottom follows
+// the newest findings again.
+func (d *Dashboard) scroll(delta int) {
+	d.mu.Lock()
+	bottom := len(d.findings) - d.pane
+	if bottom < 0 {
+		bottom = 0
+	}
+	if d.follow {
+		d.offset = bottom
+	}
+	d.offset += delta
+	if d.offset < 0 {
+		d.offset = 0
+	}
+	d.follow = d.offset >= bottom
+	if d.follow {
+		d.offset = bottom
+	}
+	d.mu.Unlock()
+	d.changed()
+}
+
+func (d *Dashboard) setLastLog(s string) {
+	d.mu.Lock()
+	d.lastLog = s
+	d.mu.Unlock()
+}
+
+// dashboardLog holds back log output while the dashboard is drawn.
+type dashboardLog struct{ d *Dashboard }
+
+func (l dashboardLog) Write(p []byte) (int, error) {
+	l.d.mu.Lock()
+	l.d.logs.Write(p)
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	l.d.lastLog = lines[len(lines)-1]
+	l.d.mu.Unlock()
+	l.d.changed()
+	return len(p), nil
+}
+
+// draw redraws the whole screen, cutting every line to the terminal's
+// width.
+func (d *Dashboard) draw() {
+	width, height := terminalSize(d.out)
+	if width <= 0 {
+		width = 80
+	}
+	if height <= 0 {
+		height = 24
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var lines []string
+	add := func(s string) { lines = append(lines, s) }
+
+	done, total := 0, 0
+	codebases := make(map[string]bool)
+	for _, a := range d.audits {
+		done += a.done
+		total += a.total
+		codebases[a.codebase] = true
+	}
+	elapsed := time.Since(d.started).Round(time.Second)
+	add(colorize(d.color, styleBoldGreen, truncateRunes(fmt.Sprintf("treeko: %d of %d prompts done in %s", done, total, elapsed), width)))
+
+	var counts []string
+	for _, sev := range Severities {
+		name := string(sev)
+		label := fmt.Sprintf("%s%s %d", strings.ToUpper(name[:1]), name[1:], d.counts[sev])
+		counts = append(counts, colorize(d.color && d.counts[sev] > 0, severityStyles[sev], label))
+	}
+	counts = append(counts, colorize(d.color && d.errors > 0, styleBoldYellow, fmt.Sprintf("Errors %d", d.errors)))
+	add(strings.Join(counts, "  "))
+	add("")
+
+	// Running audits come first; those that are done make room for them
+	// when there are more than fit.
+	audits := append([]*dashboardAudit(nil), d.audits...)
+	sort.SliceStable(audits, func(i, j int) bool {
+		return audits[i].done < audits[i].total && audits[j].done >= audits[j].total
+	})
+	rows := (height - 6) / 2
+	if rows < 1 {
+		rows = 1
+	}
+	nameWidth := 0
+	for _, a := range audits {
+		if n := len([]rune(dashboardAuditLabel(a, len(codebases) > 1))); n > nameWidth {
+			nameWidth = n
+		}
+	}
+	if nameWidth > 32 {
+		nameWidth = 32
+	}
+	for i, a := range audits {
+		if i == rows-1 && len(audits) > rows {
+			add(fmt.Sprintf("… and %d more audits", len(audits)-i))
+			break
+		}
+		filled := dashboardBarWidth
+		if a.total > 0 {
+			filled = a.done * dashboardBarWidth / a.total
+		}
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", dashboardBarWidth-filled)
+		label := truncateRunes(dashboardAuditLabel(a, len(codebases) > 1), nameWidth)
+		add(truncateRunes(fmt.Sprintf("%-*s %s %d/%d", nameWidth, label, bar, a.done, a.total), width))
+	}
+	add("")
+
+	pane := height - len(lines) - 2
+	if pane < 1 {
+		pane = 1
+	}
+	d.pane = pane
+	bottom := len(d.findings) - pane
+	if bottom < 0 {
+		bottom = 0
+	}
+	if d.follow || d.offset > bottom {
+		d.offset = bottom
+	}
+	position := "following"
+	if !d.follow {
+		position = fmt.Sprintf("%d-%d", d.offset+1, d.offset+pane)
+	}
+	add(truncateRunes(fmt.Sprintf("Findings (%d, %s; ↑/↓ j/k PgUp/PgDn to scroll, g top, G follow):", len(d.findings), position), width))
+	for i := d.offset; i < d.offset+pane; i++ {
+		if i >= len(d.findings) {
+			add("")
+			continue
+		}
+		f := d.findings[i]
+		style := severityStyles[f.severity]
+		if f.tag == "[ERROR]" {
+			style = styleBoldYellow
+		}
+		text := ""
+		if rest := width - len(f.tag); rest > 1 {
+			text = truncateRunes(f.text, rest-1)
+		}
+		add(colorize(d.color, style, f.tag) + " " + text)
+	}
+	add(colorize(d.color, styleGray, truncateRunes(d.lastLog, width)))
+
+	var b strings.Builder
+	b.WriteString(escHome)
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString(escClearLine)
+	}
+	b.WriteString(escClearBelow)
+	io.WriteString(d.out, b.String())
+}
+
+// dashboardAuditLabel names an audit, after its codebase when the run has
+// several.
+func dashboardAuditLabel(a *dashboardAudit, withCodebase bool) string {
+	if withCodebase {
+		return a.codebase + " " + a.name
+	}
+	return a.name
+}
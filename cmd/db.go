@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // CGO-free SQLite driver
+)
+
+const findingsSchema = `
+CREATE TABLE IF NOT EXISTS findings (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id      TEXT NOT NULL,
+	timestamp   TEXT NOT NULL,
+	codebase    TEXT NOT NULL,
+	git_commit  TEXT,
+	audit       TEXT NOT NULL,
+	prompt      TEXT NOT NULL,
+	severity    TEXT NOT NULL,
+	result      TEXT NOT NULL,
+	error       TEXT,
+	cached      INTEGER NOT NULL,
+	status      TEXT,
+	fingerprint TEXT,
+	notes       TEXT
+);
+CREATE INDEX IF NOT EXISTS findings_run_id ON findings (run_id);
+`
+
+// WriteFindingsDB appends every finding in the report to the SQLite database
+// at path, creating the schema if needed. All rows for a run are inserted in
+// a single transaction.
+func WriteFindingsDB(path string, r *Report) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(findingsSchema); err != nil {
+		return err
+	}
+	for _, column := range []string{"status", "fingerprint", "notes"} {
+		if err := addColumnIfMissing(db, "findings", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO findings
+		(run_id, timestamp, codebase, git_commit, audit, prompt, severity, result, error, cached, status, fingerprint, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, f := range r.Findings {
+		var errText, fingerprint, notes *string
+		if f.Error != "" {
+			errText = &f.Error
+		}
+		if f.Fingerprint != "" {
+			fingerprint = &f.Fingerprint
+		}
+		if len(f.Notes) > 0 {
+			joined := strings.Join(f.Notes, "\n")
+			notes = &joined
+		}
+		_, err := stmt.Exec(r.Metadata.RunID, f.Timestamp.Format(time.RFC3339Nano), r.Metadata.Codebase,
+			r.Metadata.Git.Commit, f.Audit, f.Prompt, string(f.Severity), f.Result, errText, f.Cached, string(f.Status), fingerprint, notes)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing upgrades databases created by older versions, which
+// CREATE TABLE IF NOT EXISTS leaves untouched.
+func addColumnIfMissing(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + decl)
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// RunDeadline stops the run once -max-runtime has passed, however slow the
+// backend is, so a scheduled job can't hang its pipeline.
+type RunDeadline struct {
+	timer *time.Timer
+	// ctx is cancelled when the deadline passes.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	expired bool
+}
+
+// runDeadline is nil when -max-runtime is 0.
+var runDeadline *RunDeadline
+
+// StartRunDeadline calls abort once limit has passed, unless stopped first.
+func StartRunDeadline(limit time.Duration, abort context.CancelFunc) *RunDeadline {
+	d := &RunDeadline{}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.timer = time.AfterFunc(limit, func() {
+		d.mu.Lock()
+		d.expired = true
+		d.mu.Unlock()
+		log.Printf("Run stopped by -max-runtime %s; recording the outstanding prompts as timed out.\n", limit)
+		d.cancel()
+		abort()
+	})
+	return d
+}
+
+// Stop disarms the deadline once the run's work is done, so reporting isn't
+// cut short.
+func (d *RunDeadline) Stop() {
+	if d != nil {
+		d.timer.Stop()
+		d.cancel()
+	}
+}
+
+// Context is cancelled when the deadline passes. Plugins run under it
+// rather than the run's context, which -max-findings and the auth guard
+// cancel too.
+func (d *RunDeadline) Context() context.Context {
+	if d == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// Expired reports whether the deadline cancelled the run.
+func (d *RunDeadline) Expired() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// includeRaw keeps each backend response on its finding, in the outputs
+// whose includeRawResponse is unset.
+var includeRaw = false
+
+// ResultText is a result that is usually a string. Objects, arrays and other
+// values are kept as their compact JSON rather than failing the response.
+type ResultText string
+
+func (r *ResultText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*r = ResultText(s)
+		return nil
+	}
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*r = ""
+		return nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return err
+	}
+	*r = ResultText(compact.String())
+	return nil
+}
+
+// warnedFields are the unmapped response fields already reported, so each
+// is logged once per run.
+var warnedFields sync.Map
+
+// decodeResponse decodes a JSON object into the struct v. Top-level fields v
+// doesn't map are an error with -strict-json and a -debug warning otherwise.
+func decodeResponse(data []byte, v interface{}) error {
+	if strictJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	for _, name := range unmappedFields(data, v) {
+		if _, seen := warnedFields.LoadOrStore(name, true); !seen {
+			debugf("response field %q isn't mapped; it is dropped unless -include-raw is set", name)
+		}
+	}
+	return nil
+}
+
+// unmappedFields lists the top-level keys of the object data that no field
+// of the struct v decodes, matching names case-insensitively as
+// encoding/json does.
+func unmappedFields(data []byte, v interface{}) []string {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var known []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		known = append(known, name)
+	}
+	var unmapped []string
+	for key := range object {
+		mapped := false
+		for _, name := range known {
+			if strings.EqualFold(key, name) {
+				mapped = true
+				break
+			}
+		}
+		if !mapped {
+			unmapped = append(unmapped, key)
+		}
+	}
+	sort.Strings(unmapped)
+	return unmapped
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Granularities for -dedup-by.
+const (
+	DedupContent     = "content"
+	DedupFile        = "file"
+	DedupFingerprint = "fingerprint"
+	DedupSemantic    = "semantic"
+)
+
+// DefaultDedupThreshold is the similarity from which -dedup-by semantic
+// collapses two results.
+const DefaultDedupThreshold = 0.6
+
+// semanticDedupLimit bounds the results without locations -dedup-by
+// semantic compares in a codebase; each is compared with every one kept
+// before it.
+const semanticDedupLimit = 500
+
+// FilteredDuplicate is recorded as the filter of results -dedup-by
+// collapsed into an earlier finding.
+const FilteredDuplicate = "duplicate"
+
+// dedupKey identifies the results of a codebase that -dedup-by mode
+// collapses into one: those with the same text once whitespace and case are
+// normalized, those pointing at the same set of files, or those with the
+// same fingerprint. Semantic deduplication collapses results with locations
+// by file. It is "" for findings that are never collapsed this way, such as
+// results that mention no files when deduplicating by file.
+func dedupKey(mode string, f Finding) string {
+	switch mode {
+	case DedupContent:
+		return f.Codebase + "\x00" + strings.ToLower(strings.Join(strings.Fields(f.Result), " "))
+	case DedupFile, DedupSemantic:
+		if len(f.Locations) == 0 {
+			return ""
+		}
+		seen := make(map[string]bool)
+		var paths []string
+		for _, loc := range f.Locations {
+			if p := NormalizePath(loc.Path); !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		return f.Codebase + "\x00" + strings.Join(paths, "\n")
+	case DedupFingerprint:
+		return f.Codebase + "\x00" + f.Fingerprint
+	}
+	return ""
+}
+
+// similarResult is a result without locations kept by -dedup-by semantic.
+type similarResult struct {
+	tokens      map[string]bool
+	fingerprint string
+}
+
+// similarTo compares f, a result without locations, with those its
+// codebase kept so far. If one is at least as similar as the threshold, it
+// returns the fingerprint of the most similar and the similarity; otherwise
+// f is kept. The caller must hold r.mu.
+func (r *Report) similarTo(f Finding) (string, float64, bool) {
+	tokens := resultTokens(f.Result)
+	if len(tokens) == 0 {
+		return "", 0, false
+	}
+	kept := r.similar[f.Codebase]
+	if len(kept) >= semanticDedupLimit {
+		if !r.similarFull[f.Codebase] {
+			log.Printf("Warning: %s has more than %d results without locations; -dedup-by semantic keeps the rest without comparing them\n", f.Codebase, semanticDedupLimit)
+			if r.similarFull == nil {
+				r.similarFull = make(map[string]bool)
+			}
+			r.similarFull[f.Codebase] = true
+		}
+		return "", 0, false
+	}
+	best, score := -1, 0.0
+	for i, k := range kept {
+		if s := jaccard(tokens, k.tokens); s > score {
+			best, score = i, s
+		}
+	}
+	if best >= 0 && score >= r.dedupThreshold {
+		return kept[best].fingerprint, score, true
+	}
+	if r.similar == nil {
+		r.similar = make(map[string][]similarResult)
+	}
+	r.similar[f.Codebase] = append(kept, similarResult{tokens: tokens, fingerprint: f.Fingerprint})
+	return "", 0, false
+}
+
+// stopWords carry no meaning of their own, so they are left out of the
+// token sets results are compared by.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"can": true, "do": true, "does": true, "for": true, "from": true, "has": true, "have": true,
+	"in": true, "into": true, "is": true, "it": true, "its": true, "may": true, "of": true, "on": true,
+	"or": true, "that": true, "the": true, "there": true, "these": true, "this": true, "those": true,
+	"to": true, "was": true, "were": true, "which": true, "with": true, "without": true, "would": true,
+	"found": true, "codebase": true, "code": true, "appears": true, "seems": true,
+}
+
+// resultTokens normalizes a result into the set of words it is compared by:
+// lowercased, without punctuation or stop words, and with common English
+// suffixes stripped so "validates" and "validated" match.
+func resultTokens(text string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) < 2 || stopWords[word] {
+			continue
+		}
+		tokens[stem(word)] = true
+	}
+	return tokens
+}
+
+// stem strips a plural or verb suffix from longer words.
+func stem(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if len(word) > len(suffix)+3 && strings.HasSuffix(word, suffix) {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// jaccard is the size of the intersection of two token sets over the size
+// of their union.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"testing"
+)
+
+// semanticReport is a report deduplicating by semantic similarity.
+func semanticReport(threshold float64) *Report {
+	r := NewReport(RunMetadata{})
+	r.dedupBy, r.dedupThreshold = DedupSemantic, threshold
+	return r
+}
+
+// addResult records a result without locations, fingerprinted by id.
+func addResult(r *Report, codebase, id, result string) Finding {
+	return r.Add(Finding{Codebase: codebase, Audit: "Security", AuditID: "security", Prompt: id, PromptID: id, Result: result, Fingerprint: id})
+}
+
+func TestSemanticDedupParaphrases(t *testing.T) {
+	for _, tt := range []struct {
+		name, first, second string
+		similarity          float64
+		collapsed           bool
+	}{
+		// 7 of 11 words shared.
+		{"just above", "API responses include stack traces when an exception is raised in production.",
+			"In production, raised exceptions put stack traces into API error responses.", 7.0 / 11, true},
+		// 6 of 11 words shared.
+		{"just below", "Session tokens are not invalidated when a user logs out.",
+			"After a user logs out, their session token is not revoked.", 6.0 / 11, false},
+		// 6 of 10 words shared: the threshold itself collapses.
+		{"at the threshold", "User passwords are hashed with unsalted MD5 before being stored in the database.",
+			"Passwords of users are stored in the database hashed with MD5 without a salt.", 0.6, true},
+		{"unrelated", "User passwords are hashed with unsalted MD5 before being stored in the database.",
+			"Session tokens are not invalidated when a user logs out.", 1.0 / 16, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jaccard(resultTokens(tt.first), resultTokens(tt.second)); math.Abs(got-tt.similarity) > 1e-9 {
+				t.Fatalf("similarity = %.3f, want %.3f", got, tt.similarity)
+			}
+			r := semanticReport(DefaultDedupThreshold)
+			addResult(r, "acme/payments", "first", tt.first)
+			second := addResult(r, "acme/payments", "second", tt.second)
+			if !tt.collapsed {
+				if second.FilteredBy != "" || len(r.Findings) != 2 {
+					t.Errorf("second result filtered by %q, want both kept", second.FilteredBy)
+				}
+				return
+			}
+			if len(r.Findings) != 1 || len(r.Filtered) != 1 {
+				t.Fatalf("%d kept and %d filtered, want the second collapsed", len(r.Findings), len(r.Filtered))
+			}
+			dup := r.Filtered[0]
+			if dup.FilteredBy != FilteredDuplicate || dup.DuplicateOf != "first" || math.Abs(dup.Similarity-tt.similarity) > 1e-9 {
+				t.Errorf("duplicate = filtered by %q, of %q, similarity %.3f; want %s of first with %.3f", dup.FilteredBy, dup.DuplicateOf, dup.Similarity, FilteredDuplicate, tt.similarity)
+			}
+		})
+	}
+}
+
+func TestSemanticDedupScope(t *testing.T) {
+	const (
+		leak       = "API responses include stack traces when an exception is raised in production."
+		paraphrase = "In production, raised exceptions put stack traces into API error responses."
+	)
+
+	// A higher threshold keeps the paraphrase.
+	r := semanticReport(0.7)
+	addResult(r, "acme/payments", "first", leak)
+	if f := addResult(r, "acme/payments", "second", paraphrase); f.FilteredBy != "" {
+		t.Errorf("paraphrase collapsed at threshold 0.7")
+	}
+
+	// Results of other codebases are never compared.
+	r = semanticReport(DefaultDedupThreshold)
+	addResult(r, "acme/payments", "first", leak)
+	if f := addResult(r, "acme/ledger", "second", leak); f.FilteredBy != "" {
+		t.Errorf("result of another codebase collapsed")
+	}
+
+	// Results with locations are deduplicated by file instead.
+	r = semanticReport(DefaultDedupThreshold)
+	r.Add(Finding{Codebase: "acme/payments", Result: "Stack traces leak in api/errors.go", Fingerprint: "first"})
+	if f := r.Add(Finding{Codebase: "acme/payments", Result: "Stack traces leak in api/handlers.go", Fingerprint: "second"}); f.FilteredBy != "" {
+		t.Errorf("similar results about different files collapsed")
+	}
+	if f := r.Add(Finding{Codebase: "acme/payments", Result: "Unrelated issue in api/errors.go", Fingerprint: "third"}); f.DuplicateOf != "first" || f.Similarity != 0 {
+		t.Errorf("result about the same file = duplicate of %q with similarity %g, want first without a similarity", f.DuplicateOf, f.Similarity)
+	}
+}
+
+func TestSemanticDedupLimit(t *testing.T) {
+	var logs bytes.Buffer
+	logOutput := log.Writer()
+	t.Cleanup(func() { log.SetOutput(logOutput) })
+	log.SetOutput(&logs)
+
+	r := semanticReport(DefaultDedupThreshold)
+	// Any two of these share 2 of 4 words, below the threshold.
+	for i := 0; i < semanticDedupLimit; i++ {
+		addResult(r, "acme/payments", fmt.Sprint(i), fmt.Sprintf("Issue number %d", 100+i))
+	}
+	if len(r.Findings) != semanticDedupLimit {
+		t.Fatalf("%d of %d distinct results kept", len(r.Findings), semanticDedupLimit)
+	}
+	// Past the cap even an exact repeat is kept, with one warning.
+	addResult(r, "acme/payments", "again", "Issue number 100")
+	addResult(r, "acme/payments", "again2", "Issue number 101")
+	if len(r.Filtered) != 0 {
+		t.Errorf("%d results collapsed past the cap", len(r.Filtered))
+	}
+	if n := strings.Count(logs.String(), "-dedup-by semantic keeps the rest"); n != 1 {
+		t.Errorf("%d warnings about the cap, want 1:\n%s", n, logs.String())
+	}
+}
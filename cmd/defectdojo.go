@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefectDojoScanType is the DefectDojo parser treeko's export is written for.
+const DefectDojoScanType = "Generic Findings Import"
+
+// dojoClient allows for large imports, which DefectDojo processes before it
+// responds.
+var dojoClient = &http.Client{Timeout: 2 * time.Minute, Transport: newOutboundTransport()}
+
+// dojoFinding is one finding in DefectDojo's Generic Findings Import format.
+type dojoFinding struct {
+	Title            string   `json:"title"`
+	Description      string   `json:"description"`
+	Severity         string   `json:"severity"`
+	Date             string   `json:"date"`
+	CWE              int      `json:"cwe,omitempty"`
+	FilePath         string   `json:"file_path,omitempty"`
+	Line             int      `json:"line,omitempty"`
+	UniqueIDFromTool string   `json:"unique_id_from_tool"`
+	VulnIDFromTool   string   `json:"vuln_id_from_tool,omitempty"`
+	StaticFinding    bool     `json:"static_finding"`
+	DynamicFinding   bool     `json:"dynamic_finding"`
+	Active           bool     `json:"active"`
+	OutOfScope       bool     `json:"out_of_scope,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+}
+
+type dojoImport struct {
+	Findings []dojoFinding `json:"findings"`
+}
+
+func dojoSeverity(s Severity) string {
+	switch s {
+	case SeverityCritical:
+		return "Critical"
+	case SeverityHigh:
+		return "High"
+	case SeverityMedium:
+		return "Medium"
+	case SeverityLow:
+		return "Low"
+	}
+	return "Info"
+}
+
+// DefectDojoExport converts the findings of r that have a result. Suppressed
+// findings are exported inactive so DefectDojo records them as known.
+func DefectDojoExport(r *Report) ([]byte, error) {
+	out := dojoImport{Findings: []dojoFinding{}}
+	for _, f := range r.Findings {
+		if !f.HasResult() {
+			continue
+		}
+		ts := f.Timestamp
+		if ts.IsZero() {
+			ts = r.Metadata.StartedAt
+		}
+		desc := fmt.Sprintf("%s\n\nCodebase: %s\nPrompt: %s", strings.TrimSpace(resultWithNotes(f)), f.Codebase, f.Prompt)
+		if f.Suppressed {
+			desc += "\nSuppressed in " + IgnoreFileName + ": " + f.Justification
+		}
+		df := dojoFinding{
+			Title:            annotationTitle(f),
+			Description:      desc,
+			Severity:         dojoSeverity(f.Severity),
+			Date:             ts.UTC().Format("2006-01-02"),
+			CWE:              f.CWE,
+			UniqueIDFromTool: f.Fingerprint,
+			StaticFinding:    true,
+			Active:           !f.Suppressed,
+			OutOfScope:       f.OutOfScope,
+			Tags:             f.Tags,
+		}
+		if f.AuditID != "" {
+			df.VulnIDFromTool = f.AuditID + "." + f.PromptID
+		}
+		if len(f.Locations) > 0 {
+			df.FilePath = f.Locations[0].Path
+			df.Line = f.Locations[0].Line
+		}
+		out.Findings = append(out.Findings, df)
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// DefectDojo pushes exports to a DefectDojo instance.
+type DefectDojo struct {
+	URL         string
+	Token       string
+	Product     string
+	ProductType string
+	Engagement  string
+	TestTitle   string
+}
+
+// Reimport uploads the export through the reimport-scan endpoint, which
+// updates the findings of the existing test instead of adding duplicates,
+// and creates the product, engagement and test if they are missing.
+func (d *DefectDojo) Reimport(export []byte) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"scan_type", DefectDojoScanType},
+		{"product_name", d.Product},
+		{"engagement_name", d.Engagement},
+		{"test_title", d.TestTitle},
+		{"auto_create_context", "true"},
+		{"active", "true"},
+		{"verified", "false"},
+	}
+	if d.ProductType != "" {
+		fields = append(fields, [2]string{"product_type_name", d.ProductType})
+	}
+	for _, kv := range fields {
+		if err := mw.WriteField(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "treeko-defectdojo.json")
+	if err != nil {
+		return err
+	}
+	fw.Write(export)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	url, err := buildSearchURL(d.URL, nil, "api", "v2", "reimport-scan", "")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+d.Token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := dojoClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("DefectDojo reimport: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var result struct {
+		Test int `json:"test"`
+	}
+	if json.Unmarshal(data, &result) == nil && result.Test != 0 {
+		debugf("DefectDojo reimported into test %d", result.Test)
+	}
+	return nil
+}
+
+// WriteDefectDojoFile writes the export for a manual import.
+func WriteDefectDojoFile(path string, export []byte) error {
+	return reportEncryption.WriteFile(path, append(export, '\n'))
+}
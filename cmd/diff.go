@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// ReportDiff partitions the findings of two reports by fingerprint.
+type ReportDiff struct {
+	Added     []Finding
+	Removed   []Finding
+	Unchanged []Finding
+}
+
+// LoadReport reads a JSON report written by -output json.
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return &r, nil
+}
+
+// withResults returns the findings that produced a result, keyed by
+// fingerprint. Reports written before fingerprints existed are fingerprinted
+// on load.
+func withResults(r *Report) map[string]Finding {
+	out := make(map[string]Finding)
+	for _, f := range r.Findings {
+		if !f.HasResult() {
+			continue
+		}
+		if f.Fingerprint == "" {
+			f.Fingerprint = f.ComputeFingerprint()
+		}
+		out[f.Fingerprint] = f
+	}
+	return out
+}
+
+func DiffReports(old, new *Report) ReportDiff {
+	before := withResults(old)
+	after := withResults(new)
+	var d ReportDiff
+	for fp, f := range after {
+		if _, ok := before[fp]; ok {
+			d.Unchanged = append(d.Unchanged, f)
+		} else {
+			d.Added = append(d.Added, f)
+		}
+	}
+	for fp, f := range before {
+		if _, ok := after[fp]; !ok {
+			d.Removed = append(d.Removed, f)
+		}
+	}
+	for _, list := range [][]Finding{d.Added, d.Removed, d.Unchanged} {
+		sortFindings(list)
+	}
+	return d
+}
+
+func sortFindings(findings []Finding) {
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Codebase != findings[j].Codebase {
+			return findings[i].Codebase < findings[j].Codebase
+		}
+		if findings[i].Audit != findings[j].Audit {
+			return findings[i].Audit < findings[j].Audit
+		}
+		if findings[i].Prompt != findings[j].Prompt {
+			return findings[i].Prompt < findings[j].Prompt
+		}
+		return findings[i].Fingerprint < findings[j].Fingerprint
+	})
+}
+
+// WriteDiff lists the diff. With color, new findings are bold green and
+// suppressed or unchanged ones, which are already known, gray.
+func WriteDiff(w io.Writer, d ReportDiff, color bool) {
+	fmt.Fprintf(w, "Added (%d):\n", len(d.Added))
+	for _, f := range d.Added {
+		line := fmt.Sprintf("  + %s [%s] %s: %s", f.Fingerprint, f.Severity, f.Audit, f.Prompt)
+		if f.Suppressed {
+			fmt.Fprintln(w, colorize(color, styleGray, line+" (suppressed)"))
+		} else {
+			fmt.Fprintln(w, colorize(color, styleBoldGreen, line))
+		}
+	}
+	fmt.Fprintf(w, "Removed (%d):\n", len(d.Removed))
+	for _, f := range d.Removed {
+		fmt.Fprintf(w, "  - %s [%s] %s: %s\n", f.Fingerprint, f.Severity, f.Audit, f.Prompt)
+	}
+	fmt.Fprintf(w, "Unchanged (%d):\n", len(d.Unchanged))
+	for _, f := range d.Unchanged {
+		fmt.Fprintln(w, colorize(color, styleGray, fmt.Sprintf("    %s [%s] %s: %s", f.Fingerprint, f.Severity, f.Audit, f.Prompt)))
+	}
+}
+
+// runDiffCommand compares two saved reports and exits non-zero when the
+// newer one contains findings the older one didn't, unless they are
+// suppressed.
+func runDiffCommand(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	noColor := flags.Bool("no-color", false, "Don't color the output, even in a terminal")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko diff [-no-color] old.json new.json")
+	}
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 2 {
+		flags.Usage()
+		return ExitUsage
+	}
+	old, err := LoadReport(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading report: %v\n", err)
+		return ExitUsage
+	}
+	new, err := LoadReport(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading report: %v\n", err)
+		return ExitUsage
+	}
+	d := DiffReports(old, new)
+	WriteDiff(os.Stdout, d, colorEnabled(os.Stdout, *noColor))
+	for _, f := range d.Added {
+		if !f.Suppressed {
+			return ExitFindings
+		}
+	}
+	return ExitOK
+}
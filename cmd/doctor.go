@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Outcomes of a doctor check.
+const (
+	DoctorPass = "PASS"
+	DoctorWarn = "WARN"
+	DoctorFail = "FAIL"
+)
+
+// doctorResult is the outcome of one check, with a suggestion when it
+// didn't pass.
+type doctorResult struct {
+	Status string
+	Detail string
+	Hint   string
+}
+
+func doctorPass(format string, args ...interface{}) doctorResult {
+	return doctorResult{Status: DoctorPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+// doctorCheck is a named check. Checks run in order, each under its own
+// timeout, and may leave state for the checks after them.
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) doctorResult
+}
+
+// doctor holds what the checks of a treeko doctor run find out.
+type doctor struct {
+	flags      *flag.FlagSet
+	opts       *queryOptions
+	userConfig bool
+
+	target     Target
+	client     *http.Client
+	apiURL     *url.URL
+	proxy      *url.URL
+	repoStatus *http.Response
+	repoBody   []byte
+}
+
+// runDoctorCommand checks the setup treeko needs, printing PASS, WARN or
+// FAIL for each check. It exits 1 if any check fails.
+func runDoctorCommand(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	d := &doctor{flags: flags, opts: addQueryFlags(flags)}
+	cacheDir := flags.String("cache-dir", "", "Cache directory to check for writability")
+	outDir := flags.String("out-dir", "", "Output directory to check for writability")
+	noUserConfig := flags.Bool("no-user-config", false, "Skip the machine-wide config.yaml and prompts in $XDG_CONFIG_HOME/treeko (default ~/.config/treeko)")
+	timeout := flags.Duration("timeout", 10*time.Second, "Give up on each check after this long")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko doctor [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	d.userConfig = !*noUserConfig
+	if *timeout <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -timeout must be positive, got %s\n", *timeout)
+		return ExitUsage
+	}
+
+	checks := []doctorCheck{
+		{"Configuration", d.checkConfig},
+		{"API key", d.checkAPIKey},
+		{"DNS", d.checkDNS},
+		{"TCP", d.checkTCP},
+		{"TLS", d.checkTLS},
+		{"API access", d.checkAPI},
+		{"Codebase index", d.checkIndex},
+	}
+	// The directory flags are read once the configuration check applied
+	// the .treeko file to them.
+	checks = append(checks, doctorCheck{"Cache directory", func(ctx context.Context) doctorResult {
+		return checkWritable(*cacheDir, "-cache-dir")
+	}}, doctorCheck{"Output directory", func(ctx context.Context) doctorResult {
+		return checkWritable(*outDir, "-out-dir")
+	}})
+
+	counts := make(map[string]int)
+	for _, c := range checks {
+		res := runDoctorCheck(c, *timeout)
+		if res.Status == "" {
+			continue
+		}
+		counts[res.Status]++
+		fmt.Printf("%s  %-17s %s\n", res.Status, c.name, res.Detail)
+		if res.Hint != "" {
+			fmt.Printf("      %-17s %s\n", "", res.Hint)
+		}
+	}
+	fmt.Printf("%d passed, %d warnings, %d failed\n", counts[DoctorPass], counts[DoctorWarn], counts[DoctorFail])
+	if counts[DoctorFail] > 0 {
+		return 1
+	}
+	return ExitOK
+}
+
+// runDoctorCheck runs c under timeout. A check that doesn't return in time
+// fails, even if it ignores its context.
+func runDoctorCheck(c doctorCheck, timeout time.Duration) doctorResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan doctorResult, 1)
+	go func() { done <- c.run(ctx) }()
+	select {
+	case res := <-done:
+		return res
+	case <-ctx.Done():
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("no answer within %s", timeout), Hint: "Something on the network is holding the connection; check firewalls and proxies, or raise -timeout"}
+	}
+}
+
+// checkConfig resolves the codebase the way treeko query does and reports
+// where the settings came from.
+func (d *doctor) checkConfig(ctx context.Context) doctorResult {
+	var sources []string
+	dotPath := FindDotFile(".")
+	if dotPath != "" {
+		sources = append(sources, dotPath)
+	}
+	target, backend, err := d.opts.setup(d.flags)
+	if err != nil {
+		return doctorResult{Status: DoctorFail, Detail: err.Error(), Hint: "Fix the file named above; treeko audit stops on the same error"}
+	}
+	d.target, d.client = target, backend.Client
+	if *d.opts.config != "" {
+		if _, err := LoadConfig(*d.opts.config); err != nil {
+			return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("loading config: %v", err), Hint: "Fix the configuration file; treeko audit stops on the same error"}
+		}
+		sources = append(sources, *d.opts.config)
+	}
+	var user *UserDefaults
+	if d.userConfig {
+		user, err = LoadUserDefaults(UserConfigDir())
+	}
+	if err != nil {
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("loading user config: %v", err), Hint: "Fix it, or pass -no-user-config to treeko audit to ignore it"}
+	}
+	if user != nil {
+		if user.ConfigPath != "" {
+			sources = append(sources, user.ConfigPath)
+		}
+		if user.PromptsDir != "" {
+			sources = append(sources, user.PromptsDir)
+		}
+	}
+	from := "-config"
+	codebaseSet := false
+	d.flags.Visit(func(f *flag.Flag) { codebaseSet = codebaseSet || f.Name == "codebase" })
+	switch {
+	case codebaseSet:
+		from = "-codebase"
+	case target.Codebase == CodebaseID:
+		return doctorResult{Status: DoctorWarn, Detail: fmt.Sprintf("no codebase configured, using the placeholder %s", CodebaseID),
+			Hint: "Bind the checkout with codebase=org/repo in a .treeko file, or pass -codebase"}
+	case dotPath != "" && *d.opts.config == "":
+		from = DotFileName
+	}
+	if len(sources) == 0 {
+		sources = append(sources, "no configuration files")
+	}
+	codebase := target.Codebase
+	if target.Alias != "" {
+		codebase = fmt.Sprintf("%s (%s)", target.Alias, target.Codebase)
+	}
+	return doctorPass("codebase %s from %s; read %s", codebase, from, strings.Join(sources, ", "))
+}
+
+func (d *doctor) checkAPIKey(ctx context.Context) doctorResult {
+	if APIKey == "" || APIKey == "your_greptile_api_key" {
+		return doctorResult{Status: DoctorFail, Detail: "the Greptile API key is not set", Hint: "Set APIKey in cmd/main.go to your key from the Greptile dashboard and rebuild"}
+	}
+	return doctorPass("set (%d characters)", len(APIKey))
+}
+
+// checkDNS resolves the API host, noting a proxy that would resolve it on
+// treeko's behalf.
+func (d *doctor) checkDNS(ctx context.Context) doctorResult {
+	u, err := url.Parse(GreptileAPIUrl)
+	if err != nil {
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("invalid API URL %s: %v", GreptileAPIUrl, err)}
+	}
+	d.apiURL = u
+	if d.proxy, err = http.ProxyFromEnvironment(&http.Request{URL: u}); err != nil {
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("invalid proxy setting: %v", err), Hint: "Fix HTTPS_PROXY or HTTP_PROXY in the environment"}
+	}
+	addrs, err := netSettings.LookupHost(ctx, u.Hostname())
+	if err != nil {
+		if d.proxy != nil {
+			return doctorResult{Status: DoctorWarn, Detail: fmt.Sprintf("%s doesn't resolve here: %v", u.Hostname(), err),
+				Hint: fmt.Sprintf("Requests go through the proxy %s, which resolves the host itself", d.proxy.Host)}
+		}
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("resolving %s: %v", u.Hostname(), err), Hint: "Check the network connection and DNS servers, or set HTTPS_PROXY if the network requires a proxy"}
+	}
+	return doctorPass("%s resolves to %s", u.Hostname(), strings.Join(addrs, ", "))
+}
+
+// hostPort is the address of u, with the scheme's default port.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// checkTCP connects to the API host, or to the proxy if one is set.
+func (d *doctor) checkTCP(ctx context.Context) doctorResult {
+	if d.apiURL == nil {
+		return doctorResult{}
+	}
+	addr, via := hostPort(d.apiURL), ""
+	if d.proxy != nil {
+		addr, via = hostPort(d.proxy), " (proxy)"
+	}
+	start := time.Now()
+	conn, err := netSettings.DialContext(ctx, 0, "tcp", addr)
+	if err != nil {
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("connecting to %s%s: %v", addr, via, err), Hint: "A firewall may block outgoing connections; ask for the host to be allowed, or set HTTPS_PROXY"}
+	}
+	conn.Close()
+	return doctorPass("connected to %s%s in %s", addr, via, formatDurationMs(time.Since(start).Milliseconds()))
+}
+
+// checkTLS completes a TLS handshake with the API host. Through a proxy,
+// the API access check covers it instead.
+func (d *doctor) checkTLS(ctx context.Context) doctorResult {
+	if d.apiURL == nil || d.apiURL.Scheme != "https" {
+		return doctorResult{}
+	}
+	if d.proxy != nil {
+		return doctorResult{Status: DoctorWarn, Detail: "not checked directly, requests go through a proxy", Hint: "The API access check below makes a TLS connection through it"}
+	}
+	dialer := tls.Dialer{NetDialer: netSettings.Dialer(0), Config: &tls.Config{ServerName: d.apiURL.Hostname()}}
+	conn, err := dialer.DialContext(ctx, netSettings.network("tcp"), hostPort(d.apiURL))
+	if err != nil {
+		res := doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("handshake with %s: %v", d.apiURL.Hostname(), err)}
+		var invalid x509.CertificateInvalidError
+		var unknown x509.UnknownAuthorityError
+		switch {
+		case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+			res.Hint = fmt.Sprintf("The system clock may be wrong (it says %s); fix it and retry", time.Now().UTC().Format(time.RFC3339))
+		case errors.As(err, &unknown):
+			res.Hint = "A proxy or antivirus may intercept TLS; add its CA certificate to the system trust store"
+		default:
+			res.Hint = "Something between here and the API interrupts TLS; try another network"
+		}
+		return res
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	cert := state.PeerCertificates[0]
+	return doctorPass("%s, certificate for %s valid until %s", tls.VersionName(state.Version), cert.Subject.CommonName, cert.NotAfter.UTC().Format("2006-01-02"))
+}
+
+// repositoryID is the Greptile ID of a GitHub repository on a branch.
+func repositoryID(target Target) string {
+	branch := target.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	return "github:" + branch + ":" + target.Codebase
+}
+
+// repositoryURL is the API endpoint describing target's repository, the
+// lightest authenticated request there is.
+func repositoryURL(target Target) string {
+	return GreptileIndexURL + "/" + url.PathEscape(repositoryID(target))
+}
+
+// checkAPI makes an authenticated request for the codebase's repository,
+// which the index check then reads.
+func (d *doctor) checkAPI(ctx context.Context) doctorResult {
+	if d.target.Codebase == "" {
+		return doctorResult{}
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", repositoryURL(d.target), nil)
+	if err != nil {
+		return doctorResult{Status: DoctorFail, Detail: err.Error()}
+	}
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return doctorResult{Status: DoctorFail, Detail: err.Error(), Hint: "The checks above point at the cause; rerun with -dump-http for the full exchange"}
+	}
+	defer resp.Body.Close()
+	d.repoBody, _ = io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	elapsed := formatDurationMs(time.Since(start).Milliseconds())
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("Greptile rejected the API key: %s", resp.Status), Hint: "Check the key in the Greptile dashboard; it may have been revoked"}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return doctorResult{Status: DoctorWarn, Detail: fmt.Sprintf("rate limited: %s", resp.Status), Hint: "Wait and retry; for long runs see -rate and -concurrency-auto"}
+	case resp.StatusCode >= 500:
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("Greptile answered %s", resp.Status), Hint: "The service may be degraded; retry later"}
+	}
+	d.repoStatus = resp
+	return doctorPass("authenticated request answered %s in %s", resp.Status, elapsed)
+}
+
+// checkIndex reports the indexing status from the API access check.
+func (d *doctor) checkIndex(ctx context.Context) doctorResult {
+	resp := d.repoStatus
+	if resp == nil {
+		return doctorResult{}
+	}
+	name := repositoryID(d.target)
+	if resp.StatusCode == http.StatusNotFound {
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("%s is not indexed", name), Hint: "Index it in the Greptile dashboard, or audit it with -github-org, which requests indexing"}
+	}
+	if resp.StatusCode >= 300 {
+		return doctorResult{Status: DoctorWarn, Detail: fmt.Sprintf("status of %s: %s", name, resp.Status)}
+	}
+	var repo struct {
+		Status         string `json:"status"`
+		FilesProcessed int    `json:"filesProcessed"`
+		NumFiles       int    `json:"numFiles"`
+	}
+	if err := json.Unmarshal(d.repoBody, &repo); err != nil || repo.Status == "" {
+		return doctorResult{Status: DoctorWarn, Detail: fmt.Sprintf("no indexing status for %s in the response", name)}
+	}
+	if !strings.EqualFold(repo.Status, "completed") {
+		progress := ""
+		if repo.NumFiles > 0 {
+			progress = fmt.Sprintf(", %d of %d files processed", repo.FilesProcessed, repo.NumFiles)
+		}
+		return doctorResult{Status: DoctorWarn, Detail: fmt.Sprintf("%s is %s%s", name, strings.ToLower(repo.Status), progress), Hint: "Results are incomplete until indexing completes"}
+	}
+	return doctorPass("%s is indexed", name)
+}
+
+// checkWritable creates and removes a file in dir. It reports nothing for a
+// directory that isn't configured.
+func checkWritable(dir, flagName string) doctorResult {
+	if dir == "" {
+		return doctorResult{}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("%s: %v", flagName, err), Hint: "Create the directory or choose another with " + flagName}
+	}
+	f, err := os.CreateTemp(dir, ".treeko-doctor-*")
+	if err != nil {
+		return doctorResult{Status: DoctorFail, Detail: fmt.Sprintf("%s: %v", flagName, err), Hint: "Fix the directory's permissions or choose another with " + flagName}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	abs, _ := filepath.Abs(dir)
+	return doctorPass("%s is writable", abs)
+}
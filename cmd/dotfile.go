@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DotFileName binds a checkout to its codebase. treeko looks for it in the
+// working directory and its parents, the way git finds its repository.
+const DotFileName = ".treeko"
+
+// dotFilePathFlags take paths, which a .treeko file gives relative to its
+// own directory.
+var dotFilePathFlags = map[string]bool{
+	"config": true, "policy": true, "cache-dir": true, "repo-root": true, "db": true,
+	"prompts-dir": true, "report-template": true, "report-out": true, "report-pdf": true,
+	"defectdojo-file": true, "openai-system-prompt": true,
+}
+
+// DotFile is a parsed .treeko file. It is either key=value lines, naming the
+// codebase and branch and giving defaults for audit flags, or a
+// configuration file in the format of treeko.yaml.
+type DotFile struct {
+	Path     string
+	Codebase string
+	Branch   string
+	// Flags maps flag names to their default values, in file order.
+	Flags [][2]string
+	// Config is set when the file is a configuration file.
+	Config bool
+}
+
+// FindDotFile returns the path of the nearest .treeko in dir or its
+// parents, or "" if there is none.
+func FindDotFile(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, DotFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// isKeyValue reports whether every line of data, comments and blank lines
+// aside, is key=value with a bare key. Anything else is read as YAML.
+func isKeyValue(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i <= 0 || strings.ContainsAny(strings.TrimSpace(line[:i]), ": \t") {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadDotFile parses the .treeko file at path. Keys other than codebase and
+// branch must name a flag of fs, unless fs is nil.
+func LoadDotFile(path string, fs *flag.FlagSet) (*DotFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dot := &DotFile{Path: path}
+	if !isKeyValue(data) {
+		dot.Config = true
+		return dot, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "codebase":
+			dot.Codebase = value
+		case "branch":
+			dot.Branch = value
+		default:
+			if fs != nil && fs.Lookup(key) == nil {
+				return nil, fmt.Errorf("%s:%d: unknown key '%s'", path, n, key)
+			}
+			if dotFilePathFlags[key] && value != "" && !filepath.IsAbs(value) {
+				value = filepath.Join(filepath.Dir(path), value)
+			}
+			dot.Flags = append(dot.Flags, [2]string{key, value})
+		}
+	}
+	return dot, scanner.Err()
+}
+
+// Apply sets the flags the command line left unset, skipping those fs
+// doesn't define. A configuration file is used as -config.
+func (d *DotFile) Apply(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if d.Config {
+		if !set["config"] && fs.Lookup("config") != nil {
+			return fs.Set("config", d.Path)
+		}
+		return nil
+	}
+	for _, kv := range d.Flags {
+		if set[kv[0]] || fs.Lookup(kv[0]) == nil {
+			continue
+		}
+		if err := fs.Set(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("%s: %s: %v", d.Path, kv[0], err)
+		}
+	}
+	return nil
+}
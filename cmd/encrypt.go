@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AgeExt is added to the name of every encrypted artifact.
+const AgeExt = ".age"
+
+// ageBinary is the age command artifacts are encrypted and decrypted with;
+// $TREEKO_AGE overrides it.
+func ageBinary() string {
+	if path := os.Getenv("TREEKO_AGE"); path != "" {
+		return path
+	}
+	return "age"
+}
+
+// ReportEncryption encrypts report artifacts for -encrypt-reports with age
+// (https://age-encryption.org) before they are written, so a report never
+// reaches disk in the clear. treeko runs the age command rather than
+// implementing the format, and so never handles key material itself:
+// recipients are public keys, and decryption reads the identity file in
+// age.
+type ReportEncryption struct {
+	// args are age's recipient flags: -r for a recipient, -R for a file
+	// listing them.
+	args []string
+}
+
+// reportEncryption encrypts the artifacts of a run; nil writes them as they
+// are.
+var reportEncryption *ReportEncryption
+
+// ParseReportEncryption parses -encrypt-reports: comma-separated
+// age:<recipient> entries, where the recipient is an age public key
+// (age1...) or an SSH public key, and age-recipients:<file> entries naming a
+// file of recipients. It checks that age can be found.
+func ParseReportEncryption(spec string) (*ReportEncryption, error) {
+	e := &ReportEncryption{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		scheme, value, ok := strings.Cut(entry, ":")
+		value = strings.TrimSpace(value)
+		switch {
+		case !ok || value == "":
+			return nil, fmt.Errorf("-encrypt-reports entry '%s' must be age:<recipient> or age-recipients:<file>", entry)
+		case scheme == "age":
+			if !strings.HasPrefix(value, "age1") && !strings.HasPrefix(value, "ssh-") {
+				return nil, fmt.Errorf("-encrypt-reports recipient '%s' is neither an age public key (age1...) nor an SSH public key", value)
+			}
+			e.args = append(e.args, "-r", value)
+		case scheme == "age-recipients":
+			if _, err := os.Stat(value); err != nil {
+				return nil, fmt.Errorf("-encrypt-reports recipients file: %v", err)
+			}
+			e.args = append(e.args, "-R", value)
+		case scheme == "passphrase":
+			return nil, errors.New("-encrypt-reports doesn't support passphrases: age only reads them from a terminal, which unattended runs don't have; use a recipient")
+		default:
+			return nil, fmt.Errorf("-encrypt-reports entry '%s' must be age:<recipient> or age-recipients:<file>", entry)
+		}
+	}
+	if _, err := exec.LookPath(ageBinary()); err != nil {
+		return nil, fmt.Errorf("-encrypt-reports needs age (https://age-encryption.org): %v", err)
+	}
+	return e, nil
+}
+
+// checkEncryptedOutputs rejects -encrypt-reports alongside outputs it can't
+// encrypt: the findings database, which is updated in place, and the
+// webhook and DefectDojo, which must read the report they receive.
+func checkEncryptedOutputs(dbPath, webhookURL, dojoURL string) error {
+	switch {
+	case dbPath != "":
+		return errors.New("-db can't be combined with -encrypt-reports: the findings database is updated in place")
+	case webhookURL != "":
+		return errors.New("-webhook can't be combined with -encrypt-reports: the webhook receives the report in the clear")
+	case dojoURL != "":
+		return errors.New("-defectdojo-url can't be combined with -encrypt-reports: DefectDojo receives the findings in the clear")
+	}
+	return nil
+}
+
+// Path is the name an artifact meant for path is written to.
+func (e *ReportEncryption) Path(path string) string {
+	if e == nil {
+		return path
+	}
+	return path + AgeExt
+}
+
+// Create creates the artifact meant for path, at e.Path(path). What is
+// written to it is encrypted on its way to disk; the file is complete once
+// it is closed.
+func (e *ReportEncryption) Create(path string) (io.WriteCloser, error) {
+	return e.createFile(e.Path(path))
+}
+
+// WriteFile writes the artifact meant for path like os.WriteFile.
+func (e *ReportEncryption) WriteFile(path string, data []byte) error {
+	w, err := e.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		// A write to age fails with a broken pipe once age has quit; what
+		// it printed on the way out says why.
+		if cerr := w.Close(); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	return w.Close()
+}
+
+// createFile creates name itself, encrypting into it unless e is nil.
+func (e *ReportEncryption) createFile(name string) (io.WriteCloser, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return f, nil
+	}
+	w, err := startAge(e.encryptArgs(), f)
+	if err != nil {
+		f.Close()
+		os.Remove(name)
+		return nil, err
+	}
+	w.remove = name
+	return w, nil
+}
+
+func (e *ReportEncryption) encryptArgs() []string {
+	return append([]string{"-e"}, e.args...)
+}
+
+// ageWriter feeds an age process, whose output goes to out.
+type ageWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	out    io.Closer
+	stderr bytes.Buffer
+	// remove is the file to delete if age fails, so no half-written
+	// artifact is left behind.
+	remove string
+}
+
+func startAge(args []string, out io.WriteCloser) (*ageWriter, error) {
+	w := &ageWriter{out: out}
+	w.cmd = exec.Command(ageBinary(), args...)
+	w.cmd.Stdout = out
+	w.cmd.Stderr = &w.stderr
+	stdin, err := w.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	w.stdin = stdin
+	if err := w.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting age: %v", err)
+	}
+	return w, nil
+}
+
+func (w *ageWriter) Write(p []byte) (int, error) {
+	return w.stdin.Write(p)
+}
+
+// Close ends the input and waits for age to finish writing.
+func (w *ageWriter) Close() error {
+	err := w.stdin.Close()
+	if werr := w.cmd.Wait(); werr != nil {
+		err = ageError(werr, w.stderr.String())
+	}
+	if cerr := w.out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && w.remove != "" {
+		os.Remove(w.remove)
+	}
+	return err
+}
+
+// ageError describes a failed age run by what age printed.
+func ageError(err error, stderr string) error {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return fmt.Errorf("age: %s", msg)
+	}
+	return fmt.Errorf("age: %v", err)
+}
+
+// EncryptStdout sends what the process writes to stdout through age into
+// the original stdout, for -encrypt-stdout. Call the returned function
+// before exiting to finish the encrypted stream.
+func (e *ReportEncryption) EncryptStdout() (finish func() error, err error) {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout := os.Stdout
+	w, err := startAge(e.encryptArgs(), nopCloser{stdout})
+	if err != nil {
+		r.Close()
+		pw.Close()
+		return nil, err
+	}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(w, r)
+		copied <- err
+	}()
+	os.Stdout = pw
+	return func() error {
+		os.Stdout = stdout
+		pw.Close()
+		err := <-copied
+		r.Close()
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// runReportCommand runs treeko report's subcommands.
+func runReportCommand(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "decrypt":
+			return runReportDecryptCommand(args[1:])
+		case "verify":
+			return runReportVerifyCommand(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: treeko report decrypt -i <identity-file> [-o <file>] <file.age>")
+	fmt.Fprintln(os.Stderr, "       treeko report verify <report.json> <report.sig> -pub <key.pub>")
+	return ExitUsage
+}
+
+// runReportDecryptCommand decrypts an artifact written with
+// -encrypt-reports, by default next to it without the .age extension.
+func runReportDecryptCommand(args []string) int {
+	flags := flag.NewFlagSet("report decrypt", flag.ExitOnError)
+	identity := flags.String("i", "", "age identity file holding the private key, e.g. from age-keygen; an SSH private key works for SSH recipients")
+	output := flags.String("o", "", "Write the decrypted report here, - for stdout (default: the file's name without .age)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko report decrypt -i <identity-file> [-o <file>] <file.age>")
+		flags.PrintDefaults()
+	}
+	positional := parseFlagsAnywhere(flags, args)
+	if len(positional) != 1 || *identity == "" {
+		flags.Usage()
+		return ExitUsage
+	}
+	in := positional[0]
+	out := *output
+	if out == "" {
+		if !strings.HasSuffix(in, AgeExt) {
+			fmt.Fprintf(os.Stderr, "%s doesn't end in %s; name the output with -o\n", in, AgeExt)
+			return ExitUsage
+		}
+		out = strings.TrimSuffix(in, AgeExt)
+	}
+	if err := DecryptReport(in, *identity, out); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decrypting %s: %v\n", in, err)
+		return ExitErrors
+	}
+	if out != "-" {
+		fmt.Println(out)
+	}
+	return 0
+}
+
+// DecryptReport decrypts the file in with the age identity file identity
+// into out, or to stdout if out is "-". Nothing is left at out if
+// decryption fails.
+func DecryptReport(in, identity, out string) error {
+	if _, err := os.Stat(in); err != nil {
+		return err
+	}
+	var w io.WriteCloser = nopCloser{os.Stdout}
+	if out != "-" {
+		f, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(ageBinary(), "-d", "-i", identity, in)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		err = ageError(err, stderr.String())
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && out != "-" {
+		os.Remove(out)
+	}
+	return err
+}
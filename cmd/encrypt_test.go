@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const stubRecipient = "age1stubrecipient0000000000000000000000000000000000000000000"
+
+// useAgeStub points $TREEKO_AGE at testdata/age-stub.sh, so the tests don't
+// need age installed, and returns the file the stub logs its arguments to.
+func useAgeStub(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the age stub needs sh")
+	}
+	stub, err := filepath.Abs(filepath.Join("testdata", "age-stub.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(t.TempDir(), "age.log")
+	t.Setenv("TREEKO_AGE", stub)
+	t.Setenv("AGE_STUB_LOG", log)
+	t.Setenv("AGE_STUB_FAIL", "")
+	return log
+}
+
+func stubIdentity(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "identity.txt")
+	if err := os.WriteFile(path, []byte("AGE-SECRET-KEY-STUB"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReportEncryptionRoundTrip(t *testing.T) {
+	log := useAgeStub(t)
+	e, err := ParseReportEncryption("age:" + stubRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := os.ReadFile(filepath.Join("testdata", fixtureReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.json")
+	if err := e.WriteFile(path, report); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s exists in the clear: %v", path, err)
+	}
+	encrypted, err := os.ReadFile(path + AgeExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("Passwords are hashed")) {
+		t.Errorf("%s holds the report in the clear", path+AgeExt)
+	}
+
+	out := filepath.Join(dir, "decrypted.json")
+	if err := DecryptReport(path+AgeExt, stubIdentity(t), out); err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, report) {
+		t.Errorf("decrypted report differs from the original:\n%s", decrypted)
+	}
+
+	calls, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	if len(lines) != 2 || lines[0] != "-e -r "+stubRecipient || !strings.HasPrefix(lines[1], "-d -i ") {
+		t.Errorf("age was run as %q, want an encryption to the recipient then a decryption", lines)
+	}
+}
+
+func TestReportEncryptionRecipientsFile(t *testing.T) {
+	log := useAgeStub(t)
+	recipients := filepath.Join(t.TempDir(), "recipients.txt")
+	if err := os.WriteFile(recipients, []byte(stubRecipient+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseReportEncryption("age:" + stubRecipient + ", age-recipients:" + recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteFile(filepath.Join(t.TempDir(), "report.json"), []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	calls, _ := os.ReadFile(log)
+	if want := "-e -r " + stubRecipient + " -R " + recipients + "\n"; string(calls) != want {
+		t.Errorf("age was run as %q, want %q", calls, want)
+	}
+}
+
+func TestReportEncryptionFailureLeavesNoArtifact(t *testing.T) {
+	useAgeStub(t)
+	e, err := ParseReportEncryption("age:" + stubRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AGE_STUB_FAIL", "recipient is invalid")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.json")
+
+	err = e.WriteFile(path, bytes.Repeat([]byte("finding\n"), 1<<14))
+	if err == nil || !strings.Contains(err.Error(), "age: recipient is invalid") {
+		t.Errorf("err = %v, want age's error", err)
+	}
+	w, err := e.Create(filepath.Join(dir, "findings.sarif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "{}")
+	if err := w.Close(); err == nil {
+		t.Error("closing the artifact succeeded, want age's error")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("failed encryption left %s behind", entry.Name())
+	}
+}
+
+func TestDecryptReportFailureLeavesNoOutput(t *testing.T) {
+	useAgeStub(t)
+	e, err := ParseReportEncryption("age:" + stubRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.json")
+	if err := e.WriteFile(path, []byte(`{"findings":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	wrong := filepath.Join(dir, "other-identity.txt")
+	if err := os.WriteFile(wrong, []byte("AGE-SECRET-KEY-OTHER"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err = DecryptReport(path+AgeExt, wrong, path)
+	if err == nil || !strings.Contains(err.Error(), "no identity matched") {
+		t.Errorf("err = %v, want age's error", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("failed decryption left %s behind: %v", path, err)
+	}
+}
+
+func TestParseReportEncryptionErrors(t *testing.T) {
+	useAgeStub(t)
+	for _, spec := range []string{"", "age:", "age:not-a-key", "passphrase:hunter2", "gpg:ABCDEF", "age-recipients:" + filepath.Join(t.TempDir(), "missing")} {
+		if _, err := ParseReportEncryption(spec); err == nil {
+			t.Errorf("ParseReportEncryption(%q) succeeded, want an error", spec)
+		}
+	}
+	t.Setenv("TREEKO_AGE", filepath.Join(t.TempDir(), "no-age"))
+	if _, err := ParseReportEncryption("age:" + stubRecipient); err == nil || !strings.Contains(err.Error(), "needs age") {
+		t.Errorf("without age: err = %v, want a missing age error", err)
+	}
+}
+
+func TestCheckEncryptedOutputs(t *testing.T) {
+	if err := checkEncryptedOutputs("", "", ""); err != nil {
+		t.Errorf("no unencrypted outputs: %v", err)
+	}
+	for _, tt := range []struct {
+		db, webhook, dojo, flag string
+	}{
+		{"findings.db", "", "", "-db"},
+		{"", "https://hooks.example.com/treeko", "", "-webhook"},
+		{"", "", "https://dojo.example.com", "-defectdojo-url"},
+	} {
+		err := checkEncryptedOutputs(tt.db, tt.webhook, tt.dojo)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.flag+" can't be combined with -encrypt-reports") {
+			t.Errorf("%s: err = %v, want it rejected", tt.flag, err)
+		}
+	}
+}
+
+func TestNilReportEncryptionWritesInTheClear(t *testing.T) {
+	var e *ReportEncryption
+	path := filepath.Join(t.TempDir(), "report.json")
+	if got := e.Path(path); got != path {
+		t.Errorf("Path(%q) = %q, want it unchanged", path, got)
+	}
+	if err := e.WriteFile(path, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "{}" {
+		t.Errorf("read %q, %v; want {}", data, err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrorLog logs prompt errors, collapsing those of the same class within a
+// window into one count, so an outage that fails every prompt produces one
+// message per cause instead of one per prompt. Every error is still logged
+// in full at debug level and recorded on its finding.
+type ErrorLog struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	repeats map[string]*errorRepeats
+}
+
+// errorRepeats counts the errors of a class since the first was logged.
+type errorRepeats struct {
+	count int
+	since time.Time
+	timer *time.Timer
+}
+
+// errorLog is nil when -error-log-window is 0, and every error is logged.
+var errorLog *ErrorLog
+
+func NewErrorLog(window time.Duration) *ErrorLog {
+	return &ErrorLog{window: window, repeats: make(map[string]*errorRepeats)}
+}
+
+// Log logs msg, an error of class, unless an error of that class was logged
+// within the window; the repeats are counted and reported when it ends.
+func (l *ErrorLog) Log(class, msg string) {
+	if l == nil {
+		log.Print(msg)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r := l.repeats[class]; r != nil {
+		r.count++
+		debugf("%s", msg)
+		return
+	}
+	log.Print(msg)
+	l.repeats[class] = &errorRepeats{count: 1, since: time.Now(), timer: time.AfterFunc(l.window, func() { l.flush(class) })}
+}
+
+// Flush reports the repeats still being counted, once the run's prompts are
+// done.
+func (l *ErrorLog) Flush() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	var classes []string
+	for class := range l.repeats {
+		classes = append(classes, class)
+	}
+	l.mu.Unlock()
+	sort.Strings(classes)
+	for _, class := range classes {
+		l.flush(class)
+	}
+}
+
+func (l *ErrorLog) flush(class string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.repeats[class]
+	if r == nil {
+		return
+	}
+	r.timer.Stop()
+	delete(l.repeats, class)
+	if r.count > 1 {
+		log.Printf("%s (x%d in the last %s)\n", class, r.count, time.Since(r.since).Round(time.Second))
+	}
+}
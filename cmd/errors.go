@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// Status is the outcome of a prompt, distinguishing "no findings" from
+// the different ways a request can fail.
+type Status string
+
+const (
+	StatusOK          Status = "ok"
+	StatusError       Status = "error"
+	StatusTimeout     Status = "timeout"
+	StatusRateLimited Status = "ratelimited"
+	StatusCancelled   Status = "cancelled"
+	StatusBadResponse Status = "badresponse"
+)
+
+var (
+	ErrTimeout     = errors.New("request timed out")
+	ErrRateLimited = errors.New("rate limited")
+	ErrCancelled   = errors.New("request cancelled")
+	ErrBadResponse = errors.New("response is not JSON")
+)
+
+// badResponseBody is how much of a non-JSON body an error keeps.
+const badResponseBody = 300
+
+// APIError is a non-2xx response from a backend, Greptile unless Backend
+// says otherwise.
+type APIError struct {
+	Backend    string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	backend := backendOrDefault(e.Backend)
+	if e.Message == "" {
+		return fmt.Sprintf("%s returned %d %s", backend, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s returned %d: %s", backend, e.StatusCode, e.Message)
+}
+
+// backendOrDefault names backend in error messages; errors without one
+// came from Greptile.
+func backendOrDefault(backend string) string {
+	if backend == "" {
+		return BackendGreptile
+	}
+	return backend
+}
+
+// Is lets errors.Is(err, ErrRateLimited) match 429 responses.
+func (e *APIError) Is(target error) bool {
+	return target == ErrRateLimited && e.StatusCode == http.StatusTooManyRequests
+}
+
+// BadResponseError is a response whose body isn't JSON, such as a gateway's
+// HTML error page. Body holds its start, with whitespace collapsed.
+type BadResponseError struct {
+	Backend     string
+	StatusCode  int
+	ContentType string
+	Body        string
+}
+
+func (e *BadResponseError) Error() string {
+	backend := backendOrDefault(e.Backend)
+	msg := fmt.Sprintf("%s returned %d with a non-JSON body", backend, e.StatusCode)
+	if e.ContentType != "" {
+		msg += " (" + e.ContentType + ")"
+	}
+	if e.Body == "" {
+		return msg
+	}
+	return msg + ": " + e.Body
+}
+
+func (e *BadResponseError) Is(target error) bool { return target == ErrBadResponse }
+
+// Unwrap exposes a failed status as an *APIError, so auth failures and 429s
+// are counted whatever the body.
+func (e *BadResponseError) Unwrap() error {
+	if e.StatusCode == http.StatusOK {
+		return nil
+	}
+	return &APIError{Backend: e.Backend, StatusCode: e.StatusCode}
+}
+
+// checkJSONBody returns a *BadResponseError if resp's body data is HTML or
+// doesn't parse as JSON. Empty error responses are left to the caller's
+// *APIError.
+func checkJSONBody(backend string, resp *http.Response, data []byte) error {
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 && resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	if mediaType != "text/html" && json.Valid(trimmed) {
+		return nil
+	}
+	return &BadResponseError{
+		Backend:     backend,
+		StatusCode:  resp.StatusCode,
+		ContentType: contentType,
+		Body:        truncateRunes(strings.Join(strings.Fields(string(trimmed)), " "), badResponseBody),
+	}
+}
+
+// ClassifyError maps an error onto the status recorded on a finding.
+func ClassifyError(err error) Status {
+	if err == nil {
+		return StatusOK
+	}
+	if errors.Is(err, ErrCancelled) || errors.Is(err, context.Canceled) {
+		return StatusCancelled
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return StatusTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return StatusTimeout
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return StatusRateLimited
+	}
+	if errors.Is(err, ErrBadResponse) {
+		return StatusBadResponse
+	}
+	return StatusError
+}
+
+// ErrorClass names the cause of err without the details that differ between
+// prompts, such as the URL path or request ID, so failures with the same
+// cause can be counted together, e.g. "connection refused to
+// api.greptile.com" or "greptile returned 503 Service Unavailable".
+func ErrorClass(err error) string {
+	var badErr *BadResponseError
+	if errors.As(err, &badErr) {
+		return fmt.Sprintf("%s returned %d with a non-JSON body", backendOrDefault(badErr.Backend), badErr.StatusCode)
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Sprintf("%s returned %d %s", backendOrDefault(apiErr.Backend), apiErr.StatusCode, http.StatusText(apiErr.StatusCode))
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.class()
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err.Error()
+	}
+	host := urlErr.URL
+	if u, err := url.Parse(urlErr.URL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused to " + host
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset by " + host
+	case errors.As(err, &dnsErr):
+		return "cannot resolve " + dnsErr.Name
+	case ClassifyError(err) == StatusTimeout:
+		return "request to " + host + " timed out"
+	case ClassifyError(err) == StatusCancelled:
+		return "request to " + host + " cancelled"
+	}
+	return fmt.Sprintf("request to %s failed: %v", host, urlErr.Err)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Outputs whose findings the config's outputs section can trim, named after
+// the -output format or the flag that writes them.
+const (
+	OutputJSON          = "json"
+	OutputGroupedJSON   = "grouped-json"
+	OutputSonarQube     = "sonarqube"
+	OutputOCSF          = "ocsf"
+	OutputRunDir        = "out-dir"
+	OutputDefectDojo    = "defectdojo"
+	OutputTemplate      = "report-template"
+	OutputPDF           = "report-pdf"
+	OutputWebhook       = "webhook"
+	OutputPostProcessor = "post-processor"
+)
+
+// reportRequiredFields are the finding fields the report schema requires,
+// which every output of JSON reports keeps.
+var reportRequiredFields = []string{"audit", "prompt", "result", "cached"}
+
+// outputRequiredFields are the finding fields each output can't be written
+// without. The formats with a schema of their own need the error and
+// result to tell results from failed prompts, the severity, and the text
+// their issues are titled and described with.
+var outputRequiredFields = map[string][]string{
+	OutputJSON:          reportRequiredFields,
+	OutputGroupedJSON:   reportRequiredFields,
+	OutputSonarQube:     {"audit", "prompt", "result", "error", "severity"},
+	OutputOCSF:          {"result", "error", "severity"},
+	OutputRunDir:        reportRequiredFields,
+	OutputDefectDojo:    {"audit", "prompt", "result", "error", "severity"},
+	OutputTemplate:      nil,
+	OutputPDF:           {"audit", "prompt", "result", "error", "severity"},
+	OutputWebhook:       reportRequiredFields,
+	OutputPostProcessor: reportRequiredFields,
+}
+
+// OutputConfig selects what the findings of an output carry. Everything
+// else about the output is set by its flags.
+type OutputConfig struct {
+	// IncludeRawResponse keeps each backend response on its finding; unset,
+	// -include-raw decides.
+	IncludeRawResponse *bool `yaml:"includeRawResponse"`
+	// Fields lists the finding fields to keep, by their names in JSON
+	// reports; empty keeps them all. The raw response is selected with
+	// IncludeRawResponse instead.
+	Fields []string `yaml:"fields"`
+}
+
+// outputConfigs are the outputs of the config with -fields applied; nil
+// leaves every output whole.
+var outputConfigs map[string]OutputConfig
+
+// findingField is a field of Finding by its name in JSON reports.
+type findingField struct {
+	name  string
+	index int
+}
+
+// findingFields lists Finding's fields in declaration order.
+var findingFields = func() []findingField {
+	var fields []findingField
+	t := reflect.TypeOf(Finding{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, findingField{name, i})
+		}
+	}
+	return fields
+}()
+
+func isFindingField(name string) bool {
+	for _, f := range findingFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOutputs checks every output of the config's outputs section.
+func validateOutputs(outputs map[string]OutputConfig) error {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := checkOutputFields(name, outputs[name].Fields); err != nil {
+			return fmt.Errorf("outputs: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkOutputFields rejects fields for output if it names something that
+// isn't a finding field or leaves out one the output requires.
+func checkOutputFields(output string, fields []string) error {
+	required, ok := outputRequiredFields[output]
+	if !ok {
+		known := make([]string, 0, len(outputRequiredFields))
+		for name := range outputRequiredFields {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("unknown output '%s', expected one of %s", output, strings.Join(known, ", "))
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	for _, name := range fields {
+		switch {
+		case name == "raw":
+			return fmt.Errorf("%s keeps the raw response with includeRawResponse or -include-raw, not in fields", output)
+		case !isFindingField(name):
+			return fmt.Errorf("%s has unknown finding field '%s'", output, name)
+		}
+	}
+	for _, name := range required {
+		if !hasTag(fields, name) {
+			return fmt.Errorf("%s can't be written without the finding field '%s'", output, name)
+		}
+	}
+	return nil
+}
+
+// rawWanted reports whether any output keeps backend responses, which are
+// only recorded on findings then.
+func rawWanted() bool {
+	if includeRaw {
+		return true
+	}
+	for _, c := range outputConfigs {
+		if c.IncludeRawResponse != nil && *c.IncludeRawResponse {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldSelection is what the findings of a report view keep.
+type fieldSelection struct {
+	// keep holds the fields kept; nil keeps every field but raw, which raw
+	// decides.
+	keep map[string]bool
+	raw  bool
+}
+
+// outputSelection is the selection of output, or nil if the output keeps
+// its findings whole.
+func outputSelection(output string) *fieldSelection {
+	c := outputConfigs[output]
+	s := &fieldSelection{raw: includeRaw}
+	if c.IncludeRawResponse != nil {
+		s.raw = *c.IncludeRawResponse
+	}
+	if len(c.Fields) == 0 && (s.raw || !rawWanted()) {
+		return nil
+	}
+	if len(c.Fields) > 0 {
+		s.keep = make(map[string]bool)
+		for _, name := range c.Fields {
+			s.keep[name] = true
+		}
+	}
+	return s
+}
+
+func (s *fieldSelection) keeps(name string) bool {
+	if name == "raw" {
+		return s.raw
+	}
+	return s.keep == nil || s.keep[name]
+}
+
+// apply clears the fields of f that s leaves out and marks f so its JSON
+// omits them.
+func (s *fieldSelection) apply(f Finding) Finding {
+	v := reflect.ValueOf(&f).Elem()
+	for _, field := range findingFields {
+		if !s.keeps(field.name) {
+			fv := v.Field(field.index)
+			fv.Set(reflect.Zero(fv.Type()))
+		}
+	}
+	if s.keep != nil {
+		f.selection = s
+	}
+	return f
+}
+
+func (s *fieldSelection) applyAll(findings []Finding) []Finding {
+	if findings == nil {
+		return nil
+	}
+	out := make([]Finding, len(findings))
+	for i, f := range findings {
+		out[i] = s.apply(f)
+	}
+	return out
+}
+
+// plainFinding is a Finding encoded without the selection of a view.
+type plainFinding Finding
+
+// MarshalJSON leaves out the fields the finding's report view doesn't keep,
+// including those that are otherwise written even when empty.
+func (f Finding) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(plainFinding(f))
+	if err != nil || f.selection == nil {
+		return data, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		name, _ := t.(string)
+		if !f.selection.keeps(name) {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// findingView is f as output sees it.
+func findingView(f Finding, output string) Finding {
+	if s := outputSelection(output); s != nil {
+		return s.apply(f)
+	}
+	return f
+}
+
+// reportView is the report output sees: r itself when the output keeps its
+// findings whole, or a copy whose findings lack the fields the output
+// leaves out. Renderers are given the view rather than trimming findings
+// themselves; anything computed from the findings, like the summaries, is
+// the whole report's.
+func reportView(r *Report, output string) *Report {
+	s := outputSelection(output)
+	if s == nil {
+		return r
+	}
+	return &Report{
+		SchemaVersion: r.SchemaVersion,
+		Metadata:      r.Metadata,
+		Summary:       r.Summary,
+		Codebases:     r.Codebases,
+		Skipped:       r.Skipped,
+		Findings:      s.applyAll(r.Findings),
+		Filtered:      s.applyAll(r.Filtered),
+		LowConfidence: s.applyAll(r.LowConfidence),
+		Clean:         r.Clean,
+		Clusters:      r.Clusters,
+		Suppressions:  r.Suppressions,
+		Policy:        r.Policy,
+		comparison:    r.comparison,
+		whole:         r,
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter actions.
+const (
+	FilterDrop        = "drop"
+	FilterSetSeverity = "set-severity"
+	FilterTag         = "tag"
+)
+
+// FilteredUntagged is recorded as the filter of results dropped by -tag.
+const FilteredUntagged = "tag"
+
+// FilterRule drops or reclassifies findings matching every condition it
+// sets. Rules are applied in the order they appear in the config, as each
+// finding is added; a dropped finding is not seen by later rules.
+type FilterRule struct {
+	Name     string      `yaml:"name" json:"name"`
+	Match    FilterMatch `yaml:"match" json:"match"`
+	Action   string      `yaml:"action" json:"action"`
+	Severity Severity    `yaml:"severity" json:"severity,omitempty"`
+	Tag      string      `yaml:"tag" json:"tag,omitempty"`
+}
+
+// FilterMatch is the set of conditions of a rule. Audit accepts an audit ID
+// or name and PromptID a prompt's ID, Paths matches only findings whose
+// locations all match one of the patterns, ExcludePaths rejects those, and
+// Prompt and Result are regular expressions.
+type FilterMatch struct {
+	Audit        string     `yaml:"audit" json:"audit,omitempty"`
+	Check        string     `yaml:"check" json:"check,omitempty"`
+	Prompt       string     `yaml:"prompt" json:"prompt,omitempty"`
+	PromptID     string     `yaml:"promptId" json:"promptId,omitempty"`
+	Severity     []Severity `yaml:"severity" json:"severity,omitempty"`
+	Paths        []string   `yaml:"paths" json:"paths,omitempty"`
+	ExcludePaths []string   `yaml:"excludePaths" json:"excludePaths,omitempty"`
+	Result       string     `yaml:"result" json:"result,omitempty"`
+
+	prompt *regexp.Regexp
+	result *regexp.Regexp
+}
+
+// compile validates the rule.
+func (r *FilterRule) compile(audits []Audit) error {
+	if r.Name == "" {
+		return fmt.Errorf("filter has no name")
+	}
+	switch r.Action {
+	case FilterDrop:
+	case FilterSetSeverity:
+		if !r.Severity.Valid() {
+			return fmt.Errorf("filter '%s' has unknown severity '%s'", r.Name, r.Severity)
+		}
+	case FilterTag:
+		if r.Tag == "" {
+			return fmt.Errorf("filter '%s' has no tag", r.Name)
+		}
+	default:
+		return fmt.Errorf("filter '%s' has unknown action '%s'", r.Name, r.Action)
+	}
+	if err := r.Match.compile(audits); err != nil {
+		return fmt.Errorf("filter '%s': %v", r.Name, err)
+	}
+	return nil
+}
+
+// compile validates the conditions and resolves an audit ID to the name
+// findings carry.
+func (m *FilterMatch) compile(audits []Audit) error {
+	for _, s := range m.Severity {
+		if !s.Valid() {
+			return fmt.Errorf("unknown severity '%s'", s)
+		}
+	}
+	if a := findAudit(audits, m.Audit); a != nil {
+		m.Audit = a.Name
+	}
+	var err error
+	if m.Prompt != "" {
+		if m.prompt, err = regexp.Compile(m.Prompt); err != nil {
+			return err
+		}
+	}
+	if m.Result != "" {
+		if m.result, err = regexp.Compile(m.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompileFilters compiles every rule against the audits of the run.
+func CompileFilters(rules []FilterRule, audits []Audit) error {
+	names := make(map[string]bool)
+	for i := range rules {
+		if err := rules[i].compile(audits); err != nil {
+			return err
+		}
+		if names[rules[i].Name] {
+			return fmt.Errorf("filter '%s' is listed twice", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+	return nil
+}
+
+func (m FilterMatch) matches(f Finding) bool {
+	if m.Audit != "" && m.Audit != f.Audit {
+		return false
+	}
+	if m.Check != "" && m.Check != f.Check {
+		return false
+	}
+	if m.PromptID != "" && m.PromptID != f.PromptID {
+		return false
+	}
+	if m.prompt != nil && !m.prompt.MatchString(f.Prompt) {
+		return false
+	}
+	if m.result != nil && !m.result.MatchString(f.Result) {
+		return false
+	}
+	if len(m.Severity) > 0 {
+		found := false
+		for _, s := range m.Severity {
+			if s == f.Severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(m.Paths) > 0 && !allLocationsMatch(m.Paths, f.Locations) {
+		return false
+	}
+	if len(m.ExcludePaths) > 0 && allLocationsMatch(m.ExcludePaths, f.Locations) {
+		return false
+	}
+	return true
+}
+
+// allLocationsMatch reports whether locs is non-empty and every location
+// matches one of the patterns.
+func allLocationsMatch(patterns []string, locs []Location) bool {
+	if len(locs) == 0 {
+		return false
+	}
+	for _, loc := range locs {
+		if !matchesAnyPath(patterns, loc.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAnyPath is matchPattern extended with "dir/**", which matches
+// everything below dir.
+func matchesAnyPath(patterns []string, file string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "/**") {
+			if strings.HasPrefix(file, strings.TrimSuffix(p, "**")) {
+				return true
+			}
+		} else if matchPattern(p, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyFilters runs rules over f. It returns the name of the rule that
+// dropped it, or "" if it was kept. Findings without a result are never
+// filtered, so failures can't be hidden by a rule.
+func ApplyFilters(rules []FilterRule, f *Finding) string {
+	if !f.HasResult() {
+		return ""
+	}
+	for _, r := range rules {
+		if !r.Match.matches(*f) {
+			continue
+		}
+		switch r.Action {
+		case FilterDrop:
+			return r.Name
+		case FilterSetSeverity:
+			f.Severity = r.Severity
+		case FilterTag:
+			if !hasTag(f.Tags, r.Tag) {
+				f.Tags = append(f.Tags, r.Tag)
+			}
+		}
+	}
+	return ""
+}
+
+// hasAnyTag reports whether tags includes one of want.
+func hasAnyTag(tags, want []string) bool {
+	for _, t := range want {
+		if hasTag(tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
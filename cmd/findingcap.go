@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// FindingCap stops the run once enough findings have been reported, for
+// sampling a large codebase with -max-findings. Findings that were filtered,
+// suppressed or have no result don't count.
+type FindingCap struct {
+	limit int
+	abort context.CancelFunc
+
+	mu      sync.Mutex
+	count   int
+	reached bool
+}
+
+// findingCap is nil when -max-findings is 0.
+var findingCap *FindingCap
+
+func NewFindingCap(limit int, abort context.CancelFunc) *FindingCap {
+	return &FindingCap{limit: limit, abort: abort}
+}
+
+// Record counts f and cancels the run when it is the last one allowed.
+func (c *FindingCap) Record(f Finding) {
+	if c == nil || !f.HasResult() || f.FilteredBy != "" || f.Suppressed {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	if c.count >= c.limit && !c.reached {
+		c.reached = true
+		log.Printf("Run capped at %d findings by -max-findings; skipping the remaining prompts.\n", c.limit)
+		c.abort()
+	}
+}
+
+// Reached reports whether the cap cancelled the run.
+func (c *FindingCap) Reached() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reached
+}
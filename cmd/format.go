@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localTime shows timestamps in text output in the local time zone instead
+// of UTC. JSON reports always use UTC.
+var localTime = false
+
+// formatDurationMs renders a duration in milliseconds for people: "340ms",
+// "1.2s", "2m3s".
+func formatDurationMs(ms int64) string {
+	switch {
+	case ms < 1000:
+		return strconv.FormatInt(ms, 10) + "ms"
+	case ms < 60*1000:
+		return strings.TrimSuffix(strconv.FormatFloat(float64(ms)/1000, 'f', 1, 64), ".0") + "s"
+	default:
+		return (time.Duration(ms) * time.Millisecond).Round(time.Second).String()
+	}
+}
+
+// maxResponseChars caps how many characters of a result text output shows;
+// 0 shows results whole. truncatedMarker ends a capped result.
+var (
+	maxResponseChars = 0
+	truncatedMarker  = "… [truncated, full text with -output json]"
+)
+
+// truncateResponse cuts s to at most n runes, preferring to end at a
+// paragraph break, then a line break, in the second half of the text kept.
+// It reports whether anything was cut.
+func truncateResponse(s string, n int) (string, bool) {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s, false
+	}
+	kept := string(runes[:n])
+	for _, sep := range []string{"\n\n", "\n"} {
+		if i := strings.LastIndex(kept, sep); i >= 0 && len([]rune(kept[:i])) >= n/2 {
+			return strings.TrimRight(kept[:i], " \t\n"), true
+		}
+	}
+	return strings.TrimRight(kept, " \t"), true
+}
+
+// formatTime renders a timestamp as RFC 3339, in UTC unless -local-time is
+// set.
+func formatTime(t time.Time) string {
+	if localTime {
+		t = t.Local()
+	} else {
+		t = t.UTC()
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ANSI styles for terminal output.
+const (
+	styleReset     = "\x1b[0m"
+	styleBoldGreen = "\x1b[1;32m"
+	styleGray      = "\x1b[90m"
+)
+
+// colorEnabled reports whether output to f should be colored: only when f is
+// a terminal, and never with -no-color, NO_COLOR set or TERM=dumb.
+func colorEnabled(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in style when color is on.
+func colorize(color bool, style, s string) string {
+	if !color {
+		return s
+	}
+	return style + s + styleReset
+}
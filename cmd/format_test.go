@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateResponse(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		n    int
+		want string
+		cut  bool
+	}{
+		{"short", 10, "short", false},
+		{"unlimited", 0, "unlimited", false},
+		{"héllo wörld", 11, "héllo wörld", false},
+		{"héllo wörld", 4, "héll", true},
+		{"日本語のテキストです", 3, "日本語", true},
+		{"🔒🔑🔓 locks", 2, "🔒🔑", true},
+		// A paragraph break in the second half is preferred over a line
+		// break, and either over a cut mid-line.
+		{"第一段落です。\n\n第二段落はもっと長いです", 12, "第一段落です。", true},
+		{"über die Brücke\nnoch mehr Text", 20, "über die Brücke", true},
+		{"ça\n\nva très bien, merci", 10, "ça\n\nva trè", true},
+	} {
+		got, cut := truncateResponse(tt.in, tt.n)
+		if got != tt.want || cut != tt.cut {
+			t.Errorf("truncateResponse(%q, %d) = %q, %v, want %q, %v", tt.in, tt.n, got, cut, tt.want, tt.cut)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateResponse(%q, %d) split a UTF-8 sequence: %q", tt.in, tt.n, got)
+		}
+		if tt.n > 0 && utf8.RuneCountInString(got) > tt.n {
+			t.Errorf("truncateResponse(%q, %d) kept %d runes", tt.in, tt.n, utf8.RuneCountInString(got))
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// FrameworkAsk, as -framework, asks the backend which frameworks the
+// codebase uses.
+const FrameworkAsk = "ask"
+
+// How the framework of a run was chosen.
+const (
+	FrameworkFromFlag     = "flag"
+	FrameworkFromManifest = "manifest"
+	FrameworkFromQuery    = "query"
+)
+
+// FrameworkInfo is the framework prompts were tailored to. When detection
+// found several, Name is empty and Candidates lists them.
+type FrameworkInfo struct {
+	Name       string   `json:"name,omitempty"`
+	Source     string   `json:"source"`
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+// frameworkDependencies map dependency names, as they appear in each kind
+// of manifest, to the frameworks they indicate. With prefix, a dependency
+// also matches when it continues the name with "-" or "/", as Maven
+// artifacts (spring-boot-starter-web) and Go major versions (echo/v4) do.
+var frameworkDependencies = []struct {
+	files  []string
+	prefix bool
+	deps   map[string]string
+}{
+	{[]string{"requirements.txt", "pyproject.toml", "Pipfile", "setup.py"}, false, map[string]string{
+		"django": "django", "flask": "flask", "fastapi": "fastapi",
+	}},
+	{[]string{"package.json"}, false, map[string]string{
+		"express": "express", "koa": "koa", "fastify": "fastify", "@nestjs/core": "nestjs", "next": "nextjs",
+	}},
+	{[]string{"pom.xml", "build.gradle", "build.gradle.kts"}, true, map[string]string{
+		"spring-boot": "spring", "quarkus": "quarkus", "micronaut": "micronaut",
+	}},
+	{[]string{"go.mod"}, true, map[string]string{
+		"github.com/gin-gonic/gin": "gin", "github.com/labstack/echo": "echo", "github.com/gofiber/fiber": "fiber", "github.com/go-chi/chi": "chi",
+	}},
+	{[]string{"Gemfile"}, false, map[string]string{
+		"rails": "rails", "sinatra": "sinatra",
+	}},
+	{[]string{"composer.json"}, false, map[string]string{
+		"laravel/framework": "laravel", "symfony/symfony": "symfony",
+	}},
+}
+
+// manifestToken splits manifest lines into the names a dependency can
+// appear as.
+var manifestToken = regexp.MustCompile(`[A-Za-z0-9@/._-]+`)
+
+// DetectFrameworks sniffs the manifests at the root of the checkout and
+// returns every framework they depend on, sorted. Plain-text manifests are
+// matched token by token, so "django>=4" counts and "django-environ"
+// doesn't; package.json and composer.json are matched on their dependency
+// keys.
+func DetectFrameworks(root string) []string {
+	found := make(map[string]bool)
+	for _, kind := range frameworkDependencies {
+		for _, name := range kind.files {
+			data, err := os.ReadFile(filepath.Join(root, name))
+			if err != nil {
+				continue
+			}
+			for _, dep := range manifestDependencies(name, data) {
+				if fw, ok := kind.deps[dep]; ok {
+					found[fw] = true
+				}
+				if !kind.prefix {
+					continue
+				}
+				for name, fw := range kind.deps {
+					if strings.HasPrefix(dep, name+"-") || strings.HasPrefix(dep, name+"/") {
+						found[fw] = true
+					}
+				}
+			}
+		}
+	}
+	var names []string
+	for fw := range found {
+		names = append(names, fw)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// manifestDependencies lists the names a manifest may declare dependencies
+// by, lowercased.
+func manifestDependencies(name string, data []byte) []string {
+	if strings.HasSuffix(name, ".json") {
+		var manifest struct {
+			Dependencies map[string]json.RawMessage `json:"dependencies"`
+			Require      map[string]json.RawMessage `json:"require"`
+		}
+		if json.Unmarshal(data, &manifest) != nil {
+			return nil
+		}
+		var deps []string
+		for _, m := range []map[string]json.RawMessage{manifest.Dependencies, manifest.Require} {
+			for dep := range m {
+				deps = append(deps, strings.ToLower(dep))
+			}
+		}
+		return deps
+	}
+	var deps []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.ToLower(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 && name != "pom.xml" {
+			line = line[:i]
+		}
+		for _, token := range manifestToken.FindAllString(line, -1) {
+			deps = append(deps, strings.Trim(token, "._-"))
+		}
+	}
+	return deps
+}
+
+// frameworkQuestion is the bootstrap prompt of -framework=ask.
+const frameworkQuestion = "Which web application frameworks does this codebase use, e.g. django, flask, express, spring or rails? Answer with only a comma-separated list of lowercase framework names, or none."
+
+// AskFrameworks asks backend which frameworks target uses and parses the
+// answer into a sorted list of names.
+func AskFrameworks(ctx context.Context, backend Backend, target Target) ([]string, error) {
+	answer, err := backend.Query(ctx, frameworkQuestion, target)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	for _, field := range strings.FieldsFunc(strings.ToLower(answer.Result), func(r rune) bool {
+		return r == ',' || r == '\n' || r == ';'
+	}) {
+		name := strings.Trim(strings.TrimSpace(field), ".*`'\"- ")
+		if name != "" && name != "none" && !strings.Contains(name, " ") {
+			found[name] = true
+		}
+	}
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// NewFrameworkInfo settles on a framework if exactly one was found.
+func NewFrameworkInfo(source string, found []string) *FrameworkInfo {
+	info := &FrameworkInfo{Source: source}
+	if len(found) == 1 {
+		info.Name = found[0]
+	} else {
+		info.Candidates = found
+	}
+	return info
+}
+
+// promptVars are the variables prompt templates and when conditions see.
+type promptVars struct {
+	Framework string
+	Language  string
+}
+
+// whenPattern matches one comparison of a when condition.
+var whenPattern = regexp.MustCompile(`^(framework|language)\s*(==|!=)\s*"([^"]*)"$`)
+
+// whenComparison compares a variable with a value.
+type whenComparison struct {
+	variable, value string
+	equal           bool
+}
+
+// whenCondition is a parsed when: comparisons, any of which holding
+// includes the prompt.
+type whenCondition []whenComparison
+
+// ParseWhen parses a condition such as `framework == "django"`. Conditions
+// compare framework or language with a quoted value using == or !=, and
+// several can be joined with ||.
+func ParseWhen(expr string) (whenCondition, error) {
+	var cond whenCondition
+	for _, part := range strings.Split(expr, "||") {
+		m := whenPattern.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, fmt.Errorf("invalid when '%s'; expected e.g. framework == \"django\"", expr)
+		}
+		cond = append(cond, whenComparison{variable: m[1], value: strings.ToLower(m[3]), equal: m[2] == "=="})
+	}
+	return cond, nil
+}
+
+func (c whenCondition) holds(vars promptVars) bool {
+	for _, cmp := range c {
+		actual := vars.Framework
+		if cmp.variable == "language" {
+			actual = vars.Language
+		}
+		if (actual == cmp.value) == cmp.equal {
+			return true
+		}
+	}
+	return false
+}
+
+// TailorAudits drops the prompts whose when doesn't hold for vars and
+// renders the {{.Framework}} and {{.Language}} templates in the text of the
+// rest. The audits given are left untouched.
+func TailorAudits(audits []Audit, vars promptVars) ([]Audit, error) {
+	tailored := make([]Audit, 0, len(audits))
+	for _, a := range audits {
+		var prompts []Prompt
+		for _, p := range a.Prompts {
+			if p.When != "" {
+				cond, err := ParseWhen(p.When)
+				if err != nil {
+					return nil, fmt.Errorf("audit '%s' prompt '%s': %v", a.ID, p.ID, err)
+				}
+				if !cond.holds(vars) {
+					debugf("audit %s: skipping prompt %s, %s doesn't hold", a.ID, p.ID, p.When)
+					continue
+				}
+			}
+			var err error
+			if p.Text, err = renderPromptText(p.Text, vars); err != nil {
+				return nil, fmt.Errorf("audit '%s' prompt '%s': %v", a.ID, p.ID, err)
+			}
+			if len(p.Variants) > 0 {
+				variants := make(map[string]string, len(p.Variants))
+				for lang, text := range p.Variants {
+					if variants[lang], err = renderPromptText(text, vars); err != nil {
+						return nil, fmt.Errorf("audit '%s' prompt '%s' variant %s: %v", a.ID, p.ID, lang, err)
+					}
+				}
+				p.Variants = variants
+			}
+			prompts = append(prompts, p)
+		}
+		a.Prompts = prompts
+		tailored = append(tailored, a)
+	}
+	return tailored, nil
+}
+
+func renderPromptText(text string, vars promptVars) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// GitInfo describes the checkout a run was made against. Fields are nil when
+// the information isn't available so that they still serialize as null.
+type GitInfo struct {
+	Commit *string `json:"commit"`
+	Branch *string `json:"branch"`
+	Dirty  *bool   `json:"dirty"`
+}
+
+func runGit(dir string, args ...string) (string, bool) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// CollectGitInfo inspects the git checkout containing dir. A detached HEAD
+// leaves Branch nil.
+func CollectGitInfo(dir string) GitInfo {
+	var info GitInfo
+	commit, ok := runGit(dir, "rev-parse", "HEAD")
+	if !ok {
+		return info
+	}
+	info.Commit = &commit
+	if branch, ok := runGit(dir, "rev-parse", "--abbrev-ref", "HEAD"); ok && branch != "HEAD" {
+		info.Branch = &branch
+	}
+	if status, ok := runGit(dir, "status", "--porcelain"); ok {
+		dirty := status != ""
+		info.Dirty = &dirty
+	}
+	return info
+}
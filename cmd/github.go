@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const GitHubAPIUrl = "https://api.github.com"
+
+// GitHubRepo holds the fields of GitHub's repository object that treeko
+// filters on.
+type GitHubRepo struct {
+	FullName      string   `json:"full_name"`
+	DefaultBranch string   `json:"default_branch"`
+	Language      string   `json:"language"`
+	Topics        []string `json:"topics"`
+	Archived      bool     `json:"archived"`
+}
+
+// RepoFilter selects which repositories of an organization are audited.
+// Empty fields match everything.
+type RepoFilter struct {
+	Topic           string
+	Language        string
+	IncludeArchived bool
+}
+
+func (f RepoFilter) Match(r GitHubRepo) bool {
+	if r.Archived && !f.IncludeArchived {
+		return false
+	}
+	if f.Language != "" && !strings.EqualFold(r.Language, f.Language) {
+		return false
+	}
+	if f.Topic != "" {
+		for _, t := range r.Topics {
+			if strings.EqualFold(t, f.Topic) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// ListOrgRepos pages through an organization's repositories, returning at
+// most max of those that pass filter. A max of zero means no limit.
+func ListOrgRepos(org, token string, filter RepoFilter, max int) ([]GitHubRepo, error) {
+	var repos []GitHubRepo
+	next, err := buildSearchURL(GitHubAPIUrl, url.Values{"per_page": {"100"}, "type": {"all"}}, "orgs", org, "repos")
+	if err != nil {
+		return nil, err
+	}
+	for next != "" {
+		req, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing repositories of %s: %s: %s", org, resp.Status, strings.TrimSpace(string(data)))
+		}
+		var page []GitHubRepo
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("parsing repository list: %v", err)
+		}
+		for _, r := range page {
+			if !filter.Match(r) {
+				continue
+			}
+			repos = append(repos, r)
+			if max > 0 && len(repos) >= max {
+				return repos, nil
+			}
+		}
+		next = ""
+		if m := linkNextPattern.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+	}
+	return repos, nil
+}
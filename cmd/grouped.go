@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// AuditGroup is one audit's section of -output grouped-json: its findings in
+// report order and a summary of them alone. With -compare-codebases it also
+// has the audit's rows of the comparison.
+type AuditGroup struct {
+	Name       string          `json:"name"`
+	Summary    Summary         `json:"summary"`
+	Findings   []Finding       `json:"findings"`
+	Comparison []ComparisonRow `json:"comparison,omitempty"`
+}
+
+// auditKey identifies the audit a finding came from. Plugin findings have no
+// audit ID, so their name stands in.
+func auditKey(f Finding) string {
+	if f.AuditID != "" {
+		return f.AuditID
+	}
+	return PromptID(f.Audit)
+}
+
+// GroupByAudit splits a summarized report by audit. Filtered and
+// low-confidence results count in their audit's summary but aren't listed.
+// A report view is grouped and summarized by its whole report's findings.
+func GroupByAudit(r *Report) map[string]*AuditGroup {
+	whole := r
+	if r.whole != nil {
+		whole = r.whole
+	}
+	groups := make(map[string]*AuditGroup)
+	group := func(f Finding) *AuditGroup {
+		g := groups[auditKey(f)]
+		if g == nil {
+			g = &AuditGroup{Name: f.Audit, Findings: []Finding{}}
+			groups[auditKey(f)] = g
+		}
+		return g
+	}
+	for i, f := range whole.Findings {
+		g := group(f)
+		g.Summary.add(f)
+		g.Findings = append(g.Findings, r.Findings[i])
+	}
+	for _, f := range whole.Filtered {
+		group(f).Summary.addFiltered(f)
+	}
+	for _, f := range whole.LowConfidence {
+		group(f).Summary.addLowConfidence(f)
+	}
+	for key, g := range groups {
+		if r.comparison != nil {
+			g.Comparison = r.comparison.forAudit(key)
+		}
+		if lowConfidence != nil {
+			g.Summary.MinConfidence = lowConfidence.Min
+		}
+		g.Summary.finish()
+	}
+	return groups
+}
+
+// WriteGroupedJSON writes the report's findings as one object keyed by audit
+// ID, for consumers that ingest results per audit.
+func WriteGroupedJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(GroupByAudit(r))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HookWarn = "warn"
+	HookFail = "fail"
+
+	defaultHookTimeout = time.Minute
+	// maxHookResultLen keeps TREEKO_FINDING_RESULT well under the
+	// environment size limits of common platforms.
+	maxHookResultLen = 4096
+)
+
+// HooksConfig lists commands run at points of the run's lifecycle.
+type HooksConfig struct {
+	PreRun    []HookConfig `yaml:"preRun"`
+	PostRun   []HookConfig `yaml:"postRun"`
+	OnFinding []HookConfig `yaml:"onFinding"`
+}
+
+// HookConfig is a command executed directly, not through a shell, with the
+// run's context in TREEKO_* environment variables. OnError is "warn" (the
+// default) or "fail", which aborts a preRun hook's run and otherwise makes
+// the run exit with ExitErrors.
+type HookConfig struct {
+	Command string        `yaml:"command"`
+	Args    []string      `yaml:"args"`
+	Timeout time.Duration `yaml:"timeout"`
+	OnError string        `yaml:"onError"`
+}
+
+func (h HookConfig) fatal() bool {
+	return h.OnError == HookFail
+}
+
+func (c HooksConfig) validate(path string) error {
+	events := []struct {
+		name  string
+		hooks []HookConfig
+	}{{"preRun", c.PreRun}, {"postRun", c.PostRun}, {"onFinding", c.OnFinding}}
+	for _, e := range events {
+		for i, h := range e.hooks {
+			if h.Command == "" {
+				return fmt.Errorf("%s: hooks.%s[%d] has no command", path, e.name, i)
+			}
+			if h.OnError != "" && h.OnError != HookWarn && h.OnError != HookFail {
+				return fmt.Errorf("%s: hooks.%s[%d]: onError must be '%s' or '%s'", path, e.name, i, HookWarn, HookFail)
+			}
+		}
+	}
+	return nil
+}
+
+// hookSecrets are the credentials of the run's backends. A hook never sees
+// a variable holding one of them, whatever its name.
+var hookSecrets []string
+
+// hookSecretEnv are the variables backend credentials are read from by
+// default.
+var hookSecretEnv = []string{"OPENAI_API_KEY", "SRC_ACCESS_TOKEN"}
+
+// hookEnv returns the environment for a hook: treeko's own environment minus
+// the Greptile and treeko variables and anything carrying a backend
+// credential, plus vars.
+func hookEnv(vars map[string]string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, value := kv, ""
+		if i := strings.Index(kv, "="); i >= 0 {
+			name, value = kv[:i], kv[i+1:]
+		}
+		if strings.HasPrefix(strings.ToUpper(name), "GREPTILE_") || strings.HasPrefix(name, "TREEKO_") || hasTag(hookSecretEnv, name) || isHookSecret(value) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// isHookSecret reports whether value is one of hookSecrets.
+func isHookSecret(value string) bool {
+	for _, s := range hookSecrets {
+		if s != "" && value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RunHook runs one hook. Its output goes to stderr so that it never mixes
+// with a JSON report on stdout.
+func RunHook(event string, hook HookConfig, vars map[string]string) error {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	cmd.Env = hookEnv(vars)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	debugf("running %s hook %s", event, hook.Command)
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s hook '%s' timed out after %s", event, hook.Command, timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%s hook '%s' exited with status %d", event, hook.Command, exitErr.ExitCode())
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook '%s': %v", event, hook.Command, err)
+	}
+	return nil
+}
+
+// RunHooks runs hooks in order, logging failures. It stops at, and returns,
+// the first failure of a hook marked onError: fail.
+func RunHooks(event string, hooks []HookConfig, vars map[string]string) error {
+	for _, h := range hooks {
+		if err := RunHook(event, h, vars); err != nil {
+			if h.fatal() {
+				return err
+			}
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// RunPostRunHooks writes the report to a temporary file, passed to the hooks
+// as TREEKO_REPORT_FILE, and runs them.
+func RunPostRunHooks(hooks []HookConfig, r *Report, exitCode int) error {
+	f, err := os.CreateTemp("", "treeko-report-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return RunHooks("postRun", hooks, map[string]string{
+		"TREEKO_RUN_ID":      r.Metadata.RunID,
+		"TREEKO_CODEBASE":    r.Metadata.Codebase,
+		"TREEKO_REPORT_FILE": f.Name(),
+		"TREEKO_EXIT_CODE":   strconv.Itoa(exitCode),
+	})
+}
+
+// FindingHooks runs the onFinding hooks for each finding with a result, one
+// finding at a time on a single goroutine, so a noisy run queues hook
+// invocations instead of forking one process per finding at once.
+type FindingHooks struct {
+	hooks  []HookConfig
+	runID  string
+	queue  chan Finding
+	done   chan struct{}
+	failed error
+}
+
+func StartFindingHooks(hooks []HookConfig, runID string) *FindingHooks {
+	h := &FindingHooks{hooks: hooks, runID: runID, queue: make(chan Finding, 256), done: make(chan struct{})}
+	go h.run()
+	return h
+}
+
+func (h *FindingHooks) run() {
+	defer close(h.done)
+	for f := range h.queue {
+		if h.failed != nil {
+			continue // drain
+		}
+		result := f.Result
+		if len(result) > maxHookResultLen {
+			result = result[:maxHookResultLen]
+		}
+		err := RunHooks("onFinding", h.hooks, map[string]string{
+			"TREEKO_RUN_ID":              h.runID,
+			"TREEKO_CODEBASE":            f.Codebase,
+			"TREEKO_FINDING_AUDIT":       f.Audit,
+			"TREEKO_FINDING_PROMPT":      f.Prompt,
+			"TREEKO_FINDING_SEVERITY":    string(f.Severity),
+			"TREEKO_FINDING_SOURCE":      f.Source,
+			"TREEKO_FINDING_FINGERPRINT": f.Fingerprint,
+			"TREEKO_FINDING_RESULT":      result,
+		})
+		if err != nil {
+			log.Printf("Error: %v; skipping remaining onFinding hooks\n", err)
+			h.failed = err
+		}
+	}
+}
+
+// Notify queues f. It blocks while the queue is full.
+func (h *FindingHooks) Notify(f Finding) {
+	if f.HasResult() {
+		h.queue <- f
+	}
+}
+
+// Close waits for queued hooks to finish and returns the fatal failure, if
+// any.
+func (h *FindingHooks) Close() error {
+	close(h.queue)
+	<-h.done
+	return h.failed
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHookEnvDropsBackendCredentials(t *testing.T) {
+	saved := hookSecrets
+	t.Cleanup(func() { hookSecrets = saved })
+	// The Sourcegraph token came from -sourcegraph-token, not its default
+	// variable, and no OpenAI key was given.
+	hookSecrets = []string{APIKey, "", "sgp_0123456789"}
+	t.Setenv("OPENAI_API_KEY", "sk-from-env")
+	t.Setenv("SRC_ACCESS_TOKEN", "sgp_other")
+	t.Setenv("CI_SOURCEGRAPH_TOKEN", "sgp_0123456789")
+	t.Setenv("GREPTILE_API_KEY", "greptile-key")
+	t.Setenv("TREEKO_WEBHOOK_SECRET", "hook-secret")
+	t.Setenv("CI_JOB_NAME", "audit")
+	t.Setenv("CI_EMPTY", "")
+
+	env := make(map[string]string)
+	for _, kv := range hookEnv(map[string]string{"TREEKO_EVENT": "preRun"}) {
+		i := strings.Index(kv, "=")
+		env[kv[:i]] = kv[i+1:]
+	}
+	for _, name := range []string{"OPENAI_API_KEY", "SRC_ACCESS_TOKEN", "CI_SOURCEGRAPH_TOKEN", "GREPTILE_API_KEY", "TREEKO_WEBHOOK_SECRET"} {
+		if value, ok := env[name]; ok {
+			t.Errorf("hook environment has %s=%s", name, value)
+		}
+	}
+	// An empty credential matches nothing.
+	if value, ok := env["CI_EMPTY"]; !ok || value != "" {
+		t.Errorf("CI_EMPTY = %q, %v; want kept empty", value, ok)
+	}
+	if env["CI_JOB_NAME"] != "audit" || env["TREEKO_EVENT"] != "preRun" {
+		t.Errorf("hook environment lacks CI_JOB_NAME or the hook's own variables: %v", env)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const GreptileIndexURL = "https://api.greptile.com/v2/repositories"
+
+type indexRequest struct {
+	Remote     string `json:"remote"`
+	Repository string `json:"repository"`
+	Branch     string `json:"branch,omitempty"`
+}
+
+// EnsureIndexed asks Greptile to index a GitHub repository. Greptile treats
+// requests for an already indexed repository as a no-op, so this is safe to
+// call before every audit. githubToken grants Greptile access to private
+// repositories.
+func EnsureIndexed(repository, branch, githubToken string) error {
+	body, err := json.Marshal(indexRequest{Remote: "github", Repository: repository, Branch: branch})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", GreptileIndexURL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	if githubToken != "" {
+		req.Header.Set("X-GitHub-Token", githubToken)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("indexing %s: %s: %s", repository, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
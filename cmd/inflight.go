@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// inflightCalls coalesces identical backend requests that are in flight at
+// the same time, e.g. one prompt listed by two audits, so they are sent
+// once. Unlike the cache, it never returns an answer that arrived before
+// the caller asked.
+type inflightCalls struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+	done   chan struct{}
+	answer Answer
+	ids    *RequestIDs
+	err    error
+}
+
+// inflight coalesces the requests of a run.
+var inflight inflightCalls
+
+// inflightKey identifies the request query sends to backend about target.
+func inflightKey(backend Backend, query string, target Target) string {
+	return backend.Name() + "\x00" + target.Codebase + "\x00" + target.Branch + "\x00" + target.Session + "\x00" + query
+}
+
+// Do calls send unless a call with the same key is in flight, in which case
+// it waits for that call and returns its result, with shared set.
+func (c *inflightCalls) Do(key string, send sendFunc) (Answer, *RequestIDs, error, bool) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.answer, call.ids, call.err, true
+	}
+	call := &inflightCall{done: make(chan struct{})}
+	if c.calls == nil {
+		c.calls = make(map[string]*inflightCall)
+	}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.answer, call.ids, call.err = send()
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	close(call.done)
+	return call.answer, call.ids, call.err, false
+}
+
+// coalesced sends through inflight under ctx. The shared call runs under
+// the context of the caller that started it, so if that one was cancelled
+// while ctx wasn't, the request is sent again.
+func coalesced(ctx context.Context, key string, send sendFunc) (Answer, *RequestIDs, error) {
+	answer, ids, err, shared := inflight.Do(key, send)
+	if shared && ctx.Err() == nil && errors.Is(err, context.Canceled) {
+		debugf("shared request was cancelled; sending it again")
+		return send()
+	}
+	if shared {
+		debugf("shared the request in flight instead of sending another")
+	}
+	return answer, ids, err
+}
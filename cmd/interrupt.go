@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// RunInterrupt stops the run on the first SIGINT or SIGTERM much as
+// -max-runtime does: prompts waiting for a slot are skipped, those in flight
+// are cancelled, and the report of everything that completed is still
+// written. A second signal kills the process as usual.
+type RunInterrupt struct {
+	signals chan os.Signal
+	done    chan struct{}
+
+	mu          sync.Mutex
+	interrupted bool
+}
+
+// runInterrupt is nil outside of a run.
+var runInterrupt *RunInterrupt
+
+// WatchInterrupt calls abort on the first SIGINT or SIGTERM, until stopped.
+func WatchInterrupt(abort context.CancelFunc) *RunInterrupt {
+	i := &RunInterrupt{signals: make(chan os.Signal, 1), done: make(chan struct{})}
+	signal.Notify(i.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(i.signals)
+		select {
+		case <-i.signals:
+			i.mu.Lock()
+			i.interrupted = true
+			i.mu.Unlock()
+			log.Println("Run interrupted; recording the outstanding prompts. Interrupt again to quit at once.")
+			abort()
+		case <-i.done:
+		}
+	}()
+	return i
+}
+
+// Stop restores the default handling of the signals once the run's prompts
+// are done.
+func (i *RunInterrupt) Stop() {
+	if i != nil {
+		close(i.done)
+	}
+}
+
+// Interrupted reports whether a signal cancelled the run.
+func (i *RunInterrupt) Interrupted() bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.interrupted
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingBackend answers its first answered prompts at once and holds every
+// later one until its context is cancelled.
+type blockingBackend struct {
+	answered int
+	started  chan string
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (b *blockingBackend) Name() string { return "blocking" }
+
+func (b *blockingBackend) Query(ctx context.Context, prompt string, target Target) (Answer, error) {
+	b.mu.Lock()
+	b.calls++
+	call := b.calls
+	b.mu.Unlock()
+	if call <= b.answered {
+		return Answer{Result: "answer to " + prompt}, nil
+	}
+	b.started <- prompt
+	<-ctx.Done()
+	return Answer{}, ctx.Err()
+}
+
+func TestInterruptAccountsForEveryPrompt(t *testing.T) {
+	const prompts, workers, answered = 12, 3, 2
+	savedFormat, savedInterrupt := outputFormat, runInterrupt
+	t.Cleanup(func() { outputFormat, runInterrupt = savedFormat, savedInterrupt })
+	outputFormat = "json"
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runInterrupt = WatchInterrupt(cancel)
+	defer runInterrupt.Stop()
+
+	backend := &blockingBackend{answered: answered, started: make(chan string, prompts)}
+	audit := Audit{ID: "auth", Name: "Auth"}
+	for i := 0; i < prompts; i++ {
+		audit.Prompts = append(audit.Prompts, Prompt{ID: fmt.Sprintf("p%d", i), Text: fmt.Sprintf("prompt %d", i), Severity: SeverityHigh})
+	}
+	report := NewReport(RunMetadata{})
+	pool := NewConcurrencyPool(1, workers, workers)
+	target := Target{Codebase: "org/svc", Backend: backend}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go RunAudit(runCtx, target, audit, report, pool, &wg)
+
+	// Interrupt once every slot holds a prompt that won't complete, so the
+	// rest are still waiting for one.
+	for i := 0; i < workers; i++ {
+		select {
+		case <-backend.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d slots were taken", i, workers)
+		}
+	}
+	interrupted := time.Now()
+	runInterrupt.signals <- os.Interrupt
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("audit still running 5s after the interrupt")
+	}
+	if elapsed := time.Since(interrupted); elapsed > 2*time.Second {
+		t.Errorf("audit took %v to stop after the interrupt", elapsed)
+	}
+	if !runInterrupt.Interrupted() {
+		t.Error("Interrupted() = false after the signal")
+	}
+
+	seen := make(map[string]int)
+	var ok, cancelled int
+	for _, f := range report.Findings {
+		seen[f.Prompt]++
+		switch f.Status {
+		case StatusOK:
+			ok++
+		case StatusCancelled:
+			cancelled++
+			if f.Error != "interrupted" {
+				t.Errorf("cancelled finding for %q has error %q, want %q", f.Prompt, f.Error, "interrupted")
+			}
+		default:
+			t.Errorf("finding for %q has status %q", f.Prompt, f.Status)
+		}
+	}
+	for _, s := range report.Skipped {
+		seen[s.Prompt]++
+		if s.Reason != SkipInterrupted {
+			t.Errorf("skipped %q with reason %q, want %q", s.Prompt, s.Reason, SkipInterrupted)
+		}
+	}
+	for _, p := range audit.Prompts {
+		if seen[p.Text] != 1 {
+			t.Errorf("prompt %q recorded %d times, want once", p.Text, seen[p.Text])
+		}
+	}
+	if len(seen) != prompts {
+		t.Errorf("recorded %d distinct prompts, want %d", len(seen), prompts)
+	}
+	if ok != answered || cancelled != workers {
+		t.Errorf("got %d answered and %d cancelled findings, want %d and %d", ok, cancelled, answered, workers)
+	}
+	if skipped := len(report.Skipped); skipped != prompts-answered-workers {
+		t.Errorf("got %d skipped prompts, want %d", skipped, prompts-answered-workers)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// promptLanguage selects the prompt variants to ask; "" asks every prompt's
+// default text.
+var promptLanguage = ""
+
+// languageMarkers map files at the root of a checkout to the language they
+// indicate, in the order they are looked for. package.json comes last since
+// projects in other languages often keep one for their tooling.
+var languageMarkers = []struct {
+	file, language string
+}{
+	{"go.mod", "go"},
+	{"pom.xml", "java"},
+	{"build.gradle", "java"},
+	{"build.gradle.kts", "java"},
+	{"requirements.txt", "python"},
+	{"pyproject.toml", "python"},
+	{"setup.py", "python"},
+	{"package.json", "javascript"},
+}
+
+// DetectLanguage returns the language of the checkout at root and the file
+// it was recognized by, or "" if no marker file is present.
+func DetectLanguage(root string) (string, string) {
+	for _, m := range languageMarkers {
+		if _, err := os.Stat(filepath.Join(root, m.file)); err == nil {
+			return m.language, m.file
+		}
+	}
+	return "", ""
+}
+
+// textFor returns the prompt's variant for lang and the variant's name, or
+// the default text and "" when it has none.
+func (p Prompt) textFor(lang string) (string, string) {
+	if text, ok := p.Variants[strings.ToLower(lang)]; ok && lang != "" {
+		return text, strings.ToLower(lang)
+	}
+	return p.Text, ""
+}
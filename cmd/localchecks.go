@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Finding sources. Prompt findings are sourced from the backend that
+// answered them, see Backend.Name.
+const (
+	SourceGreptile = "greptile"
+	SourceLocal    = "local"
+)
+
+const (
+	// maxLocalFileSize skips generated bundles and other large files that
+	// are unlikely to be hand-written source.
+	maxLocalFileSize = 2 << 20
+	// maxLocalMatchesPerCheck keeps one noisy pattern from flooding the
+	// report.
+	maxLocalMatchesPerCheck = 100
+)
+
+// LocalCheck is a regular expression run against the local checkout instead
+// of asking Greptile. Files is a pattern with the same semantics as an
+// audit's requires entries.
+type LocalCheck struct {
+	ID       string   `json:"id" yaml:"id"`
+	Pattern  string   `json:"pattern" yaml:"pattern"`
+	Files    string   `json:"files" yaml:"files"`
+	Message  string   `json:"message" yaml:"message"`
+	Severity Severity `json:"severity" yaml:"severity"`
+	CWE      int      `json:"cwe,omitempty" yaml:"cwe"`
+	Tags     []string `json:"tags,omitempty" yaml:"tags"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the check, filling in defaults. It is called when
+// audits are loaded so that a bad pattern fails before any request is sent.
+func (c *LocalCheck) compile() error {
+	if c.Pattern == "" {
+		return fmt.Errorf("local check '%s' has no pattern", c.ID)
+	}
+	re, err := regexp.Compile(c.Pattern)
+	if err != nil {
+		return fmt.Errorf("local check '%s': %v", c.ID, err)
+	}
+	c.re = re
+	if c.Files == "" {
+		c.Files = "*"
+	}
+	if c.Message == "" {
+		c.Message = "Matched " + c.Pattern
+	}
+	if c.Severity == "" {
+		c.Severity = SeverityMedium
+	}
+	if !c.Severity.Valid() {
+		return fmt.Errorf("local check '%s' has unknown severity '%s'", c.ID, c.Severity)
+	}
+	return nil
+}
+
+// CompileLocalChecks compiles every local check of the given audits.
+func CompileLocalChecks(audits []Audit) error {
+	for i := range audits {
+		for j := range audits[i].LocalChecks {
+			if err := audits[i].LocalChecks[j].compile(); err != nil {
+				return fmt.Errorf("audit '%s': %v", audits[i].ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchPattern matches a slash-separated relative path. Patterns without a
+// slash (e.g. "*.tf", "Dockerfile") match file names anywhere in the tree;
+// patterns with a slash match the whole path.
+func matchPattern(pattern, file string) bool {
+	target := file
+	if !strings.Contains(pattern, "/") {
+		target = path.Base(file)
+	}
+	ok, _ := path.Match(pattern, target)
+	return ok
+}
+
+// RunLocalChecks runs every local check of audit over files (paths relative
+// to root), one goroutine per check, and records matches in report.
+func RunLocalChecks(root string, files []string, codebase string, audit Audit, report *Report) {
+	var wg sync.WaitGroup
+	for _, check := range audit.LocalChecks {
+		wg.Add(1)
+		go func(check LocalCheck) {
+			defer wg.Done()
+			matches := 0
+			for _, rel := range files {
+				if !matchPattern(check.Files, rel) {
+					continue
+				}
+				for _, m := range scanFile(filepath.Join(root, rel), check.re) {
+					if matches == maxLocalMatchesPerCheck {
+						log.Printf("Warning: local check '%s' matched more than %d times; ignoring the rest\n", check.ID, maxLocalMatchesPerCheck)
+						return
+					}
+					matches++
+					finding := Finding{
+						Codebase:  codebase,
+						Audit:     audit.Name,
+						Prompt:    check.Message,
+						Severity:  check.Severity,
+						CWE:       check.CWE,
+						Tags:      append([]string(nil), check.Tags...),
+						Source:    SourceLocal,
+						Status:    StatusOK,
+						Check:     check.ID,
+						Result:    fmt.Sprintf("%s:%d: %s", rel, m.line, m.text),
+						Locations: []Location{{Path: rel, Line: m.line}},
+						Timestamp: time.Now().UTC(),
+					}
+					if outputFormat == "text" {
+						finding.locate()
+						fmt.Printf("Local check '%s' matched %s:%d (fingerprint %s)\n", check.ID, rel, m.line, finding.Fingerprint)
+					}
+					report.Add(finding)
+				}
+			}
+		}(check)
+	}
+	wg.Wait()
+}
+
+type lineMatch struct {
+	line int
+	text string
+}
+
+// scanFile returns the lines of the named file matching re. Binary and
+// oversized files are skipped.
+func scanFile(name string, re *regexp.Regexp) []lineMatch {
+	info, err := os.Stat(name)
+	if err != nil || info.Size() > maxLocalFileSize {
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	head := data
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+	var matches []lineMatch
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxLocalFileSize)
+	for n := 1; scanner.Scan(); n++ {
+		if re.Match(scanner.Bytes()) {
+			matches = append(matches, lineMatch{line: n, text: strings.TrimSpace(scanner.Text())})
+		}
+	}
+	return matches
+}
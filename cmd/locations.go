@@ -0,0 +1,59 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Location is a file (and optionally line) referenced by a result.
+type Location struct {
+	Path string `json:"path"`
+	Line int    `json:"line,omitempty"`
+}
+
+// locationPattern matches source file references such as `src/db/query.go`
+// or `app/views.py:42`. Only well-known source extensions are accepted so
+// that prose like "e.g." isn't mistaken for a file.
+var locationPattern = regexp.MustCompile(`(?:^|[\s"'(\x60\[])((?:\.{0,2}/)?(?:[\w@.-]+/)*[\w@-][\w@.-]*\.(?:go|py|js|jsx|ts|tsx|mjs|cjs|java|kt|kts|scala|rb|php|cs|c|h|cc|cpp|hpp|rs|swift|m|sql|sh|bash|yaml|yml|json|toml|xml|html|htm|erb|ejs|vue|svelte|tf|hcl|properties|ini|conf|env|gradle|lua|pl|ex|exs|dart))(?::(\d+))?`)
+
+// ExtractLocations returns the distinct file references in text, sorted by
+// path and line.
+func ExtractLocations(text string) []Location {
+	seen := make(map[Location]bool)
+	var locs []Location
+	for _, m := range locationPattern.FindAllStringSubmatch(text, -1) {
+		loc := Location{Path: NormalizePath(m[1])}
+		if m[2] != "" {
+			loc.Line, _ = strconv.Atoi(m[2])
+		}
+		if !seen[loc] {
+			seen[loc] = true
+			locs = append(locs, loc)
+		}
+	}
+	sort.Slice(locs, func(i, j int) bool {
+		if locs[i].Path != locs[j].Path {
+			return locs[i].Path < locs[j].Path
+		}
+		return locs[i].Line < locs[j].Line
+	})
+	return locs
+}
+
+// NormalizePath makes repository-relative paths comparable.
+func NormalizePath(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	for strings.HasPrefix(p, "./") {
+		p = p[2:]
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// pathMatches is lenient about leading directories because Greptile
+// sometimes reports paths relative to a subdirectory.
+func pathMatches(a, b string) bool {
+	a, b = NormalizePath(a), NormalizePath(b)
+	return a == b || strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
@@ -18,121 +25,1667 @@ const (
 	MaxConcurrent  = 5 // Set the maximum number of concurrent Greptile requests
 )
 
+// Process exit codes.
+const (
+	ExitOK       = 0
+	ExitFindings = 1 // new findings reported by diff, or a failed policy
+	ExitUsage    = 2 // bad flags, arguments or configuration
+	ExitErrors   = 3 // one or more prompts failed
+	ExitAuth     = 4 // aborted after repeated authentication failures
+	ExitDeadline = 5 // stopped by -max-runtime before the work was done
+)
+
 type GreptileRequest struct {
 	Prompt   string `json:"prompt"`
 	Codebase string `json:"codebase"`
+	Branch   string `json:"branch,omitempty"`
+	// SessionID lets a question follow up on earlier ones of the session.
+	SessionID string `json:"sessionId,omitempty"`
 }
 
+// GreptileResponse is the search response. Score, when present, is
+// Greptile's confidence that the result is relevant, from 0 to 1.
 type GreptileResponse struct {
-	Result string `json:"result"`
-	Error  string `json:"error"`
+	Result ResultText `json:"result"`
+	Score  *float64   `json:"score,omitempty"`
+	Error  string     `json:"error"`
 }
 
-var authSearchPrompts = []string{
-	"Find functions related to password hashing, e.g., bcrypt, scrypt, argon2.",
-	"Locate login routes or endpoints, e.g., routes containing '/login' or 'auth'.",
-	"Search for token generation methods, e.g., JWT (json web token) creation.",
-	"Look for hardcoded credentials or sensitive tokens.",
-	"Identify OAuth configuration or calls to external authentication providers.",
-	"Search for references to user sessions, session management, and cookies.",
-	"Find environment variable lookups for secrets, e.g., SECRET_KEY, API_KEY.",
+var authSearchPrompts = []Prompt{
+	{ID: "password-hashing", Text: "Find functions related to password hashing, e.g., bcrypt, scrypt, argon2.", Severity: SeverityMedium, CWE: 916},
+	{ID: "login-routes", Text: "Locate login routes or endpoints, e.g., routes containing '/login' or 'auth'.", Severity: SeverityMedium},
+	{ID: "token-generation", Text: "Search for token generation methods, e.g., JWT (json web token) creation.", Severity: SeverityMedium},
+	{ID: "hardcoded-credentials", Text: "Look for hardcoded credentials or sensitive tokens.", Severity: SeverityCritical, CWE: 798},
+	{ID: "oauth-config", Text: "Identify OAuth configuration or calls to external authentication providers.", Severity: SeverityLow},
+	{ID: "sessions", Text: "Search for references to user sessions, session management, and cookies.", Severity: SeverityMedium},
+	{ID: "env-secrets", Text: "Find environment variable lookups for secrets, e.g., SECRET_KEY, API_KEY.", Severity: SeverityLow},
+	{ID: "django-login-required", Text: "Find Django views that handle user data without @login_required, LoginRequiredMixin or a permission check.", Severity: SeverityHigh, CWE: 862, When: `framework == "django"`},
+	{ID: "flask-login-required", Text: "Find Flask routes that handle user data without @login_required or an equivalent check of the current user.", Severity: SeverityHigh, CWE: 862, When: `framework == "flask"`},
+	{ID: "express-auth-middleware", Text: "Find Express routes that read req.user or account data but are registered without authentication middleware, e.g., passport.authenticate or a session check.", Severity: SeverityHigh, CWE: 862, When: `framework == "express"`},
+	{ID: "spring-security", Text: "Find Spring controller mappings that aren't covered by Spring Security rules or annotated with @PreAuthorize or @Secured.", Severity: SeverityHigh, CWE: 862, When: `framework == "spring"`},
 }
 
-var sqlInjectionPrompts = []string{
-	"Find SQL query constructions without parameterized queries, e.g., direct string concatenation with SQL statements.",
-	"Locate raw SQL query executions with user inputs.",
-	"Identify potential SQL injection vulnerabilities by inspecting query building functions or user inputs in SQL contexts.",
+var sqlInjectionPrompts = []Prompt{
+	{ID: "string-concatenation", Text: "Find SQL query constructions without parameterized queries, e.g., direct string concatenation with SQL statements.", Severity: SeverityHigh, CWE: 89},
+	{ID: "raw-queries", Text: "Locate raw SQL query executions with user inputs.", Severity: SeverityHigh, CWE: 89},
+	{ID: "query-builders", Text: "Identify potential SQL injection vulnerabilities by inspecting query building functions or user inputs in SQL contexts.", Severity: SeverityHigh, CWE: 89},
 }
 
-var owaspTop10Prompts = []string{
-	"Look for SQL injections, such as unparameterized SQL queries.",
-	"Find insecure deserialization usage, which can lead to remote code execution.",
-	"Identify potential XSS vulnerabilities, such as unescaped user inputs in HTML.",
-	"Check for weak or missing authentication mechanisms in endpoints.",
-	"Detect sensitive data exposure, such as unencrypted data storage or transmission.",
-	"Search for misconfigurations in security headers, such as missing Content-Security-Policy.",
-	"Find code that allows unrestricted file uploads, which may lead to RCE.",
-	"Identify usage of vulnerable libraries by analyzing imported dependencies.",
-	"Look for improper access controls, e.g., endpoints without authorization checks.",
-	"Identify excessive data exposure in APIs, e.g., exposing sensitive fields directly.",
+var owaspTop10Prompts = []Prompt{
+	{ID: "sql-injection", Text: "Look for SQL injections, such as unparameterized SQL queries.", Severity: SeverityHigh, CWE: 89, Variants: map[string]string{
+		"go":         "Look for SQL injections, such as queries built with fmt.Sprintf or string concatenation and passed to db.Query, db.Exec or QueryRow instead of using placeholders.",
+		"java":       "Look for SQL injections, such as queries concatenated into Statement.executeQuery or createQuery strings instead of PreparedStatement parameters.",
+		"python":     "Look for SQL injections, such as cursor.execute with f-strings, % formatting or .format(), or raw() and extra() in Django, instead of query parameters.",
+		"javascript": "Look for SQL injections, such as template literals or concatenation passed to query(), knex.raw or sequelize.query instead of bound parameters.",
+	}},
+	{ID: "insecure-deserialization", Text: "Find insecure deserialization usage, which can lead to remote code execution.", Severity: SeverityCritical, CWE: 502, Variants: map[string]string{
+		"go":         "Find insecure deserialization of untrusted input, e.g., encoding/gob decoding into interfaces or yaml.Unmarshal into types that run code, which can lead to remote code execution.",
+		"java":       "Find insecure deserialization of untrusted input, e.g., ObjectInputStream.readObject, XMLDecoder, or Jackson with default typing enabled, which can lead to remote code execution.",
+		"python":     "Find insecure deserialization of untrusted input, e.g., pickle.loads, marshal.loads, yaml.load without SafeLoader, or jsonpickle, which can lead to remote code execution.",
+		"javascript": "Find insecure deserialization of untrusted input, e.g., node-serialize unserialize, eval or new Function on parsed data, or funcster, which can lead to remote code execution.",
+	}},
+	{ID: "xss", Text: "Identify potential XSS vulnerabilities, such as unescaped user inputs in HTML.", Severity: SeverityHigh, CWE: 79, Variants: map[string]string{
+		"go":         "Identify potential XSS vulnerabilities, such as user input written with text/template instead of html/template, or converted to template.HTML, template.JS or template.URL.",
+		"java":       "Identify potential XSS vulnerabilities, such as request parameters written with response.getWriter().print, JSP <%= %> expressions, or Thymeleaf th:utext without escaping.",
+		"python":     "Identify potential XSS vulnerabilities, such as user input passed through Markup(), |safe or mark_safe, Jinja2 with autoescape off, or HTML built with string formatting.",
+		"javascript": "Identify potential XSS vulnerabilities, such as user input assigned to innerHTML or outerHTML, passed to document.write, or rendered with dangerouslySetInnerHTML or v-html.",
+	}},
+	{ID: "broken-authentication", Text: "Check for weak or missing authentication mechanisms in endpoints.", Severity: SeverityHigh, CWE: 287},
+	{ID: "sensitive-data-exposure", Text: "Detect sensitive data exposure, such as unencrypted data storage or transmission.", Severity: SeverityHigh, CWE: 311},
+	{ID: "security-headers", Text: "Search for misconfigurations in security headers, such as missing Content-Security-Policy.", Severity: SeverityMedium, CWE: 693},
+	{ID: "file-uploads", Text: "Find code that allows unrestricted file uploads, which may lead to RCE.", Severity: SeverityCritical, CWE: 434},
+	{ID: "vulnerable-dependencies", Text: "Identify usage of vulnerable libraries by analyzing imported dependencies.", Severity: SeverityMedium, CWE: 1104},
+	{ID: "access-control", Text: "Look for improper access controls, e.g., endpoints without authorization checks.", Severity: SeverityHigh, CWE: 862},
+	{ID: "excessive-data-exposure", Text: "Identify excessive data exposure in APIs, e.g., exposing sensitive fields directly.", Severity: SeverityMedium, CWE: 213},
 }
 
-var httpClient = &http.Client{Timeout: 10 * time.Second}
+// Prompt is a single question put to Greptile. ID identifies it within its
+// audit; prompt files may omit it, in which case it is derived from Text.
+type Prompt struct {
+	ID       string   `json:"id" yaml:"id"`
+	Text     string   `json:"text" yaml:"text"`
+	Severity Severity `json:"severity" yaml:"severity"`
+	// CWE is the number of the weakness the prompt looks for, if any.
+	CWE int `json:"cwe,omitempty" yaml:"cwe"`
+	// Tags are copied onto every finding of the prompt, e.g. to route it to
+	// a team or map it to a compliance control.
+	Tags []string `json:"tags,omitempty" yaml:"tags"`
+	// Remediation tells whoever reads a finding how to fix it.
+	Remediation string `json:"remediation,omitempty" yaml:"remediation"`
+	// SourcegraphQuery, when Sourcegraph is configured, is searched for
+	// instead of asking the backend the prompt.
+	SourcegraphQuery string `json:"sourcegraphQuery,omitempty" yaml:"sourcegraphQuery"`
+	// Timeout, when set, replaces the default timeout of each attempt of
+	// the prompt's backend request.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout"`
+	// Variants replace Text for codebases in the language they are keyed by,
+	// e.g. python, so the prompt can name that language's APIs.
+	Variants map[string]string `json:"variants,omitempty" yaml:"variants"`
+	// When, e.g. framework == "django", limits the prompt to codebases the
+	// condition holds for.
+	When string `json:"when,omitempty" yaml:"when"`
+}
 
-func CreateGreptileRequest(prompt string, sem chan struct{}, wg *sync.WaitGroup) {
-	defer wg.Done()
-	sem <- struct{}{} // Acquire semaphore
+// Audit is a named group of prompts that run together.
+// Requires lists file patterns (e.g. "*.tf", "Dockerfile") at least one of
+// which must exist in the local checkout for the audit to be worth running.
+// LocalChecks run against that checkout when one is given.
+type Audit struct {
+	ID          string       `json:"id" yaml:"id"`
+	Name        string       `json:"name" yaml:"name"`
+	Requires    []string     `json:"requires,omitempty" yaml:"requires"`
+	Prompts     []Prompt     `json:"prompts" yaml:"prompts"`
+	LocalChecks []LocalCheck `json:"localChecks,omitempty" yaml:"localChecks"`
+	// Merge says how a prompt file's audit combines with one of the same
+	// ID loaded before it; see MergeAudits.
+	Merge string `json:"merge,omitempty" yaml:"merge"`
+}
 
-	payload := GreptileRequest{Prompt: prompt, Codebase: CodebaseID}
-	body, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error marshaling JSON payload for prompt '%s': %v\n", prompt, err)
-		<-sem // Release semaphore
-		return
+var authLocalChecks = []LocalCheck{
+	{ID: "aws-access-key", Pattern: `\b(AKIA|ASIA)[0-9A-Z]{16}\b`, Message: "AWS access key ID committed to the repository.", Severity: SeverityCritical, CWE: 798},
+	{ID: "private-key", Pattern: `-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`, Message: "Private key committed to the repository.", Severity: SeverityCritical, CWE: 321},
+}
+
+var owaspLocalChecks = []LocalCheck{
+	{ID: "go-insecure-skip-verify", Pattern: `InsecureSkipVerify:\s*true`, Files: "*.go", Message: "TLS certificate verification is disabled.", Severity: SeverityHigh, CWE: 295},
+}
+
+var builtinAudits = []Audit{
+	{ID: "auth", Name: "Authentication", Prompts: authSearchPrompts, LocalChecks: authLocalChecks},
+	{ID: "sql", Name: "SQL Injection", Prompts: sqlInjectionPrompts},
+	{ID: "owasp", Name: "OWASP Top 10", Prompts: owaspTop10Prompts, LocalChecks: owaspLocalChecks},
+}
+
+// Target is the codebase, branch and revision a prompt is run against.
+// Files, when set, restricts the prompt to those paths.
+type Target struct {
+	Codebase string
+	// Alias is the friendly name of the codebase, if it has one.
+	Alias    string
+	Branch   string
+	Revision string
+	Files    []string
+	// Backend answers the target's prompts; nil means defaultBackend.
+	Backend Backend
+	// Session, when set, is the conversation treeko query continues.
+	Session string
+}
+
+func (t Target) backend() Backend {
+	if t.Backend == nil {
+		return defaultBackend
 	}
+	return t.Backend
+}
 
-	req, err := http.NewRequest("POST", GreptileAPIUrl, bytes.NewBuffer(body))
-	if err != nil {
-		log.Printf("Error creating request for prompt '%s': %v\n", prompt, err)
-		<-sem // Release semaphore
+var httpClient = &http.Client{Timeout: 10 * time.Second, Transport: newOutboundTransport()}
+
+// resultCache is nil when caching is disabled.
+var resultCache *ResultCache
+
+// debugLog receives diagnostics that are only shown with -debug.
+var debugLog = log.New(ioutil.Discard, "debug: ", log.LstdFlags)
+
+func debugf(format string, args ...interface{}) {
+	debugLog.Printf(format, args...)
+}
+
+// strictJSON rejects Greptile responses containing fields GreptileResponse
+// doesn't model, so API changes are noticed instead of silently ignored.
+var strictJSON = false
+
+// failFast cancels the remaining prompts of an audit once one of them
+// produces a critical finding.
+var failFast = false
+
+// sourcegraph answers prompts with a sourcegraphQuery; nil leaves them to
+// the codebase's backend.
+var sourcegraph *SourcegraphBackend
+
+// replay holds the results of earlier reports given with -replay; nil
+// queries every prompt.
+var replay *Replay
+
+// explain prints each prompt's remediation guidance under its results.
+var explain = false
+
+// printPrompt prints the whole prompt as sent to the backend above each
+// result in text and compact output.
+var printPrompt = false
+
+// outputFormat selects how results are reported: "text" streams results as
+// they arrive, "json", "grouped-json" and "sonarqube" write a single report
+// once the run completes, "ocsf" writes one OCSF event per finding, and
+// "tree" and "compact" draw the findings as a tree or one line each once the
+// run completes. -tui sets it to "tui", which draws the dashboard during the
+// run and the text summary after it.
+var outputFormat = "text"
+
+// maxRuntimeError is the error of the prompts -max-runtime stopped.
+const maxRuntimeError = "stopped by -max-runtime"
+
+// promptFinding is the finding of prompt against target before it runs, and
+// the backend it is sent to.
+func promptFinding(target Target, audit Audit, prompt Prompt) (Finding, Backend) {
+	text, variant := prompt.textFor(promptLanguage)
+	backend, query := target.backend(), scopedPrompt(text, target.Files)
+	if prompt.SourcegraphQuery != "" && sourcegraph != nil {
+		// Sourcegraph applies the scope as a file filter.
+		backend, query = sourcegraph, prompt.SourcegraphQuery
+	}
+	return Finding{
+		Codebase:      target.Codebase,
+		CodebaseAlias: target.Alias,
+		Audit:         audit.Name,
+		AuditID:       audit.ID,
+		Prompt:        prompt.Text,
+		PromptID:      prompt.ID,
+		Severity:      prompt.Severity,
+		CWE:           prompt.CWE,
+		Tags:          append([]string(nil), prompt.Tags...),
+		Source:        backend.Name(),
+		Status:        StatusOK,
+		Remediation:   prompt.Remediation,
+		Variant:       variant,
+		query:         query,
+	}, backend
+}
+
+// recordTimedOut records f as a prompt -max-runtime stopped before it ran.
+func recordTimedOut(report *Report, f Finding) {
+	f.Error = maxRuntimeError
+	f.Status = StatusTimeout
+	f.Timestamp = time.Now().UTC()
+	report.Add(f)
+}
+
+// RunPrompt runs one prompt and records its finding. ctx is the
+// audit's context: once it is cancelled, by -fail-fast or the auth guard,
+// prompts that haven't completed, including those still waiting for a slot
+// in pool, are recorded as skipped instead. When -max-runtime cancels it,
+// prompts that haven't completed are recorded as timed out, whether sent or
+// still waiting, and when a signal interrupts the run, those already sent
+// are recorded as cancelled. With -fail-fast a critical result calls
+// cancelAudit.
+func RunPrompt(ctx context.Context, cancelAudit context.CancelFunc, target Target, audit Audit, prompt Prompt, report *Report, pool *ConcurrencyPool, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer dashboard.PromptDone(target.Codebase, audit.Name)
+	finding, backend := promptFinding(target, audit, prompt)
+	query := finding.query
+	if !pool.Acquire(ctx) {
+		if runDeadline.Expired() {
+			recordTimedOut(report, finding)
+			return
+		}
+		report.AddSkipped(SkippedAudit{Codebase: target.Codebase, Audit: audit.Name, Prompt: prompt.Text, Reason: skipReason()})
 		return
 	}
 
-	req.Header.Set("Authorization", "Bearer "+APIKey)
-	req.Header.Set("Content-Type", "application/json")
+	// skip, when set, is the reason the prompt is recorded as skipped.
+	skip := ""
+	defer func() {
+		if skip != "" {
+			report.AddSkipped(SkippedAudit{Codebase: target.Codebase, Audit: audit.Name, Prompt: prompt.Text, Reason: skip})
+			return
+		}
+		finding.Timestamp = time.Now().UTC()
+		added := report.Add(finding)
+		if failFast && added.Severity == SeverityCritical && added.HasResult() && added.FilteredBy == "" && !added.Suppressed && !added.LowConfidence {
+			if outputFormat == "text" {
+				fmt.Printf("Critical finding in %s audit; skipping its remaining prompts\n", audit.Name)
+			}
+			cancelAudit()
+		}
+	}()
+	// Registered after the deferred Add so the slot is free again before the
+	// finding is recorded and its hooks are queued.
+	defer pool.Release()
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		log.Printf("Error sending request for prompt '%s': %v\n", prompt, err)
-		<-sem // Release semaphore
+	// -repeat measures how the backend answers, so earlier answers aren't
+	// reused.
+	if previous, ok := replay.Get(target.Codebase, audit, prompt); ok && repeat == 1 {
+		finding.Result = previous.Result
+		finding.Score = previous.Score
+		finding.Locations = previous.Locations
+		finding.Revision = previous.Revision
+		finding.Replayed = true
+		printResult(report, finding, "replayed")
 		return
 	}
-	defer resp.Body.Close()
 
-	responseData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response for prompt '%s': %v\n", prompt, err)
-		<-sem // Release semaphore
+	key := cacheQuery(backend, query)
+
+	if resultCache != nil {
+		finding.Revision = target.Revision
+		if result, score, ok := resultCache.Get(target.Codebase, target.Revision, key); ok && repeat == 1 {
+			finding.Result = result
+			finding.Score = score
+			finding.Cached = true
+			printResult(report, finding, "cache hit, rev "+shortRev(target.Revision))
+			return
+		}
+	}
+
+	if _, ok := backend.(offlineBackend); ok {
+		if outputFormat == "text" {
+			fmt.Printf("No cached result for '%s': %s\n", prompt.Text, SkipOffline)
+		}
+		skip = SkipOffline
 		return
 	}
 
-	var greptileResponse GreptileResponse
-	if err := json.Unmarshal(responseData, &greptileResponse); err != nil {
-		log.Printf("Error parsing JSON response for prompt '%s': %v\n", prompt, err)
-		<-sem // Release semaphore
+	start := time.Now()
+	defer func() { finding.DurationMs = time.Since(start).Milliseconds() }()
+
+	send := func() (Answer, *RequestIDs, error) {
+		queryCtx, ids := withRequestIDs(ctx)
+		if prompt.Timeout > 0 {
+			queryCtx = withAttemptTimeout(queryCtx, prompt.Timeout)
+		}
+		answer, err := backend.Query(queryCtx, query, target)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			authGuard.Record(apiErr.StatusCode)
+			pool.Record(apiErr.StatusCode)
+		} else if err == nil {
+			authGuard.Record(http.StatusOK)
+			pool.Record(http.StatusOK)
+		}
+		return answer, ids, err
+	}
+	answer, ids, err := coalesced(ctx, inflightKey(backend, query, target), send)
+	if err == nil && repeat > 1 {
+		answer, ids, finding.Stability = repeatPrompt(ctx, finding, answer, ids, send)
+	}
+	finding.RequestID, finding.ServerRequestID = ids.Sent, ids.Echoed
+	if err != nil {
+		if ctx.Err() != nil && runDeadline.Expired() {
+			finding.Error = maxRuntimeError
+			finding.Status = StatusTimeout
+			return
+		}
+		if ctx.Err() != nil && runInterrupt.Interrupted() {
+			finding.Error = "interrupted"
+			finding.Status = StatusCancelled
+			return
+		}
+		if ctx.Err() != nil {
+			skip = skipReason()
+			return
+		}
+		msg := fmt.Sprintf("Error from %s for prompt '%s': %v\n", backend.Name(), prompt.Text, err)
+		if ids.Sent != "" {
+			msg = fmt.Sprintf("Error from %s for prompt '%s' (%s): %v\n", backend.Name(), prompt.Text, ids, err)
+		}
+		errorLog.Log(ErrorClass(err), msg)
+		finding.fail(err)
 		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error from Greptile for prompt '%s': %v\n", prompt, greptileResponse.Error)
+	finding.Result = answer.Result
+	finding.Score = answer.Score
+	if len(answer.Locations) > 0 {
+		finding.Locations = answer.Locations
+	}
+	if rawWanted() {
+		finding.Raw = answer.Raw
+	}
+	if resultCache != nil {
+		if err := resultCache.Put(target.Codebase, target.Revision, key, answer.Result, answer.Score); err != nil {
+			log.Printf("Error caching result for prompt '%s': %v\n", prompt.Text, err)
+		}
+		printResult(report, finding, "cache miss, rev "+shortRev(target.Revision))
 	} else {
-		fmt.Printf("Result for '%s': %s\n", prompt, greptileResponse.Result)
+		printResult(report, finding, "")
 	}
+}
 
-	<-sem // Release semaphore
+// printResult streams a result in text mode. note describes where it came
+// from, e.g. "cache hit, rev 3f2c1a9". It shows f as report will record it,
+// but prepares a copy: the finding itself is only prepared by Add.
+func printResult(report *Report, f Finding, note string) {
+	if outputFormat != "text" || lowConfidence.hidden(f) {
+		return
+	}
+	report.prepare(&f)
+	result := f.Result
+	if text, cut := truncateResponse(result, maxResponseChars); cut {
+		result = text + "\n" + truncatedMarker
+	}
+	var details []string
+	if note != "" {
+		details = append(details, note)
+	}
+	if f.Score != nil {
+		details = append(details, fmt.Sprintf("score %.2f", *f.Score))
+	}
+	if f.Fingerprint != "" {
+		details = append(details, "fingerprint "+f.Fingerprint)
+	}
+	if lowConfidence.Below(f) {
+		details = append(details, "low confidence")
+	}
+	if f.Redactions > 0 {
+		details = append(details, fmt.Sprintf("%d redacted", f.Redactions))
+	}
+	if s := f.Stability; s != nil {
+		details = append(details, fmt.Sprintf("agreement %.0f%% of %d runs", s.Agreement*100, s.Runs))
+	}
+	label := f.Prompt
+	if printPrompt && f.query != "" {
+		// The prompt line says it all; the ID is enough to follow it.
+		fmt.Printf("Prompt: %s\n", f.query)
+		if f.PromptID != "" {
+			label = f.PromptID
+		}
+	}
+	if len(details) == 0 {
+		fmt.Printf("Result for '%s': %s\n", label, result)
+	} else {
+		fmt.Printf("Result for '%s' (%s): %s\n", label, strings.Join(details, ", "), result)
+	}
+	for _, note := range f.Notes {
+		fmt.Printf("  Note: %s\n", note)
+	}
+	if explain && f.Remediation != "" {
+		fmt.Printf("  Remediation: %s\n", f.Remediation)
+	}
 }
 
-func RunAudit(prompts []string, auditName string, sem chan struct{}, wg *sync.WaitGroup) {
-	fmt.Printf("Starting %s audit:\n", auditName)
+// RunAudit runs every prompt of audit under a context derived from the
+// run's.
+func RunAudit(runCtx context.Context, target Target, audit Audit, report *Report, pool *ConcurrencyPool, wg *sync.WaitGroup) {
+	if outputFormat == "text" {
+		fmt.Printf("Starting %s audit:\n", audit.Name)
+	}
+	ctx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+	chunks := [][]string{nil}
+	if report.Metadata.Scope != nil {
+		chunks = chunkFiles(report.Metadata.Scope.Files)
+	}
+	dashboard.StartAudit(target.Codebase, audit.Name, len(audit.Prompts)*len(chunks))
 	var localWg sync.WaitGroup
-	for _, prompt := range prompts {
-		localWg.Add(1)
-		go CreateGreptileRequest(prompt, sem, &localWg)
+	for _, prompt := range audit.Prompts {
+		for _, files := range chunks {
+			scoped := target
+			scoped.Files = files
+			localWg.Add(1)
+			go RunPrompt(ctx, cancel, scoped, audit, prompt, report, pool, &localWg)
+		}
 	}
 	localWg.Wait()
-	fmt.Printf("%s audit completed.\n", auditName)
+	if outputFormat == "text" {
+		fmt.Printf("%s audit completed.\n", audit.Name)
+	}
 	wg.Done()
 }
 
 func main() {
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, MaxConcurrent) // Semaphore with max concurrency limit
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "-diff" || args[0] == "--diff") {
+		os.Exit(runDiffCommand(args[1:]))
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "audit":
+			args = args[1:]
+		case "schema":
+			os.Exit(runSchemaCommand(args[1:]))
+		case "diff":
+			os.Exit(runDiffCommand(args[1:]))
+		case "validate":
+			os.Exit(runValidateCommand(args[1:]))
+		case "bundle":
+			os.Exit(runBundleCommand(args[1:]))
+		case "query":
+			os.Exit(runQueryCommand(args[1:]))
+		case "report":
+			os.Exit(runReportCommand(args[1:]))
+		case "repl":
+			os.Exit(runReplCommand(args[1:]))
+		case "validate-config":
+			os.Exit(runValidateConfigCommand(args[1:]))
+		case "bench":
+			os.Exit(runBenchCommand(args[1:]))
+		case "ping":
+			os.Exit(runPingCommand(args[1:]))
+		case "doctor":
+			os.Exit(runDoctorCommand(args[1:]))
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command '%s'\n", args[0])
+			os.Exit(ExitUsage)
+		}
+	}
+	os.Exit(runAuditCommand(args))
+}
 
-	wg.Add(3)
-	go RunAudit(authSearchPrompts, "Authentication", sem, &wg)
-	go RunAudit(sqlInjectionPrompts, "SQL Injection", sem, &wg)
-	go RunAudit(owaspTop10Prompts, "OWASP Top 10", sem, &wg)
+func runAuditCommand(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to a treeko.yaml configuration file")
+	annotations := flags.String("annotations", AnnotationsAuto, "Emit CI annotations for findings: auto (detect the CI system), github, azure, teamcity, buildkite or none")
+	policyPath := flags.String("policy", "", "Decide pass or fail with the rules in this policy file")
+	notesPath := flags.String("notes", "", "Attach notes and severity overrides to the findings matching the rules in this file")
+	cacheDir := flags.String("cache-dir", "", "Cache successful results in this directory")
+	codebaseRev := flags.String("codebase-rev", "", "Codebase revision used to key cached results (default: git HEAD)")
+	repoRoot := flags.String("repo-root", ".", "Local checkout of the audited codebase, read for git metadata, .treekoignore, -changed-files-from, language detection and the locations of annotations and SonarQube issues; given explicitly with a single codebase, it is also scanned to pre-filter audits, run local checks and detect frameworks, and passed to plugins")
+	dbPath := flags.String("db", "", "Append findings to this SQLite database")
+	encryptReports := flags.String("encrypt-reports", "", "Encrypt every report artifact written to disk with age, as <name>.age: comma-separated age:<recipient> or age-recipients:<file>")
+	signKeyPath := flags.String("sign-key", "", "Sign the run directory's report.json with this Ed25519 private key (PKCS #8 PEM), writing report.sig and attestation.json next to it")
+	encryptStdout := flags.Bool("encrypt-stdout", false, "With -encrypt-reports, encrypt what is written to stdout too")
+	promptsDir := flags.String("prompts-dir", "", "Load additional audits from every .yaml/.json file in this directory")
+	promptsRecursive := flags.Bool("prompts-recursive", false, "Also load prompt files from subdirectories of -prompts-dir")
+	strictPrompts := flags.Bool("strict", false, "Fail when a prompt file has an invalid audit, prompt or local check, instead of skipping it with a warning")
+	noUserConfig := flags.Bool("no-user-config", false, "Ignore the machine-wide config.yaml and prompts in $XDG_CONFIG_HOME/treeko (default ~/.config/treeko)")
+	githubOrg := flags.String("github-org", "", "Audit the repositories of this GitHub organization")
+	githubToken := flags.String("github-token", os.Getenv("GITHUB_TOKEN"), "GitHub token for -github-org (default $GITHUB_TOKEN)")
+	topic := flags.String("topic", "", "With -github-org, only audit repositories with this topic")
+	language := flags.String("language", "", "Ask the prompt variants for this language, e.g. go, java, python or javascript (default: detected from -repo-root); with -github-org, also only audit repositories whose primary language is this")
+	includeArchived := flags.Bool("include-archived", false, "With -github-org, also audit archived repositories")
+	maxRepos := flags.Int("max-repos", 50, "With -github-org, audit at most this many repositories (0 for no limit)")
+	changedFrom := flags.String("changed-files-from", "", "Scope prompts to changed files: git:<range> (e.g. git:origin/main...HEAD) or - for a list on stdin")
+	noPrefilter := flags.Bool("no-prefilter", false, "Run every audit even if -repo-root has no files matching its requires patterns")
+	dryRun := flags.Bool("dry-run", false, "With -github-org, print the repositories that would be audited and exit")
+	auditsFlag := flags.String("audits", "", "Comma-separated IDs of the audits to run, overriding the config file; - reads ad-hoc prompts from stdin, one per line")
+	promptsTxt := flags.String("prompts-txt", "", "Run the prompts in this text file, one per line, as the ad-hoc audit \""+AdHocAuditID+"\"")
+	reportTemplate := flags.String("report-template", "", "Render the report with this Go text/template file")
+	reportOut := flags.String("report-out", "", "With -report-template, write the rendered report to this file")
+	webhookURL := flags.String("webhook", "", "POST the JSON report to this URL once the run completes")
+	var webhookHeaders headerFlags
+	flags.Var(&webhookHeaders, "webhook-header", "Extra `Name: value` header for -webhook requests (repeatable)")
+	webhookSecret := flags.String("webhook-secret", os.Getenv("TREEKO_WEBHOOK_SECRET"), "Sign -webhook requests with HMAC-SHA256 using this secret (default $TREEKO_WEBHOOK_SECRET)")
+	webhookPayload := flags.String("webhook-payload", WebhookReport, "What -webhook sends: report or summary")
+	webhookAttempts := flags.Int("webhook-attempts", 3, "How many times to try delivering -webhook before giving up")
+	dojoFile := flags.String("defectdojo-file", "", "Write findings to this file in DefectDojo's Generic Findings Import format")
+	dojoURL := flags.String("defectdojo-url", "", "Reimport findings into the DefectDojo instance at this URL")
+	dojoToken := flags.String("defectdojo-token", os.Getenv("DEFECTDOJO_TOKEN"), "DefectDojo API token (default $DEFECTDOJO_TOKEN)")
+	dojoProduct := flags.String("defectdojo-product", "", "With -defectdojo-url, the product to import into")
+	dojoProductType := flags.String("defectdojo-product-type", "", "With -defectdojo-url, the product type used if the product has to be created")
+	dojoEngagement := flags.String("defectdojo-engagement", "treeko", "With -defectdojo-url, the engagement to import into, created if missing")
+	dojoTest := flags.String("defectdojo-test", "treeko", "With -defectdojo-url, the title of the test whose findings are updated on each import")
+	reportPDF := flags.String("report-pdf", "", "Also write the report as a PDF to this file")
+	manifestPath := flags.String("manifest", "", "Write a coverage manifest to this file: every prompt of the run and whether it ran, was skipped or failed")
+	outDir := flags.String("out-dir", "", "Write the run's artifacts to a new <timestamp>-<run-id> directory under this one, e.g. treeko-runs")
+	postProcessor := flags.String("post-processor", "", "Command that receives the JSON report on stdin once the run completes")
+	postProcessorTimeout := flags.Duration("post-processor-timeout", 30*time.Second, "Kill the post-processor after this long")
+	postProcessorReplace := flags.Bool("post-processor-replace", false, "Print the post-processor's output instead of the normal report")
+	backendName := flags.String("backend", BackendGreptile, "Backend answering prompts: greptile or openai; a codebase's backend in the config file overrides it")
+	openAIURL := flags.String("openai-url", "https://api.openai.com/v1", "Base URL of the OpenAI-compatible API for -backend openai")
+	openAIModel := flags.String("openai-model", "", "Model for -backend openai")
+	openAIKey := flags.String("openai-key", os.Getenv("OPENAI_API_KEY"), "API key for -backend openai (default $OPENAI_API_KEY)")
+	openAISystem := flags.String("openai-system-prompt", "", "Template file for the system prompt sent with -backend openai (default: one naming the codebase, branch, revision and scoped files)")
+	sourcegraphURL := flags.String("sourcegraph-url", "", "Sourcegraph instance to run prompts' sourcegraphQuery searches against (overrides sourcegraph.url in the config file)")
+	sourcegraphToken := flags.String("sourcegraph-token", os.Getenv("SRC_ACCESS_TOKEN"), "Sourcegraph access token (default $SRC_ACCESS_TOKEN)")
+	concurrencyAuto := flags.Bool("concurrency-auto", false, "Start at -concurrency-min prompts in flight and adapt between the bounds to 429 responses")
+	concurrencyMin := flags.Int("concurrency-min", 1, "Lower bound for -concurrency-auto and SIGUSR1")
+	concurrencyMax := flags.Int("concurrency-max", 2*MaxConcurrent, "Upper bound for -concurrency-auto and SIGUSR2")
+	offline := flags.Bool("offline", false, "Never use the network: prompts that aren't replayed or cached are skipped, local checks and plugins still run")
+	replayPath := flags.String("replay", "", "Answer prompts with the results of this JSON report, or of the reports in this directory, instead of querying")
+	rateFlag := flags.String("rate", "", "Send at most this many Greptile requests per second, minute or hour, e.g. 2/s or 60/m")
+	maxRuntime := flags.Duration("max-runtime", 0, "Stop the run after this long, recording outstanding prompts as timed out (0 for no limit)")
+	sampleRate := flags.Float64("sample-rate", 1, "Run this fraction (0-1) of the prompts, chosen at random, e.g. 0.25 for quick checks")
+	seed := flags.Int64("seed", 0, "With -sample-rate, choose the prompts with this seed to repeat a run's selection (default: a random seed, recorded in the report)")
+	maxFindings := flags.Int("max-findings", 0, "Stop the run once this many findings have been reported (0 for no limit)")
+	maxAuthFailures := flags.Int("max-auth-failures", 3, "Abort the run after this many consecutive 401/403 responses (0 to never abort)")
+	errorLogWindow := flags.Duration("error-log-window", 30*time.Second, "Log prompt errors with the same cause once within this long, then how often they repeated (0 logs every error)")
+	debug := flags.Bool("debug", false, "Log debugging information to stderr")
+	dumpHTTP := flags.Bool("dump-http", false, "Log every backend request and response to stderr, with credentials redacted")
+	retryOpts := addRetryFlags(flags)
+	retryBudgetSize := flags.Int("retry-budget", 0, "Retry at most this many backend requests in the whole run, so an outage doesn't multiply the calls (0 for no limit)")
+	timeoutOpts := addTimeoutFlags(flags)
+	networkOpts := addNetworkFlags(flags)
+	compressRequests := flags.Bool("compress-requests", false, "Gzip request bodies, for backends that accept Content-Encoding: gzip")
+	flags.BoolVar(&failFast, "fail-fast", false, "Skip the remaining prompts of an audit once one of them reports a critical finding")
+	flags.BoolVar(&explain, "explain", false, "Show each prompt's remediation guidance under its results")
+	flags.BoolVar(&printPrompt, "print-prompt", false, "Show the whole prompt as sent to the backend above each result in text and compact output")
+	flags.BoolVar(&strictJSON, "strict-json", false, "Fail prompts whose response contains fields treeko doesn't know about")
+	flags.BoolVar(&includeRaw, "include-raw", false, "Keep each backend response on its finding under raw in -output json")
+	fieldsFlag := flags.String("fields", "", "Comma-separated finding fields to keep in -output json or grouped-json, by their JSON names (default: all)")
+	noRedact := flags.Bool("no-redact", false, "Don't redact secrets quoted in results, for local triage; never use it for reports that leave the machine")
+	flags.StringVar(&outputFormat, "output", "text", "Output format: text, json, grouped-json, sonarqube, ocsf, tree or compact")
+	sonarDefaultFile := flags.String("sonar-default-file", "README.md", "With -output sonarqube, the file that findings without a location are attached to")
+	flags.BoolVar(&localTime, "local-time", false, "Show timestamps in text output in the local time zone instead of UTC")
+	compareCodebases := flags.Bool("compare-codebases", false, "Run the same audits on every codebase and show which findings appear in which, as a table in text output or per audit with -output grouped-json")
+	tui := flags.Bool("tui", false, "Draw a live dashboard of the run in the terminal instead of streaming results: progress per audit, findings by severity and a scrollable list of findings")
+	noSummary := flags.Bool("no-summary", false, "Don't print the end-of-run summary in text output")
+	tagFlag := flags.String("tag", "", "Only report results carrying one of these comma-separated tags")
+	minConfidence := flags.Float64("min-confidence", 0, "Report results whose confidence score is below this (0-1) as low confidence, apart from the findings and never failing the run")
+	unscoredLow := flags.Bool("unscored-low-confidence", false, "With -min-confidence, count results without a confidence score as low confidence too (default: they are kept with the findings)")
+	showLowConfidence := flags.Bool("show-low-confidence", true, "List low-confidence results in text output; with false they are only counted, though JSON reports still include them")
+	dedupBy := flags.String("dedup-by", "", "Collapse results of a codebase that repeat an earlier one by content, file, fingerprint or semantic similarity (default: keep them all)")
+	dedupThreshold := flags.Float64("dedup-threshold", DefaultDedupThreshold, "With -dedup-by semantic, how similar (0-1) two results without locations must be to collapse them")
+	maxChars := flags.Int("max-response-chars", 2000, "In text output on a terminal, cut results longer than this many characters; the report keeps them whole (0 for no limit)")
+	frameworkFlag := flags.String("framework", "", "Tailor prompts to this framework, e.g. django; ask to have the backend detect it (default: detected from manifests at -repo-root; -framework= turns detection off)")
+	flags.IntVar(&repeat, "repeat", 1, "Ask each prompt this many times and report how often the answers agree, flagging prompts whose results vary")
+	showClean := flags.Bool("show-clean", false, "List the prompts that returned no results in the report")
+	showFiltered := flags.Bool("show-filtered", false, "Include findings dropped by filter rules in the report")
+	summary := flags.Bool("summary", false, "Print the end-of-run summary even with -output json (to stderr)")
+	cpuProfile := flags.String("cpuprofile", "", "Write a CPU profile of the run to this file")
+	memProfile := flags.String("memprofile", "", "Write a heap profile to this file at the end of the run")
+	tracePath := flags.String("trace", "", "Write an execution trace of the run to this file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s:\n", flags.Name())
+		printVisibleDefaults(flags)
+	}
+	flags.Parse(args)
 
-	wg.Wait()
-	fmt.Println("All audits completed.")
+	var dotFile *DotFile
+	if path := FindDotFile("."); path != "" {
+		dot, err := LoadDotFile(path, flags)
+		if err == nil {
+			err = dot.Apply(flags)
+		}
+		if err != nil {
+			log.Printf("Error reading %s: %v\n", DotFileName, err)
+			return ExitUsage
+		}
+		dotFile = dot
+	}
+
+	if outputFormat != "text" && outputFormat != "json" && outputFormat != "grouped-json" && outputFormat != "sonarqube" && outputFormat != "ocsf" && outputFormat != "tree" && outputFormat != "compact" {
+		log.Printf("Unknown output format '%s'\n", outputFormat)
+		return ExitUsage
+	}
+	if *tui {
+		if outputFormat != "text" {
+			log.Printf("-tui replaces the text output and can't be combined with -output %s\n", outputFormat)
+			return ExitUsage
+		}
+		if !isTerminal(os.Stdout) {
+			log.Println("-tui needs a terminal on stdout")
+			return ExitUsage
+		}
+		outputFormat = "tui"
+	}
+	if *compareCodebases && outputFormat != "text" && outputFormat != "tui" && outputFormat != "grouped-json" {
+		log.Printf("-compare-codebases needs -output text or grouped-json, not %s\n", outputFormat)
+		return ExitUsage
+	}
+	var selectedFields []string
+	if *fieldsFlag != "" {
+		if outputFormat != OutputJSON && outputFormat != OutputGroupedJSON {
+			log.Printf("-fields needs -output json or grouped-json, not %s\n", outputFormat)
+			return ExitUsage
+		}
+		for _, name := range strings.Split(*fieldsFlag, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				selectedFields = append(selectedFields, name)
+			}
+		}
+		if err := checkOutputFields(outputFormat, selectedFields); err != nil {
+			log.Printf("-fields: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *encryptReports != "" {
+		if err := checkEncryptedOutputs(*dbPath, *webhookURL, *dojoURL); err != nil {
+			log.Println(err)
+			return ExitUsage
+		}
+		var err error
+		if reportEncryption, err = ParseReportEncryption(*encryptReports); err != nil {
+			log.Println(err)
+			return ExitUsage
+		}
+	}
+	var signKey ed25519.PrivateKey
+	if *signKeyPath != "" {
+		if *outDir == "" {
+			log.Println("-sign-key needs -out-dir, where the signed report is written")
+			return ExitUsage
+		}
+		var err error
+		if signKey, err = LoadSigningKey(*signKeyPath); err != nil {
+			log.Printf("Error loading signing key: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *encryptStdout {
+		if reportEncryption == nil {
+			log.Println("-encrypt-stdout needs -encrypt-reports")
+			return ExitUsage
+		}
+		if *tui {
+			log.Println("-encrypt-stdout can't be combined with -tui")
+			return ExitUsage
+		}
+		finish, err := reportEncryption.EncryptStdout()
+		if err != nil {
+			log.Printf("Error encrypting stdout: %v\n", err)
+			return ExitErrors
+		}
+		defer func() {
+			if err := finish(); err != nil {
+				log.Printf("Error encrypting stdout: %v\n", err)
+			}
+		}()
+	}
+	if *noSummary && *summary {
+		log.Println("-summary and -no-summary are mutually exclusive")
+		return ExitUsage
+	}
+	showSummary := *summary || ((outputFormat == "text" || outputFormat == "tui") && !*noSummary)
+	annotator, err := NewAnnotator(*annotations)
+	if err != nil {
+		log.Println(err)
+		return ExitUsage
+	}
+	if *dedupBy != "" && *dedupBy != DedupContent && *dedupBy != DedupFile && *dedupBy != DedupFingerprint && *dedupBy != DedupSemantic {
+		log.Printf("Unknown -dedup-by '%s', expected content, file, fingerprint or semantic\n", *dedupBy)
+		return ExitUsage
+	}
+	if *dedupThreshold <= 0 || *dedupThreshold > 1 {
+		log.Printf("-dedup-threshold must be above 0 and at most 1, got %g\n", *dedupThreshold)
+		return ExitUsage
+	}
+	if *maxChars < 0 {
+		log.Printf("-max-response-chars must not be negative, got %d\n", *maxChars)
+		return ExitUsage
+	}
+	if repeat < 1 {
+		log.Printf("-repeat must be at least 1, got %d\n", repeat)
+		return ExitUsage
+	}
+	if *minConfidence < 0 || *minConfidence > 1 {
+		log.Printf("-min-confidence must be between 0 and 1, got %g\n", *minConfidence)
+		return ExitUsage
+	}
+	if *minConfidence > 0 || *unscoredLow {
+		lowConfidence = &ConfidenceThreshold{Min: *minConfidence, Unscored: *unscoredLow, Hide: !*showLowConfidence}
+	}
+	if *backendName != BackendGreptile && *backendName != BackendOpenAI {
+		log.Printf("Unknown backend '%s'\n", *backendName)
+		return ExitUsage
+	}
+	if *offline {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{{"-github-org", *githubOrg != ""}, {"-webhook", *webhookURL != ""}, {"-defectdojo-url", *dojoURL != ""}, {"-repeat", repeat > 1}} {
+			if f.set {
+				log.Printf("%s needs the network and can't be used with -offline\n", f.name)
+				return ExitUsage
+			}
+		}
+	}
+	if *replayPath != "" {
+		var err error
+		if replay, err = LoadReplay(*replayPath); err != nil {
+			log.Printf("Error loading -replay: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *concurrencyMin < 1 || *concurrencyMax < *concurrencyMin {
+		log.Printf("-concurrency-min must be at least 1 and not above -concurrency-max, got %d and %d\n", *concurrencyMin, *concurrencyMax)
+		return ExitUsage
+	}
+	if *sampleRate <= 0 || *sampleRate > 1 {
+		log.Printf("-sample-rate must be above 0 and at most 1, got %g\n", *sampleRate)
+		return ExitUsage
+	}
+	if *maxFindings < 0 || *maxAuthFailures < 0 || *maxRuntime < 0 || *retryBudgetSize < 0 {
+		log.Println("-max-findings, -max-auth-failures, -max-runtime and -retry-budget must not be negative")
+		return ExitUsage
+	}
+	if *errorLogWindow < 0 {
+		log.Printf("-error-log-window must not be negative, got %s\n", *errorLogWindow)
+		return ExitUsage
+	}
+	if *errorLogWindow > 0 {
+		errorLog = NewErrorLog(*errorLogWindow)
+	}
+	if *debug {
+		debugLog.SetOutput(os.Stderr)
+		listFailedPrompts = true
+	}
+	profiler, err := StartProfiling(*cpuProfile, *memProfile, *tracePath)
+	if err != nil {
+		log.Printf("Error starting profiling: %v\n", err)
+		return ExitUsage
+	}
+	defer profiler.Stop()
+	if *rateFlag != "" {
+		limit, err := ParseRate(*rateFlag)
+		if err != nil {
+			log.Println(err)
+			return ExitUsage
+		}
+		rateLimiter = NewRateLimiter(limit)
+	}
+	retryPolicies, err := retryOpts.policies()
+	if err != nil {
+		log.Println(err)
+		return ExitUsage
+	}
+	timeouts, err := timeoutOpts.timeouts()
+	if err != nil {
+		log.Println(err)
+		return ExitUsage
+	}
+	if *retryBudgetSize > 0 {
+		retryBudget = NewRetryBudget(*retryBudgetSize)
+	}
+	if err := networkOpts.apply(); err != nil {
+		log.Println(err)
+		return ExitUsage
+	}
+	// The run ID is sent with every backend request, so it is chosen before
+	// the clients are built.
+	runID := NewRunID()
+	debugf("run %s", runID)
+	httpMetrics := &HTTPMetrics{}
+	var httpDump *log.Logger
+	if *dumpHTTP {
+		httpDump = log.New(os.Stderr, "http: ", log.LstdFlags)
+	}
+	// Every backend shares the rate limit, retry policy and metrics; only
+	// the credentials differ.
+	backendClient := func(header, value string) *http.Client {
+		return NewBackendClient(
+			WithAuth(header, value),
+			WithCorrelation(runID),
+			WithRateLimit(rateLimiter),
+			WithRetry(retryPolicies),
+			WithTimeouts(timeouts),
+			WithRequestCompression(*compressRequests),
+			WithMetrics(httpMetrics),
+			WithDump(httpDump),
+		)
+	}
+	defaultBackend = &GreptileBackend{URL: GreptileAPIUrl, Client: backendClient("Authorization", "Bearer "+APIKey)}
+
+	audits := builtinAudits
+	if err := CompileLocalChecks(audits); err != nil {
+		log.Printf("Error in built-in audits: %v\n", err)
+		return ExitUsage
+	}
+	var skippedPrompts []error
+	if *promptsDir != "" {
+		var skipped []error
+		var err error
+		audits, skipped, err = LoadPromptsDir(audits, *promptsDir, *promptsRecursive)
+		skippedPrompts = append(skippedPrompts, skipped...)
+		if err != nil {
+			log.Printf("Error loading prompts: %v\n", err)
+			return ExitUsage
+		}
+	}
+	var userDefaults *UserDefaults
+	if !*noUserConfig {
+		var err error
+		if userDefaults, err = LoadUserDefaults(UserConfigDir()); err != nil {
+			log.Printf("Error loading user config: %v\n", err)
+			return ExitUsage
+		}
+	}
+	// Personal prompts merge after the repository's, so a prompt both define
+	// keeps the repository's version.
+	if userDefaults != nil && userDefaults.PromptsDir != "" {
+		debugf("loading prompts from %s", userDefaults.PromptsDir)
+		var skipped []error
+		var err error
+		audits, skipped, err = LoadPromptsDir(audits, userDefaults.PromptsDir, true)
+		skippedPrompts = append(skippedPrompts, skipped...)
+		if err != nil {
+			log.Printf("Error loading prompts: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if len(skippedPrompts) > 0 {
+		if *strictPrompts {
+			for _, err := range skippedPrompts {
+				log.Printf("Error loading prompts: %v\n", err)
+			}
+			return ExitUsage
+		}
+		for _, err := range skippedPrompts {
+			log.Printf("Warning: skipping invalid prompt file entry: %v\n", err)
+		}
+		log.Printf("Warning: skipped %d invalid prompt file entries; -strict makes them errors\n", len(skippedPrompts))
+	}
+
+	// Ad-hoc prompts form one audit, which runs alone unless -audits names
+	// others too.
+	var auditIDs []string
+	adhocSelected := false
+	for _, id := range strings.Split(*auditsFlag, ",") {
+		if id = strings.TrimSpace(id); id == "-" && !adhocSelected {
+			auditIDs = append(auditIDs, AdHocAuditID)
+			adhocSelected = true
+			adhoc, err := LoadPromptLines(os.Stdin, "stdin")
+			if err != nil {
+				log.Printf("Error loading prompts: %v\n", err)
+				return ExitUsage
+			}
+			audits = MergeAudits(audits, "stdin", []Audit{adhoc})
+		} else if id != "" && id != "-" {
+			auditIDs = append(auditIDs, id)
+		}
+	}
+	if *promptsTxt != "" {
+		f, err := os.Open(*promptsTxt)
+		if err != nil {
+			log.Printf("Error loading prompts: %v\n", err)
+			return ExitUsage
+		}
+		adhoc, err := LoadPromptLines(f, *promptsTxt)
+		f.Close()
+		if err != nil {
+			log.Printf("Error loading prompts: %v\n", err)
+			return ExitUsage
+		}
+		audits = MergeAudits(audits, *promptsTxt, []Audit{adhoc})
+		if !adhocSelected {
+			auditIDs = append(auditIDs, AdHocAuditID)
+		}
+	}
+
+	if *webhookPayload != WebhookReport && *webhookPayload != WebhookSummary {
+		log.Printf("Unknown -webhook-payload '%s', expected report or summary\n", *webhookPayload)
+		return ExitUsage
+	}
+	if *webhookAttempts < 1 {
+		log.Println("-webhook-attempts must be at least 1")
+		return ExitUsage
+	}
+
+	if *dojoURL != "" && (*dojoToken == "" || *dojoProduct == "") {
+		log.Println("-defectdojo-url needs -defectdojo-token and -defectdojo-product")
+		return ExitUsage
+	}
+
+	var tmpl *template.Template
+	if *reportTemplate != "" {
+		if *reportOut == "" && *outDir == "" {
+			log.Println("-report-template needs -report-out or -out-dir")
+			return ExitUsage
+		}
+		var err error
+		if tmpl, err = LoadReportTemplate(*reportTemplate); err != nil {
+			log.Printf("Error loading report template: %v\n", err)
+			return ExitUsage
+		}
+	}
+
+	var policy *Policy
+	if *policyPath != "" {
+		var err error
+		if policy, err = LoadPolicy(*policyPath, audits); err != nil {
+			log.Printf("Error loading policy: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *notesPath != "" {
+		var err error
+		if noteRules, err = LoadNoteRules(*notesPath, audits); err != nil {
+			log.Printf("Error loading notes: %v\n", err)
+			return ExitUsage
+		}
+	}
+
+	codebases := []CodebaseConfig{{ID: CodebaseID}}
+	if dotFile != nil {
+		debugf("using %s", dotFile.Path)
+		if dotFile.Codebase != "" {
+			codebases = []CodebaseConfig{{ID: dotFile.Codebase, Branch: dotFile.Branch}}
+		}
+	}
+	var plugins []PluginConfig
+	var hooks HooksConfig
+	var filters []FilterRule
+	var severityOverrides []SeverityOverride
+	var auditSwitches map[string]bool
+	var cfg *Config
+	if *configPath != "" {
+		var err error
+		cfg, err = LoadConfig(*configPath)
+		if err == nil {
+			err = cfg.Validate(*configPath, audits)
+		}
+		if err != nil {
+			log.Printf("Error loading config: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if userDefaults != nil && userDefaults.Config != nil {
+		debugf("using %s", userDefaults.ConfigPath)
+		if err := userDefaults.Config.Validate(userDefaults.ConfigPath, audits); err != nil {
+			log.Printf("Error loading user config: %v\n", err)
+			return ExitUsage
+		}
+		if cfg == nil {
+			cfg = &Config{}
+		}
+		cfg.Beneath(userDefaults.Config)
+	}
+	if cfg != nil {
+		if len(cfg.Codebases) > 0 {
+			codebases = cfg.Codebases
+		}
+		plugins = cfg.Plugins
+		hooks = cfg.Hooks
+		filters = cfg.Filters
+		severityOverrides = cfg.SeverityOverrides
+		auditSwitches = cfg.Audits
+		outputConfigs = cfg.Outputs
+		if *sourcegraphURL == "" {
+			*sourcegraphURL = cfg.Sourcegraph.URL
+		}
+	}
+	// -fields overrides the config's fields for the JSON output.
+	if selectedFields != nil {
+		c := outputConfigs[outputFormat]
+		c.Fields = selectedFields
+		configs := map[string]OutputConfig{outputFormat: c}
+		for name, o := range outputConfigs {
+			if name != outputFormat {
+				configs[name] = o
+			}
+		}
+		outputConfigs = configs
+	}
+	if err := cfg.ResolveAliases(codebases); err != nil {
+		log.Printf("Error resolving codebase aliases: %v\n", err)
+		return ExitUsage
+	}
+	if !*noRedact {
+		var extra []string
+		if cfg != nil {
+			extra = cfg.Redact
+		}
+		if redactor, err = NewRedactor(extra); err != nil {
+			log.Printf("Error loading redact patterns: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *sourcegraphURL != "" && !*offline {
+		auth := ""
+		if *sourcegraphToken != "" {
+			auth = "token " + *sourcegraphToken
+		}
+		sourcegraph = &SourcegraphBackend{URL: *sourcegraphURL, Client: backendClient("Authorization", auth)}
+	}
+	if audits, err = EnabledAudits(audits, auditSwitches, auditIDs); err != nil {
+		log.Printf("Error selecting audits: %v\n", err)
+		return ExitUsage
+	}
+
+	if *githubOrg != "" {
+		filter := RepoFilter{Topic: *topic, Language: *language, IncludeArchived: *includeArchived}
+		repos, err := ListOrgRepos(*githubOrg, *githubToken, filter, *maxRepos)
+		if err != nil {
+			log.Printf("Error listing GitHub repositories: %v\n", err)
+			return ExitErrors
+		}
+		if *dryRun {
+			for _, r := range repos {
+				fmt.Printf("%s (branch %s)\n", r.FullName, r.DefaultBranch)
+			}
+			fmt.Printf("%d repositories would be audited.\n", len(repos))
+			return ExitOK
+		}
+		if *configPath == "" {
+			codebases = nil
+		}
+		for _, r := range repos {
+			if hasCodebase(codebases, r.FullName) {
+				continue
+			}
+			if *backendName == BackendGreptile {
+				if err := EnsureIndexed(r.FullName, r.DefaultBranch, *githubToken); err != nil {
+					log.Printf("Warning: %v\n", err)
+				}
+			}
+			_, alias := cfg.ResolveCodebase(r.FullName)
+			codebases = append(codebases, CodebaseConfig{ID: r.FullName, Branch: r.DefaultBranch, Alias: alias})
+		}
+		if len(codebases) == 0 {
+			log.Printf("No repositories in %s match the filters\n", *githubOrg)
+			return ExitUsage
+		}
+	}
+
+	if *compareCodebases && len(codebases) < 2 {
+		log.Printf("-compare-codebases needs at least two codebases, got %d\n", len(codebases))
+		return ExitUsage
+	}
+
+	var openAI *OpenAIBackend
+	for i := range codebases {
+		if codebases[i].Backend == "" && *backendName != BackendGreptile {
+			codebases[i].Backend = *backendName
+		}
+		if codebases[i].Backend == BackendOpenAI && openAI == nil {
+			if *openAIModel == "" {
+				log.Println("-openai-model is required for the openai backend")
+				return ExitUsage
+			}
+			system, err := LoadSystemPrompt(*openAISystem)
+			if err != nil {
+				log.Printf("Error loading system prompt: %v\n", err)
+				return ExitUsage
+			}
+			auth := ""
+			if *openAIKey != "" {
+				auth = "Bearer " + *openAIKey
+			}
+			openAI = &OpenAIBackend{BaseURL: *openAIURL, Model: *openAIModel, System: system, Client: backendClient("Authorization", auth)}
+		}
+	}
+
+	hookSecrets = []string{APIKey, *openAIKey, *sourcegraphToken}
+
+	gitInfo := CollectGitInfo(*repoRoot)
+	ids := make([]string, len(codebases))
+	for i, cb := range codebases {
+		ids[i] = cb.ID
+	}
+	report := NewReport(RunMetadata{
+		RunID:       runID,
+		ToolVersion: Version,
+		Codebase:    strings.Join(ids, ","),
+		ConfigHash:  ConfigHash(audits, codebases, plugins, filters),
+		StartedAt:   time.Now().UTC(),
+		Git:         gitInfo,
+	})
+	report.filters = filters
+	report.severityOverrides = severityOverrides
+	report.dedupBy = *dedupBy
+	report.dedupThreshold = *dedupThreshold
+	for _, t := range strings.Split(*tagFlag, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			report.tags = append(report.tags, t)
+		}
+	}
+
+	// .treekoignore belongs to the local checkout, which only describes the
+	// codebase when a single one is audited.
+	if len(codebases) == 1 {
+		ignorePath := filepath.Join(*repoRoot, IgnoreFileName)
+		if _, err := os.Stat(ignorePath); err == nil {
+			entries, err := LoadIgnoreFile(ignorePath, time.Now())
+			if err != nil {
+				log.Printf("Error loading suppressions: %v\n", err)
+				return ExitUsage
+			}
+			for _, s := range entries {
+				if s.expired {
+					log.Printf("Warning: %s: suppression of %s expired on %s and no longer applies\n", ignorePath, s.Target, s.Expires)
+				}
+			}
+			report.Suppressions = ActiveSuppressions(entries)
+		}
+	}
+
+	if *changedFrom != "" {
+		files, err := LoadChangedFiles(*changedFrom, *repoRoot)
+		if err != nil {
+			log.Printf("Error loading changed files: %v\n", err)
+			return ExitUsage
+		}
+		report.Metadata.Scope = &RunScope{Source: *changedFrom, Files: files}
+		if outputFormat == "text" {
+			fmt.Printf("Scoped to %d changed files from %s\n", len(files), *changedFrom)
+		}
+	}
+
+	if *cacheDir != "" {
+		cache, err := NewResultCache(*cacheDir)
+		if err != nil {
+			log.Printf("Caching disabled: %v\n", err)
+		} else {
+			resultCache = cache
+		}
+	}
+	if *offline && resultCache == nil && replay == nil {
+		log.Println("Warning: -offline without -cache-dir or -replay skips every prompt; only local checks and plugins run")
+	}
+
+	// The local checkout's revision only describes the codebase when a
+	// single one is audited; with several, each must declare its own.
+	defaultRev := *codebaseRev
+	if defaultRev == "" && gitInfo.Commit != nil {
+		defaultRev = *gitInfo.Commit
+	}
+	if len(codebases) > 1 {
+		defaultRev = ""
+	}
+
+	// Pre-filtering and local checks inspect the local checkout, so they only
+	// apply when one was given explicitly and it describes the single
+	// audited codebase.
+	repoRootSet, maxCharsSet, frameworkSet, seedSet := false, false, false, false
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "seed":
+			seedSet = true
+		case "repo-root":
+			repoRootSet = true
+		case "max-response-chars":
+			maxCharsSet = true
+		case "framework":
+			frameworkSet = true
+		}
+	})
+	if *sampleRate < 1 {
+		if !seedSet {
+			*seed = time.Now().UnixNano()
+		}
+		report.Metadata.Sample = &SampleInfo{Rate: *sampleRate, Seed: *seed}
+	}
+	// Piped output is usually kept, so only a terminal gets the default cap.
+	if maxCharsSet || isTerminal(os.Stdout) {
+		maxResponseChars = *maxChars
+	}
+	var repoFiles []string
+	localScan := repoRootSet && len(codebases) == 1
+	if localScan {
+		files, err := ScanRepoFiles(*repoRoot)
+		if err != nil {
+			log.Printf("Warning: pre-filter and local checks disabled, scanning %s failed: %v\n", *repoRoot, err)
+			localScan = false
+		}
+		repoFiles = files
+	}
+	prefilter := localScan && !*noPrefilter
+
+	// Like its revision, the checkout's language only describes the
+	// codebase when a single one is audited.
+	promptLanguage = strings.ToLower(strings.TrimSpace(*language))
+	if promptLanguage == "" && len(codebases) == 1 {
+		if lang, marker := DetectLanguage(*repoRoot); lang != "" {
+			promptLanguage = lang
+			if outputFormat == "text" {
+				fmt.Printf("Asking %s prompt variants (found %s)\n", lang, marker)
+			}
+		}
+	}
+
+	// The framework is sniffed from the checkout's manifests, so like the
+	// local checks it needs one, unless the backend is asked.
+	switch framework := strings.ToLower(strings.TrimSpace(*frameworkFlag)); {
+	case framework == FrameworkAsk:
+		if len(codebases) != 1 || *offline {
+			log.Println("-framework=ask needs a single codebase and the network")
+			return ExitUsage
+		}
+		target := Target{Codebase: codebases[0].ID, Branch: codebases[0].Branch}
+		if codebases[0].Backend == BackendOpenAI {
+			target.Backend = openAI
+		}
+		found, err := AskFrameworks(context.Background(), target.backend(), target)
+		if err != nil {
+			log.Printf("Warning: asking for the codebase's frameworks failed, prompts aren't tailored: %v\n", err)
+		} else {
+			report.Metadata.Framework = NewFrameworkInfo(FrameworkFromQuery, found)
+		}
+	case framework != "":
+		report.Metadata.Framework = &FrameworkInfo{Name: framework, Source: FrameworkFromFlag}
+	case !frameworkSet && localScan:
+		if found := DetectFrameworks(*repoRoot); len(found) > 0 {
+			report.Metadata.Framework = NewFrameworkInfo(FrameworkFromManifest, found)
+		}
+	}
+	vars := promptVars{Language: promptLanguage}
+	if fw := report.Metadata.Framework; fw != nil && fw.Name != "" {
+		vars.Framework = fw.Name
+		if outputFormat == "text" && fw.Source != FrameworkFromFlag {
+			fmt.Printf("Tailoring prompts to %s (detected by %s)\n", fw.Name, fw.Source)
+		}
+	} else if fw != nil && len(fw.Candidates) > 0 {
+		log.Printf("Warning: found several frameworks (%s); prompts aren't tailored to any, choose one with -framework\n", strings.Join(fw.Candidates, ", "))
+	}
+	if audits, err = TailorAudits(audits, vars); err != nil {
+		log.Printf("Error in prompts: %v\n", err)
+		return ExitUsage
+	}
+
+	var runDir *RunDir
+	var journal *Journal
+	if *outDir != "" {
+		// Created before any prompt runs, so even a crashed run leaves its
+		// metadata behind.
+		runDir, err = CreateRunDir(*outDir, report.Metadata)
+		if err != nil {
+			log.Printf("Error creating run directory: %v\n", err)
+			return ExitErrors
+		}
+		if journal, err = runDir.CreateJournal(); err != nil {
+			log.Printf("Error creating run journal: %v\n", err)
+			return ExitErrors
+		}
+		if *debug {
+			if f, err := runDir.CreateDebugLog("debug.log"); err == nil {
+				defer f.Close()
+				debugLog.SetOutput(io.MultiWriter(os.Stderr, f))
+			}
+		}
+		if httpDump != nil {
+			if f, err := runDir.CreateDebugLog("http.log"); err == nil {
+				defer f.Close()
+				httpDump.SetOutput(io.MultiWriter(os.Stderr, f))
+			}
+		}
+		if tmpl != nil && *reportOut == "" {
+			*reportOut = runDir.File("report" + templateExt(*reportTemplate))
+		}
+		if outputFormat == "text" {
+			fmt.Printf("Writing artifacts to %s\n", runDir.Path)
+		}
+		truncatedMarker = "… [truncated, full text in " + reportEncryption.Path(runDir.File(RunDirReport)) + "]"
+	}
+
+	hookFailed := false
+	signFailed := false
+	if err := RunHooks("preRun", hooks.PreRun, map[string]string{
+		"TREEKO_RUN_ID":   report.Metadata.RunID,
+		"TREEKO_CODEBASE": report.Metadata.Codebase,
+	}); err != nil {
+		log.Printf("Error: %v\n", err)
+		return ExitErrors
+	}
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+	if *maxAuthFailures > 0 {
+		authGuard = NewAuthGuard(*maxAuthFailures, cancelRun)
+	}
+	if *maxFindings > 0 {
+		findingCap = NewFindingCap(*maxFindings, cancelRun)
+	}
+	if *maxRuntime > 0 {
+		runDeadline = StartRunDeadline(*maxRuntime, cancelRun)
+	}
+	runInterrupt = WatchInterrupt(cancelRun)
+
+	var findingHooks *FindingHooks
+	if len(hooks.OnFinding) > 0 {
+		findingHooks = StartFindingHooks(hooks.OnFinding, report.Metadata.RunID)
+	}
+	report.onFinding = func(f Finding) {
+		if findingHooks != nil {
+			findingHooks.Notify(f)
+		}
+		if journal != nil {
+			journal.Write(findingView(f, OutputRunDir))
+		}
+		findingCap.Record(f)
+		dashboard.Record(f)
+	}
+
+	var pool *ConcurrencyPool
+	if *concurrencyAuto {
+		pool = NewAdaptiveConcurrency(*concurrencyMin, *concurrencyMax)
+	} else {
+		limit := MaxConcurrent
+		if limit > *concurrencyMax {
+			limit = *concurrencyMax
+		}
+		if limit < *concurrencyMin {
+			limit = *concurrencyMin
+		}
+		pool = NewConcurrencyPool(*concurrencyMin, *concurrencyMax, limit)
+	}
+	watchConcurrencySignals(runCtx, pool)
+
+	if outputFormat == "tui" {
+		dashboard = StartDashboard(os.Stdout)
+	}
+	// plan is what each codebase was to run, for -manifest.
+	var plan []manifestPlan
+	for _, cb := range codebases {
+		target := Target{Codebase: cb.ID, Alias: cb.Alias, Branch: cb.Branch, Revision: cb.Revision}
+		if cb.Backend == BackendOpenAI {
+			target.Backend = openAI
+		}
+		if *offline {
+			target.Backend = Offline(target.backend())
+		}
+		if target.Revision == "" {
+			target.Revision = defaultRev
+		}
+		if resultCache != nil && target.Revision == "" {
+			log.Printf("Caching disabled for codebase '%s': %v\n", cb.ID, errNoRevision)
+		}
+		if outputFormat == "text" && len(codebases) > 1 {
+			fmt.Printf("Auditing codebase %s:\n", codebaseLabel(cb.ID, cb.Alias))
+		}
+
+		selected := selectAudits(audits, cb.Audits)
+		if *compareCodebases {
+			// A comparison asks every codebase the same prompts.
+			selected = audits
+		}
+		plan = append(plan, manifestPlan{codebase: cb, audits: selected})
+		if sample := report.Metadata.Sample; sample != nil {
+			var kept, out int
+			selected, kept, out = SamplePrompts(selected, sample.Rate, sample.Seed)
+			plan[len(plan)-1].sampled = selected
+			sample.Sampled += kept
+			sample.SampledOut += out
+			if outputFormat == "text" {
+				fmt.Printf("Sampled %d of %d prompts (-sample-rate %g -seed %d)\n", kept, kept+out, sample.Rate, sample.Seed)
+			}
+		}
+		if runCtx.Err() != nil {
+			// The run was aborted or capped before this codebase started;
+			// -max-runtime times out its prompts like any outstanding work.
+			for _, a := range selected {
+				if !runDeadline.Expired() {
+					report.AddSkipped(SkippedAudit{Codebase: cb.ID, Audit: a.Name, Reason: skipReason()})
+					continue
+				}
+				for _, prompt := range a.Prompts {
+					f, _ := promptFinding(target, a, prompt)
+					recordTimedOut(report, f)
+				}
+			}
+			continue
+		}
+		if prefilter {
+			var skipped []Audit
+			selected, skipped = PrefilterAudits(selected, repoFiles)
+			for _, a := range skipped {
+				report.AddSkipped(SkippedAudit{Codebase: cb.ID, Audit: a.Name, Reason: SkipNoMatchingFiles})
+				if outputFormat == "text" {
+					fmt.Printf("Skipping %s audit: %s\n", a.Name, SkipNoMatchingFiles)
+				}
+			}
+		}
+		var wg sync.WaitGroup
+		wg.Add(len(selected))
+		for _, audit := range selected {
+			go RunAudit(runCtx, target, audit, report, pool, &wg)
+		}
+		if localScan {
+			for _, audit := range selected {
+				wg.Add(1)
+				go func(audit Audit) {
+					defer wg.Done()
+					RunLocalChecks(*repoRoot, repoFiles, cb.ID, audit, report)
+				}(audit)
+			}
+		}
+		pctx := PluginContext{RunID: report.Metadata.RunID, Codebase: cb.ID, Branch: cb.Branch, Revision: target.Revision}
+		if localScan {
+			pctx.RepoRoot, _ = filepath.Abs(*repoRoot)
+		}
+		if report.Metadata.Scope != nil {
+			pctx.Files = report.Metadata.Scope.Files
+		}
+		for _, plugin := range plugins {
+			wg.Add(1)
+			go func(plugin PluginConfig) {
+				defer wg.Done()
+				RunPlugin(runDeadline.Context(), plugin, pctx, report)
+			}(plugin)
+		}
+		wg.Wait()
+	}
+
+	runDeadline.Stop()
+	runInterrupt.Stop()
+	dashboard.Close()
+	errorLog.Flush()
+	if runDeadline.Expired() {
+		report.Metadata.Stopped = fmt.Sprintf("-max-runtime %s reached", *maxRuntime)
+	} else if runInterrupt.Interrupted() {
+		report.Metadata.Stopped = "interrupted"
+	}
+	if findingHooks != nil {
+		if err := findingHooks.Close(); err != nil {
+			hookFailed = true
+		}
+	}
+
+	report.Metadata.FinishedAt = time.Now().UTC()
+	report.Metadata.RetryBudget = retryBudget.Info()
+	if attempts, failed, statuses := httpMetrics.Snapshot(); attempts > 0 {
+		debugf("backend requests: %d attempts, %d without a response, by status %v", attempts, failed, statuses)
+		if timeouts := httpMetrics.Timeouts(); len(timeouts) > 0 {
+			debugf("backend timeouts by phase: %v", timeouts)
+		}
+	}
+	if *concurrencyAuto && outputFormat == "text" {
+		fmt.Printf("Concurrency settled at %d (bounds %d to %d).\n", pool.Limit(), *concurrencyMin, *concurrencyMax)
+	}
+	report.Summarize(codebases)
+	if *compareCodebases {
+		report.comparison = CompareCodebases(report)
+	}
+	if *showClean {
+		report.ListClean()
+	}
+	if policy != nil {
+		report.Policy = policy.Evaluate(report.Findings)
+	}
+	if !*showFiltered {
+		report.Filtered = nil
+	}
+
+	if *dbPath != "" {
+		if err := WriteFindingsDB(*dbPath, report); err != nil {
+			log.Printf("Error writing findings to %s: %v\n", *dbPath, err)
+		}
+	}
+
+	if runDir != nil {
+		if err := journal.Close(); err != nil {
+			log.Printf("Error writing %s: %v\n", reportEncryption.Path(runDir.File(RunDirJournal)), err)
+		}
+		if err := runDir.WriteReport(reportView(report, OutputRunDir)); err != nil {
+			log.Printf("Error writing %s: %v\n", reportEncryption.Path(runDir.File(RunDirReport)), err)
+		}
+		if signKey != nil {
+			if err := runDir.WriteSignature(reportView(report, OutputRunDir), signKey); err != nil {
+				log.Printf("Error signing the report: %v\n", err)
+				signFailed = true
+			}
+		}
+		if err := runDir.WriteMetadata(report.Metadata); err != nil {
+			log.Printf("Error writing %s: %v\n", reportEncryption.Path(runDir.File(RunDirMetadata)), err)
+		}
+	}
+
+	exitCode := report.ExitCode()
+	if len(hooks.PostRun) > 0 {
+		if err := RunPostRunHooks(hooks.PostRun, report, exitCode); err != nil {
+			log.Printf("Error: %v\n", err)
+			hookFailed = true
+		}
+	}
+	if hookFailed || signFailed {
+		exitCode = ExitErrors
+	}
+	if authGuard.Tripped() {
+		exitCode = ExitAuth
+	} else if runDeadline.Expired() {
+		exitCode = ExitDeadline
+	} else if runInterrupt.Interrupted() {
+		exitCode = ExitErrors
+	}
+	if annotator != nil {
+		// CI systems also read their commands from stderr; keep a JSON or
+		// SonarQube report on stdout parseable.
+		w := os.Stdout
+		if outputFormat != "text" {
+			w = os.Stderr
+		}
+		if err := annotator.Annotate(w, report, Annotations(*repoRoot, report)); err != nil {
+			log.Printf("Error writing annotations: %v\n", err)
+		}
+	}
+	if *webhookURL != "" {
+		hook := &Webhook{URL: *webhookURL, Header: webhookHeaders.header(), Secret: *webhookSecret, Payload: *webhookPayload, Attempts: *webhookAttempts}
+		if err := hook.Send(reportView(report, OutputWebhook)); err != nil {
+			log.Printf("Error: %v\n", err)
+			exitCode = ExitErrors
+		}
+	}
+	if *dojoFile != "" || *dojoURL != "" {
+		export, err := DefectDojoExport(reportView(report, OutputDefectDojo))
+		if err == nil && *dojoFile != "" {
+			err = WriteDefectDojoFile(*dojoFile, export)
+		}
+		if err == nil && *dojoURL != "" {
+			dojo := &DefectDojo{URL: *dojoURL, Token: *dojoToken, Product: *dojoProduct, ProductType: *dojoProductType, Engagement: *dojoEngagement, TestTitle: *dojoTest}
+			err = dojo.Reimport(export)
+		}
+		if err != nil {
+			log.Printf("Error exporting to DefectDojo: %v\n", err)
+			exitCode = ExitErrors
+		}
+	}
+	if tmpl != nil {
+		if err := WriteTemplateReport(tmpl, *reportOut, reportView(report, OutputTemplate)); err != nil {
+			log.Printf("Error rendering report template: %v\n", err)
+			exitCode = ExitErrors
+		}
+	}
+	if *reportPDF != "" {
+		// A PDF that can't be written doesn't fail the run.
+		if err := WritePDFReport(*reportPDF, reportView(report, OutputPDF)); err != nil {
+			log.Printf("Error writing PDF report: %v\n", err)
+		}
+	}
+	if *manifestPath != "" {
+		if err := WriteManifest(*manifestPath, BuildManifest(plan, report)); err != nil {
+			log.Printf("Error writing manifest: %v\n", err)
+			exitCode = ExitErrors
+		}
+	}
+	if *postProcessor != "" {
+		out, err := RunPostProcessor(*postProcessor, *postProcessorTimeout, reportView(report, OutputPostProcessor))
+		if err != nil {
+			log.Printf("Error running post-processor: %v\n", err)
+			exitCode = ExitErrors
+		} else if *postProcessorReplace {
+			os.Stdout.Write(out)
+			return exitCode
+		}
+		if len(out) > 0 {
+			log.Printf("Post-processor output:\n%s", out)
+		}
+	}
+
+	switch outputFormat {
+	case "json":
+		if err := WriteJSONReport(os.Stdout, reportView(report, OutputJSON)); err != nil {
+			log.Printf("Error writing JSON report: %v\n", err)
+			return ExitErrors
+		}
+		// Keep stdout parseable; the summary is for whoever watches the run.
+		if showSummary {
+			WriteTextSummary(os.Stderr, report)
+			WriteTextMetadata(os.Stderr, report.Metadata)
+		}
+	case "grouped-json":
+		if err := WriteGroupedJSON(os.Stdout, reportView(report, OutputGroupedJSON)); err != nil {
+			log.Printf("Error writing JSON report: %v\n", err)
+			return ExitErrors
+		}
+		if showSummary {
+			WriteTextSummary(os.Stderr, report)
+			WriteTextMetadata(os.Stderr, report.Metadata)
+		}
+	case "sonarqube":
+		if err := WriteSonarReport(os.Stdout, *repoRoot, *sonarDefaultFile, reportView(report, OutputSonarQube)); err != nil {
+			log.Printf("Error writing SonarQube report: %v\n", err)
+			return ExitErrors
+		}
+		if showSummary {
+			WriteTextSummary(os.Stderr, report)
+			WriteTextMetadata(os.Stderr, report.Metadata)
+		}
+	case "ocsf":
+		if err := WriteOCSF(os.Stdout, reportView(report, OutputOCSF)); err != nil {
+			log.Printf("Error writing OCSF events: %v\n", err)
+			return ExitErrors
+		}
+		if showSummary {
+			WriteTextSummary(os.Stderr, report)
+			WriteTextMetadata(os.Stderr, report.Metadata)
+		}
+	case "tree":
+		WriteTree(os.Stdout, report)
+		if showSummary {
+			WriteTextSummary(os.Stderr, report)
+			WriteTextMetadata(os.Stderr, report.Metadata)
+		}
+	case "compact":
+		WriteCompact(os.Stdout, report, outputWidth(os.Stdout))
+		if showSummary {
+			WriteTextSummary(os.Stderr, report)
+			WriteTextMetadata(os.Stderr, report.Metadata)
+		}
+	default:
+		if showSummary {
+			fmt.Println("All audits completed.")
+			WriteTextSummary(os.Stdout, report)
+			WriteTextMetadata(os.Stdout, report.Metadata)
+		}
+		if report.comparison != nil {
+			WriteComparison(os.Stdout, report.comparison)
+		}
+	}
+	return exitCode
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Manifest outcomes of a prompt.
+const (
+	ManifestRan     = "ran"
+	ManifestSkipped = "skipped"
+	ManifestFailed  = "failed"
+)
+
+// SkipSampledOut is the manifest's reason for prompts -sample-rate left
+// out. The report doesn't list them under skipped, where they would drown
+// the other skips out.
+const SkipSampledOut = "sampled out"
+
+// Manifest is what -manifest writes: every prompt the run was asked to
+// check, per codebase, with whether it ran, whether or not it found
+// anything. It proves what was scanned, apart from what was found.
+type Manifest struct {
+	RunID       string             `json:"runId"`
+	ToolVersion string             `json:"toolVersion"`
+	StartedAt   time.Time          `json:"startedAt"`
+	FinishedAt  time.Time          `json:"finishedAt"`
+	Totals      ManifestTotals     `json:"totals"`
+	Codebases   []ManifestCodebase `json:"codebases"`
+}
+
+// ManifestTotals counts the prompts of every codebase by outcome.
+type ManifestTotals struct {
+	Ran     int `json:"ran"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+type ManifestCodebase struct {
+	Codebase string          `json:"codebase"`
+	Alias    string          `json:"alias,omitempty"`
+	Branch   string          `json:"branch,omitempty"`
+	Audits   []ManifestAudit `json:"audits"`
+}
+
+type ManifestAudit struct {
+	ID      string           `json:"id"`
+	Name    string           `json:"name"`
+	Prompts []ManifestPrompt `json:"prompts"`
+}
+
+// ManifestPrompt is one prompt's outcome. Reason says why it was skipped
+// and Error why it failed; Results counts the results it reported.
+type ManifestPrompt struct {
+	ID       string   `json:"id"`
+	Prompt   string   `json:"prompt"`
+	Severity Severity `json:"severity"`
+	Outcome  string   `json:"outcome"`
+	Reason   string   `json:"reason,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Results  int      `json:"results"`
+}
+
+// manifestPlan is a codebase and the audits selected for it, before
+// sampling and pre-filtering, with what -sample-rate kept of them; sampled
+// is nil when every prompt was kept.
+type manifestPlan struct {
+	codebase CodebaseConfig
+	audits   []Audit
+	sampled  []Audit
+}
+
+// BuildManifest accounts for every prompt of plan from the finished report.
+// A prompt run once per file chunk failed if any chunk did.
+func BuildManifest(plan []manifestPlan, r *Report) *Manifest {
+	type key struct{ codebase, audit, prompt string }
+	ran := make(map[key]int)
+	results := make(map[key]int)
+	failed := make(map[key]string)
+	for _, list := range [][]Finding{r.Findings, r.Filtered, r.LowConfidence} {
+		for _, f := range list {
+			if f.Source == SourceLocal || f.Source == SourcePlugin {
+				continue
+			}
+			k := key{f.Codebase, f.Audit, f.Prompt}
+			ran[k]++
+			if f.Error != "" {
+				failed[k] = f.Error
+			} else if f.HasResult() {
+				results[k]++
+			}
+		}
+	}
+	skipped := make(map[key]string)
+	for _, s := range r.Skipped {
+		skipped[key{s.Codebase, s.Audit, s.Prompt}] = s.Reason
+	}
+
+	m := &Manifest{
+		RunID:       r.Metadata.RunID,
+		ToolVersion: r.Metadata.ToolVersion,
+		StartedAt:   r.Metadata.StartedAt,
+		FinishedAt:  r.Metadata.FinishedAt,
+		Codebases:   []ManifestCodebase{},
+	}
+	for _, p := range plan {
+		var kept map[key]bool
+		if p.sampled != nil {
+			kept = make(map[key]bool)
+			for _, a := range p.sampled {
+				for _, prompt := range a.Prompts {
+					kept[key{p.codebase.ID, a.Name, prompt.Text}] = true
+				}
+			}
+		}
+		cb := ManifestCodebase{Codebase: p.codebase.ID, Alias: p.codebase.Alias, Branch: p.codebase.Branch, Audits: []ManifestAudit{}}
+		for _, a := range p.audits {
+			audit := ManifestAudit{ID: a.ID, Name: a.Name, Prompts: []ManifestPrompt{}}
+			for _, prompt := range a.Prompts {
+				k := key{cb.Codebase, a.Name, prompt.Text}
+				mp := ManifestPrompt{ID: prompt.ID, Prompt: prompt.Text, Severity: prompt.Severity, Results: results[k]}
+				switch {
+				case failed[k] != "":
+					mp.Outcome, mp.Error = ManifestFailed, failed[k]
+					m.Totals.Failed++
+				case ran[k] > 0:
+					mp.Outcome = ManifestRan
+					m.Totals.Ran++
+				default:
+					mp.Outcome = ManifestSkipped
+					switch {
+					case kept != nil && !kept[k]:
+						mp.Reason = SkipSampledOut
+					case skipped[k] != "":
+						mp.Reason = skipped[k]
+					default:
+						// The whole audit was skipped.
+						mp.Reason = skipped[key{cb.Codebase, a.Name, ""}]
+					}
+					m.Totals.Skipped++
+				}
+				audit.Prompts = append(audit.Prompts, mp)
+			}
+			cb.Audits = append(cb.Audits, audit)
+		}
+		m.Codebases = append(m.Codebases, cb)
+	}
+	return m
+}
+
+// WriteManifest writes m as indented JSON to path.
+func WriteManifest(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return reportEncryption.WriteFile(path, append(data, '\n'))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// NetworkSettings choose how treeko reaches other hosts. IPVersion "4" or
+// "6" connects over that address family only, which also skips the wait
+// for the other family's fallback on networks where one of them is broken;
+// "auto" or "" uses both. DNSServer, a host:port, resolves names instead of
+// the system's resolver.
+type NetworkSettings struct {
+	IPVersion string
+	DNSServer string
+}
+
+// netSettings apply to every outbound connection: backends, GitHub,
+// webhooks, DefectDojo and treeko doctor's checks.
+var netSettings NetworkSettings
+
+// Validate checks the IP version and that the DNS server has a port.
+func (s NetworkSettings) Validate() error {
+	switch s.IPVersion {
+	case "", "auto", "4", "6":
+	default:
+		return fmt.Errorf("-ip-version must be 4, 6 or auto, got '%s'", s.IPVersion)
+	}
+	if s.DNSServer != "" {
+		if _, _, err := net.SplitHostPort(s.DNSServer); err != nil {
+			return fmt.Errorf("-dns-server must be host:port, e.g. 10.0.0.2:53: %v", err)
+		}
+	}
+	return nil
+}
+
+// network restricts a dial network such as "tcp" to the IP version, e.g.
+// "tcp4".
+func (s NetworkSettings) network(network string) string {
+	if (s.IPVersion == "4" || s.IPVersion == "6") && (network == "tcp" || network == "udp" || network == "ip") {
+		return network + s.IPVersion
+	}
+	return network
+}
+
+// Resolver is the resolver names are looked up with.
+func (s NetworkSettings) Resolver() *net.Resolver {
+	if s.DNSServer == "" {
+		return net.DefaultResolver
+	}
+	server := s.DNSServer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// LookupHost resolves host to the addresses of the IP version.
+func (s NetworkSettings) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ips, err := s.Resolver().LookupIP(ctx, s.network("ip"), host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs, nil
+}
+
+// Dialer is a dialer giving up after timeout that resolves with Resolver.
+// Dial through DialContext, which also applies the IP version.
+func (s NetworkSettings) Dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Resolver: s.Resolver()}
+}
+
+// DialContext dials address over the IP version.
+func (s NetworkSettings) DialContext(ctx context.Context, timeout time.Duration, network, address string) (net.Conn, error) {
+	return s.Dialer(timeout).DialContext(ctx, s.network(network), address)
+}
+
+// dialContext is a transport's DialContext that gives up after timeout.
+// It reads netSettings on every dial, so clients created before the flags
+// were parsed follow them too.
+func dialContext(timeout time.Duration) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return netSettings.DialContext(ctx, timeout, network, address)
+	}
+}
+
+// newOutboundTransport is http.DefaultTransport dialing with netSettings.
+func newOutboundTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = dialContext(30 * time.Second)
+	return tr
+}
+
+// networkFlags are the network flags of a run and of the commands sharing
+// treeko query's client.
+type networkFlags struct {
+	ipVersion, dnsServer *string
+}
+
+func addNetworkFlags(flags *flag.FlagSet) *networkFlags {
+	return &networkFlags{
+		ipVersion: flags.String("ip-version", "auto", "Connect over IPv4 (4) or IPv6 (6) only, or either (auto)"),
+		dnsServer: flags.String("dns-server", "", "Resolve host names with the DNS server at this host:port, e.g. 10.0.0.2:53 (default: the system resolver)"),
+	}
+}
+
+// apply validates the flags and sets netSettings from them.
+func (f *networkFlags) apply() error {
+	s := NetworkSettings{IPVersion: *f.ipVersion, DNSServer: *f.dnsServer}
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	netSettings = s
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useNetSettings sets netSettings for the test.
+func useNetSettings(t *testing.T, s NetworkSettings) {
+	saved := netSettings
+	t.Cleanup(func() { netSettings = saved })
+	netSettings = s
+}
+
+func TestNetworkSettingsNetwork(t *testing.T) {
+	for _, tt := range []struct {
+		version, network, want string
+	}{
+		{"", "tcp", "tcp"},
+		{"auto", "tcp", "tcp"},
+		{"4", "tcp", "tcp4"},
+		{"6", "tcp", "tcp6"},
+		{"4", "udp", "udp4"},
+		{"6", "ip", "ip6"},
+		// Networks that already name a family, or have none, are kept.
+		{"4", "tcp6", "tcp6"},
+		{"6", "unix", "unix"},
+	} {
+		if got := (NetworkSettings{IPVersion: tt.version}).network(tt.network); got != tt.want {
+			t.Errorf("-ip-version %q: network(%q) = %q, want %q", tt.version, tt.network, got, tt.want)
+		}
+	}
+}
+
+func TestNetworkSettingsDialer(t *testing.T) {
+	d := NetworkSettings{}.Dialer(5 * time.Second)
+	if d.Timeout != 5*time.Second || d.Resolver != net.DefaultResolver {
+		t.Errorf("dialer = %+v, want a 5s timeout and the system resolver", d)
+	}
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	d = NetworkSettings{DNSServer: server.LocalAddr().String()}.Dialer(time.Second)
+	if d.Resolver == nil || d.Resolver == net.DefaultResolver || !d.Resolver.PreferGo || d.Resolver.Dial == nil {
+		t.Fatalf("resolver = %+v, want Go's resolver with its own Dial", d.Resolver)
+	}
+	// Queries go to the DNS server whatever name server the system has.
+	conn, err := d.Resolver.Dial(context.Background(), "udp", "192.0.2.53:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != server.LocalAddr().String() {
+		t.Errorf("resolver dialed %s, want the DNS server %s", got, server.LocalAddr())
+	}
+}
+
+func TestNetworkSettingsDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	for _, version := range []string{"", "auto", "4"} {
+		conn, err := NetworkSettings{IPVersion: version}.DialContext(context.Background(), time.Second, "tcp", addr)
+		if err != nil {
+			t.Errorf("-ip-version %q: dialing %s: %v", version, addr, err)
+			continue
+		}
+		conn.Close()
+	}
+	// Restricting the family rejects addresses of the other one without
+	// needing IPv6 connectivity.
+	for version, addr := range map[string]string{"6": addr, "4": "[::1]:9"} {
+		conn, err := NetworkSettings{IPVersion: version}.DialContext(context.Background(), time.Second, "tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		if err == nil || !strings.Contains(err.Error(), "dial tcp"+version) || !strings.Contains(err.Error(), "no suitable address") {
+			t.Errorf("-ip-version %s: dialing %s: %v, want no suitable address", version, addr, err)
+		}
+	}
+}
+
+func TestOutboundTransportFollowsNetSettings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	// Created before the flags are applied, as the clients are.
+	client := &http.Client{Transport: newOutboundTransport()}
+
+	useNetSettings(t, NetworkSettings{IPVersion: "4"})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("-ip-version 4: %v", err)
+	}
+	resp.Body.Close()
+
+	netSettings = NetworkSettings{IPVersion: "6"}
+	client.Transport.(*http.Transport).CloseIdleConnections()
+	if resp, err := client.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("-ip-version 6 reached the IPv4 server %s", srv.URL)
+	}
+}
+
+func TestNetworkFlags(t *testing.T) {
+	useNetSettings(t, NetworkSettings{})
+	for _, tt := range []struct {
+		args []string
+		want NetworkSettings
+		err  string
+	}{
+		{nil, NetworkSettings{IPVersion: "auto"}, ""},
+		{[]string{"-ip-version", "4", "-dns-server", "10.0.0.2:53"}, NetworkSettings{IPVersion: "4", DNSServer: "10.0.0.2:53"}, ""},
+		{[]string{"-ip-version", "5"}, NetworkSettings{}, "-ip-version must be 4, 6 or auto"},
+		{[]string{"-dns-server", "10.0.0.2"}, NetworkSettings{}, "-dns-server must be host:port"},
+	} {
+		netSettings = NetworkSettings{}
+		flags := flag.NewFlagSet("treeko", flag.ContinueOnError)
+		nf := addNetworkFlags(flags)
+		if err := flags.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		err := nf.apply()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: error %v, want %q", tt.args, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+		}
+		if netSettings != tt.want {
+			t.Errorf("%v: netSettings = %+v, want %+v", tt.args, netSettings, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NoteRules attach what reviewers already know about a kind of finding to
+// every finding of that kind, so nobody has to rediscover it each run. A
+// rule's note is added to the findings with a result matching it, and its
+// severity, if set, replaces theirs. Every rule a finding matches applies,
+// in order, so a later severity wins.
+//
+//	notes:
+//	  - name: legacy-md5
+//	    match: {result: "(?i)md5", paths: ["legacy/**"]}
+//	    note: Known false positive; legacy checksums aren't used for security.
+//	    severity: info
+type NoteRules struct {
+	Rules []NoteRule `yaml:"notes"`
+}
+
+// NoteRule uses the same conditions as filter rules.
+type NoteRule struct {
+	Name     string      `yaml:"name"`
+	Match    FilterMatch `yaml:"match"`
+	Note     string      `yaml:"note"`
+	Severity Severity    `yaml:"severity"`
+}
+
+// noteRules are applied to every finding; nil when -notes isn't given.
+var noteRules *NoteRules
+
+// LoadNoteRules reads and validates a notes file against the run's audits.
+func LoadNoteRules(path string, audits []Audit) (*NoteRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var n NoteRules
+	if err := yaml.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	names := make(map[string]bool)
+	for i := range n.Rules {
+		r := &n.Rules[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("%s: notes[%d] has no name", path, i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s: note '%s' is listed twice", path, r.Name)
+		}
+		names[r.Name] = true
+		r.Note = strings.TrimSpace(r.Note)
+		if r.Note == "" && r.Severity == "" {
+			return nil, fmt.Errorf("%s: note '%s' has neither a note nor a severity", path, r.Name)
+		}
+		if r.Severity != "" && !r.Severity.Valid() {
+			return nil, fmt.Errorf("%s: note '%s' has unknown severity '%s'", path, r.Name, r.Severity)
+		}
+		if err := r.Match.compile(audits); err != nil {
+			return nil, fmt.Errorf("%s: note '%s': %v", path, r.Name, err)
+		}
+	}
+	return &n, nil
+}
+
+// Apply annotates f with the rules it matches. Findings without a result
+// are left alone; Report.prepare applies the rules after the severity
+// overrides, so a note's severity has the last word.
+func (n *NoteRules) Apply(f *Finding) {
+	if n == nil || !f.HasResult() {
+		return
+	}
+	for _, r := range n.Rules {
+		if !r.Match.matches(*f) {
+			continue
+		}
+		if r.Note != "" && !hasTag(f.Notes, r.Note) {
+			f.Notes = append(f.Notes, r.Note)
+		}
+		if r.Severity != "" {
+			f.Severity = r.Severity
+		}
+	}
+}
+
+// resultWithNotes is f's result followed by its notes, for outputs that
+// have a single description field.
+func resultWithNotes(f Finding) string {
+	if len(f.Notes) == 0 {
+		return f.Result
+	}
+	s := strings.TrimRight(f.Result, "\n")
+	for _, note := range f.Notes {
+		s += "\n\nNote: " + note
+	}
+	return s
+}
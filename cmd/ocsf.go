@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+	"time"
+)
+
+// OCSF Vulnerability Finding, schema 1.1.0.
+const (
+	ocsfVersion          = "1.1.0"
+	ocsfCategoryFindings = 2
+	ocsfClassVulnFinding = 2002
+	ocsfActivityCreate   = 1
+	ocsfStatusNew        = 1
+	ocsfStatusSuppressed = 3
+	ocsfFileTypeRegular  = 1
+)
+
+type ocsfEvent struct {
+	ActivityID      int                 `json:"activity_id"`
+	ActivityName    string              `json:"activity_name"`
+	CategoryUID     int                 `json:"category_uid"`
+	CategoryName    string              `json:"category_name"`
+	ClassUID        int                 `json:"class_uid"`
+	ClassName       string              `json:"class_name"`
+	TypeUID         int                 `json:"type_uid"`
+	TypeName        string              `json:"type_name"`
+	SeverityID      int                 `json:"severity_id"`
+	Severity        string              `json:"severity"`
+	StatusID        int                 `json:"status_id"`
+	Status          string              `json:"status"`
+	Time            int64               `json:"time"`
+	Message         string              `json:"message"`
+	Metadata        ocsfMetadata        `json:"metadata"`
+	FindingInfo     ocsfFindingInfo     `json:"finding_info"`
+	Vulnerabilities []ocsfVulnerability `json:"vulnerabilities"`
+	Resources       []ocsfResource      `json:"resources"`
+}
+
+type ocsfMetadata struct {
+	Version        string      `json:"version"`
+	Product        ocsfProduct `json:"product"`
+	CorrelationUID string      `json:"correlation_uid"`
+	Labels         []string    `json:"labels,omitempty"`
+	LoggedTime     int64       `json:"logged_time"`
+}
+
+type ocsfProduct struct {
+	Name       string `json:"name"`
+	VendorName string `json:"vendor_name"`
+	Version    string `json:"version"`
+}
+
+type ocsfFindingInfo struct {
+	UID         string   `json:"uid"`
+	Title       string   `json:"title"`
+	Desc        string   `json:"desc"`
+	Types       []string `json:"types"`
+	CreatedTime int64    `json:"created_time"`
+}
+
+type ocsfVulnerability struct {
+	Title        string             `json:"title"`
+	Desc         string             `json:"desc"`
+	Severity     string             `json:"severity"`
+	CWE          *ocsfCWE           `json:"cwe,omitempty"`
+	AffectedCode []ocsfAffectedCode `json:"affected_code,omitempty"`
+}
+
+type ocsfCWE struct {
+	UID string `json:"uid"`
+}
+
+type ocsfAffectedCode struct {
+	File      ocsfFile `json:"file"`
+	StartLine int      `json:"start_line,omitempty"`
+}
+
+type ocsfFile struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	TypeID int    `json:"type_id"`
+}
+
+type ocsfResource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+}
+
+// ocsfSeverity maps a severity onto OCSF's severity_id and caption.
+func ocsfSeverity(s Severity) (int, string) {
+	switch s {
+	case SeverityCritical:
+		return 5, "Critical"
+	case SeverityHigh:
+		return 4, "High"
+	case SeverityMedium:
+		return 3, "Medium"
+	case SeverityLow:
+		return 2, "Low"
+	case SeverityInfo:
+		return 1, "Informational"
+	}
+	return 0, "Unknown"
+}
+
+func ocsfTime(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// ocsfLabels carries the run metadata that OCSF's metadata object has no
+// field for.
+func ocsfLabels(m RunMetadata) []string {
+	labels := []string{"codebase:" + m.Codebase, "config_hash:" + m.ConfigHash}
+	if m.Git.Commit != nil {
+		labels = append(labels, "git_commit:"+*m.Git.Commit)
+	}
+	if m.Git.Branch != nil {
+		labels = append(labels, "git_branch:"+*m.Git.Branch)
+	}
+	return labels
+}
+
+// OCSFEvent converts a finding with a result into a Vulnerability Finding.
+// The fingerprint is the finding's uid and the run ID its correlation_uid,
+// so every event of a run can be grouped.
+func OCSFEvent(m RunMetadata, f Finding) ocsfEvent {
+	sevID, sev := ocsfSeverity(f.Severity)
+	ts := f.Timestamp
+	if ts.IsZero() {
+		ts = m.StartedAt
+	}
+	e := ocsfEvent{
+		ActivityID:   ocsfActivityCreate,
+		ActivityName: "Create",
+		CategoryUID:  ocsfCategoryFindings,
+		CategoryName: "Findings",
+		ClassUID:     ocsfClassVulnFinding,
+		ClassName:    "Vulnerability Finding",
+		TypeUID:      ocsfClassVulnFinding*100 + ocsfActivityCreate,
+		TypeName:     "Vulnerability Finding: Create",
+		SeverityID:   sevID,
+		Severity:     sev,
+		StatusID:     ocsfStatusNew,
+		Status:       "New",
+		Time:         ocsfTime(ts),
+		Message:      resultWithNotes(f),
+		Metadata: ocsfMetadata{
+			Version:        ocsfVersion,
+			Product:        ocsfProduct{Name: "treeko", VendorName: "treeko", Version: m.ToolVersion},
+			CorrelationUID: m.RunID,
+			Labels:         ocsfLabels(m),
+			LoggedTime:     ocsfTime(m.FinishedAt),
+		},
+		FindingInfo: ocsfFindingInfo{
+			UID:         f.Fingerprint,
+			Title:       annotationTitle(f),
+			Desc:        f.Prompt,
+			Types:       []string{f.Audit},
+			CreatedTime: ocsfTime(ts),
+		},
+		Resources: []ocsfResource{{Type: "repository", UID: f.Codebase, Name: f.Codebase}},
+	}
+	if f.Suppressed {
+		e.StatusID, e.Status = ocsfStatusSuppressed, "Suppressed"
+	}
+	vuln := ocsfVulnerability{Title: annotationTitle(f), Desc: resultWithNotes(f), Severity: sev}
+	if f.CWE > 0 {
+		vuln.CWE = &ocsfCWE{UID: strconv.Itoa(f.CWE)}
+	}
+	for _, loc := range f.Locations {
+		vuln.AffectedCode = append(vuln.AffectedCode, ocsfAffectedCode{
+			File:      ocsfFile{Name: path.Base(loc.Path), Path: loc.Path, TypeID: ocsfFileTypeRegular},
+			StartLine: loc.Line,
+		})
+		e.Resources = append(e.Resources, ocsfResource{Type: "file", UID: f.Codebase + ":" + loc.Path, Name: loc.Path})
+	}
+	e.Vulnerabilities = []ocsfVulnerability{vuln}
+	return e
+}
+
+// WriteOCSF writes one event per finding with a result as NDJSON.
+func WriteOCSF(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	for _, f := range r.Findings {
+		if !f.HasResult() {
+			continue
+		}
+		if err := enc.Encode(OCSFEvent(r.Metadata, f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestWriteOCSFRequiredFields(t *testing.T) {
+	r := loadFixtureReport(t, fixtureReport)
+	var buf bytes.Buffer
+	if err := WriteOCSF(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []map[string]interface{}
+	lines := bufio.NewScanner(&buf)
+	for n := 1; lines.Scan(); n++ {
+		for _, p := range validateAgainst(t, "ocsf-vulnerability-finding.json", lines.Bytes()) {
+			t.Errorf("event %d: %s", n, p)
+		}
+		var e map[string]interface{}
+		json.Unmarshal(lines.Bytes(), &e)
+		events = append(events, e)
+	}
+	// The failed prompt has no event; the suppressed finding has one.
+	if len(events) != 5 {
+		t.Fatalf("%d events, want one per finding with a result", len(events))
+	}
+
+	for i, e := range events {
+		f := r.Findings[i]
+		classUID, activityID := e["class_uid"].(float64), e["activity_id"].(float64)
+		if e["type_uid"] != classUID*100+activityID || e["category_uid"] != float64(int(classUID)/1000) {
+			t.Errorf("%s: type_uid %v and category_uid %v don't follow from class_uid %v and activity_id %v", f.PromptID, e["type_uid"], e["category_uid"], classUID, activityID)
+		}
+		sevID, sev := ocsfSeverity(f.Severity)
+		if e["severity_id"] != float64(sevID) || e["severity"] != sev {
+			t.Errorf("%s: severity %v (%v), want %d (%s)", f.PromptID, e["severity_id"], e["severity"], sevID, sev)
+		}
+		if want := float64(f.Timestamp.UnixNano() / 1e6); e["time"] != want {
+			t.Errorf("%s: time %v, want %v ms", f.PromptID, e["time"], want)
+		}
+		info := e["finding_info"].(map[string]interface{})
+		if info["uid"] != f.Fingerprint {
+			t.Errorf("%s: finding_info.uid %v, want the fingerprint %s", f.PromptID, info["uid"], f.Fingerprint)
+		}
+		metadata := e["metadata"].(map[string]interface{})
+		if metadata["correlation_uid"] != r.Metadata.RunID {
+			t.Errorf("%s: correlation_uid %v, want the run ID", f.PromptID, metadata["correlation_uid"])
+		}
+		vulns := e["vulnerabilities"].([]interface{})
+		if len(vulns) != 1 {
+			t.Errorf("%s: %d vulnerabilities, want 1", f.PromptID, len(vulns))
+			continue
+		}
+		code, _ := vulns[0].(map[string]interface{})["affected_code"].([]interface{})
+		if len(code) != len(f.Locations) {
+			t.Errorf("%s: %d affected_code entries, want one per location", f.PromptID, len(code))
+		}
+		wantStatus := "New"
+		if f.Suppressed {
+			wantStatus = "Suppressed"
+		}
+		if e["status"] != wantStatus {
+			t.Errorf("%s: status %v, want %s", f.PromptID, e["status"], wantStatus)
+		}
+	}
+}
+
+func TestOCSFSchemaRejectsMissingFields(t *testing.T) {
+	event := OCSFEvent(RunMetadata{RunID: "run", ToolVersion: "1.0.0"}, Finding{Audit: "A", Prompt: "P", Severity: SeverityHigh, Result: "r", Fingerprint: "0123456789abcdef", CWE: 89, Locations: []Location{{Path: "a.go", Line: 1}}})
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := validateAgainst(t, "ocsf-vulnerability-finding.json", data); len(problems) > 0 {
+		t.Fatalf("valid event rejected: %v", problems)
+	}
+	for _, path := range [][]string{
+		{"class_uid"}, {"type_uid"}, {"time"}, {"severity_id"}, {"vulnerabilities"},
+		{"metadata", "product"}, {"metadata", "version"}, {"metadata", "product", "vendor_name"},
+		{"finding_info", "uid"}, {"finding_info", "title"},
+	} {
+		var doc map[string]interface{}
+		json.Unmarshal(data, &doc)
+		obj := doc
+		for _, key := range path[:len(path)-1] {
+			obj = obj[key].(map[string]interface{})
+		}
+		delete(obj, path[len(path)-1])
+		stripped, _ := json.Marshal(doc)
+		if problems := validateAgainst(t, "ocsf-vulnerability-finding.json", stripped); len(problems) == 0 {
+			t.Errorf("schema accepted an event without %s", fmt.Sprint(path))
+		}
+	}
+}
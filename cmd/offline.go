@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrOffline is returned for prompts that would need a remote backend while
+// -offline is set.
+var ErrOffline = errors.New("unavailable offline")
+
+// offlineBackend stands in for a remote backend under -offline, so a prompt
+// that reaches it fails at once instead of timing out on the network. It
+// keeps the backend it replaces for the findings' source and cache keys.
+type offlineBackend struct {
+	backend Backend
+}
+
+func (o offlineBackend) Name() string { return o.backend.Name() }
+
+func (o offlineBackend) Query(ctx context.Context, prompt string, target Target) (Answer, error) {
+	return Answer{}, ErrOffline
+}
+
+// Offline replaces a backend with one that never leaves the machine.
+func Offline(b Backend) Backend {
+	return offlineBackend{backend: b}
+}
+
+// Replay answers prompts with the results of earlier JSON reports, so a run
+// can be rendered and evaluated again without querying anything.
+type Replay struct {
+	findings map[string]Finding
+}
+
+func replayKey(codebase, auditID, promptID string) string {
+	return codebase + "\x00" + auditID + "\x00" + promptID
+}
+
+// LoadReplay reads a JSON report, or every .json report in a directory in
+// lexical order, later reports overriding earlier ones. Only prompts that
+// succeeded are kept.
+func LoadReplay(path string) (*Replay, error) {
+	paths := []string{path}
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = nil
+		for _, e := range entries {
+			if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".json") {
+				paths = append(paths, filepath.Join(path, e.Name()))
+			}
+		}
+		sort.Strings(paths)
+	}
+	replay := &Replay{findings: make(map[string]Finding)}
+	for _, p := range paths {
+		r, err := LoadReport(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range r.Findings {
+			if f.Source == SourceLocal || f.Source == SourcePlugin || f.Error != "" {
+				continue
+			}
+			auditID, promptID := f.AuditID, f.PromptID
+			if auditID == "" {
+				auditID = PromptID(f.Audit)
+			}
+			if promptID == "" {
+				promptID = PromptID(f.Prompt)
+			}
+			replay.findings[replayKey(f.Codebase, auditID, promptID)] = f
+		}
+	}
+	return replay, nil
+}
+
+// Get returns the replayed finding of a prompt. A nil Replay has none.
+func (r *Replay) Get(codebase string, audit Audit, prompt Prompt) (Finding, bool) {
+	if r == nil {
+		return Finding{}, false
+	}
+	f, ok := r.findings[replayKey(codebase, audit.ID, prompt.ID)]
+	return f, ok
+}
+
+// Len is the number of prompts that can be replayed.
+func (r *Replay) Len() int {
+	return len(r.findings)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// DefaultSystemPrompt tells an OpenAI-compatible model which repository a
+// prompt is about. Code-RAG services use these pointers to pick the context
+// they retrieve.
+const DefaultSystemPrompt = `You are a security auditor answering questions about the repository {{.Codebase}}
+{{- if .Branch}} on branch {{.Branch}}{{end}}{{if .Revision}} at revision {{.Revision}}{{end}}.
+{{- if .Files}}
+Only consider these files:
+{{- range .Files}}
+- {{.}}
+{{- end}}
+{{- end}}
+Cite every finding as path:line. If you find nothing, say so.`
+
+// OpenAIBackend queries a chat completions API compatible with OpenAI's.
+type OpenAIBackend struct {
+	// BaseURL is the API root, e.g. https://api.openai.com/v1.
+	BaseURL string
+	Model   string
+	// System is rendered with the target and sent as the system message.
+	System *template.Template
+	Client *http.Client
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// LoadSystemPrompt parses the system prompt template at path, or the default
+// one when path is empty.
+func LoadSystemPrompt(path string) (*template.Template, error) {
+	text := DefaultSystemPrompt
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return template.New("system prompt").Parse(text)
+}
+
+func (o *OpenAIBackend) Name() string { return BackendOpenAI }
+
+func (o *OpenAIBackend) Query(ctx context.Context, prompt string, target Target) (Answer, error) {
+	var system strings.Builder
+	if err := o.System.Execute(&system, target); err != nil {
+		return Answer{}, fmt.Errorf("rendering system prompt: %w", err)
+	}
+	body, err := json.Marshal(openAIRequest{
+		Model: o.Model,
+		Messages: []openAIMessage{
+			{Role: "system", Content: system.String()},
+			{Role: "user", Content: prompt},
+		},
+	})
+	if err != nil {
+		return Answer{}, fmt.Errorf("marshaling JSON payload: %w", err)
+	}
+	url, err := buildSearchURL(o.BaseURL, nil, "chat", "completions")
+	if err != nil {
+		return Answer{}, fmt.Errorf("building URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return Answer{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return Answer{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Answer{}, fmt.Errorf("reading response: %w", err)
+	}
+	if err := checkJSONBody(BackendOpenAI, resp, data); err != nil {
+		return Answer{}, err
+	}
+
+	// Chat responses carry usage and other fields treeko doesn't model, so
+	// -strict-json doesn't apply here.
+	var chat openAIResponse
+	if err := json.Unmarshal(data, &chat); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return Answer{}, &APIError{Backend: BackendOpenAI, StatusCode: resp.StatusCode}
+		}
+		return Answer{}, fmt.Errorf("parsing JSON response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{Backend: BackendOpenAI, StatusCode: resp.StatusCode}
+		if chat.Error != nil {
+			apiErr.Message = chat.Error.Message
+		}
+		return Answer{}, apiErr
+	}
+	if len(chat.Choices) == 0 {
+		return Answer{}, fmt.Errorf("response has no choices")
+	}
+	return Answer{Result: chat.Choices[0].Message.Content, Raw: data}, nil
+}
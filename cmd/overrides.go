@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Severity override modes.
+const (
+	OverrideSet   = "set"
+	OverrideRaise = "raise"
+	OverrideLower = "lower"
+)
+
+// SeverityOverride fits the severity of the findings matching it to a
+// team's own risk model: mode set (the default) replaces it, raise only
+// makes it more serious and lower only less. Overrides apply in the order
+// they appear in the config, so when several match, the last one wins.
+type SeverityOverride struct {
+	Name     string      `yaml:"name" json:"name"`
+	Match    FilterMatch `yaml:"match" json:"match"`
+	Severity Severity    `yaml:"severity" json:"severity"`
+	Mode     string      `yaml:"mode" json:"mode,omitempty"`
+}
+
+// CompileSeverityOverrides validates every override against the audits of
+// the run.
+func CompileSeverityOverrides(overrides []SeverityOverride, audits []Audit) error {
+	names := make(map[string]bool)
+	for i := range overrides {
+		o := &overrides[i]
+		if o.Name == "" {
+			return fmt.Errorf("severity override has no name")
+		}
+		if names[o.Name] {
+			return fmt.Errorf("severity override '%s' is listed twice", o.Name)
+		}
+		names[o.Name] = true
+		if !o.Severity.Valid() {
+			return fmt.Errorf("severity override '%s' has unknown severity '%s'", o.Name, o.Severity)
+		}
+		switch o.Mode {
+		case "", OverrideSet, OverrideRaise, OverrideLower:
+		default:
+			return fmt.Errorf("severity override '%s' has unknown mode '%s'", o.Name, o.Mode)
+		}
+		if err := o.Match.compile(audits); err != nil {
+			return fmt.Errorf("severity override '%s': %v", o.Name, err)
+		}
+	}
+	return nil
+}
+
+// ApplySeverityOverrides runs overrides over f, keeping its severity before
+// the first change in OriginalSeverity and the names of the overrides that
+// changed it in SeverityOverrides. Findings without a result are left
+// alone.
+func ApplySeverityOverrides(overrides []SeverityOverride, f *Finding) {
+	if !f.HasResult() {
+		return
+	}
+	for _, o := range overrides {
+		if !o.Match.matches(*f) {
+			continue
+		}
+		switch {
+		case o.Severity == f.Severity:
+			continue
+		case o.Mode == OverrideRaise && o.Severity.Rank() > f.Severity.Rank():
+			continue
+		case o.Mode == OverrideLower && o.Severity.Rank() < f.Severity.Rank():
+			continue
+		}
+		if f.OriginalSeverity == "" {
+			f.OriginalSeverity = f.Severity
+		}
+		f.Severity = o.Severity
+		f.SeverityOverrides = append(f.SeverityOverrides, o.Name)
+	}
+}
+
+// writeSeverityOverrides lists the overrides that fired with the number of
+// findings each changed.
+func writeSeverityOverrides(w io.Writer, r *Report) {
+	var names []string
+	counts := make(map[string]int)
+	for _, f := range r.Findings {
+		for _, name := range f.SeverityOverrides {
+			if counts[name] == 0 {
+				names = append(names, name)
+			}
+			counts[name]++
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, counts[name])
+	}
+	fmt.Fprintf(w, "Severity overrides: %s\n", strings.Join(parts, ", "))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// pdfSeverityColors tints the severity label of each finding.
+var pdfSeverityColors = map[Severity][3]int{
+	SeverityCritical: {176, 0, 32},
+	SeverityHigh:     {214, 69, 0},
+	SeverityMedium:   {191, 140, 0},
+	SeverityLow:      {46, 109, 180},
+	SeverityInfo:     {110, 110, 110},
+}
+
+// WritePDFReport renders r as a PDF for readers who won't open the JSON: a
+// cover page with the run metadata, an executive summary and a section per
+// audit listing its findings. Every page after the cover carries the run ID
+// and page number.
+func WritePDFReport(path string, r *Report) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	m := r.Metadata
+	pdf.SetTitle(tr("treeko audit of "+m.Codebase), false)
+	pdf.SetCreator("treeko "+m.ToolVersion, false)
+	pdf.AliasNbPages("")
+	pdf.SetHeaderFuncMode(func() {
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(110, 110, 110)
+		left, _, _, _ := pdf.GetMargins()
+		pdf.CellFormat(0, 5, tr("treeko audit of "+m.Codebase), "", 0, "L", false, 0, "")
+		pdf.SetX(left)
+		pdf.CellFormat(0, 5, "Run "+m.RunID, "", 1, "R", false, 0, "")
+		pdf.Ln(4)
+		pdf.SetTextColor(0, 0, 0)
+	}, true)
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(110, 110, 110)
+		pdf.CellFormat(0, 10, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	heading := func(size float64, text string) {
+		pdf.SetFont("Helvetica", "B", size)
+		pdf.MultiCell(0, size*0.5, tr(text), "", "L", false)
+		pdf.Ln(2)
+	}
+	field := func(label, value string) {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(35, 6, tr(label), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 6, tr(value), "", "L", false)
+	}
+
+	// Cover page.
+	pdf.AddPage()
+	pdf.Ln(50)
+	heading(24, "Security audit")
+	pdf.SetFont("Helvetica", "", 16)
+	pdf.MultiCell(0, 8, tr(m.Codebase), "", "L", false)
+	pdf.Ln(20)
+	field("Run ID", m.RunID)
+	field("Tool version", m.ToolVersion)
+	field("Git commit", stringOrNone(m.Git.Commit))
+	field("Git branch", stringOrNone(m.Git.Branch))
+	field("Started", formatTime(m.StartedAt))
+	field("Finished", fmt.Sprintf("%s (took %s)", formatTime(m.FinishedAt), formatDurationMs(m.FinishedAt.Sub(m.StartedAt).Milliseconds())))
+	field("Config hash", m.ConfigHash)
+	if m.Scope != nil {
+		field("Scope", fmt.Sprintf("%d changed files from %s", len(m.Scope.Files), m.Scope.Source))
+	}
+
+	// Executive summary.
+	pdf.AddPage()
+	heading(16, "Executive summary")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.MultiCell(0, 6, fmt.Sprintf("%d prompts were run against %d codebases: %d returned results and %d failed.",
+		r.Summary.Prompts, len(r.Codebases), r.Summary.Results, r.Summary.Errors), "", "L", false)
+	pdf.Ln(4)
+	counts := make(map[Severity]int)
+	for _, f := range r.Findings {
+		if f.HasResult() && !f.Suppressed {
+			counts[f.Severity]++
+		}
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(230, 230, 230)
+	pdf.CellFormat(50, 7, "Severity", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(30, 7, "Findings", "1", 1, "R", true, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	for _, s := range Severities {
+		pdf.CellFormat(50, 7, string(s), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(30, 7, fmt.Sprint(counts[s]), "1", 1, "R", false, 0, "")
+	}
+	pdf.Ln(4)
+	if r.Summary.Suppressed > 0 {
+		pdf.MultiCell(0, 6, fmt.Sprintf("%d findings are suppressed by %s and not counted above.", r.Summary.Suppressed, IgnoreFileName), "", "L", false)
+	}
+	if r.Summary.Filtered > 0 {
+		pdf.MultiCell(0, 6, fmt.Sprintf("%d findings were dropped by filter rules.", r.Summary.Filtered), "", "L", false)
+	}
+	if len(r.Skipped) > 0 {
+		pdf.MultiCell(0, 6, fmt.Sprintf("%d audits or prompts were skipped.", len(r.Skipped)), "", "L", false)
+	}
+	if r.Policy != nil {
+		status := "passed"
+		if !r.Policy.Passed {
+			status = "failed"
+		}
+		pdf.MultiCell(0, 6, "Policy "+status+".", "", "L", false)
+		for _, rr := range r.Policy.Rules {
+			if rr.Fired {
+				pdf.MultiCell(0, 6, tr(fmt.Sprintf("  %s: %s (%d findings, max %d)", rr.Action, rr.Name, rr.Count, rr.Max)), "", "L", false)
+			}
+		}
+	}
+	if len(r.Codebases) > 1 {
+		pdf.Ln(4)
+		for _, cb := range r.Codebases {
+			pdf.MultiCell(0, 6, tr(fmt.Sprintf("%s: %s (%d prompts, %d results, %d errors)",
+				cb.Codebase, cb.Status, cb.Summary.Prompts, cb.Summary.Results, cb.Summary.Errors)), "", "L", false)
+		}
+	}
+
+	// A section per audit.
+	byAudit := make(map[string][]Finding)
+	var names []string
+	for _, f := range r.Findings {
+		if _, ok := byAudit[f.Audit]; !ok {
+			names = append(names, f.Audit)
+		}
+		byAudit[f.Audit] = append(byAudit[f.Audit], f)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pdf.AddPage()
+		heading(16, name)
+		for _, f := range byAudit[name] {
+			writePDFFinding(pdf, tr, f)
+		}
+	}
+
+	if len(r.Clusters) > 0 {
+		pdf.AddPage()
+		heading(16, "Issue clusters")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 6, "Findings of several prompts that point at the same code.", "", "L", false)
+		pdf.Ln(2)
+		for _, c := range r.Clusters {
+			pdf.MultiCell(0, 6, tr(fmt.Sprintf("[%s] %s %s: %s", strings.ToUpper(string(c.Severity)), c.ID, c.Codebase, c.describe())), "", "L", false)
+		}
+	}
+
+	if len(r.Clean) > 0 {
+		pdf.AddPage()
+		heading(16, "Clean prompts")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 6, "These prompts ran and returned no results.", "", "L", false)
+		pdf.Ln(2)
+		for _, c := range r.Clean {
+			pdf.MultiCell(0, 6, tr(fmt.Sprintf("%s %s: %s", c.Codebase, c.Audit, c.Prompt)), "", "L", false)
+		}
+	}
+
+	w, err := reportEncryption.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pdf.Output(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func writePDFFinding(pdf *fpdf.Fpdf, tr func(string) string, f Finding) {
+	c := pdfSeverityColors[f.Severity]
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetTextColor(c[0], c[1], c[2])
+	pdf.CellFormat(20, 6, strings.ToUpper(string(f.Severity)), "", 0, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.MultiCell(0, 6, tr(f.Prompt), "", "L", false)
+
+	var details []string
+	if f.Codebase != "" {
+		details = append(details, f.CodebaseLabel())
+	}
+	if f.Status != "" && f.Status != StatusOK {
+		details = append(details, "status "+string(f.Status))
+	}
+	if len(f.Locations) > 0 {
+		var locs []string
+		for _, loc := range f.Locations {
+			if loc.Line > 0 {
+				locs = append(locs, fmt.Sprintf("%s:%d", loc.Path, loc.Line))
+			} else {
+				locs = append(locs, loc.Path)
+			}
+		}
+		details = append(details, strings.Join(locs, ", "))
+	}
+	if f.Score != nil {
+		details = append(details, fmt.Sprintf("confidence %.2f", *f.Score))
+	}
+	if f.Fingerprint != "" {
+		details = append(details, "fingerprint "+f.Fingerprint)
+	}
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.MultiCell(0, 4.5, tr(strings.Join(details, " | ")), "", "L", false)
+	if f.Suppressed {
+		pdf.MultiCell(0, 4.5, tr("Suppressed: "+f.Justification), "", "L", false)
+	}
+	for _, note := range f.Notes {
+		pdf.MultiCell(0, 4.5, tr("Note: "+note), "", "L", false)
+	}
+	pdf.SetTextColor(0, 0, 0)
+
+	// Results often quote code, so they are set in a monospace font and
+	// wrapped, long lines included, to the page width.
+	text, font := f.Result, "Courier"
+	if f.Error != "" {
+		text, font = "Error: "+f.Error, "Helvetica"
+	}
+	pdf.SetFont(font, "", 8)
+	pdf.SetFillColor(245, 245, 245)
+	text = strings.ReplaceAll(strings.TrimSpace(text), "\t", "    ")
+	pdf.MultiCell(0, 4, tr(text), "", "L", true)
+	if explain && f.Remediation != "" {
+		pdf.Ln(1)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.MultiCell(0, 4.5, tr("Remediation: "+f.Remediation), "", "L", false)
+	}
+	pdf.Ln(5)
+}
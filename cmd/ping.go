@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// pingSample is the outcome of one treeko ping request.
+type pingSample struct {
+	Status  string
+	Remote  string
+	Latency time.Duration
+	// Healthy is false when the request failed, the API key was rejected
+	// or the service answered with a 5xx or 429.
+	Healthy bool
+	Error   string
+}
+
+// pingOnce requests target's repository through client, the way a run
+// reaches the API, and times the answer. A 404 is healthy: the codebase
+// isn't indexed, but the credentials and endpoint work.
+func pingOnce(ctx context.Context, client *http.Client, target Target) pingSample {
+	var s pingSample
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		s.Remote = info.Conn.RemoteAddr().String()
+	}}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", repositoryURL(target), nil)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	s.Latency = time.Since(start)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	s.Status = resp.Status
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		s.Error = "API key rejected"
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		s.Error = "service unhealthy"
+	default:
+		s.Healthy = true
+	}
+	return s
+}
+
+// runPingCommand probes the API with -count authenticated requests and
+// prints each one's latency, then min/avg/max. It exits 0 when every
+// request was healthy and 1 otherwise.
+func runPingCommand(args []string) int {
+	flags := flag.NewFlagSet("ping", flag.ExitOnError)
+	opts := addQueryFlags(flags)
+	count := flags.Int("count", 1, "Send this many requests")
+	interval := flags.Duration("interval", time.Second, "Wait this long between requests")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko ping [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	if *count < 1 {
+		fmt.Fprintf(os.Stderr, "Error: -count must be at least 1, got %d\n", *count)
+		return ExitUsage
+	}
+	if *interval < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -interval must not be negative, got %s\n", *interval)
+		return ExitUsage
+	}
+	target, backend, err := opts.setup(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("PING %s\n", repositoryURL(target))
+	var sent, healthy int
+	var min, max, total time.Duration
+	for i := 0; i < *count && ctx.Err() == nil; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(*interval):
+			case <-ctx.Done():
+				continue
+			}
+		}
+		s := pingOnce(ctx, backend.Client, target)
+		sent++
+		line := fmt.Sprintf("seq=%d", i+1)
+		if s.Remote != "" {
+			line += " from " + s.Remote
+		}
+		if s.Status != "" {
+			line += ": " + s.Status
+		}
+		line += " time=" + formatDurationMs(s.Latency.Milliseconds())
+		if s.Error != "" {
+			line += " (" + s.Error + ")"
+		}
+		fmt.Println(line)
+		if !s.Healthy {
+			continue
+		}
+		healthy++
+		total += s.Latency
+		if healthy == 1 || s.Latency < min {
+			min = s.Latency
+		}
+		if s.Latency > max {
+			max = s.Latency
+		}
+	}
+	fmt.Printf("%d requests, %d healthy", sent, healthy)
+	if healthy > 0 {
+		fmt.Printf(", latency min/avg/max = %s/%s/%s", formatDurationMs(min.Milliseconds()),
+			formatDurationMs((total / time.Duration(healthy)).Milliseconds()), formatDurationMs(max.Milliseconds()))
+	}
+	fmt.Println()
+	if sent == 0 || healthy < sent {
+		return 1
+	}
+	return ExitOK
+}
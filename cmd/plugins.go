@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	SourcePlugin = "plugin"
+
+	defaultPluginTimeout = 5 * time.Minute
+)
+
+// PluginConfig declares an external auditor. Command is executed directly,
+// not through a shell.
+type PluginConfig struct {
+	Name    string        `yaml:"name" json:"name"`
+	Command string        `yaml:"command" json:"command"`
+	Args    []string      `yaml:"args" json:"args,omitempty"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+// PluginContext is written to a plugin's stdin as a single JSON object.
+type PluginContext struct {
+	RunID    string   `json:"runId"`
+	Codebase string   `json:"codebase"`
+	Branch   string   `json:"branch,omitempty"`
+	Revision string   `json:"revision,omitempty"`
+	RepoRoot string   `json:"repoRoot,omitempty"`
+	Files    []string `json:"changedFiles,omitempty"`
+}
+
+// PluginFinding is one line of a plugin's NDJSON output.
+type PluginFinding struct {
+	Check     string     `json:"check"`
+	Title     string     `json:"title"`
+	Severity  Severity   `json:"severity"`
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations"`
+}
+
+// RunPlugin executes plugin and records its findings in report under the
+// plugin's name. A plugin that fails, times out or prints malformed output is
+// recorded as an error finding; whatever it reported before failing is kept.
+// Cancelling parent kills it.
+func RunPlugin(parent context.Context, plugin PluginConfig, pctx PluginContext, report *Report) {
+	timeout := plugin.Timeout
+	if timeout == 0 {
+		timeout = defaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	input, err := json.Marshal(pctx)
+	if err != nil {
+		recordPluginError(plugin, pctx, report, err)
+		return
+	}
+	cmd := exec.CommandContext(ctx, plugin.Command, plugin.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	runErr := cmd.Run()
+	duration := time.Since(start)
+
+	for _, line := range strings.Split(strings.TrimRight(stderr.String(), "\n"), "\n") {
+		if line != "" {
+			debugf("plugin %s: %s", plugin.Name, line)
+		}
+	}
+
+	parseErr := parsePluginOutput(plugin, pctx, stdout.Bytes(), duration, report)
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		recordPluginError(plugin, pctx, report, fmt.Errorf("%w after %s", ErrTimeout, timeout))
+	case runErr != nil:
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			runErr = fmt.Errorf("exited with status %d", exitErr.ExitCode())
+		}
+		recordPluginError(plugin, pctx, report, runErr)
+	case parseErr != nil:
+		recordPluginError(plugin, pctx, report, parseErr)
+	}
+}
+
+func parsePluginOutput(plugin PluginConfig, pctx PluginContext, out []byte, duration time.Duration, report *Report) error {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var pf PluginFinding
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&pf); err != nil {
+			return fmt.Errorf("output line %d: %v", n, err)
+		}
+		if pf.Title == "" {
+			return fmt.Errorf("output line %d: missing title", n)
+		}
+		if pf.Severity == "" {
+			pf.Severity = SeverityMedium
+		}
+		if !pf.Severity.Valid() {
+			return fmt.Errorf("output line %d: unknown severity '%s'", n, pf.Severity)
+		}
+		for i := range pf.Locations {
+			pf.Locations[i].Path = NormalizePath(pf.Locations[i].Path)
+		}
+		if pf.Locations == nil {
+			pf.Locations = []Location{}
+		}
+		if pf.Message == "" {
+			pf.Message = pf.Title
+		}
+		report.Add(Finding{
+			Codebase:   pctx.Codebase,
+			Audit:      plugin.Name,
+			AuditID:    plugin.Name,
+			Prompt:     pf.Title,
+			PromptID:   pf.Check,
+			Severity:   pf.Severity,
+			Source:     SourcePlugin,
+			Status:     StatusOK,
+			Check:      pf.Check,
+			Result:     pf.Message,
+			Locations:  pf.Locations,
+			Timestamp:  time.Now().UTC(),
+			DurationMs: duration.Milliseconds(),
+		})
+	}
+	return scanner.Err()
+}
+
+func recordPluginError(plugin PluginConfig, pctx PluginContext, report *Report, err error) {
+	log.Printf("Error running plugin '%s' for codebase '%s': %v\n", plugin.Name, pctx.Codebase, err)
+	f := Finding{
+		Codebase:  pctx.Codebase,
+		Audit:     plugin.Name,
+		AuditID:   plugin.Name,
+		Prompt:    "plugin " + plugin.Name,
+		Severity:  SeverityInfo,
+		Source:    SourcePlugin,
+		Timestamp: time.Now().UTC(),
+	}
+	f.fail(err)
+	report.Add(f)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// buildTodoScanner builds examples/plugins/todo-scanner.
+func buildTodoScanner(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds the example plugin")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("needs the go command to build the example plugin")
+	}
+	bin := filepath.Join(t.TempDir(), "todo-scanner")
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+	cmd := exec.Command(goTool, "build", "-o", bin, "../examples/plugins/todo-scanner")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building the plugin: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestTodoScannerPlugin(t *testing.T) {
+	bin := buildTodoScanner(t)
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"api/login.go":                "package api\n\n// TODO(security): validate redirect target\nfunc Login() {}\n",
+		"web/app.js":                  "// FIXME(security) escape the user name\nrender(user)\n// TODO: not a security marker\n",
+		"docs/notes.md":               "Nothing to see.\n",
+		".git/hooks/pre-commit":       "# TODO(security): ignored inside .git\n",
+		"node_modules/dep/index.js":   "// TODO(security): ignored in node_modules\n",
+		"vendor/lib/lib.go":           "// TODO(security): ignored in vendor\n",
+		"scripts/deploy/run.sh":       "#!/bin/sh\n\n\n# TODO(security):check the token scope\n",
+		"internal/db/query_test.go":   "package db\n",
+		"internal/db/query_legacy.go": "package db\n// FIXME(security): \n",
+	} {
+		file := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := NewReport(RunMetadata{})
+	plugin := PluginConfig{Name: "Security TODOs", Command: bin}
+	RunPlugin(context.Background(), plugin, PluginContext{RunID: "run-1", Codebase: "acme/payments", RepoRoot: root}, report)
+
+	var got []string
+	for _, f := range report.Findings {
+		if f.Error != "" {
+			t.Fatalf("plugin failed: %s", f.Error)
+		}
+		if f.Source != SourcePlugin || f.Audit != plugin.Name || f.Check != "security-todo" || f.Prompt != "Outstanding security TODO" || f.Severity != SeverityLow {
+			t.Errorf("finding = %+v", f)
+		}
+		if f.Fingerprint == "" {
+			t.Errorf("finding at %v has no fingerprint", f.Locations)
+		}
+		if len(f.Locations) != 1 {
+			t.Errorf("finding %q has locations %v, want one", f.Result, f.Locations)
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s:%d %s", f.Locations[0].Path, f.Locations[0].Line, f.Result))
+	}
+	sort.Strings(got)
+	// A marker without a message is reported with the title.
+	want := []string{
+		"api/login.go:3 validate redirect target",
+		"internal/db/query_legacy.go:2 Outstanding security TODO",
+		"scripts/deploy/run.sh:4 check the token scope",
+		"web/app.js:1 escape the user name",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTodoScannerPluginWithoutRepoRoot(t *testing.T) {
+	bin := buildTodoScanner(t)
+	report := NewReport(RunMetadata{})
+	RunPlugin(context.Background(), PluginConfig{Name: "Security TODOs", Command: bin}, PluginContext{Codebase: "acme/payments"}, report)
+	if len(report.Findings) != 0 {
+		t.Errorf("findings without a checkout: %+v", report.Findings)
+	}
+}
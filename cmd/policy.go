@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy actions.
+const (
+	PolicyFail   = "fail"
+	PolicyWarn   = "warn"
+	PolicyIgnore = "ignore"
+)
+
+// Policy decides whether a run passes from its findings. Each finding with a
+// result is claimed by the first rule it matches, so an earlier rule can
+// exempt findings from later ones; a rule fires when it claims more findings
+// than its max. Suppressed findings are never counted.
+//
+//	rules:
+//	  - name: llm-is-advisory
+//	    match: {audit: llm}
+//	    action: warn
+//	  - name: no-critical-outside-legacy
+//	    match: {severity: [critical], excludePaths: ["legacy/**"]}
+//	    action: fail
+//	  - name: no-sql-injection
+//	    match: {audit: sql}
+//	    action: fail
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule uses the same conditions as filter rules.
+type PolicyRule struct {
+	Name   string      `yaml:"name"`
+	Match  FilterMatch `yaml:"match"`
+	Max    int         `yaml:"max"`
+	Action string      `yaml:"action"`
+}
+
+// PolicyResult is the policy section of a report.
+type PolicyResult struct {
+	Passed bool               `json:"passed"`
+	Rules  []PolicyRuleResult `json:"rules"`
+}
+
+// PolicyRuleResult explains one rule's evaluation. Findings lists the
+// fingerprints of the findings the rule claimed.
+type PolicyRuleResult struct {
+	Name     string   `json:"name"`
+	Action   string   `json:"action"`
+	Max      int      `json:"max"`
+	Count    int      `json:"count"`
+	Fired    bool     `json:"fired"`
+	Findings []string `json:"findings"`
+}
+
+// LoadPolicy reads and validates a policy file against the run's audits.
+func LoadPolicy(path string, audits []Audit) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	names := make(map[string]bool)
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("%s: rules[%d] has no name", path, i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s: rule '%s' is listed twice", path, r.Name)
+		}
+		names[r.Name] = true
+		switch r.Action {
+		case PolicyFail, PolicyWarn, PolicyIgnore:
+		default:
+			return nil, fmt.Errorf("%s: rule '%s' has unknown action '%s'", path, r.Name, r.Action)
+		}
+		if r.Max < 0 {
+			return nil, fmt.Errorf("%s: rule '%s' has a negative max", path, r.Name)
+		}
+		if err := r.Match.compile(audits); err != nil {
+			return nil, fmt.Errorf("%s: rule '%s': %v", path, r.Name, err)
+		}
+	}
+	return &p, nil
+}
+
+// Evaluate applies the policy to findings, which must already be in report
+// order so that the result is deterministic.
+func (p *Policy) Evaluate(findings []Finding) *PolicyResult {
+	res := &PolicyResult{Passed: true, Rules: make([]PolicyRuleResult, len(p.Rules))}
+	for i, r := range p.Rules {
+		res.Rules[i] = PolicyRuleResult{Name: r.Name, Action: r.Action, Max: r.Max, Findings: []string{}}
+	}
+	for _, f := range findings {
+		if !f.HasResult() || f.Suppressed {
+			continue
+		}
+		for i, r := range p.Rules {
+			if r.Match.matches(f) {
+				res.Rules[i].Count++
+				res.Rules[i].Findings = append(res.Rules[i].Findings, f.Fingerprint)
+				break
+			}
+		}
+	}
+	for i := range res.Rules {
+		rr := &res.Rules[i]
+		rr.Fired = rr.Action != PolicyIgnore && rr.Count > rr.Max
+		if rr.Fired && rr.Action == PolicyFail {
+			res.Passed = false
+		}
+	}
+	return res
+}
+
+func WritePolicyResult(w io.Writer, res *PolicyResult) {
+	status := "passed"
+	if !res.Passed {
+		status = "failed"
+	}
+	fmt.Fprintf(w, "Policy: %s\n", status)
+	for _, rr := range res.Rules {
+		mark := "ok"
+		if rr.Fired {
+			mark = rr.Action
+		}
+		fmt.Fprintf(w, "  [%s] %s: %d findings (max %d)\n", mark, rr.Name, rr.Count, rr.Max)
+	}
+}
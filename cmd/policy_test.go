@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		passed bool
+		counts []int
+		fired  []bool
+	}{
+		{"audit fails", "rules:\n  - {name: sql, match: {audit: sql}, action: fail}\n", false, []int{1}, []bool{true}},
+		{"audit within max", "rules:\n  - {name: sql, match: {audit: sql}, max: 1, action: fail}\n", true, []int{1}, []bool{false}},
+		{"check fails", "rules:\n  - {name: keys, match: {check: aws-access-key}, action: fail}\n", false, []int{1}, []bool{true}},
+		{"check passes", "rules:\n  - {name: keys, match: {check: private-key}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"prompt fails", "rules:\n  - {name: passwords, match: {prompt: passwords}, action: fail}\n", false, []int{1}, []bool{true}},
+		{"prompt passes", "rules:\n  - {name: xss, match: {prompt: '^Is XSS'}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"prompt ID fails", "rules:\n  - {name: llm, match: {promptId: llm-output}, action: fail}\n", false, []int{1}, []bool{true}},
+		// auth-logout failed, so it has no result to count.
+		{"failed prompt passes", "rules:\n  - {name: logout, match: {prompt: logout}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"prompt ID of a failed prompt passes", "rules:\n  - {name: logout, match: {promptId: auth-logout}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"severity fails", "rules:\n  - {name: critical, match: {severity: [critical]}, action: fail}\n", false, []int{2}, []bool{true}},
+		{"severity passes", "rules:\n  - {name: low, match: {severity: [low, info]}, action: fail}\n", true, []int{0}, []bool{false}},
+		// crypto-tls is suppressed in .treekoignore.
+		{"suppressed finding passes", "rules:\n  - {name: tls, match: {prompt: '^Is TLS'}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"suppressed prompt ID passes", "rules:\n  - {name: tls, match: {promptId: crypto-tls}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"paths fail", "rules:\n  - {name: legacy, match: {paths: ['legacy/**']}, action: fail}\n", false, []int{1}, []bool{true}},
+		{"paths pass", "rules:\n  - {name: docs, match: {paths: ['docs/**']}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"exclude paths fail", "rules:\n  - {name: critical, match: {severity: [critical], excludePaths: ['legacy/**']}, action: fail}\n", false, []int{1}, []bool{true}},
+		{"exclude paths pass", "rules:\n  - {name: secrets, match: {audit: secrets, excludePaths: ['legacy/**']}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"result fails", "rules:\n  - {name: sprintf, match: {result: 'fmt\\.Sprintf'}, action: fail}\n", false, []int{1}, []bool{true}},
+		{"result passes", "rules:\n  - {name: md5, match: {result: '(?i)md5'}, action: fail}\n", true, []int{0}, []bool{false}},
+		{"warn fires without failing", "rules:\n  - {name: sql, match: {audit: sql}, action: warn}\n", true, []int{1}, []bool{true}},
+		{"ignore never fires", "rules:\n  - {name: critical, match: {severity: [critical]}, action: ignore}\n", true, []int{2}, []bool{false}},
+		{
+			"earlier rule claims findings first",
+			"rules:\n  - {name: secrets, match: {audit: secrets}, action: warn}\n  - {name: critical, match: {severity: [critical]}, max: 1, action: fail}\n",
+			true, []int{1, 1}, []bool{true, false},
+		},
+		{
+			"later rule fails without the exemption",
+			"rules:\n  - {name: llm, match: {audit: llm}, action: warn}\n  - {name: critical, match: {severity: [critical]}, max: 1, action: fail}\n",
+			false, []int{1, 2}, []bool{true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := loadFixtureReport(t, fixtureReport)
+			report.Codebases[0].Status = CodebaseOK
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(path, []byte(tt.policy), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			policy, err := LoadPolicy(path, fixtureAudits(report))
+			if err != nil {
+				t.Fatal(err)
+			}
+			report.Policy = policy.Evaluate(report.Findings)
+			if report.Policy.Passed != tt.passed {
+				t.Errorf("Passed = %v, want %v", report.Policy.Passed, tt.passed)
+			}
+			for i, rr := range report.Policy.Rules {
+				if rr.Count != tt.counts[i] || rr.Fired != tt.fired[i] || len(rr.Findings) != rr.Count {
+					t.Errorf("rule %s claimed %d findings %v (fired %v), want %d (fired %v)", rr.Name, rr.Count, rr.Findings, rr.Fired, tt.counts[i], tt.fired[i])
+				}
+			}
+			want := ExitOK
+			if !tt.passed {
+				want = ExitFindings
+			}
+			if got := report.ExitCode(); got != want {
+				t.Errorf("ExitCode() = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestPolicyExitCodeFailedPromptsWin(t *testing.T) {
+	report := loadFixtureReport(t, fixtureReport)
+	for _, passed := range []bool{true, false} {
+		report.Policy = &PolicyResult{Passed: passed}
+		if got := report.ExitCode(); got != ExitErrors {
+			t.Errorf("ExitCode() of a partial codebase with a policy that passed=%v = %d, want %d", passed, got, ExitErrors)
+		}
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for name, policy := range map[string]string{
+		"no name":        "rules:\n  - {match: {audit: sql}, action: fail}\n",
+		"duplicate":      "rules:\n  - {name: a, action: fail}\n  - {name: a, action: warn}\n",
+		"unknown action": "rules:\n  - {name: a, action: block}\n",
+		"negative max":   "rules:\n  - {name: a, max: -1, action: fail}\n",
+		"bad severity":   "rules:\n  - {name: a, match: {severity: [urgent]}, action: fail}\n",
+		"bad regexp":     "rules:\n  - {name: a, match: {result: '('}, action: fail}\n",
+	} {
+		path := filepath.Join(t.TempDir(), "policy.yaml")
+		if err := os.WriteFile(path, []byte(policy), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadPolicy(path, nil); err == nil {
+			t.Errorf("%s: LoadPolicy succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// Skip reasons.
+const (
+	// SkipNoMatchingFiles is recorded for audits whose requires patterns
+	// match nothing in the local checkout.
+	SkipNoMatchingFiles = "no matching files"
+	// SkipFailFast is recorded for prompts cancelled by -fail-fast after
+	// another prompt of their audit reported a critical finding.
+	SkipFailFast = "fail-fast"
+	// SkipAuthFailure is recorded for prompts not sent because the run was
+	// aborted after repeated authentication failures.
+	SkipAuthFailure = "authentication failing"
+	// SkipFindingCap is recorded for work cancelled once -max-findings
+	// findings were reported.
+	SkipFindingCap = "max-findings reached"
+	// SkipInterrupted is recorded for prompts not yet sent when SIGINT or
+	// SIGTERM stopped the run.
+	SkipInterrupted = "interrupted"
+	// SkipOffline is recorded for prompts that needed a remote backend
+	// while -offline was set.
+	SkipOffline = "unavailable offline"
+)
+
+// skipReason explains why a prompt's context was cancelled: the run was
+// aborted by the auth guard, capped by -max-findings or interrupted, or else
+// its audit was cancelled by -fail-fast. Prompts -max-runtime stops are
+// recorded as timed out instead of skipped.
+func skipReason() string {
+	switch {
+	case authGuard.Tripped():
+		return SkipAuthFailure
+	case findingCap.Reached():
+		return SkipFindingCap
+	case runInterrupt.Interrupted():
+		return SkipInterrupted
+	}
+	return SkipFailFast
+}
+
+// SkippedAudit records an audit, or with Prompt set a single prompt of it,
+// that was not run against a codebase.
+type SkippedAudit struct {
+	Codebase string `json:"codebase"`
+	Audit    string `json:"audit"`
+	Prompt   string `json:"prompt,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// prescanSkipDirs are never descended into; they are either VCS metadata or
+// third-party code that would make every suite look relevant.
+var prescanSkipDirs = map[string]bool{
+	".git":         true,
+	".hg":          true,
+	"node_modules": true,
+	"vendor":       true,
+}
+
+// ScanRepoFiles lists the files under root as slash-separated relative paths.
+func ScanRepoFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && prescanSkipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+// matchesAny reports whether any file matches one of the patterns.
+func matchesAny(patterns, files []string) bool {
+	for _, pattern := range patterns {
+		for _, f := range files {
+			if matchPattern(pattern, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PrefilterAudits splits audits into those whose requires patterns match
+// the scanned files and those that can be skipped. Audits without requires
+// always run.
+func PrefilterAudits(audits []Audit, files []string) (run []Audit, skipped []Audit) {
+	for _, a := range audits {
+		if len(a.Requires) == 0 || matchesAny(a.Requires, files) {
+			run = append(run, a)
+		} else {
+			skipped = append(skipped, a)
+		}
+	}
+	return run, skipped
+}
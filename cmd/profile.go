@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// hiddenFlags are left out of the usage message; they diagnose treeko
+// itself rather than the codebase.
+var hiddenFlags = map[string]bool{"cpuprofile": true, "memprofile": true, "trace": true}
+
+// printVisibleDefaults is fs.PrintDefaults without the hidden flags.
+func printVisibleDefaults(fs *flag.FlagSet) {
+	visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	visible.SetOutput(fs.Output())
+	fs.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
+}
+
+// Profiler records the CPU profile and execution trace of a run and writes
+// its heap profile when it stops.
+type Profiler struct {
+	cpu, trace *os.File
+	memPath    string
+}
+
+// StartProfiling starts the profiles whose paths aren't empty. It returns
+// nil if none is.
+func StartProfiling(cpuPath, memPath, tracePath string) (*Profiler, error) {
+	if cpuPath == "" && memPath == "" && tracePath == "" {
+		return nil, nil
+	}
+	p := &Profiler{memPath: memPath}
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("starting CPU profile: %v", err)
+		}
+		p.cpu = f
+	}
+	if tracePath != "" {
+		f, err := os.Create(tracePath)
+		if err == nil {
+			if err = trace.Start(f); err != nil {
+				f.Close()
+				err = fmt.Errorf("starting trace: %v", err)
+			}
+		}
+		if err != nil {
+			p.Stop()
+			return nil, err
+		}
+		p.trace = f
+	}
+	return p, nil
+}
+
+// Stop finishes the profiles and writes the heap profile, logging what
+// fails.
+func (p *Profiler) Stop() {
+	if p == nil {
+		return
+	}
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		if err := p.cpu.Close(); err != nil {
+			log.Printf("Error writing CPU profile: %v\n", err)
+		}
+	}
+	if p.trace != nil {
+		trace.Stop()
+		if err := p.trace.Close(); err != nil {
+			log.Printf("Error writing trace: %v\n", err)
+		}
+	}
+	if p.memPath != "" {
+		if err := writeHeapProfile(p.memPath); err != nil {
+			log.Printf("Error writing memory profile: %v\n", err)
+		}
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Collect first so the profile shows live memory as of the end of the
+	// run.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PromptFile is the format of custom prompt files:
+//
+//	audits:
+//	  - id: secrets
+//	    name: Secrets
+//	    merge: replace       # optional; see MergeAudits
+//	    prompts:
+//	      - id: committed-keys   # optional; derived from the text if omitted
+//	        text: Find private keys committed to the repository.
+//	        severity: critical
+//	    localChecks:
+//	      - id: pem-private-key
+//	        pattern: "-----BEGIN [A-Z ]*PRIVATE KEY-----"
+//	        message: Private key committed to the repository.
+//	        severity: critical
+type PromptFile struct {
+	Audits []Audit `yaml:"audits"`
+}
+
+func isPromptFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// LoadPromptFile reads and validates a single prompt file. Prompts without
+// a severity default to medium. An invalid audit, prompt or local check is
+// left out and its error returned in skipped, so one bad entry doesn't cost
+// the rest of the file; err is for a file that can't be read or parsed as
+// a whole.
+func LoadPromptFile(path string) (audits []Audit, skipped []error, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A PromptFile whose audits are decoded one by one.
+	var file struct {
+		Audits []yaml.Node `yaml:"audits"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for i := range file.Audits {
+		a, errs, err := loadAuditEntry(path, i, &file.Audits[i])
+		skipped = append(skipped, errs...)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		audits = append(audits, a)
+	}
+	return audits, skipped, nil
+}
+
+// loadAuditEntry decodes and validates audits[i] of a prompt file. An error
+// leaves out the whole audit; the errors of the prompts and local checks
+// left out of it are returned in skipped.
+func loadAuditEntry(path string, i int, node *yaml.Node) (a Audit, skipped []error, err error) {
+	rest, prompts, checks := splitAuditNode(node)
+	if err := rest.Decode(&a); err != nil {
+		return Audit{}, nil, fmt.Errorf("%s: audits[%d]: %v", path, i, yamlError(err))
+	}
+	if a.ID == "" {
+		return Audit{}, nil, fmt.Errorf("%s: audits[%d] has no id", path, i)
+	}
+	if a.Name == "" {
+		a.Name = a.ID
+	}
+	switch a.Merge {
+	case "", MergeAppend, MergeReplace:
+	default:
+		return Audit{}, nil, fmt.Errorf("%s: audit '%s' has unknown merge '%s', expected %s or %s", path, a.ID, a.Merge, MergeAppend, MergeReplace)
+	}
+	seen := make(map[string]bool)
+	for j, n := range prompts {
+		var p Prompt
+		if err := n.Decode(&p); err != nil {
+			skipped = append(skipped, fmt.Errorf("%s: audit '%s' prompt %d: %v", path, a.ID, j, yamlError(err)))
+			continue
+		}
+		if err := checkPrompt(&p, seen); err != nil {
+			skipped = append(skipped, fmt.Errorf("%s: audit '%s' prompt %d %v", path, a.ID, j, err))
+			continue
+		}
+		seen[p.ID] = true
+		a.Prompts = append(a.Prompts, p)
+	}
+	for j, n := range checks {
+		var c LocalCheck
+		if err := n.Decode(&c); err != nil {
+			skipped = append(skipped, fmt.Errorf("%s: audit '%s' local check %d: %v", path, a.ID, j, yamlError(err)))
+			continue
+		}
+		if err := c.compile(); err != nil {
+			skipped = append(skipped, fmt.Errorf("%s: audit '%s': %v", path, a.ID, err))
+			continue
+		}
+		a.LocalChecks = append(a.LocalChecks, c)
+	}
+	return a, skipped, nil
+}
+
+// checkPrompt validates a prompt of a prompt file and fills in its
+// defaults; seen holds the IDs of the audit's prompts so far. The error
+// completes "prompt N ...".
+func checkPrompt(p *Prompt, seen map[string]bool) error {
+	if strings.TrimSpace(p.Text) == "" {
+		return errors.New("has no text")
+	}
+	if p.ID == "" {
+		p.ID = PromptID(p.Text)
+	}
+	if seen[p.ID] {
+		return fmt.Errorf("has the id '%s' of an earlier prompt", p.ID)
+	}
+	if p.Severity == "" {
+		p.Severity = SeverityMedium
+	}
+	if !p.Severity.Valid() {
+		return fmt.Errorf("has unknown severity '%s'", p.Severity)
+	}
+	if p.CWE < 0 {
+		return fmt.Errorf("has invalid cwe %d", p.CWE)
+	}
+	if p.Timeout < 0 {
+		return errors.New("has a negative timeout")
+	}
+	for _, t := range p.Tags {
+		if strings.TrimSpace(t) == "" {
+			return errors.New("has an empty tag")
+		}
+	}
+	if p.When != "" {
+		if _, err := ParseWhen(p.When); err != nil {
+			return fmt.Errorf("has an invalid when: %v", err)
+		}
+	}
+	for lang, text := range p.Variants {
+		if lang != strings.ToLower(lang) || strings.TrimSpace(text) == "" {
+			return fmt.Errorf("has invalid variant '%s'; variants are keyed by lowercase language and need text", lang)
+		}
+	}
+	return nil
+}
+
+// splitAuditNode separates the prompts and local checks of an audit from
+// the rest of it, so each can be decoded on its own. Lists that aren't
+// sequences stay in rest, where decoding them fails.
+func splitAuditNode(node *yaml.Node) (rest *yaml.Node, prompts, checks []*yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return node, nil, nil
+	}
+	stripped := *node
+	stripped.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind == yaml.SequenceNode {
+			switch key.Value {
+			case "prompts":
+				prompts = value.Content
+				continue
+			case "localChecks":
+				checks = value.Content
+				continue
+			}
+		}
+		stripped.Content = append(stripped.Content, key, value)
+	}
+	return &stripped, prompts, checks
+}
+
+// yamlError puts the errors of a failed decode on one line.
+func yamlError(err error) string {
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		return strings.Join(te.Errors, "; ")
+	}
+	return err.Error()
+}
+
+// PromptFilesInDir lists the prompt files in dir in lexical order, which is
+// also the order they are merged in.
+func PromptFilesInDir(dir string, recursive bool) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isPromptFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// How an audit merges with an earlier one of the same ID.
+const (
+	MergeAppend  = "append"
+	MergeReplace = "replace"
+)
+
+// MergeAudits adds the audits loaded from source to base, in order. An audit
+// whose ID is already defined is merged into the earlier definition, which
+// keeps its name and position: by default its prompts, requirements and
+// local checks are appended, a prompt or local check already defined is
+// reported and only its first definition kept, and a repeated requirement
+// is dropped. With merge: replace the audit replaces the earlier definition
+// altogether, apart from its position. base is not modified.
+func MergeAudits(base []Audit, source string, extra []Audit) []Audit {
+	merged := make([]Audit, len(base))
+	for i, a := range base {
+		merged[i] = a
+		merged[i].Prompts = append([]Prompt(nil), a.Prompts...)
+		merged[i].Requires = append([]string(nil), a.Requires...)
+		merged[i].LocalChecks = append([]LocalCheck(nil), a.LocalChecks...)
+	}
+	for _, a := range extra {
+		target := findAudit(merged, a.ID)
+		switch {
+		case target == nil:
+			merged = append(merged, Audit{ID: a.ID, Name: a.Name})
+			target = &merged[len(merged)-1]
+		case a.Merge == MergeReplace:
+			debugf("%s: replacing audit '%s' (prompts: %d)", source, a.ID, len(target.Prompts))
+			*target = Audit{ID: a.ID, Name: a.Name}
+		}
+		target.Merge = ""
+		for _, r := range a.Requires {
+			if !hasTag(target.Requires, r) {
+				target.Requires = append(target.Requires, r)
+			}
+		}
+		for _, c := range a.LocalChecks {
+			if hasLocalCheck(target.LocalChecks, c.ID) {
+				log.Printf("Warning: %s: local check '%s' is already defined in audit '%s'; ignoring duplicate\n", source, c.ID, a.ID)
+				continue
+			}
+			target.LocalChecks = append(target.LocalChecks, c)
+		}
+		for _, p := range a.Prompts {
+			if hasPrompt(target.Prompts, p) {
+				log.Printf("Warning: %s: prompt '%s' is already defined in audit '%s'; ignoring duplicate\n", source, p.Text, a.ID)
+				continue
+			}
+			target.Prompts = append(target.Prompts, p)
+		}
+		debugf("%s: merged audit '%s' (prompts: %d)", source, a.ID, len(target.Prompts))
+	}
+	return merged
+}
+
+// hasLocalCheck reports whether checks has one with the ID id; checks
+// without an ID are never duplicates.
+func hasLocalCheck(checks []LocalCheck, id string) bool {
+	for _, c := range checks {
+		if id != "" && c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPrompt reports whether prompts already contains p's text or ID.
+func hasPrompt(prompts []Prompt, p Prompt) bool {
+	for _, q := range prompts {
+		if q.Text == p.Text || (p.ID != "" && q.ID == p.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// PromptID derives an ID from a prompt's text: its first few words,
+// lowercased and joined with hyphens.
+func PromptID(text string) string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) {
+		words = append(words, w)
+		if len(words) == 6 {
+			break
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// AdHocAuditID is the audit formed by the prompts of -audits=- and
+// -prompts-txt.
+const AdHocAuditID = "stdin"
+
+// LoadPromptLines reads one prompt per line from r for the ad-hoc audit,
+// skipping blank lines and lines starting with #. IDs are derived from the
+// text as in prompt files, with a numeric suffix where two would clash.
+// Input without any prompt is an error.
+func LoadPromptLines(r io.Reader, source string) (Audit, error) {
+	audit := Audit{ID: AdHocAuditID, Name: AdHocAuditID}
+	ids := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id := PromptID(line)
+		if ids[id]++; ids[id] > 1 {
+			id = fmt.Sprintf("%s-%d", id, ids[id])
+		}
+		audit.Prompts = append(audit.Prompts, Prompt{ID: id, Text: line, Severity: SeverityMedium})
+	}
+	if err := scanner.Err(); err != nil {
+		return Audit{}, fmt.Errorf("reading %s: %v", source, err)
+	}
+	if len(audit.Prompts) == 0 {
+		return Audit{}, fmt.Errorf("%s has no prompts", source)
+	}
+	return audit, nil
+}
+
+// LoadPromptsDir merges every prompt file in dir into audits, returning the
+// errors of the entries left out as LoadPromptFile does.
+func LoadPromptsDir(audits []Audit, dir string, recursive bool) ([]Audit, []error, error) {
+	files, err := PromptFilesInDir(dir, recursive)
+	if err != nil {
+		return nil, nil, err
+	}
+	var skipped []error
+	for _, path := range files {
+		extra, errs, err := LoadPromptFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		skipped = append(skipped, errs...)
+		audits = MergeAudits(audits, path, extra)
+	}
+	return audits, skipped, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// promptIDs lists the IDs of an audit's prompts.
+func promptIDs(a Audit) string {
+	var ids []string
+	for _, p := range a.Prompts {
+		ids = append(ids, p.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+// mergeLogs captures the warnings and debug output of MergeAudits.
+func mergeLogs(t *testing.T) (warnings, debug *bytes.Buffer) {
+	warnings, debug = new(bytes.Buffer), new(bytes.Buffer)
+	logOutput := log.Writer()
+	t.Cleanup(func() {
+		log.SetOutput(logOutput)
+		debugLog.SetOutput(io.Discard)
+	})
+	log.SetOutput(warnings)
+	debugLog.SetOutput(debug)
+	return warnings, debug
+}
+
+func testBaseAudits() []Audit {
+	return []Audit{
+		{ID: "owasp", Name: "OWASP Top 10", Requires: []string{"web"},
+			Prompts:     []Prompt{{ID: "injection", Text: "Is user input concatenated into queries?"}, {ID: "xss", Text: "Is user input rendered unescaped?"}},
+			LocalChecks: []LocalCheck{{ID: "skip-verify", Pattern: `InsecureSkipVerify:\s*true`}},
+		},
+		{ID: "auth", Name: "Authentication", Prompts: []Prompt{{ID: "hash", Text: "Are passwords hashed with a weak algorithm?"}}},
+	}
+}
+
+func TestMergeAuditsAppend(t *testing.T) {
+	_, debug := mergeLogs(t)
+	merged := MergeAudits(testBaseAudits(), "prompts.yaml", []Audit{
+		{ID: "owasp", Name: "Renamed", Merge: MergeAppend, Requires: []string{"web", "api"},
+			Prompts: []Prompt{{ID: "ssrf", Text: "Are outbound URLs built from user input?"}}},
+		{ID: "crypto", Name: "Cryptography", Prompts: []Prompt{{ID: "tls", Text: "Is TLS verification disabled?"}}},
+	})
+	if len(merged) != 3 || merged[0].ID != "owasp" || merged[1].ID != "auth" || merged[2].ID != "crypto" {
+		t.Fatalf("audits = %+v, want owasp and auth in place and crypto appended", merged)
+	}
+	owasp := merged[0]
+	if owasp.Name != "OWASP Top 10" || owasp.Merge != "" {
+		t.Errorf("merged audit is named %q with merge %q, want the first name and no merge", owasp.Name, owasp.Merge)
+	}
+	if got := promptIDs(owasp); got != "injection xss ssrf" {
+		t.Errorf("prompts = %s, want the new one after the earlier ones", got)
+	}
+	if got := strings.Join(owasp.Requires, " "); got != "web api" {
+		t.Errorf("requires = %s, want web api", got)
+	}
+	if len(owasp.LocalChecks) != 1 {
+		t.Errorf("local checks = %+v, want the earlier one kept", owasp.LocalChecks)
+	}
+	for _, line := range []string{"prompts.yaml: merged audit 'owasp' (prompts: 3)", "prompts.yaml: merged audit 'crypto' (prompts: 1)"} {
+		if !strings.Contains(debug.String(), line) {
+			t.Errorf("debug log lacks %q:\n%s", line, debug)
+		}
+	}
+}
+
+func TestMergeAuditsReplace(t *testing.T) {
+	_, debug := mergeLogs(t)
+	merged := MergeAudits(testBaseAudits(), "prompts.d/owasp.yaml", []Audit{
+		{ID: "owasp", Name: "OWASP (ours)", Merge: MergeReplace,
+			Prompts: []Prompt{{ID: "xss", Text: "Does the template engine autoescape?"}}},
+	})
+	if len(merged) != 2 || merged[0].ID != "owasp" {
+		t.Fatalf("audits = %+v, want owasp replaced in place", merged)
+	}
+	owasp := merged[0]
+	if owasp.Name != "OWASP (ours)" || owasp.Merge != "" || len(owasp.Requires) != 0 || len(owasp.LocalChecks) != 0 {
+		t.Errorf("replaced audit = %+v, want only the new definition", owasp)
+	}
+	// The replaced prompt of the same ID is not a duplicate.
+	if len(owasp.Prompts) != 1 || owasp.Prompts[0].Text != "Does the template engine autoescape?" {
+		t.Errorf("prompts = %+v, want only the new one", owasp.Prompts)
+	}
+	if !strings.Contains(debug.String(), "replacing audit 'owasp' (prompts: 2)") {
+		t.Errorf("debug log lacks the replacement:\n%s", debug)
+	}
+}
+
+func TestMergeAuditsDuplicates(t *testing.T) {
+	warnings, _ := mergeLogs(t)
+	merged := MergeAudits(testBaseAudits(), "prompts.yaml", []Audit{{
+		ID: "owasp",
+		Prompts: []Prompt{
+			{ID: "injection", Text: "Is SQL built by concatenation?"},
+			{ID: "xss-again", Text: "Is user input rendered unescaped?"},
+			{ID: "csrf", Text: "Do forms lack CSRF tokens?"},
+			{ID: "csrf", Text: "Are state-changing GETs allowed?"},
+		},
+		LocalChecks: []LocalCheck{
+			{ID: "skip-verify", Pattern: `tls\.Config\{`},
+			{Pattern: "unnamed"},
+			{Pattern: "also unnamed"},
+		},
+	}})
+	owasp := merged[0]
+	// By ID, by text, and repeated within the same source.
+	if got := promptIDs(owasp); got != "injection xss csrf" {
+		t.Errorf("prompts = %s, want each duplicate dropped", got)
+	}
+	if owasp.Prompts[0].Text != "Is user input concatenated into queries?" {
+		t.Errorf("prompt injection = %q, want its first definition", owasp.Prompts[0].Text)
+	}
+	// Checks without an ID are never duplicates.
+	if len(owasp.LocalChecks) != 3 || owasp.LocalChecks[0].Pattern != `InsecureSkipVerify:\s*true` {
+		t.Errorf("local checks = %+v, want the first skip-verify and both unnamed checks", owasp.LocalChecks)
+	}
+	for _, line := range []string{
+		"prompts.yaml: prompt 'Is SQL built by concatenation?' is already defined in audit 'owasp'",
+		"prompts.yaml: prompt 'Is user input rendered unescaped?' is already defined in audit 'owasp'",
+		"prompts.yaml: prompt 'Are state-changing GETs allowed?' is already defined in audit 'owasp'",
+		"prompts.yaml: local check 'skip-verify' is already defined in audit 'owasp'",
+	} {
+		if !strings.Contains(warnings.String(), line) {
+			t.Errorf("warnings lack %q:\n%s", line, warnings)
+		}
+	}
+}
+
+func TestMergeAuditsLeavesBase(t *testing.T) {
+	mergeLogs(t)
+	base := testBaseAudits()
+	// Spare capacity would let appends write through to base's arrays.
+	base[0].Prompts = append(make([]Prompt, 0, 8), base[0].Prompts...)
+	base[0].Requires = append(make([]string, 0, 8), base[0].Requires...)
+	base[0].LocalChecks = append(make([]LocalCheck, 0, 8), base[0].LocalChecks...)
+	merged := MergeAudits(base, "prompts.yaml", []Audit{
+		{ID: "owasp", Requires: []string{"api"}, Prompts: []Prompt{{ID: "ssrf", Text: "Are outbound URLs built from user input?"}}, LocalChecks: []LocalCheck{{ID: "md5", Pattern: "md5"}}},
+		{ID: "auth", Merge: MergeReplace, Name: "Auth"},
+	})
+	merged[0].Prompts[0].Text = "changed"
+	if !reflect.DeepEqual(base, testBaseAudits()) {
+		t.Errorf("base = %+v, want it unmodified", base)
+	}
+	if got := base[0].Prompts[:3]; got[2].ID != "" {
+		t.Errorf("merging wrote %+v past the end of base's prompts", got[2])
+	}
+}
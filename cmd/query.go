@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// QueryResult is what treeko query -json prints.
+type QueryResult struct {
+	Codebase        string   `json:"codebase"`
+	CodebaseAlias   string   `json:"codebaseAlias,omitempty"`
+	Branch          string   `json:"branch,omitempty"`
+	Prompt          string   `json:"prompt"`
+	Result          string   `json:"result,omitempty"`
+	Score           *float64 `json:"score,omitempty"`
+	SessionID       string   `json:"sessionId,omitempty"`
+	RequestID       string   `json:"requestId,omitempty"`
+	ServerRequestID string   `json:"serverRequestId,omitempty"`
+	DurationMs      int64    `json:"durationMs"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// queryOptions are the flags treeko query and treeko repl share.
+type queryOptions struct {
+	codebase, branch, config, session *string
+	retry                             *retryFlags
+	timeouts                          *timeoutFlags
+	network                           *networkFlags
+	debug, dumpHTTP, compressRequests *bool
+	// aliases is the -config file once setup loaded it, for resolving
+	// codebase aliases.
+	aliases *Config
+}
+
+func addQueryFlags(flags *flag.FlagSet) *queryOptions {
+	return &queryOptions{
+		codebase:         flags.String("codebase", "", "Codebase ID or -config alias to ask about (default: the .treeko codebase, the only codebase of -config, or the built-in one)"),
+		branch:           flags.String("branch", "", "Branch of the codebase to ask about"),
+		config:           flags.String("config", "", "Configuration file whose codebase is asked about when it lists exactly one, and whose aliases name codebases"),
+		session:          flags.String("session", os.Getenv("TREEKO_SESSION"), "Session ID; consecutive queries with the same one can follow up on each other (default $TREEKO_SESSION)"),
+		retry:            addRetryFlags(flags),
+		timeouts:         addTimeoutFlags(flags),
+		network:          addNetworkFlags(flags),
+		debug:            flags.Bool("debug", false, "Log debugging information to stderr"),
+		dumpHTTP:         flags.Bool("dump-http", false, "Log the request and response to stderr, with credentials redacted"),
+		compressRequests: flags.Bool("compress-requests", false, "Gzip request bodies, for backends that accept Content-Encoding: gzip"),
+	}
+}
+
+// setup applies the .treeko file to flags, then resolves the codebase and
+// builds the Greptile backend. Errors are usage errors.
+func (o *queryOptions) setup(flags *flag.FlagSet) (Target, *GreptileBackend, error) {
+	var dotFile *DotFile
+	if path := FindDotFile("."); path != "" {
+		dot, err := LoadDotFile(path, nil)
+		if err == nil {
+			err = dot.Apply(flags)
+		}
+		if err != nil {
+			return Target{}, nil, fmt.Errorf("reading %s: %w", DotFileName, err)
+		}
+		dotFile = dot
+	}
+	retryPolicies, err := o.retry.policies()
+	if err != nil {
+		return Target{}, nil, err
+	}
+	timeouts, err := o.timeouts.timeouts()
+	if err != nil {
+		return Target{}, nil, err
+	}
+	if err := o.network.apply(); err != nil {
+		return Target{}, nil, err
+	}
+	if *o.debug {
+		debugLog.SetOutput(os.Stderr)
+	}
+
+	target := Target{Codebase: *o.codebase, Branch: *o.branch, Session: *o.session}
+	if target.Codebase == "" && dotFile != nil && dotFile.Codebase != "" {
+		target.Codebase = dotFile.Codebase
+		if target.Branch == "" {
+			target.Branch = dotFile.Branch
+		}
+	}
+	var cfg *Config
+	if *o.config != "" {
+		var err error
+		if cfg, err = LoadConfig(*o.config); err != nil {
+			return Target{}, nil, fmt.Errorf("loading config: %w", err)
+		}
+	}
+	if target.Codebase == "" && cfg != nil {
+		if len(cfg.Codebases) != 1 {
+			return Target{}, nil, fmt.Errorf("%s lists %d codebases; choose one with -codebase", *o.config, len(cfg.Codebases))
+		}
+		target.Codebase = cfg.Codebases[0].ID
+		if target.Branch == "" {
+			target.Branch = cfg.Codebases[0].Branch
+		}
+	}
+	if target.Codebase == "" {
+		target.Codebase = CodebaseID
+	}
+	target.Codebase, target.Alias = cfg.ResolveCodebase(target.Codebase)
+	o.aliases = cfg
+
+	var httpDump *log.Logger
+	if *o.dumpHTTP {
+		httpDump = log.New(os.Stderr, "http: ", log.LstdFlags)
+	}
+	backend := &GreptileBackend{URL: GreptileAPIUrl, Client: NewBackendClient(
+		WithAuth("Authorization", "Bearer "+APIKey),
+		WithCorrelation(NewRunID()),
+		WithRetry(retryPolicies),
+		WithTimeouts(timeouts),
+		WithRequestCompression(*o.compressRequests),
+		WithDump(httpDump),
+	)}
+	return target, backend, nil
+}
+
+// ask sends prompt about target and times the answer.
+func ask(ctx context.Context, backend Backend, prompt string, target Target) QueryResult {
+	ctx, ids := withRequestIDs(ctx)
+	start := time.Now()
+	answer, err := backend.Query(ctx, prompt, target)
+	result := QueryResult{
+		Codebase:        target.Codebase,
+		CodebaseAlias:   target.Alias,
+		Branch:          target.Branch,
+		Prompt:          prompt,
+		Result:          answer.Result,
+		Score:           answer.Score,
+		SessionID:       target.Session,
+		RequestID:       ids.Sent,
+		ServerRequestID: ids.Echoed,
+		DurationMs:      time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// runQueryCommand asks one question about a codebase and prints the answer,
+// without audits, filters or a report. It exits 1 if the request fails.
+func runQueryCommand(args []string) int {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	opts := addQueryFlags(flags)
+	asJSON := flags.Bool("json", false, "Print the answer as a JSON object")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko query [flags] \"question\"")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || strings.TrimSpace(flags.Arg(0)) == "" {
+		flags.Usage()
+		return ExitUsage
+	}
+	target, backend, err := opts.setup(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result := ask(ctx, backend, flags.Arg(0), target)
+	switch {
+	case *asJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	case result.Error != "":
+		fmt.Fprintf(os.Stderr, "Error querying %s: %s\n", target.Codebase, result.errorText())
+	default:
+		fmt.Println(strings.TrimRight(result.Result, "\n"))
+	}
+	if result.Error != "" {
+		return 1
+	}
+	return ExitOK
+}
+
+// errorText is the error with the IDs of the request that failed.
+func (r QueryResult) errorText() string {
+	ids := &RequestIDs{Sent: r.RequestID, Echoed: r.ServerRequestID}
+	if ids.Sent == "" {
+		return r.Error
+	}
+	return fmt.Sprintf("%s (%s)", r.Error, ids)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter caps how many requests per second are sent to the backends; it
+// is shared by their clients, see WithRateLimit. It is nil when -rate is not
+// set, in which case only the semaphore applies.
+var rateLimiter *rate.Limiter
+
+// ParseRate parses a request rate such as "2/s", "30/m" or "0.5/s". A bare
+// number is taken as requests per second.
+func ParseRate(s string) (rate.Limit, error) {
+	count, unit := s, "s"
+	if i := strings.Index(s, "/"); i >= 0 {
+		count, unit = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s': expected a positive number of requests, e.g. 2/s", s)
+	}
+	var per time.Duration
+	switch strings.TrimSpace(unit) {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return 0, fmt.Errorf("invalid rate '%s': unit must be s, m or h", s)
+	}
+	return rate.Limit(n / per.Seconds()), nil
+}
+
+// NewRateLimiter returns a limiter for limit with a burst of one, so requests
+// are spread evenly instead of being sent in bursts at the start of a run.
+func NewRateLimiter(limit rate.Limit) *rate.Limiter {
+	return rate.NewLimiter(limit, 1)
+}
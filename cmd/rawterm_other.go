@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// rawTerminal can't switch the terminal's mode on this platform.
+func rawTerminal(f *os.File) (restore func(), err error) {
+	return nil, errors.New("reading keys isn't supported on this platform")
+}
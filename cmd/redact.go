@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RedactedMarker replaces what redaction removes.
+const RedactedMarker = "***REDACTED***"
+
+// defaultRedactPatterns catch the secrets audits most often quote back:
+// cloud and API keys, credentials assigned in code or env files, bearer
+// tokens and private keys. Where a pattern has a group named secret, only
+// the group is replaced, so the finding still says what was leaked.
+var defaultRedactPatterns = []string{
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`,
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}`,
+	`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{16,}=*)`,
+	`(?i)\b(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|client[_-]?secret|private[_-]?key)["']?\s*[:=]\s*["'](?P<secret>[^"'\s]{4,})["']`,
+	`(?im)^\s*(?:export\s+)?[A-Z0-9_]*(?:PASSWORD|PASSWD|SECRET|TOKEN|API_KEY|ACCESS_KEY)[A-Z0-9_]*\s*=\s*(?P<secret>[^\s"']{4,})`,
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`,
+}
+
+// Redactor removes secrets from findings before any output sees them.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// redactor is applied to every finding; nil with -no-redact.
+var redactor *Redactor
+
+// NewRedactor compiles the default patterns followed by extra, e.g. those
+// of the config's redact list.
+func NewRedactor(extra []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range append(append([]string(nil), defaultRedactPatterns...), extra...) {
+		re, err := compileRedactPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+func compileRedactPattern(p string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, fmt.Errorf("redact pattern %q: %v", p, err)
+	}
+	return re, nil
+}
+
+// String redacts s, returning the result and the number of secrets
+// replaced. Text that is already redacted is left as it is, so redacting
+// again replaces nothing more.
+func (r *Redactor) String(s string) (string, int) {
+	count := 0
+	for _, re := range r.patterns {
+		group := re.SubexpIndex("secret")
+		var b strings.Builder
+		last := 0
+		for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+			start, end := m[0], m[1]
+			if group > 0 && m[2*group] >= 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			if start == end || strings.Contains(s[start:end], RedactedMarker) {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(RedactedMarker)
+			last = end
+			count++
+		}
+		if last > 0 {
+			b.WriteString(s[last:])
+			s = b.String()
+		}
+	}
+	return s, count
+}
+
+// Apply redacts f's result and raw response, adding what it replaced to
+// f.Redactions.
+func (r *Redactor) Apply(f *Finding) {
+	if r == nil {
+		return
+	}
+	var n int
+	f.Result, n = r.String(f.Result)
+	f.Redactions += n
+	if len(f.Raw) > 0 {
+		// The raw response is redacted value by value so it stays valid
+		// JSON; its secrets are the result's, so they aren't counted again.
+		var v interface{}
+		if json.Unmarshal(f.Raw, &v) == nil {
+			if data, err := json.Marshal(r.value(v)); err == nil {
+				f.Raw = data
+			}
+		}
+	}
+}
+
+// value redacts every string in a decoded JSON value.
+func (r *Redactor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		s, _ := r.String(v)
+		return s
+	case []interface{}:
+		for i := range v {
+			v[i] = r.value(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = r.value(v[k])
+		}
+	}
+	return v
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replHistoryFile is where treeko repl keeps the lines entered, in the
+// home directory.
+const replHistoryFile = ".treeko_history"
+
+const replHelp = `Type a question to ask it about the codebase. Commands:
+  :codebase [id]   show or switch the codebase, by ID or alias
+  :branch [name]   show or switch the branch
+  :run <audit>     run a built-in audit, e.g. :run auth
+  :save <file>     save the transcript as Markdown
+  :history         show the last 20 lines entered
+  :help            show this help
+  :quit            exit (or Ctrl-D)
+Ctrl-C cancels a question or audit that is running.`
+
+// replEntry is one exchange of a repl transcript.
+type replEntry struct {
+	Question string
+	// Answer is the Markdown shown under the question.
+	Answer string
+}
+
+// repl is the state of an interactive session.
+type repl struct {
+	target  Target
+	aliases *Config
+	backend Backend
+	out     io.Writer
+	color   bool
+	width   int
+	history *os.File
+	entries []replEntry
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// runReplCommand reads questions from stdin and answers each in the same
+// session until Ctrl-D or :quit.
+func runReplCommand(args []string) int {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	opts := addQueryFlags(flags)
+	noColor := flags.Bool("no-color", false, "Don't color the output, even in a terminal")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko repl [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	target, backend, err := opts.setup(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitUsage
+	}
+	if target.Session == "" {
+		target.Session = NewRunID()
+	}
+	if err := CompileLocalChecks(builtinAudits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error in built-in audits: %v\n", err)
+		return ExitUsage
+	}
+	r := &repl{target: target, aliases: opts.aliases, backend: backend, out: os.Stdout, color: colorEnabled(os.Stdout, *noColor), width: outputWidth(os.Stdout)}
+	if home, err := os.UserHomeDir(); err == nil {
+		r.history, err = os.OpenFile(filepath.Join(home, replHistoryFile), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+		if err != nil {
+			debugf("history disabled: %v", err)
+		} else {
+			defer r.history.Close()
+		}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		for range sigs {
+			if !r.interrupt() {
+				fmt.Fprint(r.out, "\n(Ctrl-D to exit)\n"+r.prompt())
+			}
+		}
+	}()
+
+	fmt.Fprintf(r.out, "treeko repl on %s, session %s. :help lists the commands.\n", r.target.Codebase, r.target.Session)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(r.out, r.prompt())
+		if !scanner.Scan() {
+			fmt.Fprintln(r.out)
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		r.remember(line)
+		if line == ":quit" || line == ":exit" {
+			break
+		}
+		r.handle(line)
+	}
+	return ExitOK
+}
+
+func (r *repl) prompt() string {
+	return colorize(r.color, styleGray, codebaseLabel(r.target.Codebase, r.target.Alias)) + "> "
+}
+
+// interrupt cancels the request in flight, reporting whether there was one.
+func (r *repl) interrupt() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel == nil {
+		return false
+	}
+	r.cancel()
+	return true
+}
+
+// cancellable returns a context Ctrl-C cancels, and the function that
+// releases it.
+func (r *repl) cancellable() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.cancel = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		r.cancel = nil
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// remember appends line to the history file.
+func (r *repl) remember(line string) {
+	if r.history != nil {
+		fmt.Fprintln(r.history, line)
+	}
+}
+
+func (r *repl) handle(line string) {
+	if !strings.HasPrefix(line, ":") {
+		r.ask(line)
+		return
+	}
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case ":help":
+		fmt.Fprintln(r.out, replHelp)
+	case ":codebase":
+		if arg != "" {
+			r.target.Codebase, r.target.Alias = r.aliases.ResolveCodebase(arg)
+		}
+		if r.target.Alias != "" {
+			fmt.Fprintf(r.out, "Codebase: %s (%s)\n", r.target.Alias, r.target.Codebase)
+		} else {
+			fmt.Fprintf(r.out, "Codebase: %s\n", r.target.Codebase)
+		}
+	case ":branch":
+		if arg != "" {
+			r.target.Branch = arg
+		}
+		if r.target.Branch == "" {
+			fmt.Fprintln(r.out, "Branch: the codebase's default")
+			return
+		}
+		fmt.Fprintf(r.out, "Branch: %s\n", r.target.Branch)
+	case ":run":
+		r.run(arg)
+	case ":save":
+		if arg == "" {
+			fmt.Fprintln(r.out, "Usage: :save <file>")
+			return
+		}
+		if err := r.save(arg); err != nil {
+			fmt.Fprintf(r.out, "Error saving transcript: %v\n", err)
+			return
+		}
+		fmt.Fprintf(r.out, "Saved %d exchanges to %s\n", len(r.entries), arg)
+	case ":history":
+		r.showHistory()
+	default:
+		fmt.Fprintf(r.out, "Unknown command %s; :help lists the commands\n", command)
+	}
+}
+
+// ask sends a question in the session and prints the answer.
+func (r *repl) ask(question string) {
+	ctx, done := r.cancellable()
+	result := ask(ctx, r.backend, question, r.target)
+	cancelled := ctx.Err() != nil
+	done()
+	switch {
+	case cancelled:
+		fmt.Fprintln(r.out, "Cancelled.")
+		return
+	case result.Error != "":
+		fmt.Fprintf(r.out, "Error: %s\n", result.errorText())
+		r.entries = append(r.entries, replEntry{Question: question, Answer: "_Error: " + result.Error + "_"})
+		return
+	}
+	answer := strings.TrimSpace(result.Result)
+	if answer == "" {
+		answer = "(no answer)"
+	}
+	fmt.Fprintln(r.out, wrapText(answer, r.width))
+	meta := fmt.Sprintf("%s in %s", r.target.Codebase, formatDurationMs(result.DurationMs))
+	if result.Score != nil {
+		meta += fmt.Sprintf(", confidence %.2f", *result.Score)
+	}
+	fmt.Fprintln(r.out, colorize(r.color, styleGray, meta))
+	r.entries = append(r.entries, replEntry{Question: question, Answer: answer})
+}
+
+// run runs the built-in audit with ID id against the session's codebase,
+// streaming its results as a text run does, and adds its findings to the
+// transcript in compact form.
+func (r *repl) run(id string) {
+	audit := findAudit(builtinAudits, id)
+	if audit == nil {
+		var ids []string
+		for _, a := range builtinAudits {
+			ids = append(ids, a.ID)
+		}
+		fmt.Fprintf(r.out, "Unknown audit '%s'; built-in audits are %s\n", id, strings.Join(ids, ", "))
+		return
+	}
+	// Nothing is known about the codebase, so framework prompts are left
+	// out.
+	tailored, err := TailorAudits([]Audit{*audit}, promptVars{})
+	if err != nil {
+		fmt.Fprintf(r.out, "Error in audit '%s': %v\n", id, err)
+		return
+	}
+	audit = &tailored[0]
+	// Audit prompts stand alone, so they aren't sent in the session.
+	target := r.target
+	target.Session = ""
+	target.Backend = r.backend
+	report := NewReport(RunMetadata{RunID: NewRunID(), ToolVersion: Version, Codebase: target.Codebase, StartedAt: time.Now().UTC()})
+	ctx, done := r.cancellable()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	RunAudit(ctx, target, *audit, report, NewConcurrencyPool(1, MaxConcurrent, MaxConcurrent), &wg)
+	cancelled := ctx.Err() != nil
+	done()
+	report.Summarize([]CodebaseConfig{{ID: target.Codebase, Branch: target.Branch}})
+	s := report.Summary
+	fmt.Fprintf(r.out, "%d prompts, %d results, %d errors\n", s.Prompts, s.Results, s.Errors)
+	if cancelled {
+		fmt.Fprintln(r.out, "Cancelled; the remaining prompts were skipped.")
+	}
+
+	var compact bytes.Buffer
+	WriteCompact(&compact, report, 0)
+	answer := strings.TrimSpace(compact.String())
+	if answer == "" {
+		answer = "(no findings)"
+	}
+	r.entries = append(r.entries, replEntry{Question: ":run " + id, Answer: "```\n" + answer + "\n```"})
+}
+
+// save writes the transcript as Markdown, each question a heading followed
+// by its answer.
+func (r *repl) save(path string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# treeko session %s\n\n", r.target.Session)
+	fmt.Fprintf(&b, "Codebase: %s, saved %s\n", r.target.Codebase, time.Now().UTC().Format(time.RFC3339))
+	for _, e := range r.entries {
+		fmt.Fprintf(&b, "\n## %s\n\n%s\n", e.Question, e.Answer)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+func (r *repl) showHistory() {
+	if r.history == nil {
+		fmt.Fprintln(r.out, "No history file")
+		return
+	}
+	data, err := os.ReadFile(r.history.Name())
+	if err != nil {
+		fmt.Fprintf(r.out, "Error reading history: %v\n", err)
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	first := 0
+	if len(lines) > 20 {
+		first = len(lines) - 20
+	}
+	for i := first; i < len(lines); i++ {
+		fmt.Fprintf(r.out, "%5d  %s\n", i+1, lines[i])
+	}
+}
+
+// wrapText wraps each line of s to width runes at spaces. Indented lines,
+// usually code, are left alone, as is everything when width is 0.
+func wrapText(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if len([]rune(line)) <= width || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			out = append(out, line)
+			continue
+		}
+		current := ""
+		for _, word := range strings.Fields(line) {
+			if current != "" && len([]rune(current))+1+len([]rune(word)) > width {
+				out = append(out, current)
+				current = word
+				continue
+			}
+			if current != "" {
+				current += " "
+			}
+			current += word
+		}
+		out = append(out, current)
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,748 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is overridden at build time with -ldflags "-X main.Version=...".
+var Version = "dev"
+
+// Finding is the outcome of a single prompt, or one match of a local check.
+type Finding struct {
+	Codebase string `json:"codebase"`
+	// CodebaseAlias is the codebase's friendly name from the config.
+	CodebaseAlias string   `json:"codebaseAlias,omitempty"`
+	Audit         string   `json:"audit"`
+	AuditID       string   `json:"auditId,omitempty"`
+	Prompt        string   `json:"prompt"`
+	PromptID      string   `json:"promptId,omitempty"`
+	Severity      Severity `json:"severity"`
+	// OriginalSeverity is the severity before severityOverrides changed it,
+	// and SeverityOverrides the names of the overrides that did.
+	OriginalSeverity  Severity `json:"originalSeverity,omitempty"`
+	SeverityOverrides []string `json:"severityOverrides,omitempty"`
+	CWE               int      `json:"cwe,omitempty"`
+	Source            string   `json:"source"`
+	Status            Status   `json:"status"`
+	Check             string   `json:"check,omitempty"`
+	Result            string   `json:"result"`
+	Error             string   `json:"error,omitempty"`
+	// ErrorClass is the cause of Error shared with other failures, see
+	// ErrorClass.
+	ErrorClass  string     `json:"errorClass,omitempty"`
+	Score       *float64   `json:"score,omitempty"`
+	Cached      bool       `json:"cached"`
+	Replayed    bool       `json:"replayed,omitempty"`
+	Revision    string     `json:"revision,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	DurationMs  int64      `json:"durationMs"`
+	Locations   []Location `json:"locations"`
+	OutOfScope  bool       `json:"outOfScope,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Remediation string     `json:"remediation,omitempty"`
+	// Notes are attached by -notes rules the finding matches.
+	Notes []string `json:"notes,omitempty"`
+	// Redactions counts the secrets replaced in the result; see Redactor.
+	Redactions int `json:"redactions,omitempty"`
+	// Raw is the backend's response, kept with -include-raw or an output's
+	// includeRawResponse.
+	Raw        json.RawMessage `json:"raw,omitempty"`
+	FilteredBy string          `json:"filteredBy,omitempty"`
+	// Suppressed findings were acknowledged in .treekoignore; they are
+	// reported with the entry's justification but don't fail the run.
+	Suppressed    bool   `json:"suppressed,omitempty"`
+	Justification string `json:"justification,omitempty"`
+	// RequestID is the X-Request-ID of the backend request and
+	// ServerRequestID the ID the backend answered with, for support tickets.
+	RequestID       string `json:"requestId,omitempty"`
+	ServerRequestID string `json:"serverRequestId,omitempty"`
+	// DuplicateOf is the fingerprint of the finding -dedup-by kept instead.
+	// With -dedup-by semantic, Similarity is how close the two results are.
+	DuplicateOf string  `json:"duplicateOf,omitempty"`
+	Similarity  float64 `json:"similarity,omitempty"`
+	// Variant is the language whose variant of the prompt was asked.
+	Variant string `json:"variant,omitempty"`
+	// Stability is how consistently the prompt answered with -repeat.
+	Stability *Stability `json:"stability,omitempty"`
+	// LowConfidence marks a result scored below -min-confidence.
+	LowConfidence bool `json:"lowConfidence,omitempty"`
+	// ClusterID is the issue cluster the finding belongs to, if any.
+	ClusterID string `json:"clusterId,omitempty"`
+
+	// query is the prompt as sent to the backend, in its variant and with
+	// its scope, for -print-prompt.
+	query string
+	// selection is the field selection of the report view the finding is
+	// part of, if it keeps only some fields.
+	selection *fieldSelection
+}
+
+// fail records err on the finding, classifying it into a status.
+func (f *Finding) fail(err error) {
+	f.Error = err.Error()
+	f.ErrorClass = ErrorClass(err)
+	f.Status = ClassifyError(err)
+}
+
+// CodebaseLabel names the finding's codebase in text output.
+func (f Finding) CodebaseLabel() string {
+	return codebaseLabel(f.Codebase, f.CodebaseAlias)
+}
+
+// HasResult reports whether the prompt succeeded and Greptile returned
+// something, as opposed to an error or an empty answer.
+func (f Finding) HasResult() bool {
+	return f.Error == "" && strings.TrimSpace(f.Result) != ""
+}
+
+// ComputeFingerprint identifies a finding across runs. It hashes the audit
+// and prompt IDs with the set of files the finding points at, so Greptile
+// rephrasing an answer about the same code doesn't change it. Local, plugin
+// and Sourcegraph output is exact, so their line numbers are included too,
+// keeping two matches in one file apart. Findings without locations fall
+// back to the result text with whitespace and case normalized.
+func (f Finding) ComputeFingerprint() string {
+	auditKey, promptKey := f.AuditID, f.PromptID
+	if auditKey == "" {
+		auditKey = f.Audit
+	}
+	if promptKey == "" {
+		promptKey = f.Prompt
+	}
+	var subject string
+	if len(f.Locations) > 0 {
+		exact := f.Source == SourceLocal || f.Source == SourcePlugin || f.Source == BackendSourcegraph
+		seen := make(map[string]bool)
+		var keys []string
+		for _, loc := range f.Locations {
+			key := NormalizePath(loc.Path)
+			if exact && loc.Line > 0 {
+				key += ":" + strconv.Itoa(loc.Line)
+			}
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		subject = "paths\x00" + strings.Join(keys, "\n")
+	} else {
+		subject = "result\x00" + strings.ToLower(strings.Join(strings.Fields(f.Result), " "))
+	}
+	sum := sha256.Sum256([]byte(auditKey + "\x00" + promptKey + "\x00" + subject))
+	return hex.EncodeToString(sum[:8])
+}
+
+// locate extracts the file locations a result mentions, unless they are
+// already known, and fingerprints it.
+func (f *Finding) locate() {
+	if f.Locations == nil {
+		f.Locations = ExtractLocations(f.Result)
+	}
+	if f.Fingerprint == "" && f.HasResult() {
+		f.Fingerprint = f.ComputeFingerprint()
+	}
+}
+
+// RunMetadata identifies the code state and tool configuration a report
+// describes.
+type RunMetadata struct {
+	RunID       string    `json:"runId"`
+	ToolVersion string    `json:"toolVersion"`
+	Codebase    string    `json:"codebase"`
+	ConfigHash  string    `json:"configHash"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Git         GitInfo   `json:"git"`
+	Scope       *RunScope `json:"scope"`
+	// Stopped says why the run ended before its work was done, if it did.
+	Stopped string `json:"stopped,omitempty"`
+	// Sample is set when -sample-rate ran only some of the prompts.
+	Sample *SampleInfo `json:"sample,omitempty"`
+	// RetryBudget is set when -retry-budget capped the run's retries.
+	RetryBudget *RetryBudgetInfo `json:"retryBudget,omitempty"`
+	// Framework is what prompts were tailored to, if anything.
+	Framework *FrameworkInfo `json:"framework,omitempty"`
+}
+
+// Summary counts prompt outcomes.
+type Summary struct {
+	Prompts int           `json:"prompts"`
+	Results int           `json:"results"`
+	Errors  int           `json:"errors"`
+	Latency *LatencyStats `json:"latency"`
+	// Statuses counts findings by status.
+	Statuses map[Status]int `json:"statuses"`
+	// ErrorClasses counts the failed prompts by cause, most frequent first.
+	ErrorClasses []ErrorClassCount `json:"errorClasses,omitempty"`
+	// Filtered counts findings dropped by filter rules.
+	Filtered int `json:"filtered"`
+	// Suppressed counts findings acknowledged in .treekoignore.
+	Suppressed int `json:"suppressed"`
+	// Unstable counts findings whose -repeat runs disagreed.
+	Unstable int `json:"unstable,omitempty"`
+	// LowConfidence counts results scored below MinConfidence, the
+	// -min-confidence threshold.
+	LowConfidence int     `json:"lowConfidence,omitempty"`
+	MinConfidence float64 `json:"minConfidence,omitempty"`
+
+	durations []int64
+}
+
+// ErrorClassCount is how many prompts failed with an error of Class, with
+// the ID of one of them to look up in the report.
+type ErrorClassCount struct {
+	Class        string `json:"class"`
+	Count        int    `json:"count"`
+	SamplePrompt string `json:"samplePrompt"`
+}
+
+// addError counts a failed finding under its error class. Findings from
+// reports that predate classes are counted by their error.
+func (s *Summary) addError(f Finding) {
+	class := f.ErrorClass
+	if class == "" {
+		class = f.Error
+	}
+	for i := range s.ErrorClasses {
+		if s.ErrorClasses[i].Class == class {
+			s.ErrorClasses[i].Count++
+			return
+		}
+	}
+	sample := f.PromptID
+	if sample == "" {
+		sample = f.Prompt
+	}
+	s.ErrorClasses = append(s.ErrorClasses, ErrorClassCount{Class: class, Count: 1, SamplePrompt: sample})
+}
+
+// LatencyStats describes the duration of the requests actually sent to
+// Greptile; cached results are excluded. Percentiles use the nearest-rank
+// method.
+type LatencyStats struct {
+	Count  int   `json:"count"`
+	MinMs  int64 `json:"minMs"`
+	MaxMs  int64 `json:"maxMs"`
+	MeanMs int64 `json:"meanMs"`
+	P50Ms  int64 `json:"p50Ms"`
+	P90Ms  int64 `json:"p90Ms"`
+	P99Ms  int64 `json:"p99Ms"`
+}
+
+// addFiltered counts a finding dropped by a filter. The prompt still ran, so
+// it counts towards Prompts and latency but not Results.
+func (s *Summary) addFiltered(f Finding) {
+	s.Filtered++
+	if f.Source != SourceLocal && f.Source != SourcePlugin {
+		s.Prompts++
+		if !f.Cached && !f.Replayed {
+			s.durations = append(s.durations, f.DurationMs)
+		}
+	}
+}
+
+// addLowConfidence counts a result scored below -min-confidence. Like a
+// filtered one, it counts towards Prompts and latency but not Results.
+func (s *Summary) addLowConfidence(f Finding) {
+	s.LowConfidence++
+	s.Prompts++
+	if !f.Cached && !f.Replayed {
+		s.durations = append(s.durations, f.DurationMs)
+	}
+}
+
+func (s *Summary) add(f Finding) {
+	if s.Statuses == nil {
+		s.Statuses = make(map[Status]int)
+	}
+	s.Statuses[f.Status]++
+	if f.Suppressed {
+		s.Suppressed++
+	}
+	if f.Stability.Unstable() {
+		s.Unstable++
+	}
+	if f.Source == SourceLocal || f.Source == SourcePlugin {
+		if f.Error != "" {
+			s.Errors++
+			s.addError(f)
+		} else {
+			s.Results++
+		}
+		return
+	}
+	s.Prompts++
+	if f.Error != "" {
+		s.Errors++
+		s.addError(f)
+	} else if f.HasResult() {
+		s.Results++
+	}
+	if !f.Cached && !f.Replayed {
+		s.durations = append(s.durations, f.DurationMs)
+	}
+}
+
+// finish computes the latency statistics and orders the error classes once
+// every finding was added. It leaves Latency nil when no request was sent.
+func (s *Summary) finish() {
+	sort.SliceStable(s.ErrorClasses, func(i, j int) bool { return s.ErrorClasses[i].Count > s.ErrorClasses[j].Count })
+	if len(s.durations) == 0 {
+		s.Latency = nil
+		return
+	}
+	sorted := append([]int64(nil), s.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total int64
+	for _, d := range sorted {
+		total += d
+	}
+	s.Latency = &LatencyStats{
+		Count:  len(sorted),
+		MinMs:  sorted[0],
+		MaxMs:  sorted[len(sorted)-1],
+		MeanMs: total / int64(len(sorted)),
+		P50Ms:  percentile(sorted, 50),
+		P90Ms:  percentile(sorted, 90),
+		P99Ms:  percentile(sorted, 99),
+	}
+}
+
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// CodebaseResult is the per-codebase section of a report.
+type CodebaseResult struct {
+	Codebase string  `json:"codebase"`
+	Alias    string  `json:"alias,omitempty"`
+	Branch   string  `json:"branch,omitempty"`
+	Status   string  `json:"status"`
+	Summary  Summary `json:"summary"`
+}
+
+// Codebase statuses, from best to worst.
+const (
+	CodebaseOK      = "ok"
+	CodebasePartial = "partial"
+	CodebaseFailed  = "failed"
+)
+
+type Report struct {
+	SchemaVersion string           `json:"schemaVersion"`
+	Metadata      RunMetadata      `json:"metadata"`
+	Summary       Summary          `json:"summary"`
+	Codebases     []CodebaseResult `json:"codebases"`
+	Skipped       []SkippedAudit   `json:"skipped"`
+	Findings      []Finding        `json:"findings"`
+	// Filtered holds findings dropped by filter rules; it is only written
+	// with -show-filtered.
+	Filtered []Finding `json:"filtered,omitempty"`
+	// LowConfidence holds the results scored below -min-confidence. They
+	// don't count as findings, so they never affect the exit code.
+	LowConfidence []Finding `json:"lowConfidence,omitempty"`
+	// Clean lists the prompts that returned nothing; it is only written with
+	// -show-clean.
+	Clean []CleanPrompt `json:"clean,omitempty"`
+	// Clusters groups findings that point at the same code.
+	Clusters []IssueCluster `json:"clusters,omitempty"`
+	// Suppressions lists the unexpired .treekoignore entries of the run.
+	Suppressions []*Suppression `json:"suppressions,omitempty"`
+	// Policy is the evaluation of -policy, if one was given.
+	Policy *PolicyResult `json:"policy,omitempty"`
+
+	mu sync.Mutex
+	// filters are applied to every finding as it is added, after
+	// severityOverrides.
+	filters           []FilterRule
+	severityOverrides []SeverityOverride
+	// tags, when set, drops results carrying none of them.
+	tags []string
+	// onFinding, when set, is called with every finding after it is added.
+	onFinding func(Finding)
+	// comparison is the -compare-codebases matrix, built once the run is
+	// summarized.
+	comparison *Comparison
+	// whole is the report a view was made of; see reportView.
+	whole *Report
+	// dedupBy is the -dedup-by granularity; "" keeps duplicates.
+	dedupBy string
+	// kept maps the dedup key of each result kept to its fingerprint.
+	kept map[string]string
+	// dedupThreshold is the similarity from which -dedup-by semantic
+	// collapses results without locations; similar holds those kept, by
+	// codebase, and similarFull the codebases that reached the limit.
+	dedupThreshold float64
+	similar        map[string][]similarResult
+	similarFull    map[string]bool
+}
+
+// CleanPrompt is a prompt that ran without error and returned no result,
+// listed so reviewers can tell a clean check from one that didn't run.
+type CleanPrompt struct {
+	Codebase string `json:"codebase"`
+	Audit    string `json:"audit"`
+	AuditID  string `json:"auditId,omitempty"`
+	Prompt   string `json:"prompt"`
+	PromptID string `json:"promptId,omitempty"`
+}
+
+// ListClean fills in Clean from the findings, in report order. A prompt
+// run once per file chunk is clean only if every chunk came back empty.
+func (r *Report) ListClean() {
+	type key struct{ codebase, audit, prompt string }
+	dirty := make(map[key]bool)
+	for _, f := range r.Findings {
+		if f.HasResult() || f.Error != "" {
+			dirty[key{f.Codebase, f.Audit, f.Prompt}] = true
+		}
+	}
+	for _, f := range r.Filtered {
+		dirty[key{f.Codebase, f.Audit, f.Prompt}] = true
+	}
+	r.Clean = []CleanPrompt{}
+	for _, f := range r.Findings {
+		k := key{f.Codebase, f.Audit, f.Prompt}
+		if dirty[k] {
+			continue
+		}
+		dirty[k] = true
+		r.Clean = append(r.Clean, CleanPrompt{Codebase: f.Codebase, Audit: f.Audit, AuditID: f.AuditID, Prompt: f.Prompt, PromptID: f.PromptID})
+	}
+}
+
+func NewReport(metadata RunMetadata) *Report {
+	return &Report{SchemaVersion: ReportSchemaVersion, Metadata: metadata, Skipped: []SkippedAudit{}, Findings: []Finding{}}
+}
+
+// prepare runs the steps Add takes before filtering f: it extracts the
+// locations, redacts secrets, applies the severity overrides and then the
+// notes, and demotes findings outside the scope. Each step sees the output
+// of the one before, so it must run once per finding, in this order.
+func (r *Report) prepare(f *Finding) {
+	f.locate()
+	redactor.Apply(f)
+	ApplySeverityOverrides(r.severityOverrides, f)
+	noteRules.Apply(f)
+	if r.Metadata.Scope != nil && len(f.Locations) > 0 && !r.Metadata.Scope.InScope(f.Locations) {
+		f.OutOfScope = true
+		f.Severity = SeverityInfo
+	}
+}
+
+// Add records a finding, extracting the file locations it mentions,
+// overriding its severity and attaching its notes. In a scoped run,
+// findings that only point outside the changed files are demoted to
+// informational. It returns the finding as recorded, after filters and
+// suppressions. It is safe for concurrent use.
+func (r *Report) Add(f Finding) Finding {
+	r.prepare(&f)
+	rule := ApplyFilters(r.filters, &f)
+	if rule == "" && len(r.tags) > 0 && f.HasResult() && !hasAnyTag(f.Tags, r.tags) {
+		rule = FilteredUntagged
+	}
+	f.LowConfidence = rule == "" && lowConfidence.Below(f)
+	if rule == "" && !f.LowConfidence && r.dedupBy != "" && f.HasResult() {
+		if key := dedupKey(r.dedupBy, f); key != "" {
+			r.mu.Lock()
+			if first, ok := r.kept[key]; ok {
+				rule, f.DuplicateOf = FilteredDuplicate, first
+			} else {
+				if r.kept == nil {
+					r.kept = make(map[string]string)
+				}
+				r.kept[key] = f.Fingerprint
+			}
+			r.mu.Unlock()
+		} else if r.dedupBy == DedupSemantic && len(f.Locations) == 0 {
+			r.mu.Lock()
+			if first, similarity, ok := r.similarTo(f); ok {
+				rule, f.DuplicateOf, f.Similarity = FilteredDuplicate, first, similarity
+			}
+			r.mu.Unlock()
+		}
+	}
+	if rule != "" {
+		f.FilteredBy = rule
+		r.mu.Lock()
+		r.Filtered = append(r.Filtered, f)
+		r.mu.Unlock()
+		return f
+	}
+	if f.LowConfidence {
+		r.mu.Lock()
+		r.LowConfidence = append(r.LowConfidence, f)
+		r.mu.Unlock()
+		return f
+	}
+	r.mu.Lock()
+	applySuppressions(r.Suppressions, &f)
+	r.Findings = append(r.Findings, f)
+	r.mu.Unlock()
+	if r.onFinding != nil {
+		r.onFinding(f)
+	}
+	return f
+}
+
+// NewRunID returns a random RFC 4122 version 4 UUID.
+func NewRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("run-%d", time.Now().UnixNano())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (r *Report) AddSkipped(s SkippedAudit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, s)
+}
+
+// Summarize fills in the overall and per-codebase summaries once every
+// prompt has completed. A codebase whose prompts all failed (for example
+// because it isn't indexed) is "failed"; one with some failures is
+// "partial".
+func (r *Report) Summarize(codebases []CodebaseConfig) {
+	r.Summary = Summary{}
+	r.Codebases = make([]CodebaseResult, len(codebases))
+	for i, cb := range codebases {
+		res := CodebaseResult{Codebase: cb.ID, Alias: cb.Alias, Branch: cb.Branch}
+		for _, f := range r.Findings {
+			if f.Codebase == cb.ID {
+				res.Summary.add(f)
+			}
+		}
+		for _, f := range r.Filtered {
+			if f.Codebase == cb.ID {
+				res.Summary.addFiltered(f)
+			}
+		}
+		for _, f := range r.LowConfidence {
+			if f.Codebase == cb.ID {
+				res.Summary.addLowConfidence(f)
+			}
+		}
+		res.Summary.finish()
+		switch {
+		case res.Summary.Errors == 0:
+			res.Status = CodebaseOK
+		case res.Summary.Errors == res.Summary.Prompts:
+			res.Status = CodebaseFailed
+		default:
+			res.Status = CodebasePartial
+		}
+		r.Codebases[i] = res
+	}
+	for _, f := range r.Findings {
+		r.Summary.add(f)
+	}
+	for _, f := range r.Filtered {
+		r.Summary.addFiltered(f)
+	}
+	for _, f := range r.LowConfidence {
+		r.Summary.addLowConfidence(f)
+	}
+	if lowConfidence != nil {
+		r.Summary.MinConfidence = lowConfidence.Min
+	}
+	r.Summary.finish()
+	sortFindings(r.Findings)
+	sortFindings(r.Filtered)
+	sortFindings(r.LowConfidence)
+	r.Correlate()
+	sort.SliceStable(r.Skipped, func(i, j int) bool {
+		a, b := r.Skipped[i], r.Skipped[j]
+		if a.Codebase != b.Codebase {
+			return a.Codebase < b.Codebase
+		}
+		if a.Audit != b.Audit {
+			return a.Audit < b.Audit
+		}
+		return a.Prompt < b.Prompt
+	})
+}
+
+// ExitCode reflects the worst outcome across all codebases: failed prompts,
+// then a failed policy.
+func (r *Report) ExitCode() int {
+	for _, cb := range r.Codebases {
+		if cb.Status != CodebaseOK {
+			return ExitErrors
+		}
+	}
+	if r.Policy != nil && !r.Policy.Passed {
+		return ExitFindings
+	}
+	return ExitOK
+}
+
+// ConfigHash fingerprints everything that influences the results of a run.
+func ConfigHash(audits []Audit, codebases []CodebaseConfig, plugins []PluginConfig, filters []FilterRule) string {
+	data, _ := json.Marshal(struct {
+		APIUrl        string
+		Codebases     []CodebaseConfig
+		MaxConcurrent int
+		Audits        []Audit
+		Plugins       []PluginConfig
+		Filters       []FilterRule
+	}{GreptileAPIUrl, codebases, MaxConcurrent, audits, plugins, filters})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func WriteJSONReport(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// listFailedPrompts lists every failed prompt with its error under the error
+// classes of the text summary; -debug sets it.
+var listFailedPrompts bool
+
+func WriteTextSummary(w io.Writer, r *Report) {
+	if len(r.Codebases) > 1 {
+		fmt.Fprintln(w, "Codebases:")
+		for _, cb := range r.Codebases {
+			fmt.Fprintf(w, "  %s: %s (%d prompts, %d results, %d errors)\n",
+				codebaseLabel(cb.Codebase, cb.Alias), cb.Status, cb.Summary.Prompts, cb.Summary.Results, cb.Summary.Errors)
+		}
+	}
+	for _, s := range r.Skipped {
+		if s.Prompt != "" {
+			fmt.Fprintf(w, "Skipped: %s prompt '%s' on %s (%s)\n", s.Audit, s.Prompt, s.Codebase, s.Reason)
+		} else {
+			fmt.Fprintf(w, "Skipped: %s audit on %s (%s)\n", s.Audit, s.Codebase, s.Reason)
+		}
+	}
+	fmt.Fprintf(w, "Summary: %d prompts, %d results, %d errors\n", r.Summary.Prompts, r.Summary.Results, r.Summary.Errors)
+	if r.Summary.Errors > 0 {
+		fmt.Fprintf(w, "Statuses: %s\n", formatStatuses(r.Summary.Statuses))
+		for _, c := range r.Summary.ErrorClasses {
+			fmt.Fprintf(w, "  %s: %d prompts, e.g. %s\n", c.Class, c.Count, c.SamplePrompt)
+		}
+		if listFailedPrompts {
+			for _, f := range r.Findings {
+				if f.Error != "" {
+					fmt.Fprintf(w, "  [%s] %s %s: %s: %s\n", f.Status, f.CodebaseLabel(), f.Audit, f.Prompt, f.Error)
+				}
+			}
+		}
+	}
+	if r.Summary.Filtered > 0 {
+		if r.Filtered == nil {
+			fmt.Fprintf(w, "Filtered: %d findings dropped by filter rules (list them with -show-filtered)\n", r.Summary.Filtered)
+		} else {
+			fmt.Fprintf(w, "Filtered: %d findings dropped by filter rules:\n", r.Summary.Filtered)
+			for _, f := range r.Filtered {
+				fmt.Fprintf(w, "  [%s] %s %s: %s\n", f.FilteredBy, f.CodebaseLabel(), f.Audit, f.Prompt)
+			}
+		}
+	}
+	writeSeverityOverrides(w, r)
+	if len(r.Clean) > 0 {
+		fmt.Fprintf(w, "Clean: %d prompts returned no results:\n", len(r.Clean))
+		for _, c := range r.Clean {
+			fmt.Fprintf(w, "  %s %s: %s\n", c.Codebase, c.Audit, c.Prompt)
+		}
+	}
+	writeClusters(w, r)
+	writeUnstable(w, r)
+	writeLowConfidence(w, r)
+	if len(r.Suppressions) > 0 {
+		fmt.Fprintf(w, "Suppressed: %d findings by %d %s entries:\n", r.Summary.Suppressed, len(r.Suppressions), IgnoreFileName)
+		for _, s := range r.Suppressions {
+			expires := ""
+			if s.Expires != "" {
+				expires = ", expires " + s.Expires
+			}
+			fmt.Fprintf(w, "  %s (%d matches%s): %s\n", s.Target, s.Matches, expires, s.Justification)
+		}
+	}
+	if r.Policy != nil {
+		WritePolicyResult(w, r.Policy)
+	}
+	if l := r.Summary.Latency; l != nil {
+		fmt.Fprintf(w, "Latency over %d requests: min %s, mean %s, max %s, p50 %s, p90 %s, p99 %s\n",
+			l.Count, formatDurationMs(l.MinMs), formatDurationMs(l.MeanMs), formatDurationMs(l.MaxMs),
+			formatDurationMs(l.P50Ms), formatDurationMs(l.P90Ms), formatDurationMs(l.P99Ms))
+	}
+}
+
+func formatStatuses(counts map[Status]int) string {
+	var parts []string
+	for _, st := range []Status{StatusOK, StatusError, StatusTimeout, StatusRateLimited, StatusCancelled, StatusBadResponse} {
+		if counts[st] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", st, counts[st]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func WriteTextMetadata(w io.Writer, m RunMetadata) {
+	fmt.Fprintln(w, "Run metadata:")
+	fmt.Fprintf(w, "  Run ID:       %s\n", m.RunID)
+	fmt.Fprintf(w, "  Tool version: %s\n", m.ToolVersion)
+	fmt.Fprintf(w, "  Codebase:     %s\n", m.Codebase)
+	fmt.Fprintf(w, "  Config hash:  %s\n", m.ConfigHash)
+	fmt.Fprintf(w, "  Git commit:   %s\n", stringOrNone(m.Git.Commit))
+	fmt.Fprintf(w, "  Git branch:   %s\n", stringOrNone(m.Git.Branch))
+	if m.Git.Dirty != nil {
+		fmt.Fprintf(w, "  Git dirty:    %t\n", *m.Git.Dirty)
+	} else {
+		fmt.Fprintln(w, "  Git dirty:    none")
+	}
+	fmt.Fprintf(w, "  Started:      %s\n", formatTime(m.StartedAt))
+	fmt.Fprintf(w, "  Finished:     %s (took %s)\n", formatTime(m.FinishedAt), formatDurationMs(m.FinishedAt.Sub(m.StartedAt).Milliseconds()))
+	if m.Stopped != "" {
+		fmt.Fprintf(w, "  Stopped:      %s, results are partial\n", m.Stopped)
+	}
+	if s := m.Sample; s != nil {
+		fmt.Fprintf(w, "  Sampled:      %d prompts, %d sampled out (-sample-rate %g -seed %d)\n", s.Sampled, s.SampledOut, s.Rate, s.Seed)
+	}
+	if b := m.RetryBudget; b != nil {
+		if b.Refused > 0 {
+			fmt.Fprintf(w, "  Retries:      budget of %d spent, %d more refused\n", b.Budget, b.Refused)
+		} else {
+			fmt.Fprintf(w, "  Retries:      %d of a budget of %d\n", b.Used, b.Budget)
+		}
+	}
+	if fw := m.Framework; fw != nil && fw.Name != "" {
+		fmt.Fprintf(w, "  Framework:    %s (from %s)\n", fw.Name, fw.Source)
+	} else if fw != nil && len(fw.Candidates) > 0 {
+		fmt.Fprintf(w, "  Framework:    ambiguous, one of %s (from %s)\n", strings.Join(fw.Candidates, ", "), fw.Source)
+	}
+	if m.Scope != nil {
+		fmt.Fprintf(w, "  Scope:        %d changed files from %s\n", len(m.Scope.Files), m.Scope.Source)
+		for _, f := range m.Scope.Files {
+			fmt.Fprintf(w, "    %s\n", f)
+		}
+	}
+}
+
+func stringOrNone(s *string) string {
+	if s == nil {
+		return "none"
+	}
+	return *s
+}
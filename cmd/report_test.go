@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fixtureReport is the report shared by the tests of report outputs. One of
+// its prompts failed, so its codebase is partial.
+const fixtureReport = "report.json"
+
+// loadFixtureReport loads a report from testdata.
+func loadFixtureReport(t *testing.T, name string) *Report {
+	t.Helper()
+	r, err := LoadReport(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// fixtureAudits are the audits the findings of r were run from.
+func fixtureAudits(r *Report) []Audit {
+	var audits []Audit
+	seen := make(map[string]bool)
+	for _, f := range r.Findings {
+		if !seen[f.AuditID] {
+			seen[f.AuditID] = true
+			audits = append(audits, Audit{ID: f.AuditID, Name: f.Audit})
+		}
+	}
+	return audits
+}
+
+// fingerprintOf fingerprints f the way Report.Add does, extracting the
+// locations its result mentions first.
+func fingerprintOf(f Finding) string {
+	f.locate()
+	return f.Fingerprint
+}
+
+func TestComputeFingerprintGolden(t *testing.T) {
+	tests := []struct {
+		name     string
+		findings []Finding
+		want     string
+	}{
+		{
+			name: "paraphrased answers about the same files",
+			findings: []Finding{
+				{AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "Passwords are compared with == in web/login.py:42, and internal/db/query.go builds the lookup by concatenation."},
+				{AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "The lookup in `internal/db/query.go` concatenates user input.\nSee also ./web/login.py line 88, where the password check isn't constant time."},
+				{AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "web/login.py:10 and web/login.py:90 both compare secrets naively; internal/db/query.go:7 is injectable."},
+			},
+			want: "676674765aeddbce",
+		},
+		{
+			name: "a different set of files",
+			findings: []Finding{
+				{AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "Only web/login.py:42 compares passwords with ==."},
+				{AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "The password check in web/login.py is not constant time."},
+			},
+			want: "9a4b38af12575b77",
+		},
+		{
+			name: "exact source keeps line numbers",
+			findings: []Finding{
+				{AuditID: "secrets", PromptID: "aws-access-key", Source: SourceLocal, Result: "AWS access key ID committed to the repository.", Locations: []Location{{Path: "config/prod.env", Line: 12}}},
+				{AuditID: "secrets", PromptID: "aws-access-key", Source: SourceLocal, Result: "AWS access key ID committed to the repository (reworded).", Locations: []Location{{Path: "./config/prod.env", Line: 12}}},
+			},
+			want: "667b3d5abd52468c",
+		},
+		{
+			name: "exact source on another line",
+			findings: []Finding{
+				{AuditID: "secrets", PromptID: "aws-access-key", Source: SourceLocal, Result: "AWS access key ID committed to the repository.", Locations: []Location{{Path: "config/prod.env", Line: 40}}},
+			},
+			want: "a0497febb1f4edd5",
+		},
+		{
+			name: "no locations falls back to normalized text",
+			findings: []Finding{
+				{AuditID: "auth", PromptID: "auth-2", Source: SourceGreptile, Result: "No authentication issues were found."},
+				{AuditID: "auth", PromptID: "auth-2", Source: SourceGreptile, Result: "  NO authentication\n\tissues were   FOUND. "},
+			},
+			want: "ec866c3c456c2452",
+		},
+		{
+			name: "audit and prompt names without IDs",
+			findings: []Finding{
+				{Audit: "Auth", Prompt: "Are passwords hashed?", Source: SourceGreptile, Result: "No: web/login.py stores them in plain text."},
+				{Audit: "Auth", Prompt: "Are passwords hashed?", Source: SourceGreptile, Result: "Plain-text passwords are written in web/login.py:30."},
+			},
+			want: "6ed0b9131e1fa62d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, f := range tt.findings {
+				if got := fingerprintOf(f); got != tt.want {
+					t.Errorf("fingerprint of %q = %s, want %s", f.Result, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeFingerprintKeepsFindingsApart(t *testing.T) {
+	base := Finding{AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "web/login.py compares passwords with ==."}
+	other := map[string]Finding{
+		"audit":  {AuditID: "crypto", PromptID: "auth-1", Source: SourceGreptile, Result: base.Result},
+		"prompt": {AuditID: "auth", PromptID: "auth-3", Source: SourceGreptile, Result: base.Result},
+		"file":   {AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "web/signup.py compares passwords with ==."},
+		"text":   {AuditID: "auth", PromptID: "auth-1", Source: SourceGreptile, Result: "Passwords are compared with ==."},
+	}
+	want := fingerprintOf(base)
+	for name, f := range other {
+		if got := fingerprintOf(f); got == want {
+			t.Errorf("changing the %s kept fingerprint %s", name, got)
+		}
+	}
+}
+
+func TestFixtureReportIsValid(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", fixtureReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, problems, err := ValidateReport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
+
+func TestAddRecordsTheSameFindingInEveryOutput(t *testing.T) {
+	savedFormat, savedNotes, savedStdout := outputFormat, noteRules, os.Stdout
+	t.Cleanup(func() { outputFormat, noteRules, os.Stdout = savedFormat, savedNotes, savedStdout })
+	notesPath := filepath.Join(t.TempDir(), "notes.yaml")
+	if err := os.WriteFile(notesPath, []byte("notes:\n  - {name: tracked, match: {audit: Authentication}, note: Tracked in SEC-7., severity: high}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if noteRules, err = LoadNoteRules(notesPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	overrides := []SeverityOverride{{Name: "auth-critical", Match: FilterMatch{Audit: "Authentication"}, Severity: SeverityCritical, Mode: OverrideRaise}}
+	if err := CompileSeverityOverrides(overrides, nil); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	os.Stdout = stdout
+
+	record := func(format string) Finding {
+		outputFormat = format
+		r := NewReport(RunMetadata{})
+		r.severityOverrides = overrides
+		f := Finding{Codebase: "acme/payments", Audit: "Authentication", AuditID: "auth", Prompt: "Are passwords hashed?", PromptID: "auth-hash", Severity: SeverityMedium, Source: SourceGreptile, Result: "web/login.py:42 hashes passwords with SHA-1."}
+		printResult(r, f, "")
+		r.Add(f)
+		return r.Findings[0]
+	}
+	text, json := record("text"), record("json")
+	if !reflect.DeepEqual(text, json) {
+		t.Errorf("text mode recorded %+v\nJSON recorded %+v", text, json)
+	}
+	// The override raises the backend's severity, then the note sets its own.
+	if json.Severity != SeverityHigh || json.OriginalSeverity != SeverityMedium || strings.Join(json.SeverityOverrides, " ") != "auth-critical" || strings.Join(json.Notes, " ") != "Tracked in SEC-7." {
+		t.Errorf("recorded severity %s (was %s, overrides %v) with notes %v", json.Severity, json.OriginalSeverity, json.SeverityOverrides, json.Notes)
+	}
+	printed, _ := os.ReadFile(stdout.Name())
+	if strings.Count(string(printed), "Note: Tracked in SEC-7.") != 1 {
+		t.Errorf("text output = %q, want the note once", printed)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy is how often, and how patiently, one kind of failed backend
+// request is retried.
+type RetryPolicy struct {
+	// Retries is the number of retries after the first attempt.
+	Retries int
+	// Backoff is the wait before the first retry; it doubles after each.
+	Backoff time.Duration
+}
+
+// RetryPolicies retry timeouts, server errors and network errors
+// independently: a heavy prompt that timed out is better retried with a
+// longer deadline than at once, while a 503 just needs a moment.
+type RetryPolicies struct {
+	Timeout, Server, Network RetryPolicy
+	// TimeoutGrowth multiplies an attempt's timeout after each attempt that
+	// timed out; 1 keeps it.
+	TimeoutGrowth float64
+}
+
+// UniformRetries retries every kind of failure the same way, with the same
+// timeout for each attempt, as -retries alone does.
+func UniformRetries(retries int, backoff time.Duration) RetryPolicies {
+	p := RetryPolicy{Retries: retries, Backoff: backoff}
+	return RetryPolicies{Timeout: p, Server: p, Network: p, TimeoutGrowth: 1}
+}
+
+// ParseRetryPolicy parses "RETRIES" or "RETRIES:BACKOFF", e.g. "2:5s". The
+// backoff defaults to backoff.
+func ParseRetryPolicy(s string, backoff time.Duration) (RetryPolicy, error) {
+	count, wait, hasWait := strings.Cut(s, ":")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n < 0 {
+		return RetryPolicy{}, fmt.Errorf("invalid retry policy '%s': expected a non-negative number of retries, e.g. 2 or 2:5s", s)
+	}
+	p := RetryPolicy{Retries: n, Backoff: backoff}
+	if hasWait {
+		d, err := time.ParseDuration(strings.TrimSpace(wait))
+		if err != nil || d < 0 {
+			return RetryPolicy{}, fmt.Errorf("invalid retry policy '%s': expected a backoff such as 5s after the colon", s)
+		}
+		p.Backoff = d
+	}
+	return p, nil
+}
+
+// failure is the kind of a failed backend attempt, which picks its retry
+// policy.
+type failure int
+
+const (
+	noFailure failure = iota
+	timeoutFailure
+	serverFailure
+	networkFailure
+)
+
+// classifyAttempt is the kind of failure of an attempt of a request under
+// ctx. An attempt cut short because ctx itself ended isn't a failure worth
+// retrying.
+func classifyAttempt(ctx context.Context, resp *http.Response, err error) failure {
+	if err != nil {
+		var netErr net.Error
+		var timeoutErr *TimeoutError
+		switch {
+		case ctx.Err() != nil || errors.Is(err, context.Canceled):
+			return noFailure
+		case errors.As(err, &timeoutErr) && (timeoutErr.Phase == PhaseConnect || timeoutErr.Phase == PhaseTLSHandshake):
+			return networkFailure
+		case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+			return timeoutFailure
+		default:
+			return networkFailure
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return serverFailure
+	}
+	return noFailure
+}
+
+func (p *RetryPolicies) policy(f failure) RetryPolicy {
+	switch f {
+	case timeoutFailure:
+		return p.Timeout
+	case serverFailure:
+		return p.Server
+	case networkFailure:
+		return p.Network
+	}
+	return RetryPolicy{}
+}
+
+// retryFlags are the retry flags of a run and of the commands sharing
+// treeko query's client.
+type retryFlags struct {
+	retries                  *int
+	timeout, server, network *string
+	timeoutGrowth            *float64
+}
+
+func addRetryFlags(flags *flag.FlagSet) *retryFlags {
+	return &retryFlags{
+		retries:       flags.Int("retries", 0, "Retry backend requests that time out, fail on the network or fail with 429 or 5xx up to this many times; -prompt-timeout-retry, -server-error-retry and -network-retry override it"),
+		timeout:       flags.String("prompt-timeout-retry", "", "Retry backend requests that time out this many times, optionally with their own backoff, e.g. 2 or 2:5s (default: -retries)"),
+		server:        flags.String("server-error-retry", "", "Retry backend requests that fail with 429 or 5xx this many times, optionally with their own backoff, e.g. 3:2s (default: -retries)"),
+		network:       flags.String("network-retry", "", "Retry backend requests that fail on the network this many times, optionally with their own backoff, e.g. 1 (default: -retries)"),
+		timeoutGrowth: flags.Float64("prompt-timeout-growth", 1, "Multiply the timeout of a backend request by this after each attempt that timed out, e.g. 2 to double it"),
+	}
+}
+
+// policies are the retry policies the flags ask for.
+func (f *retryFlags) policies() (RetryPolicies, error) {
+	if *f.retries < 0 {
+		return RetryPolicies{}, fmt.Errorf("-retries must not be negative, got %d", *f.retries)
+	}
+	if *f.timeoutGrowth < 1 {
+		return RetryPolicies{}, fmt.Errorf("-prompt-timeout-growth must be at least 1, got %g", *f.timeoutGrowth)
+	}
+	p := UniformRetries(*f.retries, retryBackoff)
+	p.TimeoutGrowth = *f.timeoutGrowth
+	for _, o := range []struct {
+		name   string
+		value  string
+		policy *RetryPolicy
+	}{{"-prompt-timeout-retry", *f.timeout, &p.Timeout}, {"-server-error-retry", *f.server, &p.Server}, {"-network-retry", *f.network, &p.Network}} {
+		if o.value == "" {
+			continue
+		}
+		policy, err := ParseRetryPolicy(o.value, retryBackoff)
+		if err != nil {
+			return RetryPolicies{}, fmt.Errorf("%s: %v", o.name, err)
+		}
+		*o.policy = policy
+	}
+	return p, nil
+}
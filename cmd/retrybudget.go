@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// RetryBudget caps the retries of every backend request of a run together,
+// for -retry-budget: when the whole backend is down, per-request retries
+// would multiply the calls and the time spent, while isolated blips are
+// still retried until the budget runs out. Failures after that are returned
+// as they are.
+type RetryBudget struct {
+	limit int
+
+	mu      sync.Mutex
+	used    int
+	refused int
+}
+
+// retryBudget is nil when -retry-budget is 0, leaving retries unbounded.
+var retryBudget *RetryBudget
+
+func NewRetryBudget(limit int) *RetryBudget {
+	return &RetryBudget{limit: limit}
+}
+
+// Take spends one retry, or reports that none are left. The first refusal
+// is logged.
+func (b *RetryBudget) Take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used < b.limit {
+		b.used++
+		return true
+	}
+	if b.refused == 0 {
+		log.Printf("Retry budget of %d spent by -retry-budget; failed backend requests are no longer retried.\n", b.limit)
+	}
+	b.refused++
+	return false
+}
+
+// RetryBudgetInfo records how a run spent its -retry-budget: Used retries
+// of Budget, and Refused retries that would have been made without it.
+type RetryBudgetInfo struct {
+	Budget  int `json:"budget"`
+	Used    int `json:"used"`
+	Refused int `json:"refused"`
+}
+
+// Info describes the budget's use so far; nil without a budget.
+func (b *RetryBudget) Info() *RetryBudgetInfo {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &RetryBudgetInfo{Budget: b.limit, Used: b.used, Refused: b.refused}
+}
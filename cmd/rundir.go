@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Standard names of the artifacts in a run directory.
+const (
+	RunDirMetadata = "metadata.json"
+	RunDirReport   = "report.json"
+	RunDirJournal  = "journal.ndjson"
+	// RunDirDebug holds debug.log with -debug and http.log with -dump-http.
+	RunDirDebug = "debug"
+)
+
+// RunDir is the directory a run writes its artifacts to with -out-dir.
+type RunDir struct {
+	Path string
+}
+
+// CreateRunDir creates <parent>/<timestamp>-<run ID> for the run m
+// describes and writes its metadata.json, so even a run that crashes leaves
+// a directory that identifies it. It fails if the directory already exists.
+// With -encrypt-reports every artifact in it is encrypted and named with
+// .age added.
+func CreateRunDir(parent string, m RunMetadata) (*RunDir, error) {
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(parent, m.StartedAt.UTC().Format("20060102T150405Z")+"-"+m.RunID)
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return nil, err
+	}
+	d := &RunDir{Path: path}
+	if err := d.WriteMetadata(m); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// File is the path of the artifact name in the directory.
+func (d *RunDir) File(name string) string {
+	return filepath.Join(d.Path, name)
+}
+
+// WriteMetadata replaces metadata.json. The file is renamed into place, so a
+// reader never sees it half written.
+func (d *RunDir) WriteMetadata(m RunMetadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := reportEncryption.Path(d.File(RunDirMetadata))
+	w, err := reportEncryption.createFile(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// WriteReport writes the JSON report, whatever -output is.
+func (d *RunDir) WriteReport(r *Report) error {
+	f, err := reportEncryption.Create(d.File(RunDirReport))
+	if err != nil {
+		return err
+	}
+	if err := WriteJSONReport(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// templateExt is the extension of the file a report template renders, taken
+// from its name: ".html" for report.html.tmpl, ".txt" if it has none.
+func templateExt(name string) string {
+	for _, suffix := range []string{".tmpl", ".tpl", ".gotmpl"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	if ext := filepath.Ext(name); ext != "" {
+		return ext
+	}
+	return ".txt"
+}
+
+// CreateDebugLog creates a log file in the debug subdirectory.
+func (d *RunDir) CreateDebugLog(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(d.File(RunDirDebug), 0o755); err != nil {
+		return nil, err
+	}
+	return reportEncryption.Create(filepath.Join(d.File(RunDirDebug), name))
+}
+
+// Journal appends each finding to journal.ndjson as it is recorded, so the
+// results of an interrupted run survive it.
+type Journal struct {
+	mu  sync.Mutex
+	f   io.WriteCloser
+	enc *json.Encoder
+	err error
+}
+
+// CreateJournal creates the journal of a run directory.
+func (d *RunDir) CreateJournal() (*Journal, error) {
+	f, err := reportEncryption.Create(d.File(RunDirJournal))
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// journalEntry is a line of the journal: a finding as it appears in the
+// JSON report, stamped with the report's schema version.
+type journalEntry struct {
+	SchemaVersion string `json:"schemaVersion"`
+	Finding
+}
+
+// MarshalJSON writes the schema version ahead of the finding's fields, which
+// the finding encodes itself.
+func (e journalEntry) MarshalJSON() ([]byte, error) {
+	finding, err := json.Marshal(e.Finding)
+	if err != nil {
+		return nil, err
+	}
+	version, err := json.Marshal(e.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	data := append([]byte(`{"schemaVersion":`), version...)
+	if len(finding) > 2 {
+		data = append(data, ',')
+	}
+	return append(data, finding[1:]...), nil
+}
+
+// Write appends f. The first error is kept and reported by Close.
+func (j *Journal) Write(f Finding) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err == nil {
+		j.err = j.enc.Encode(journalEntry{SchemaVersion: ReportSchemaVersion, Finding: f})
+	}
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.f.Close(); j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
+
+func runBundleCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: treeko bundle <run-dir>")
+		return ExitUsage
+	}
+	dir := filepath.Clean(args[0])
+	if _, err := os.Stat(filepath.Join(dir, RunDirMetadata)); err != nil && !fileExists(filepath.Join(dir, RunDirMetadata+AgeExt)) {
+		fmt.Fprintf(os.Stderr, "%s is not a run directory: %v\n", dir, err)
+		return ExitUsage
+	}
+	path := dir + ".zip"
+	if err := BundleRunDir(dir, path); err != nil {
+		os.Remove(path)
+		fmt.Fprintf(os.Stderr, "Error bundling %s: %v\n", dir, err)
+		return 1
+	}
+	fmt.Println(path)
+	return 0
+}
+
+// BundleRunDir zips the run directory dir into path. Entries are named
+// below the directory's own name, so the archive unpacks into one folder.
+func BundleRunDir(dir, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	base := filepath.Base(dir)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(base, rel))
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
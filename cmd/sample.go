@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// SampleInfo records how -sample-rate thinned a run, with the seed that
+// repeats the same selection.
+type SampleInfo struct {
+	Rate float64 `json:"rate"`
+	Seed int64   `json:"seed"`
+	// Sampled counts the prompts run and SampledOut those left out, over
+	// every codebase.
+	Sampled    int `json:"sampled"`
+	SampledOut int `json:"sampledOut"`
+}
+
+// SamplePrompts keeps a random rate of the prompts of audits, at least one,
+// chosen by seed: the same audits, rate and seed always keep the same
+// prompts. Local checks are kept, since they cost nothing. It returns the
+// thinned audits and the number of prompts kept and left out.
+func SamplePrompts(audits []Audit, rate float64, seed int64) ([]Audit, int, int) {
+	type ref struct{ audit, prompt int }
+	var all []ref
+	for i, a := range audits {
+		for j := range a.Prompts {
+			all = append(all, ref{i, j})
+		}
+	}
+	keep := int(math.Round(rate * float64(len(all))))
+	if keep < 1 {
+		keep = 1
+	}
+	if keep >= len(all) {
+		return audits, len(all), 0
+	}
+	rand.New(rand.NewSource(seed)).Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+	kept := all[:keep]
+	// Prompts keep their order within their audit.
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].audit != kept[j].audit {
+			return kept[i].audit < kept[j].audit
+		}
+		return kept[i].prompt < kept[j].prompt
+	})
+	sampled := make([]Audit, len(audits))
+	for i, a := range audits {
+		sampled[i] = a
+		sampled[i].Prompts = nil
+	}
+	for _, r := range kept {
+		sampled[r.audit].Prompts = append(sampled[r.audit].Prompts, audits[r.audit].Prompts[r.prompt])
+	}
+	return sampled, keep, len(all) - keep
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReportSchemaVersion is stamped on every JSON report. Adding optional fields
+// bumps the minor version; removing or changing fields bumps the major
+// version and requires a new schemas/report-vN.json.
+const ReportSchemaVersion = "1.39.0"
+
+//go:embed schemas/*.json
+var schemaFS embed.FS
+
+// reportSchema returns the embedded report schema for a major version.
+func reportSchema(major int) ([]byte, error) {
+	return schemaFS.ReadFile(fmt.Sprintf("schemas/report-v%d.json", major))
+}
+
+func schemaMajor(version string) (int, error) {
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("malformed schema version '%s'", version)
+	}
+	return n, nil
+}
+
+// ValidateReport checks a JSON report against the embedded schema matching
+// its schemaVersion and returns every violation found.
+func ValidateReport(data []byte) (string, []string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("report must be a JSON object")
+	}
+	version, ok := obj["schemaVersion"].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("report has no schemaVersion")
+	}
+	major, err := schemaMajor(version)
+	if err != nil {
+		return version, nil, err
+	}
+	raw, err := reportSchema(major)
+	if err != nil {
+		return version, nil, fmt.Errorf("unknown schema version '%s'", version)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return version, nil, fmt.Errorf("embedded schema v%d is invalid: %v", major, err)
+	}
+	var problems []string
+	validateValue(schema, doc, "$", &problems)
+	return version, problems, nil
+}
+
+// validateValue implements the subset of JSON Schema used by treeko's own
+// schemas: type, enum, pattern, properties, required, additionalProperties
+// and items.
+func validateValue(schema map[string]interface{}, value interface{}, path string, problems *[]string) {
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		*problems = append(*problems, fmt.Sprintf("%s: expected %v, got %s", path, t, jsonType(value)))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*problems = append(*problems, fmt.Sprintf("%s: value %v is not one of %v", path, value, enum))
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, ok := value.(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(s) {
+				*problems = append(*problems, fmt.Sprintf("%s: '%s' does not match %s", path, s, pattern))
+			}
+		}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, ok := v[name]; !ok {
+					*problems = append(*problems, fmt.Sprintf("%s: missing required field '%s'", path, name))
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if sub, ok := props[k].(map[string]interface{}); ok {
+				validateValue(sub, v[k], path+"."+k, problems)
+			} else if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+				*problems = append(*problems, fmt.Sprintf("%s: unexpected field '%s'", path, k))
+			} else if sub, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				validateValue(sub, v[k], path+"."+k, problems)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateValue(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	}
+}
+
+func matchesType(t interface{}, value interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return typeMatches(t, value)
+	case []interface{}:
+		for _, alt := range t {
+			if s, ok := alt.(string); ok && typeMatches(s, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeMatches(t string, value interface{}) bool {
+	actual := jsonType(value)
+	if t == "number" && actual == "integer" {
+		return true
+	}
+	return t == actual
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func runSchemaCommand(args []string) int {
+	if len(args) == 1 && args[0] == "version" {
+		fmt.Println(ReportSchemaVersion)
+		return 0
+	}
+	if len(args) != 1 || args[0] != "report" {
+		fmt.Fprintln(os.Stderr, "Usage: treeko schema report|version")
+		return ExitUsage
+	}
+	major, _ := schemaMajor(ReportSchemaVersion)
+	raw, err := reportSchema(major)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading schema: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(raw)
+	return 0
+}
+
+func runValidateCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: treeko validate report.json")
+		return ExitUsage
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", args[0], err)
+		return 1
+	}
+	version, problems, err := ValidateReport(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], p)
+		}
+		return 1
+	}
+	fmt.Printf("%s: valid (schema version %s)\n", args[0], version)
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxScopeFilesPerPrompt bounds how many paths are appended to a single
+// prompt. Longer change sets are split across several requests.
+const maxScopeFilesPerPrompt = 40
+
+// RunScope restricts a run to a set of changed files.
+type RunScope struct {
+	Source string   `json:"source"`
+	Files  []string `json:"files"`
+}
+
+// LoadChangedFiles resolves -changed-files-from: "git:<range>" runs
+// git diff --name-only in repoRoot, "-" reads one path per line from stdin.
+func LoadChangedFiles(spec, repoRoot string) ([]string, error) {
+	var raw string
+	switch {
+	case spec == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		raw = string(data)
+	case strings.HasPrefix(spec, "git:"):
+		out, ok := runGit(repoRoot, "diff", "--name-only", strings.TrimPrefix(spec, "git:"))
+		if !ok {
+			return nil, fmt.Errorf("git diff --name-only %s failed in %s", strings.TrimPrefix(spec, "git:"), repoRoot)
+		}
+		raw = out
+	default:
+		return nil, fmt.Errorf("unsupported -changed-files-from '%s' (want git:<range> or -)", spec)
+	}
+	var files []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(raw))
+	for scanner.Scan() {
+		path := NormalizePath(strings.TrimSpace(scanner.Text()))
+		if path != "" && !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no changed files found via '%s'", spec)
+	}
+	return files, nil
+}
+
+// chunkFiles splits files into groups of at most maxScopeFilesPerPrompt.
+func chunkFiles(files []string) [][]string {
+	var chunks [][]string
+	for len(files) > maxScopeFilesPerPrompt {
+		chunks = append(chunks, files[:maxScopeFilesPerPrompt])
+		files = files[maxScopeFilesPerPrompt:]
+	}
+	return append(chunks, files)
+}
+
+// scopedPrompt constrains a prompt to the given files.
+func scopedPrompt(text string, files []string) string {
+	if len(files) == 0 {
+		return text
+	}
+	return text + " Only consider the following files: " + strings.Join(files, ", ") + "."
+}
+
+// InScope reports whether any of locs is one of the scoped files.
+func (s *RunScope) InScope(locs []Location) bool {
+	for _, loc := range locs {
+		for _, f := range s.Files {
+			if pathMatches(loc.Path, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
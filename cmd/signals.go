@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchConcurrencySignals lowers the pool's limit by one on SIGUSR1 and raises
+// it by one on SIGUSR2 until ctx is done, so a long run can be throttled
+// without restarting it.
+func watchConcurrencySignals(ctx context.Context, pool *ConcurrencyPool) {
+	// Signals sent in quick succession each count.
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case sig := <-signals:
+				delta := 1
+				if sig == syscall.SIGUSR1 {
+					delta = -1
+				}
+				if old, limit := pool.Resize(delta); limit != old {
+					log.Printf("Concurrency %d -> %d after %v\n", old, limit, sig)
+				} else {
+					log.Printf("Concurrency stays at %d after %v: it is at -concurrency-min or -concurrency-max\n", limit, sig)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
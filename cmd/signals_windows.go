@@ -0,0 +1,7 @@
+package main
+
+import "context"
+
+// watchConcurrencySignals does nothing on Windows, which has no SIGUSR1 or
+// SIGUSR2.
+func watchConcurrencySignals(ctx context.Context, pool *ConcurrencyPool) {}
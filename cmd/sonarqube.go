@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SonarEngineID identifies treeko's rules and issues in SonarQube.
+const SonarEngineID = "treeko"
+
+// sonarReport is SonarQube's Generic Issue Import format (10.3 and later),
+// read by the scanner from sonar.externalIssuesReportPaths.
+type sonarReport struct {
+	Rules  []sonarRule  `json:"rules"`
+	Issues []sonarIssue `json:"issues"`
+}
+
+type sonarRule struct {
+	ID                 string        `json:"id"`
+	Name               string        `json:"name"`
+	Description        string        `json:"description"`
+	EngineID           string        `json:"engineId"`
+	CleanCodeAttribute string        `json:"cleanCodeAttribute"`
+	Type               string        `json:"type"`
+	Severity           string        `json:"severity"`
+	Impacts            []sonarImpact `json:"impacts"`
+}
+
+type sonarImpact struct {
+	SoftwareQuality string `json:"softwareQuality"`
+	Severity        string `json:"severity"`
+}
+
+type sonarIssue struct {
+	RuleID             string          `json:"ruleId"`
+	EffortMinutes      int             `json:"effortMinutes"`
+	PrimaryLocation    sonarLocation   `json:"primaryLocation"`
+	SecondaryLocations []sonarLocation `json:"secondaryLocations,omitempty"`
+}
+
+type sonarLocation struct {
+	Message   string          `json:"message"`
+	FilePath  string          `json:"filePath"`
+	TextRange *sonarTextRange `json:"textRange,omitempty"`
+}
+
+type sonarTextRange struct {
+	StartLine int `json:"startLine"`
+}
+
+// sonarSeverity maps a severity onto the rule's legacy severity and its
+// security impact.
+func sonarSeverity(s Severity) (string, string) {
+	switch s {
+	case SeverityCritical:
+		return "BLOCKER", "HIGH"
+	case SeverityHigh:
+		return "CRITICAL", "HIGH"
+	case SeverityMedium:
+		return "MAJOR", "MEDIUM"
+	case SeverityLow:
+		return "MINOR", "LOW"
+	}
+	return "INFO", "LOW"
+}
+
+// sonarRuleID names the rule a finding raises: the audit ID with the prompt
+// ID, or the local check for local matches.
+func sonarRuleID(f Finding) string {
+	audit := f.AuditID
+	if audit == "" {
+		audit = PromptID(f.Audit)
+	}
+	id := f.PromptID
+	if f.Check != "" {
+		id = f.Check
+	}
+	if id == "" {
+		id = PromptID(f.Prompt)
+	}
+	return audit + "." + id
+}
+
+// sonarLocationOf drops the line of a location past the end of its file,
+// which SonarQube would reject; lines counts the lines of each file read.
+func sonarLocationOf(root, message string, loc Location, lines map[string]int) sonarLocation {
+	l := sonarLocation{Message: message, FilePath: loc.Path}
+	if loc.Line <= 0 {
+		return l
+	}
+	n, ok := lines[loc.Path]
+	if !ok {
+		if data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(loc.Path))); err == nil {
+			n = bytes.Count(data, []byte("\n"))
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				n++
+			}
+		}
+		lines[loc.Path] = n
+	}
+	if loc.Line <= n {
+		l.TextRange = &sonarTextRange{StartLine: loc.Line}
+	}
+	return l
+}
+
+// WriteSonarReport writes the unsuppressed findings of r as external issues.
+// SonarQube only accepts issues on files of the project, so locations are
+// checked under root and findings without one that exists are attached to
+// defaultFile instead of being dropped.
+func WriteSonarReport(w io.Writer, root, defaultFile string, r *Report) error {
+	out := sonarReport{Rules: []sonarRule{}, Issues: []sonarIssue{}}
+	rules := make(map[string]bool)
+	lines := make(map[string]int)
+	for _, f := range r.Findings {
+		if !f.HasResult() || f.Suppressed {
+			continue
+		}
+		id := sonarRuleID(f)
+		if !rules[id] {
+			rules[id] = true
+			legacy, impact := sonarSeverity(f.Severity)
+			out.Rules = append(out.Rules, sonarRule{
+				ID:                 id,
+				Name:               annotationTitle(f),
+				Description:        f.Prompt,
+				EngineID:           SonarEngineID,
+				CleanCodeAttribute: "TRUSTWORTHY",
+				Type:               "VULNERABILITY",
+				Severity:           legacy,
+				Impacts:            []sonarImpact{{SoftwareQuality: "SECURITY", Severity: impact}},
+			})
+		}
+		issue := sonarIssue{RuleID: id}
+		var located []Location
+		for _, loc := range f.Locations {
+			if _, ok := verifiedLocation(root, Finding{Locations: []Location{loc}}); ok {
+				located = append(located, loc)
+			}
+		}
+		if len(located) == 0 {
+			issue.PrimaryLocation = sonarLocation{Message: resultWithNotes(f), FilePath: defaultFile}
+		} else {
+			issue.PrimaryLocation = sonarLocationOf(root, resultWithNotes(f), located[0], lines)
+			for _, loc := range located[1:] {
+				issue.SecondaryLocations = append(issue.SecondaryLocations, sonarLocationOf(root, "Also referenced", loc, lines))
+			}
+		}
+		out.Issues = append(out.Issues, issue)
+	}
+	sort.Slice(out.Rules, func(i, j int) bool { return out.Rules[i].ID < out.Rules[j].ID })
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validateAgainst checks the JSON document data against a schema in
+// testdata, with the validator of treeko's own report schema.
+func validateAgainst(t *testing.T, schemaName string, data []byte) []string {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", schemaName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("output isn't JSON: %v", err)
+	}
+	var problems []string
+	validateValue(schema, doc, "$", &problems)
+	return problems
+}
+
+// writeCheckout creates files of the given number of lines under a new
+// directory.
+func writeCheckout(t *testing.T, files map[string]int) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, lines := range files {
+		file := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(strings.Repeat("line\n", lines)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestWriteSonarReportSchema(t *testing.T) {
+	r := loadFixtureReport(t, fixtureReport)
+	// query.go is shorter than the line the SQL finding points at, and
+	// legacy/db/report.go isn't there at all.
+	root := writeCheckout(t, map[string]int{"README.md": 3, "web/login.py": 50, "internal/db/query.go": 5, "legacy/config/prod.env": 20, "tools/fetch.go": 10})
+	var buf bytes.Buffer
+	if err := WriteSonarReport(&buf, root, "README.md", r); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range validateAgainst(t, "sonar-generic-issues.json", buf.Bytes()) {
+		t.Error(p)
+	}
+
+	var out sonarReport
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	rules := make(map[string]bool)
+	var ids []string
+	for _, rule := range out.Rules {
+		rules[rule.ID] = true
+		ids = append(ids, rule.ID)
+		if rule.EngineID != SonarEngineID {
+			t.Errorf("rule %s has engine %q", rule.ID, rule.EngineID)
+		}
+	}
+	if want := "auth.auth-hash llm.llm-output secrets.aws-access-key sql.sql-concat"; strings.Join(ids, " ") != want {
+		t.Errorf("rules = %s, want %s", strings.Join(ids, " "), want)
+	}
+	// The suppressed TLS finding and the failed prompt raise no issue.
+	if len(out.Issues) != 4 {
+		t.Fatalf("%d issues, want 4", len(out.Issues))
+	}
+	for _, issue := range out.Issues {
+		if !rules[issue.RuleID] {
+			t.Errorf("issue of undeclared rule %s", issue.RuleID)
+		}
+		locs := append([]sonarLocation{issue.PrimaryLocation}, issue.SecondaryLocations...)
+		for _, loc := range locs {
+			if _, err := os.Stat(filepath.Join(root, loc.FilePath)); err != nil {
+				t.Errorf("issue %s is on a file outside the project: %v", issue.RuleID, err)
+			}
+			if loc.TextRange != nil && loc.TextRange.StartLine < 1 {
+				t.Errorf("issue %s starts on line %d", issue.RuleID, loc.TextRange.StartLine)
+			}
+		}
+	}
+	byRule := make(map[string]sonarIssue)
+	for _, issue := range out.Issues {
+		byRule[issue.RuleID] = issue
+	}
+	if got := byRule["auth.auth-hash"].PrimaryLocation; got.FilePath != "web/login.py" || got.TextRange == nil || got.TextRange.StartLine != 42 {
+		t.Errorf("auth issue at %+v, want web/login.py line 42", got)
+	}
+	if got := byRule["sql.sql-concat"]; got.PrimaryLocation.FilePath != "internal/db/query.go" || got.PrimaryLocation.TextRange != nil || len(got.SecondaryLocations) != 0 {
+		t.Errorf("SQL issue = %+v, want query.go without a line past its end and no secondary locations", got)
+	}
+	if got := byRule["llm.llm-output"].PrimaryLocation; got.FilePath != "README.md" || got.TextRange != nil {
+		t.Errorf("unlocated issue at %+v, want README.md", got)
+	}
+}
+
+func TestSonarSchemaRejectsInvalidReports(t *testing.T) {
+	for name, doc := range map[string]string{
+		"no impacts":        `{"rules":[{"id":"a.b","name":"A","engineId":"treeko"}],"issues":[]}`,
+		"unknown severity":  `{"rules":[{"id":"a.b","name":"A","engineId":"treeko","severity":"HIGH","impacts":[]}],"issues":[]}`,
+		"no file":           `{"rules":[],"issues":[{"ruleId":"a.b","primaryLocation":{"message":"m"}}]}`,
+		"absolute file":     `{"rules":[],"issues":[{"ruleId":"a.b","primaryLocation":{"message":"m","filePath":"/etc/passwd"}}]}`,
+		"unknown field":     `{"rules":[],"issues":[{"ruleId":"a.b","severity":"MAJOR","primaryLocation":{"message":"m","filePath":"a.go"}}]}`,
+		"empty message":     `{"rules":[],"issues":[{"ruleId":"a.b","primaryLocation":{"message":" ","filePath":"a.go"}}]}`,
+		"fractional line":   `{"rules":[],"issues":[{"ruleId":"a.b","primaryLocation":{"message":"m","filePath":"a.go","textRange":{"startLine":1.5}}}]}`,
+		"issues not a list": `{"rules":[],"issues":{}}`,
+	} {
+		if problems := validateAgainst(t, "sonar-generic-issues.json", []byte(doc)); len(problems) == 0 {
+			t.Errorf("%s: schema accepted %s", name, doc)
+		}
+	}
+}
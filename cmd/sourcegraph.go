@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// sourcegraphSearch asks for the line matches of a search. lineNumber is
+// zero-based.
+const sourcegraphSearch = `query Search($query: String!) {
+  search(query: $query, version: V3) {
+    results {
+      limitHit
+      results {
+        __typename
+        ... on FileMatch {
+          repository { name }
+          file { path }
+          lineMatches { preview lineNumber }
+        }
+      }
+    }
+  }
+}`
+
+// SourcegraphBackend runs structural searches through a Sourcegraph
+// instance's GraphQL API. Unlike an LLM it locates every match exactly, so
+// it suits the mechanical checks among the prompts.
+type SourcegraphBackend struct {
+	URL    string
+	Client *http.Client
+}
+
+type sourcegraphResponse struct {
+	Data struct {
+		Search struct {
+			Results struct {
+				LimitHit bool `json:"limitHit"`
+				Results  []struct {
+					Typename string `json:"__typename"`
+					File     struct {
+						Path string `json:"path"`
+					} `json:"file"`
+					LineMatches []struct {
+						Preview    string `json:"preview"`
+						LineNumber int    `json:"lineNumber"`
+					} `json:"lineMatches"`
+				} `json:"results"`
+			} `json:"results"`
+		} `json:"search"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (s *SourcegraphBackend) Name() string { return BackendSourcegraph }
+
+// sourcegraphQuery restricts query to the target: its repository, matched by
+// suffix since Sourcegraph names repositories after their host, its revision
+// or branch, and the scoped files.
+func sourcegraphQuery(query string, target Target) string {
+	repo := "repo:" + regexp.QuoteMeta(target.Codebase) + "$"
+	if target.Revision != "" {
+		repo += "@" + target.Revision
+	} else if target.Branch != "" {
+		repo += "@" + target.Branch
+	}
+	filters := []string{repo}
+	if len(target.Files) > 0 {
+		quoted := make([]string, len(target.Files))
+		for i, f := range target.Files {
+			quoted[i] = regexp.QuoteMeta(f)
+		}
+		filters = append(filters, "file:^("+strings.Join(quoted, "|")+")$")
+	}
+	return strings.Join(filters, " ") + " " + query
+}
+
+// Query runs the search and lists each match as "path:line: text", so the
+// result reads like any other and cached results can be located again.
+func (s *SourcegraphBackend) Query(ctx context.Context, query string, target Target) (Answer, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     sourcegraphSearch,
+		"variables": map[string]string{"query": sourcegraphQuery(query, target)},
+	})
+	if err != nil {
+		return Answer{}, fmt.Errorf("marshaling JSON payload: %w", err)
+	}
+	url, err := buildSearchURL(s.URL, nil, ".api", "graphql")
+	if err != nil {
+		return Answer{}, fmt.Errorf("building URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return Answer{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Answer{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Answer{}, fmt.Errorf("reading response: %w", err)
+	}
+	if err := checkJSONBody(BackendSourcegraph, resp, data); err != nil {
+		return Answer{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Answer{}, &APIError{Backend: BackendSourcegraph, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	var search sourcegraphResponse
+	if err := json.Unmarshal(data, &search); err != nil {
+		return Answer{}, fmt.Errorf("parsing JSON response: %w", err)
+	}
+	if len(search.Errors) > 0 {
+		return Answer{}, fmt.Errorf("sourcegraph: %s", search.Errors[0].Message)
+	}
+
+	var lines []string
+	var locs []Location
+	results := search.Data.Search.Results
+	for _, m := range results.Results {
+		if m.Typename != "FileMatch" {
+			continue
+		}
+		for _, lm := range m.LineMatches {
+			loc := Location{Path: m.File.Path, Line: lm.LineNumber + 1}
+			locs = append(locs, loc)
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", loc.Path, loc.Line, strings.TrimSpace(lm.Preview)))
+		}
+	}
+	if results.LimitHit && len(lines) > 0 {
+		lines = append(lines, "(Sourcegraph stopped at its result limit; there may be more matches.)")
+	}
+	return Answer{Result: strings.Join(lines, "\n"), Locations: locs, Raw: data}, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// repeat is how many times each prompt is asked, set with -repeat. Above 1
+// the answers are compared and the finding records their Stability.
+var repeat = 1
+
+// Stability is how consistently a prompt answered across -repeat runs.
+type Stability struct {
+	// Runs counts the answers compared; Failed counts the runs that errored
+	// and were left out.
+	Runs   int `json:"runs"`
+	Failed int `json:"failed,omitempty"`
+	// Agreement is the share of runs whose answer has the reported
+	// fingerprint. Answers with no result count as one more outcome.
+	Agreement float64 `json:"agreement"`
+	// Distinct counts the different outcomes.
+	Distinct int `json:"distinct"`
+}
+
+// Unstable reports whether the runs disagreed.
+func (s *Stability) Unstable() bool {
+	return s != nil && s.Distinct > 1
+}
+
+// sendFunc sends a prompt once.
+type sendFunc func() (Answer, *RequestIDs, error)
+
+// repeatPrompt asks the prompt of f again until it was asked repeat times
+// in all, first being the answer already received. It returns the answer
+// most runs agreed on, the earliest on a tie, with the IDs of its request.
+// Once ctx is cancelled, the runs made so far are compared.
+func repeatPrompt(ctx context.Context, f Finding, first Answer, firstIDs *RequestIDs, send sendFunc) (Answer, *RequestIDs, *Stability) {
+	answers, ids := []Answer{first}, []*RequestIDs{firstIDs}
+	stability := &Stability{}
+	for i := 1; i < repeat && ctx.Err() == nil; i++ {
+		answer, id, err := send()
+		if err != nil {
+			if ctx.Err() == nil {
+				debugf("%s: run %d of prompt '%s' failed: %v", f.Audit, i+1, f.Prompt, err)
+				stability.Failed++
+			}
+			continue
+		}
+		answers, ids = append(answers, answer), append(ids, id)
+	}
+
+	outcomes := make([]string, len(answers))
+	counts := make(map[string]int)
+	for i, answer := range answers {
+		outcomes[i] = answerOutcome(f, answer)
+		counts[outcomes[i]]++
+	}
+	best := 0
+	for i, outcome := range outcomes {
+		if counts[outcome] > counts[outcomes[best]] {
+			best = i
+		}
+	}
+	stability.Runs = len(answers)
+	stability.Agreement = float64(counts[outcomes[best]]) / float64(len(answers))
+	stability.Distinct = len(counts)
+	return answers[best], ids[best], stability
+}
+
+// answerOutcome is the fingerprint answer would give f, or "" if it has no
+// result.
+func answerOutcome(f Finding, answer Answer) string {
+	f.Result, f.Locations, f.Fingerprint = answer.Result, nil, ""
+	if len(answer.Locations) > 0 {
+		f.Locations = answer.Locations
+	}
+	f.locate()
+	return f.Fingerprint
+}
+
+// writeUnstable lists the findings whose -repeat runs disagreed.
+func writeUnstable(w io.Writer, r *Report) {
+	if r.Summary.Unstable == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Unstable: %d prompts answered differently across %d runs:\n", r.Summary.Unstable, repeat)
+	for _, f := range r.Findings {
+		if s := f.Stability; s.Unstable() {
+			fmt.Fprintf(w, "  [%.0f%% agreement, %d outcomes] %s %s: %s\n", s.Agreement*100, s.Distinct, f.CodebaseLabel(), f.Audit, f.Prompt)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// IgnoreFileName is the suppression file read from the root of the audited
+// checkout.
+const IgnoreFileName = ".treekoignore"
+
+var fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Suppression acknowledges findings so they are reported but don't fail the
+// run. Target is either a finding fingerprint or audit.prompt:path-glob,
+// where prompt may be "*" and the glob must match every location of the
+// finding.
+type Suppression struct {
+	Target        string `json:"target"`
+	Expires       string `json:"expires,omitempty"`
+	Justification string `json:"justification"`
+	Matches       int    `json:"matches"`
+
+	audit   string
+	prompt  string
+	paths   []string
+	expired bool
+}
+
+// LoadIgnoreFile parses a suppression file. Each non-blank line that isn't
+// a comment has the form
+//
+//	<fingerprint | audit.prompt:path-glob> [expires: YYYY-MM-DD] <justification>
+//
+// Any line that doesn't parse is an error. Entries whose expiry date has
+// passed relative to now are returned with the rest but marked expired.
+func LoadIgnoreFile(name string, now time.Time) ([]*Suppression, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*Suppression
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s, err := parseSuppression(line, now)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		entries = append(entries, s)
+	}
+	return entries, scanner.Err()
+}
+
+func parseSuppression(line string, now time.Time) (*Suppression, error) {
+	fields := strings.Fields(line)
+	s := &Suppression{Target: fields[0]}
+	rest := fields[1:]
+	if len(rest) > 0 && rest[0] == "expires:" {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("expires: needs a date")
+		}
+		at, err := time.Parse("2006-01-02", rest[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry date '%s', expected YYYY-MM-DD", rest[1])
+		}
+		s.Expires = rest[1]
+		// An entry is effective through the whole of its expiry day.
+		s.expired = !now.Before(at.AddDate(0, 0, 1))
+		rest = rest[2:]
+	}
+	if len(rest) == 0 {
+		return nil, fmt.Errorf("'%s' has no justification", s.Target)
+	}
+	s.Justification = strings.Join(rest, " ")
+
+	if fingerprintPattern.MatchString(s.Target) {
+		return s, nil
+	}
+	i := strings.Index(s.Target, ":")
+	dot := strings.Index(s.Target, ".")
+	if i < 0 || dot < 0 || dot > i {
+		return nil, fmt.Errorf("'%s' is neither a fingerprint nor audit.prompt:path-glob", s.Target)
+	}
+	s.audit, s.prompt = s.Target[:dot], s.Target[dot+1:i]
+	glob := s.Target[i+1:]
+	if s.audit == "" || s.prompt == "" || glob == "" {
+		return nil, fmt.Errorf("'%s' is neither a fingerprint nor audit.prompt:path-glob", s.Target)
+	}
+	if _, err := path.Match(strings.TrimSuffix(glob, "/**"), ""); err != nil {
+		return nil, fmt.Errorf("invalid path glob '%s'", glob)
+	}
+	s.paths = []string{glob}
+	return s, nil
+}
+
+func (s *Suppression) matches(f Finding) bool {
+	if s.audit == "" {
+		return f.Fingerprint == s.Target
+	}
+	if s.audit != f.AuditID || (s.prompt != "*" && s.prompt != f.PromptID) {
+		return false
+	}
+	return allLocationsMatch(s.paths, f.Locations)
+}
+
+// ActiveSuppressions returns the entries that haven't expired.
+func ActiveSuppressions(entries []*Suppression) []*Suppression {
+	var active []*Suppression
+	for _, s := range entries {
+		if !s.expired {
+			active = append(active, s)
+		}
+	}
+	return active
+}
+
+// applySuppressions marks f as suppressed by the first matching entry,
+// counting the match. Callers must hold the report's lock.
+func applySuppressions(entries []*Suppression, f *Finding) {
+	if !f.HasResult() {
+		return
+	}
+	for _, s := range entries {
+		if s.matches(*f) {
+			f.Suppressed = true
+			f.Justification = s.Justification
+			s.Matches++
+			return
+		}
+	}
+}
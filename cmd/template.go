@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the helpers available to -report-template templates, in
+// addition to the text/template builtins.
+var templateFuncs = template.FuncMap{
+	// severities returns every severity, most serious first.
+	"severities": func() []Severity { return Severities },
+	// bySeverity returns the findings sorted most serious first, keeping
+	// report order within a severity.
+	"bySeverity": func(findings []Finding) []Finding {
+		sorted := append([]Finding(nil), findings...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Severity.Rank() < sorted[j].Severity.Rank()
+		})
+		return sorted
+	},
+	// withResults drops findings without a result and suppressed findings.
+	"withResults": func(findings []Finding) []Finding {
+		var out []Finding
+		for _, f := range findings {
+			if f.HasResult() && !f.Suppressed {
+				out = append(out, f)
+			}
+		}
+		return out
+	},
+	// ofSeverity keeps the findings of one severity.
+	"ofSeverity": func(s Severity, findings []Finding) []Finding {
+		var out []Finding
+		for _, f := range findings {
+			if f.Severity == s {
+				out = append(out, f)
+			}
+		}
+		return out
+	},
+	// truncate shortens s to at most n runes, ending it with "…".
+	"truncate": func(n int, s string) string {
+		r := []rune(s)
+		if n <= 0 || len(r) <= n {
+			return s
+		}
+		return string(r[:n-1]) + "…"
+	},
+	"markdown": escapeMarkdown,
+	// date formats t with a Go layout, e.g. {{date "2006-01-02" .Metadata.StartedAt}},
+	// honouring -local-time.
+	"date": func(layout string, t time.Time) string {
+		if localTime {
+			return t.Local().Format(layout)
+		}
+		return t.UTC().Format(layout)
+	},
+	// duration renders milliseconds like the text output does.
+	"duration": formatDurationMs,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"join":     strings.Join,
+	"oneLine":  func(s string) string { return strings.Join(strings.Fields(s), " ") },
+}
+
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`)
+
+// escapeMarkdown escapes the characters Markdown would interpret inline.
+func escapeMarkdown(s string) string {
+	return markdownReplacer.Replace(s)
+}
+
+// LoadReportTemplate parses a template file. The template is named after the
+// file, so parse and execution errors read "template: name.tmpl:LINE: ...".
+func LoadReportTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Funcs(templateFuncs).Parse(string(data))
+}
+
+// WriteTemplateReport executes t against r and writes the output to path.
+// Nothing is written if execution fails.
+func WriteTemplateReport(t *template.Template, path string, r *Report) error {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, r); err != nil {
+		return err
+	}
+	return reportEncryption.WriteFile(path, buf.Bytes())
+}
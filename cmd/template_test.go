@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// checkGolden compares got with testdata/name, or rewrites it with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -update to create it", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("output differs from %s:\n%s", path, got)
+	}
+}
+
+// fullFixtureReport is the fixture report with the sections only some runs
+// have: a failed policy, issue clusters and clean prompts.
+func fullFixtureReport(t *testing.T) *Report {
+	r := loadFixtureReport(t, fixtureReport)
+	r.Policy = &PolicyResult{Passed: false, Rules: []PolicyRuleResult{{Name: "no-critical", Action: PolicyFail, Count: 2, Fired: true, Findings: []string{"1f0e3dad99908345", "8f14e45fceea167a"}}}}
+	r.Clusters = []IssueCluster{{
+		ID:        "c1",
+		Codebase:  "acme/payments",
+		Severity:  SeverityCritical,
+		Findings:  []string{"1f0e3dad99908345", "6f4922f45568161a"},
+		Audits:    []string{"Authentication", "SQL Injection"},
+		Locations: []Location{{Path: "web/login.py", Line: 42}, {Path: "internal/db/query.go"}},
+	}}
+	r.Clean = []CleanPrompt{{Codebase: "acme/payments", Audit: "Cryptography", AuditID: "crypto", Prompt: "Are weak hashes used for signatures?", PromptID: "crypto-hash"}}
+	return r
+}
+
+func TestExampleTemplates(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("..", "examples", "templates", "*.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no example templates found")
+	}
+	reports := map[string]func(*testing.T) *Report{
+		"":      func(t *testing.T) *Report { return loadFixtureReport(t, fixtureReport) },
+		".full": fullFixtureReport,
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		tmpl, err := LoadReportTemplate(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for variant, report := range reports {
+			t.Run(name+variant, func(t *testing.T) {
+				out := filepath.Join(t.TempDir(), "out")
+				if err := WriteTemplateReport(tmpl, out, report(t)); err != nil {
+					t.Fatal(err)
+				}
+				got, err := os.ReadFile(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, filepath.Join("templates", name+variant+".golden"), got)
+			})
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	truncate := templateFuncs["truncate"].(func(int, string) string)
+	for _, tt := range []struct {
+		n        int
+		in, want string
+	}{
+		{5, "short", "short"},
+		{4, "longer", "lon…"},
+		{3, "héllo wörld", "hé…"},
+		{0, "unlimited", "unlimited"},
+	} {
+		if got := truncate(tt.n, tt.in); got != tt.want {
+			t.Errorf("truncate(%d, %q) = %q, want %q", tt.n, tt.in, got, tt.want)
+		}
+	}
+	if got := escapeMarkdown("a_b *c* [d](e) `f` <g> #h |i| \\"); got != `a\_b \*c\* \[d\](e) \`+"`"+`f\`+"`"+` \<g\> \#h \|i\| \\` {
+		t.Errorf("escapeMarkdown = %s", got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "os"
+
+// terminalWidth can't query the terminal on this platform.
+func terminalWidth(f *os.File) int { return 0 }
+
+// terminalSize can't query the terminal on this platform.
+func terminalSize(f *os.File) (width, height int) { return 0, 0 }
@@ -0,0 +1,32 @@
+#!/bin/sh
+# Stands in for age in tests: "encrypts" with rot13 behind a header line and
+# "decrypts" only with the identity AGE-SECRET-KEY-STUB. Every invocation's
+# arguments are appended to $AGE_STUB_LOG. With $AGE_STUB_FAIL set it writes
+# some output, prints $AGE_STUB_FAIL as its error and fails.
+printf '%s\n' "$*" >> "${AGE_STUB_LOG:-/dev/null}"
+if [ -n "$AGE_STUB_FAIL" ]; then
+	printf 'age-stub-v1\npartial'
+	echo "$AGE_STUB_FAIL" >&2
+	exit 1
+fi
+case "$1" in
+-e)
+	echo age-stub-v1
+	tr 'A-Za-z' 'N-ZA-Mn-za-m'
+	;;
+-d)
+	if [ "$2" != -i ] || [ "$(cat "$3")" != AGE-SECRET-KEY-STUB ]; then
+		echo "no identity matched any of the recipients" >&2
+		exit 1
+	fi
+	if [ "$(head -n 1 "$4")" != age-stub-v1 ]; then
+		echo "failed to read header: parsing age header: unexpected intro" >&2
+		exit 1
+	fi
+	tail -n +2 "$4" | tr 'A-Za-z' 'N-ZA-Mn-za-m'
+	;;
+*)
+	echo "unknown flags $*" >&2
+	exit 1
+	;;
+esac
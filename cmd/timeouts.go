@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts bound the phases of each attempt of a backend request
+// separately, so a backend that can't be reached fails fast while a model
+// that takes its time over a long prompt on a healthy connection isn't cut
+// off. Zero disables any of them but Request.
+type Timeouts struct {
+	// Dial bounds opening the connection and TLSHandshake securing it.
+	Dial, TLSHandshake time.Duration
+	// ResponseHeader bounds the wait for the response once the request is
+	// written.
+	ResponseHeader time.Duration
+	// Request bounds the whole attempt, body included, unless the prompt
+	// sets its own timeout.
+	Request time.Duration
+	// ReadIdle bounds how long the response body may go without sending
+	// anything.
+	ReadIdle time.Duration
+}
+
+// DefaultTimeouts are used unless flags say otherwise.
+var DefaultTimeouts = Timeouts{
+	Dial:         5 * time.Second,
+	TLSHandshake: 10 * time.Second,
+	Request:      10 * time.Second,
+	ReadIdle:     30 * time.Second,
+}
+
+// The phases of an attempt a TimeoutError can end in.
+const (
+	PhaseConnect        = "connect"
+	PhaseTLSHandshake   = "TLS handshake"
+	PhaseResponseHeader = "response header"
+	PhaseResponseBody   = "response body"
+	PhaseRequest        = "request"
+)
+
+// TimeoutError is an attempt that ran out of the timeout of one of its
+// phases. It is a net.Error whose Timeout is true, so it counts as a
+// timeout wherever one is expected. Connect and TLS handshake timeouts are
+// retried as network failures: a longer deadline won't help reach a host.
+type TimeoutError struct {
+	Phase string
+	Host  string
+	// Limit is the timeout that ran out.
+	Limit time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	switch e.Phase {
+	case PhaseConnect:
+		return fmt.Sprintf("connecting to %s timed out after %s", e.Host, e.Limit)
+	case PhaseTLSHandshake:
+		return fmt.Sprintf("TLS handshake with %s timed out after %s", e.Host, e.Limit)
+	case PhaseResponseHeader:
+		return fmt.Sprintf("%s sent no response within %s", e.Host, e.Limit)
+	case PhaseResponseBody:
+		return fmt.Sprintf("response from %s stalled for %s", e.Host, e.Limit)
+	}
+	return fmt.Sprintf("request to %s timed out after %s", e.Host, e.Limit)
+}
+
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+
+// class is what ErrorClass groups the error under.
+func (e *TimeoutError) class() string {
+	switch e.Phase {
+	case PhaseConnect:
+		return "connecting to " + e.Host + " timed out"
+	case PhaseTLSHandshake:
+		return "TLS handshake with " + e.Host + " timed out"
+	case PhaseResponseHeader:
+		return e.Host + " sent no response in time"
+	case PhaseResponseBody:
+		return "response from " + e.Host + " stalled"
+	}
+	return "request to " + e.Host + " timed out"
+}
+
+// newHTTPTransport is the outbound transport with the connect, TLS
+// handshake and response header timeouts of t.
+func newHTTPTransport(t Timeouts) *http.Transport {
+	tr := newOutboundTransport()
+	tr.DialContext = dialContext(t.Dial)
+	tr.TLSHandshakeTimeout = t.TLSHandshake
+	tr.ResponseHeaderTimeout = t.ResponseHeader
+	return tr
+}
+
+// timeoutTransport turns the timeouts of the transport below it into
+// TimeoutErrors naming the phase that ran out, which it follows with an
+// httptrace, and cancels a response whose body goes quiet for longer than
+// readIdle.
+type timeoutTransport struct {
+	next     http.RoundTripper
+	timeouts Timeouts
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var mu sync.Mutex
+	phase := PhaseResponseHeader
+	setPhase := func(p string) {
+		mu.Lock()
+		phase = p
+		mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		// The dial timeout covers resolving the host too.
+		DNSStart:          func(httptrace.DNSStartInfo) { setPhase(PhaseConnect) },
+		ConnectStart:      func(string, string) { setPhase(PhaseConnect) },
+		TLSHandshakeStart: func() { setPhase(PhaseTLSHandshake) },
+		WroteRequest:      func(httptrace.WroteRequestInfo) { setPhase(PhaseResponseHeader) },
+	}
+	ctx, cancel := context.WithCancel(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && req.Context().Err() == nil {
+			mu.Lock()
+			timeoutErr := &TimeoutError{Phase: phase, Host: req.URL.Host}
+			mu.Unlock()
+			switch timeoutErr.Phase {
+			case PhaseConnect:
+				timeoutErr.Limit = t.timeouts.Dial
+			case PhaseTLSHandshake:
+				timeoutErr.Limit = t.timeouts.TLSHandshake
+			default:
+				timeoutErr.Limit = t.timeouts.ResponseHeader
+			}
+			return nil, timeoutErr
+		}
+		return nil, err
+	}
+	body := &idleBody{ReadCloser: resp.Body, cancel: cancel, host: req.URL.Host, idle: t.timeouts.ReadIdle}
+	if body.idle > 0 {
+		body.timer = time.AfterFunc(body.idle, body.stall)
+		body.timer.Stop()
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// idleBody fails a read that gets nothing for idle by cancelling the
+// request underneath it.
+type idleBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	host    string
+	idle    time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+func (b *idleBody) stall() {
+	atomic.StoreInt32(&b.stalled, 1)
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		return b.ReadCloser.Read(p)
+	}
+	b.timer.Reset(b.idle)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && atomic.LoadInt32(&b.stalled) == 1 {
+		err = &TimeoutError{Phase: PhaseResponseBody, Host: b.host, Limit: b.idle}
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// timeoutFlags are the timeout flags of a run and of the commands sharing
+// treeko query's client.
+type timeoutFlags struct {
+	dial, tlsHandshake, responseHeader, request, readIdle *time.Duration
+}
+
+func addTimeoutFlags(flags *flag.FlagSet) *timeoutFlags {
+	d := DefaultTimeouts
+	return &timeoutFlags{
+		dial:           flags.Duration("dial-timeout", d.Dial, "Give up connecting to a backend after this long (0 for no limit)"),
+		tlsHandshake:   flags.Duration("tls-timeout", d.TLSHandshake, "Give up the TLS handshake with a backend after this long (0 for no limit)"),
+		responseHeader: flags.Duration("response-header-timeout", d.ResponseHeader, "Give up on a backend that hasn't started responding this long after a request was sent (default: -request-timeout bounds it)"),
+		request:        flags.Duration("request-timeout", d.Request, "Give up each attempt of a backend request after this long, unless its prompt sets a timeout"),
+		readIdle:       flags.Duration("read-idle-timeout", d.ReadIdle, "Give up on a backend response whose body sends nothing for this long (0 for no limit)"),
+	}
+}
+
+// timeouts are the timeouts the flags ask for.
+func (f *timeoutFlags) timeouts() (Timeouts, error) {
+	t := Timeouts{Dial: *f.dial, TLSHandshake: *f.tlsHandshake, ResponseHeader: *f.responseHeader, Request: *f.request, ReadIdle: *f.readIdle}
+	for _, o := range []struct {
+		name  string
+		value time.Duration
+	}{{"-dial-timeout", t.Dial}, {"-tls-timeout", t.TLSHandshake}, {"-response-header-timeout", t.ResponseHeader}, {"-read-idle-timeout", t.ReadIdle}} {
+		if o.value < 0 {
+			return Timeouts{}, fmt.Errorf("%s must not be negative, got %s", o.name, o.value)
+		}
+	}
+	if t.Request <= 0 {
+		return Timeouts{}, fmt.Errorf("-request-timeout must be positive, got %s", t.Request)
+	}
+	return t, nil
+}
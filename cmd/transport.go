@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type attemptTimeoutKey struct{}
+
+// withAttemptTimeout returns a context whose backend requests have d rather
+// than the client's request timeout for each attempt.
+func withAttemptTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, attemptTimeoutKey{}, d)
+}
+
+// retryBackoff is the wait before the first retry of a backend request.
+var retryBackoff = time.Second
+
+// Backend clients are assembled from these RoundTrippers, outermost first:
+//
+//	auth -> correlation -> retry and rate limit -> metrics -> dump -> compression -> timeouts -> transport
+//
+// Every attempt, retries included, waits for the rate limiter before it is
+// sent, so retrying a 429 never outpaces -rate; the wait doesn't count
+// against the attempt's timeout. Retries keep the request's ID. Metrics and
+// dumps see every attempt, and dumps show the headers actually sent with the
+// credentials redacted, apart from the compression headers, so that the
+// bodies they show are readable.
+type clientOptions struct {
+	authHeader, authValue string
+	runID                 string
+	limiter               *rate.Limiter
+	retry                 RetryPolicies
+	metrics               *HTTPMetrics
+	dump                  *log.Logger
+	compressRequests      bool
+	timeouts              Timeouts
+	transport             http.RoundTripper
+}
+
+// ClientOption enables one layer of a backend client.
+type ClientOption func(*clientOptions)
+
+// WithAuth sets header to value on every request, e.g. "Authorization" to
+// "Bearer KEY". An empty value sends no header.
+func WithAuth(header, value string) ClientOption {
+	return func(o *clientOptions) { o.authHeader, o.authValue = header, value }
+}
+
+// WithCorrelation sends runID as X-Run-ID and a fresh X-Request-ID with
+// every request, and records the ID the server echoes back in the request's
+// RequestIDs; see withRequestIDs.
+func WithCorrelation(runID string) ClientOption {
+	return func(o *clientOptions) { o.runID = runID }
+}
+
+// WithRateLimit makes requests wait for l; nil disables it.
+func WithRateLimit(l *rate.Limiter) ClientOption {
+	return func(o *clientOptions) { o.limiter = l }
+}
+
+// WithRetry retries a request that times out, fails on the network or fails
+// with 429 or a 5xx according to the policy for that kind of failure.
+func WithRetry(p RetryPolicies) ClientOption {
+	return func(o *clientOptions) { o.retry = p }
+}
+
+// WithMetrics counts every attempt in m.
+func WithMetrics(m *HTTPMetrics) ClientOption {
+	return func(o *clientOptions) { o.metrics = m }
+}
+
+// WithDump logs every request and response to l; nil disables it.
+func WithDump(l *log.Logger) ClientOption {
+	return func(o *clientOptions) { o.dump = l }
+}
+
+// WithRequestCompression gzips request bodies, for backends that accept
+// Content-Encoding: gzip. Responses are always asked for gzipped.
+func WithRequestCompression(on bool) ClientOption {
+	return func(o *clientOptions) { o.compressRequests = on }
+}
+
+// WithTimeouts replaces DefaultTimeouts.
+func WithTimeouts(t Timeouts) ClientOption {
+	return func(o *clientOptions) { o.timeouts = t }
+}
+
+// WithTransport replaces the transport at the bottom of the chain, which is
+// otherwise http.DefaultTransport with the client's connect, TLS handshake
+// and response header timeouts. Those three don't apply to t.
+func WithTransport(t http.RoundTripper) ClientOption {
+	return func(o *clientOptions) { o.transport = t }
+}
+
+// NewBackendClient assembles a client from opts. Each attempt is bounded by
+// the request timeout rather than the whole request, so retries get their
+// own.
+func NewBackendClient(opts ...ClientOption) *http.Client {
+	o := clientOptions{retry: UniformRetries(0, 0), timeouts: DefaultTimeouts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.transport == nil {
+		o.transport = newHTTPTransport(o.timeouts)
+	}
+	var rt http.RoundTripper = &timeoutTransport{next: o.transport, timeouts: o.timeouts}
+	rt = &compressTransport{next: rt, compressRequests: o.compressRequests}
+	if o.dump != nil {
+		rt = &dumpTransport{next: rt, log: o.dump}
+	}
+	if o.metrics != nil {
+		rt = &metricsTransport{next: rt, metrics: o.metrics}
+	}
+	rt = &retryTransport{next: rt, policies: o.retry, timeout: o.timeouts.Request, limiter: o.limiter}
+	if o.runID != "" {
+		rt = &correlationTransport{next: rt, runID: o.runID}
+	}
+	if o.authValue != "" {
+		rt = &authTransport{next: rt, header: o.authHeader, value: o.authValue}
+	}
+	return &http.Client{Transport: rt}
+}
+
+type authTransport struct {
+	next          http.RoundTripper
+	header, value string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set(t.header, t.value)
+	return t.next.RoundTrip(req)
+}
+
+// RequestIDs identify a backend request to the backend's operators: Sent is
+// the X-Request-ID treeko chose and Echoed the ID the server answered with,
+// if any.
+type RequestIDs struct {
+	Sent, Echoed string
+}
+
+// String describes the IDs for a log line, e.g. "request <uuid>, server
+// request abc"; it is empty if the request was never sent.
+func (ids *RequestIDs) String() string {
+	switch {
+	case ids.Sent == "":
+		return ""
+	case ids.Echoed == "":
+		return "request " + ids.Sent
+	default:
+		return "request " + ids.Sent + ", server request " + ids.Echoed
+	}
+}
+
+type requestIDsKey struct{}
+
+// withRequestIDs returns a context whose backend request records its IDs
+// in the returned RequestIDs once the request is sent.
+func withRequestIDs(ctx context.Context) (context.Context, *RequestIDs) {
+	ids := &RequestIDs{}
+	return context.WithValue(ctx, requestIDsKey{}, ids), ids
+}
+
+// echoedRequestIDHeaders are where servers return their own request ID.
+var echoedRequestIDHeaders = []string{"X-Request-ID", "Request-Id", "X-Amzn-Requestid"}
+
+type correlationTransport struct {
+	next  http.RoundTripper
+	runID string
+}
+
+func (t *correlationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ids, _ := req.Context().Value(requestIDsKey{}).(*RequestIDs)
+	if ids == nil {
+		ids = &RequestIDs{}
+	}
+	// Request IDs are random UUIDs, like run IDs.
+	ids.Sent = NewRunID()
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Run-ID", t.runID)
+	req.Header.Set("X-Request-ID", ids.Sent)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range echoedRequestIDHeaders {
+		if id := resp.Header.Get(h); id != "" {
+			ids.Echoed = id
+			break
+		}
+	}
+	return resp, nil
+}
+
+type retryTransport struct {
+	next     http.RoundTripper
+	policies RetryPolicies
+	timeout  time.Duration
+	// limiter, if set, admits each attempt.
+	limiter *rate.Limiter
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A body that can't be read again can't be resent.
+	canRetry := req.Body == nil || req.GetBody != nil
+	timeout := t.timeout
+	if d, ok := req.Context().Value(attemptTimeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+	// Each kind of failure uses up its own retries.
+	retried := map[failure]int{}
+	for attempt := 1; ; attempt++ {
+		if t.limiter != nil {
+			if err := t.limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.attempt(req, timeout)
+		kind := classifyAttempt(req.Context(), resp, err)
+		policy := t.policies.policy(kind)
+		if !canRetry || kind == noFailure || retried[kind] >= policy.Retries || !retryBudget.Take() {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		wait := policy.Backoff << retried[kind]
+		retried[kind]++
+		if kind == timeoutFailure && t.policies.TimeoutGrowth > 1 {
+			timeout = time.Duration(float64(timeout) * t.policies.TimeoutGrowth)
+		}
+		debugf("%s %s (request %s): attempt %d %s; retrying in %s with a %s timeout", req.Method, req.URL.Redacted(), req.Header.Get("X-Request-ID"), attempt, describeFailure(kind, resp), wait, timeout)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// describeFailure completes "attempt N ..." in debug logs.
+func describeFailure(kind failure, resp *http.Response) string {
+	switch kind {
+	case timeoutFailure:
+		return "timed out"
+	case serverFailure:
+		return "failed with " + resp.Status
+	}
+	return "failed on the network"
+}
+
+// attempt sends req once under timeout, which lasts until the response body
+// is closed. Running out of it is a TimeoutError of the request phase.
+func (t *retryTransport) attempt(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, requestTimedOut(req, ctx, timeout, err)
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel, req: req, ctx: ctx, timeout: timeout}
+	return resp, nil
+}
+
+// requestTimedOut is err, or a TimeoutError if the attempt's own deadline
+// ended it rather than the request's context.
+func requestTimedOut(req *http.Request, attempt context.Context, timeout time.Duration, err error) error {
+	if err != nil && attempt.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+		var timeoutErr *TimeoutError
+		if !errors.As(err, &timeoutErr) {
+			return &TimeoutError{Phase: PhaseRequest, Host: req.URL.Host, Limit: timeout}
+		}
+	}
+	return err
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	req     *http.Request
+	ctx     context.Context
+	timeout time.Duration
+}
+
+func (c *cancelOnClose) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, requestTimedOut(c.req, c.ctx, c.timeout, err)
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// HTTPMetrics counts the attempts of backend requests by outcome.
+type HTTPMetrics struct {
+	mu       sync.Mutex
+	attempts int
+	errors   int
+	statuses map[int]int
+	timeouts map[string]int
+}
+
+// Timeouts counts the attempts that timed out by the phase they timed out
+// in, including response bodies that stalled.
+func (m *HTTPMetrics) Timeouts() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	timeouts := make(map[string]int, len(m.timeouts))
+	for phase, n := range m.timeouts {
+		timeouts[phase] = n
+	}
+	return timeouts
+}
+
+// recordTimeout counts err if it is a timeout. The retry layer above only
+// names running out of the attempt's deadline, which reaches metrics as
+// context.DeadlineExceeded, once it is past.
+func (m *HTTPMetrics) recordTimeout(err error) {
+	var timeoutErr *TimeoutError
+	phase := PhaseRequest
+	if errors.As(err, &timeoutErr) {
+		phase = timeoutErr.Phase
+	} else if !errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	m.mu.Lock()
+	if m.timeouts == nil {
+		m.timeouts = make(map[string]int)
+	}
+	m.timeouts[phase]++
+	m.mu.Unlock()
+}
+
+// Snapshot returns the number of attempts, of those that failed without a
+// response, and the responses by status code.
+func (m *HTTPMetrics) Snapshot() (int, int, map[int]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make(map[int]int, len(m.statuses))
+	for code, n := range m.statuses {
+		statuses[code] = n
+	}
+	return m.attempts, m.errors, statuses
+}
+
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics *HTTPMetrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	m := t.metrics
+	m.mu.Lock()
+	m.attempts++
+	if err != nil {
+		m.errors++
+	} else {
+		if m.statuses == nil {
+			m.statuses = make(map[int]int)
+		}
+		m.statuses[resp.StatusCode]++
+	}
+	m.mu.Unlock()
+	if err != nil {
+		m.recordTimeout(err)
+		return nil, err
+	}
+	resp.Body = &metricsBody{ReadCloser: resp.Body, metrics: m}
+	return resp, nil
+}
+
+// metricsBody records a response body that stalled.
+type metricsBody struct {
+	io.ReadCloser
+	metrics  *HTTPMetrics
+	recorded bool
+}
+
+func (b *metricsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && !b.recorded {
+		b.recorded = true
+		b.metrics.recordTimeout(err)
+	}
+	return n, err
+}
+
+// redactedHeaders never appear in dumps.
+var redactedHeaders = []string{"Authorization", "X-Github-Token"}
+
+type dumpTransport struct {
+	next http.RoundTripper
+	log  *log.Logger
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	shown := req.Clone(req.Context())
+	for _, h := range redactedHeaders {
+		if shown.Header.Get(h) != "" {
+			shown.Header.Set(h, "REDACTED")
+		}
+	}
+	withBody := req.GetBody != nil
+	if withBody {
+		shown.Body, _ = req.GetBody()
+	}
+	if data, err := httputil.DumpRequestOut(shown, withBody); err == nil {
+		t.log.Printf("request:\n%s", strings.TrimSpace(string(data)))
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.log.Printf("%s %s: %v", req.Method, req.URL.Redacted(), err)
+		return nil, err
+	}
+	if data, err := httputil.DumpResponse(resp, true); err == nil {
+		t.log.Printf("response:\n%s", strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
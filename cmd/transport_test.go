@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// fakeTransport stands in for the network at the bottom of a client: it
+// records each request it is sent and answers with respond, called with the
+// number of the attempt from 1.
+type fakeTransport struct {
+	respond func(n int, req *http.Request) (*http.Response, error)
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		req.Body.Close()
+		body = string(data)
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.bodies = append(f.bodies, body)
+	n := len(f.requests)
+	f.mu.Unlock()
+	if f.respond == nil {
+		return fakeResponse(req, http.StatusOK, "{}"), nil
+	}
+	return f.respond(n, req)
+}
+
+func (f *fakeTransport) attempts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+func fakeResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// statuses answers with each status in turn, then with the last.
+func statuses(codes ...int) func(int, *http.Request) (*http.Response, error) {
+	return func(n int, req *http.Request) (*http.Response, error) {
+		if n > len(codes) {
+			n = len(codes)
+		}
+		return fakeResponse(req, codes[n-1], "{}"), nil
+	}
+}
+
+func newPost(t *testing.T, ctx context.Context, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.example.com/query", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestAuthTransport(t *testing.T) {
+	base := &fakeTransport{}
+	rt := &authTransport{next: base, header: "Authorization", value: "Bearer secret"}
+	req := newPost(t, context.Background(), "{}")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := base.requests[0].Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer secret")
+	}
+	if got := req.Header.Get("Authorization"); got != "" {
+		t.Errorf("caller's request was given Authorization %q", got)
+	}
+}
+
+func TestCorrelationTransport(t *testing.T) {
+	base := &fakeTransport{respond: func(n int, req *http.Request) (*http.Response, error) {
+		resp := fakeResponse(req, http.StatusOK, "{}")
+		if n == 2 {
+			resp.Header.Set("Request-Id", "srv-42")
+		}
+		return resp, nil
+	}}
+	rt := &correlationTransport{next: base, runID: "run-1"}
+
+	var sent []string
+	for i, wantEchoed := range []string{"", "srv-42"} {
+		ctx, ids := withRequestIDs(context.Background())
+		resp, err := rt.RoundTrip(newPost(t, ctx, "{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		got := base.requests[i].Header
+		if got.Get("X-Run-ID") != "run-1" {
+			t.Errorf("X-Run-ID = %q, want run-1", got.Get("X-Run-ID"))
+		}
+		if ids.Sent == "" || got.Get("X-Request-ID") != ids.Sent {
+			t.Errorf("X-Request-ID = %q, recorded as %q", got.Get("X-Request-ID"), ids.Sent)
+		}
+		if ids.Echoed != wantEchoed {
+			t.Errorf("echoed request ID = %q, want %q", ids.Echoed, wantEchoed)
+		}
+		sent = append(sent, ids.Sent)
+	}
+	if sent[0] == sent[1] {
+		t.Errorf("both requests were sent with ID %s", sent[0])
+	}
+}
+
+func TestRetryTransportRateLimit(t *testing.T) {
+	base := &fakeTransport{}
+	rt := &retryTransport{next: base, policies: UniformRetries(0, 0), timeout: time.Minute, limiter: rate.NewLimiter(rate.Every(time.Hour), 1)}
+	resp, err := rt.RoundTrip(newPost(t, context.Background(), "{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The burst is spent, so the next request would wait an hour.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := rt.RoundTrip(newPost(t, ctx, "{}")); err == nil {
+		t.Error("second request wasn't held back by the limiter")
+	}
+	if n := base.attempts(); n != 1 {
+		t.Errorf("base transport was sent %d requests, want 1", n)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	networkThenOK := func(n int, req *http.Request) (*http.Response, error) {
+		if n == 1 {
+			return nil, errors.New("connection reset by peer")
+		}
+		return fakeResponse(req, http.StatusOK, "{}"), nil
+	}
+	tests := []struct {
+		name     string
+		respond  func(int, *http.Request) (*http.Response, error)
+		retries  int
+		attempts int
+		status   int
+	}{
+		{"server errors retried", statuses(503, 429, 200), 2, 3, 200},
+		{"retries run out", statuses(503), 1, 2, 503},
+		{"client errors not retried", statuses(400), 3, 1, 400},
+		{"network errors retried", networkThenOK, 1, 2, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &fakeTransport{respond: tt.respond}
+			rt := &retryTransport{next: base, policies: UniformRetries(tt.retries, time.Millisecond), timeout: time.Minute}
+			resp, err := rt.RoundTrip(newPost(t, context.Background(), `{"q":1}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if base.attempts() != tt.attempts {
+				t.Errorf("%d attempts, want %d", base.attempts(), tt.attempts)
+			}
+			for i, body := range base.bodies {
+				if body != `{"q":1}` {
+					t.Errorf("attempt %d sent body %q", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportUnreplayableBody(t *testing.T) {
+	base := &fakeTransport{respond: statuses(503, 200)}
+	rt := &retryTransport{next: base, policies: UniformRetries(2, time.Millisecond), timeout: time.Minute}
+	req := newPost(t, context.Background(), "{}")
+	req.GetBody = nil
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 || base.attempts() != 1 {
+		t.Errorf("got %d after %d attempts, want the 503 of the only attempt", resp.StatusCode, base.attempts())
+	}
+}
+
+func TestRetryTransportAttemptTimeout(t *testing.T) {
+	hang := func(n int, req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	base := &fakeTransport{respond: hang}
+	rt := &retryTransport{next: base, policies: UniformRetries(1, time.Millisecond), timeout: time.Hour}
+	ctx := withAttemptTimeout(context.Background(), 20*time.Millisecond)
+	start := time.Now()
+	_, err := rt.RoundTrip(newPost(t, ctx, "{}"))
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != PhaseRequest || timeoutErr.Limit != 20*time.Millisecond {
+		t.Errorf("err = %v, want a request TimeoutError after 20ms", err)
+	}
+	if base.attempts() != 2 {
+		t.Errorf("%d attempts, want the timeout retried once", base.attempts())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v; the prompt's attempt timeout wasn't used", elapsed)
+	}
+}
+
+func TestRetryTransportCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	base := &fakeTransport{respond: func(n int, req *http.Request) (*http.Response, error) {
+		cancel()
+		return fakeResponse(req, http.StatusServiceUnavailable, "{}"), nil
+	}}
+	rt := &retryTransport{next: base, policies: UniformRetries(3, time.Hour), timeout: time.Minute}
+	done := make(chan error, 1)
+	go func() {
+		_, err := rt.RoundTrip(newPost(t, ctx, "{}"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry kept waiting after the request was cancelled")
+	}
+}
+
+func TestRetryTransportBudget(t *testing.T) {
+	saved := retryBudget
+	t.Cleanup(func() { retryBudget = saved })
+	retryBudget = NewRetryBudget(1)
+	logOutput := log.Writer()
+	t.Cleanup(func() { log.SetOutput(logOutput) })
+	log.SetOutput(io.Discard)
+
+	base := &fakeTransport{respond: statuses(503)}
+	rt := &retryTransport{next: base, policies: UniformRetries(5, time.Millisecond), timeout: time.Minute}
+	resp, err := rt.RoundTrip(newPost(t, context.Background(), "{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if base.attempts() != 2 {
+		t.Errorf("%d attempts, want one retry from the budget", base.attempts())
+	}
+}
+
+func TestMetricsTransport(t *testing.T) {
+	base := &fakeTransport{respond: func(n int, req *http.Request) (*http.Response, error) {
+		switch n {
+		case 1:
+			return nil, errors.New("connection refused")
+		case 2:
+			return nil, &TimeoutError{Phase: PhaseConnect, Host: req.URL.Host, Limit: time.Second}
+		case 3:
+			return fakeResponse(req, http.StatusTooManyRequests, "{}"), nil
+		}
+		return fakeResponse(req, http.StatusOK, "{}"), nil
+	}}
+	m := &HTTPMetrics{}
+	rt := &metricsTransport{next: base, metrics: m}
+	for i := 0; i < 5; i++ {
+		if resp, err := rt.RoundTrip(newPost(t, context.Background(), "{}")); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	attempts, failed, codes := m.Snapshot()
+	if attempts != 5 || failed != 2 || codes[200] != 2 || codes[429] != 1 {
+		t.Errorf("Snapshot() = %d, %d, %v; want 5 attempts, 2 failed, 2 200s and a 429", attempts, failed, codes)
+	}
+	if got := m.Timeouts(); len(got) != 1 || got[PhaseConnect] != 1 {
+		t.Errorf("Timeouts() = %v, want one connect timeout", got)
+	}
+}
+
+func TestDumpTransport(t *testing.T) {
+	base := &fakeTransport{respond: func(n int, req *http.Request) (*http.Response, error) {
+		return fakeResponse(req, http.StatusOK, `{"message":"ok"}`), nil
+	}}
+	var out bytes.Buffer
+	rt := &dumpTransport{next: base, log: log.New(&out, "", 0)}
+	req := newPost(t, context.Background(), `{"query":"Are passwords hashed?"}`)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Github-Token", "ghp_secret")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	dump := out.String()
+	if strings.Contains(dump, "secret") {
+		t.Errorf("dump shows credentials:\n%s", dump)
+	}
+	for _, want := range []string{"Authorization: REDACTED", "X-Github-Token: REDACTED", `{"query":"Are passwords hashed?"}`, "200 OK", `{"message":"ok"}`} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump lacks %q:\n%s", want, dump)
+		}
+	}
+	if string(data) != `{"message":"ok"}` {
+		t.Errorf("response body after the dump = %q", data)
+	}
+	if got := base.requests[0].Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization sent as %q", got)
+	}
+	if base.bodies[0] != `{"query":"Are passwords hashed?"}` {
+		t.Errorf("body sent as %q", base.bodies[0])
+	}
+}
+
+func TestNewBackendClientChain(t *testing.T) {
+	base := &fakeTransport{respond: statuses(502, 200)}
+	m := &HTTPMetrics{}
+	client := NewBackendClient(
+		WithAuth("Authorization", "Bearer secret"),
+		WithCorrelation("run-1"),
+		WithRetry(UniformRetries(1, time.Millisecond)),
+		WithMetrics(m),
+		WithTransport(base),
+	)
+	resp, err := client.Do(newPost(t, context.Background(), "{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if base.attempts() != 2 {
+		t.Fatalf("%d attempts, want 2", base.attempts())
+	}
+	// Retries keep the request's ID and credentials, and metrics see each
+	// attempt.
+	first, second := base.requests[0].Header, base.requests[1].Header
+	if first.Get("X-Request-ID") == "" || first.Get("X-Request-ID") != second.Get("X-Request-ID") {
+		t.Errorf("attempts sent X-Request-ID %q and %q, want the same", first.Get("X-Request-ID"), second.Get("X-Request-ID"))
+	}
+	if second.Get("Authorization") != "Bearer secret" || second.Get("Accept-Encoding") != "gzip" {
+		t.Errorf("retry headers = %v", second)
+	}
+	if attempts, _, codes := m.Snapshot(); attempts != 2 || codes[502] != 1 || codes[200] != 1 {
+		t.Errorf("metrics saw %d attempts with %v", attempts, codes)
+	}
+}
+
+func TestNewBackendClientRateLimitsRetries(t *testing.T) {
+	base := &fakeTransport{respond: statuses(429, 503, 200)}
+	// Three tokens and no refill to speak of: each attempt takes one.
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 3)
+	client := NewBackendClient(
+		WithRateLimit(limiter),
+		WithRetry(UniformRetries(3, time.Millisecond)),
+		WithTransport(base),
+	)
+	resp, err := client.Do(newPost(t, context.Background(), "{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if base.attempts() != 3 || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d attempts ending in %d, want 3 ending in 200", base.attempts(), resp.StatusCode)
+	}
+	if acquired := 3 - math.Round(limiter.Tokens()); acquired != 3 {
+		t.Errorf("limiter admitted %g attempts, want all 3", acquired)
+	}
+
+	// With the tokens spent, a retry waits for the limiter like a new
+	// request and gives up with the request's context.
+	base = &fakeTransport{respond: statuses(429, 200)}
+	limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	client = NewBackendClient(WithRateLimit(limiter), WithRetry(UniformRetries(1, time.Millisecond)), WithTransport(base))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if resp, err := client.Do(newPost(t, ctx, "{}")); err == nil {
+		resp.Body.Close()
+		t.Error("retry wasn't held back by the limiter")
+	}
+	if n := base.attempts(); n != 1 {
+		t.Errorf("%d attempts, want the retry held back", n)
+	}
+}
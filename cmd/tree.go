@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// treeResultWidth is how much of a result's first line the tree shows.
+const treeResultWidth = 100
+
+type treeNode struct {
+	label    string
+	children []*treeNode
+	// empty counts prompts under an audit node that returned nothing; they
+	// are collapsed into one line.
+	empty int
+}
+
+// child returns the node's child with label, adding it if there is none.
+func (n *treeNode) child(label string) *treeNode {
+	for _, c := range n.children {
+		if c.label == label {
+			return c
+		}
+	}
+	c := &treeNode{label: label}
+	n.children = append(n.children, c)
+	return c
+}
+
+// WriteTree renders the report as codebase, audit, prompt and results, in
+// the report's order, with each result's severity. Prompts that succeeded
+// without a result are counted on one line per audit instead of listed.
+func WriteTree(w io.Writer, r *Report) {
+	root := &treeNode{}
+	for _, f := range r.Findings {
+		audit := root.child(f.Codebase).child(f.Audit)
+		if !f.HasResult() && f.Error == "" {
+			audit.empty++
+			continue
+		}
+		prompt := audit.child(f.Prompt)
+		prompt.children = append(prompt.children, &treeNode{label: fmt.Sprintf("[%s] %s", f.Severity, treeResult(f))})
+	}
+	for _, s := range r.Skipped {
+		audit := root.child(s.Codebase).child(s.Audit)
+		parent := audit
+		if s.Prompt != "" {
+			parent = audit.child(s.Prompt)
+		}
+		parent.children = append(parent.children, &treeNode{label: "skipped: " + s.Reason})
+	}
+	for _, cb := range root.children {
+		fmt.Fprintln(w, cb.label)
+		writeTreeChildren(w, cb, "")
+	}
+}
+
+func writeTreeChildren(w io.Writer, n *treeNode, indent string) {
+	children := n.children
+	if n.empty > 0 {
+		label := fmt.Sprintf("%d prompts with no results", n.empty)
+		if n.empty == 1 {
+			label = "1 prompt with no results"
+		}
+		children = append(children[:len(children):len(children)], &treeNode{label: label})
+	}
+	for i, c := range children {
+		branch, next := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Fprintln(w, indent+branch+c.label)
+		writeTreeChildren(w, c, indent+next)
+	}
+}
+
+// treeResult summarizes a finding as the first line of its result or its
+// error, noting how much was left out.
+func treeResult(f Finding) string {
+	if f.Error != "" {
+		return "error: " + f.Error
+	}
+	var lines []string
+	for _, line := range strings.Split(f.Result, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	summary := lines[0]
+	if runes := []rune(summary); len(runes) > treeResultWidth {
+		summary = string(runes[:treeResultWidth-3]) + "..."
+	}
+	if len(lines) > 1 {
+		summary += fmt.Sprintf(" (+%d lines)", len(lines)-1)
+	}
+	if f.Suppressed {
+		summary += " (suppressed)"
+	}
+	for _, note := range f.Notes {
+		summary += " (note: " + note + ")"
+	}
+	return summary
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// buildSearchURL appends path segments to base and sets the query parameters
+// in query, replacing any of the same name already in base. Each segment is
+// escaped whole, so a "/" inside one can't add a path level; an empty last
+// segment ends the path with a slash. A trailing slash on base is ignored.
+func buildSearchURL(base string, query url.Values, segments ...string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", base)
+	}
+	escaped := strings.TrimSuffix(u.EscapedPath(), "/")
+	for _, s := range segments {
+		escaped += "/" + url.PathEscape(s)
+	}
+	if u.Path, err = url.PathUnescape(escaped); err != nil {
+		return "", err
+	}
+	u.RawPath = escaped
+	if len(query) > 0 {
+		q := u.Query()
+		for name, values := range query {
+			q[name] = values
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
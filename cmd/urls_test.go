@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestBuildSearchURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		query    url.Values
+		segments []string
+		want     string
+	}{
+		{"segments", "https://api.example.com", nil, []string{"orgs", "acme", "repos"}, "https://api.example.com/orgs/acme/repos"},
+		{"base path", "https://example.com/api", nil, []string{"v2", "scan"}, "https://example.com/api/v2/scan"},
+		{"trailing slash on base", "https://example.com/api/", nil, []string{"chat", "completions"}, "https://example.com/api/chat/completions"},
+		{"trailing slash on host", "https://example.com/", nil, []string{".api", "graphql"}, "https://example.com/.api/graphql"},
+		{"empty last segment", "https://dojo.example.com", nil, []string{"api", "v2", "reimport-scan", ""}, "https://dojo.example.com/api/v2/reimport-scan/"},
+		{"empty last segment after trailing slash", "https://dojo.example.com/", nil, []string{"api", ""}, "https://dojo.example.com/api/"},
+		{"no segments", "https://example.com/api/", nil, nil, "https://example.com/api"},
+		{"slash in segment", "https://api.example.com", nil, []string{"orgs", "a/b", "repos"}, "https://api.example.com/orgs/a%2Fb/repos"},
+		{"space in segment", "https://api.example.com", nil, []string{"orgs", "my org"}, "https://api.example.com/orgs/my%20org"},
+		{"query and fragment characters in segment", "https://api.example.com", nil, []string{"a?b#c"}, "https://api.example.com/a%3Fb%23c"},
+		{"percent in segment", "https://api.example.com", nil, []string{"100%"}, "https://api.example.com/100%25"},
+		{"dot segments stay literal", "https://api.example.com/x", nil, []string{"..", "y"}, "https://api.example.com/x/../y"},
+		{"non-ASCII segment", "https://api.example.com", nil, []string{"müller"}, "https://api.example.com/m%C3%BCller"},
+		{"escaped base path kept", "https://example.com/a%2Fb", nil, []string{"c"}, "https://example.com/a%2Fb/c"},
+		{"port and user kept", "http://user@127.0.0.1:8080/base", nil, []string{"x"}, "http://user@127.0.0.1:8080/base/x"},
+		{"query", "https://api.example.com", url.Values{"per_page": {"100"}, "type": {"all"}}, []string{"orgs", "acme", "repos"}, "https://api.example.com/orgs/acme/repos?per_page=100&type=all"},
+		{"query replaces base parameter", "https://api.example.com/x?per_page=10&keep=1", url.Values{"per_page": {"100"}}, nil, "https://api.example.com/x?keep=1&per_page=100"},
+		{"query special characters", "https://api.example.com", url.Values{"q": {"a b&c=d"}}, []string{"search"}, "https://api.example.com/search?q=a+b%26c%3Dd"},
+		{"base query kept without query", "https://api.example.com/x?token=a%2Bb", nil, []string{"y"}, "https://api.example.com/x/y?token=a%2Bb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildSearchURL(tt.base, tt.query, tt.segments...)
+			if err != nil {
+				t.Fatalf("buildSearchURL(%q, %v, %q): %v", tt.base, tt.query, tt.segments, err)
+			}
+			if got != tt.want {
+				t.Errorf("buildSearchURL(%q, %v, %q) = %q, want %q", tt.base, tt.query, tt.segments, got, tt.want)
+			}
+			if _, err := url.Parse(got); err != nil {
+				t.Errorf("result %q doesn't parse: %v", got, err)
+			}
+		})
+	}
+}
+
+func TestBuildSearchURLErrors(t *testing.T) {
+	for _, base := range []string{"", "not a url", "/relative/path", "example.com/api", "https://", "http://[::1"} {
+		if got, err := buildSearchURL(base, nil, "x"); err == nil {
+			t.Errorf("buildSearchURL(%q) = %q, want an error", base, got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Names of the machine-wide defaults in the user configuration directory.
+const (
+	UserConfigFile    = "config.yaml"
+	UserPromptsSubdir = "prompts"
+)
+
+// UserConfigDir is where treeko looks for machine-wide defaults:
+// $XDG_CONFIG_HOME/treeko, or ~/.config/treeko when XDG_CONFIG_HOME isn't
+// set. It returns "" if neither can be determined.
+func UserConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "treeko")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "treeko")
+}
+
+// UserDefaults are the parts of the user configuration directory that
+// exist: a configuration file in the format of treeko.yaml and a directory
+// of prompt files. Repository settings take precedence over both.
+type UserDefaults struct {
+	// ConfigPath is config.yaml and Config its contents, if it exists.
+	ConfigPath string
+	Config     *Config
+	// PromptsDir is the prompts subdirectory, if it exists.
+	PromptsDir string
+}
+
+// LoadUserDefaults reads the user configuration directory dir. It returns
+// nil if dir holds neither a config.yaml nor a prompts directory.
+func LoadUserDefaults(dir string) (*UserDefaults, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	u := &UserDefaults{}
+	if path := filepath.Join(dir, UserConfigFile); fileExists(path) {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		u.ConfigPath, u.Config = path, cfg
+	}
+	if path := filepath.Join(dir, UserPromptsSubdir); dirExists(path) {
+		u.PromptsDir = path
+	}
+	if u.Config == nil && u.PromptsDir == "" {
+		return nil, nil
+	}
+	return u, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Beneath fills in what c leaves unset from the user configuration base.
+// The Sourcegraph URL comes from base only if c has none; plugins and filter
+// rules are added after c's unless c has one of the same name, hooks run
+// after c's, and audit switches, aliases and outputs apply to the names c
+// doesn't use.
+// Codebases belong to a repository, so base's are ignored.
+func (c *Config) Beneath(base *Config) {
+	if c.Sourcegraph.URL == "" {
+		c.Sourcegraph.URL = base.Sourcegraph.URL
+	}
+	plugins := make(map[string]bool)
+	for _, p := range c.Plugins {
+		plugins[p.Name] = true
+	}
+	for _, p := range base.Plugins {
+		if !plugins[p.Name] {
+			c.Plugins = append(c.Plugins, p)
+		}
+	}
+	rules := make(map[string]bool)
+	for _, r := range c.Filters {
+		if r.Name != "" {
+			rules[r.Name] = true
+		}
+	}
+	for _, r := range base.Filters {
+		if r.Name == "" || !rules[r.Name] {
+			c.Filters = append(c.Filters, r)
+		}
+	}
+	overrides := make(map[string]bool)
+	for _, o := range c.SeverityOverrides {
+		overrides[o.Name] = true
+	}
+	// The machine-wide overrides come first, so the project's win.
+	var merged []SeverityOverride
+	for _, o := range base.SeverityOverrides {
+		if !overrides[o.Name] {
+			merged = append(merged, o)
+		}
+	}
+	c.SeverityOverrides = append(merged, c.SeverityOverrides...)
+	for _, p := range base.Redact {
+		if !hasTag(c.Redact, p) {
+			c.Redact = append(c.Redact, p)
+		}
+	}
+	c.Hooks.PreRun = append(c.Hooks.PreRun, base.Hooks.PreRun...)
+	c.Hooks.PostRun = append(c.Hooks.PostRun, base.Hooks.PostRun...)
+	c.Hooks.OnFinding = append(c.Hooks.OnFinding, base.Hooks.OnFinding...)
+	for alias, id := range base.Aliases {
+		if _, ok := c.Aliases[alias]; ok {
+			continue
+		}
+		if c.Aliases == nil {
+			c.Aliases = make(map[string]string)
+		}
+		c.Aliases[alias] = id
+	}
+	for id, on := range base.Audits {
+		if _, ok := c.Audits[id]; ok {
+			continue
+		}
+		if c.Audits == nil {
+			c.Audits = make(map[string]bool)
+		}
+		c.Audits[id] = on
+	}
+	for name, o := range base.Outputs {
+		if _, ok := c.Outputs[name]; ok {
+			continue
+		}
+		if c.Outputs == nil {
+			c.Outputs = make(map[string]OutputConfig)
+		}
+		c.Outputs[name] = o
+	}
+}
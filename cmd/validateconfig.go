@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// configProblems collects what treeko validate-config finds wrong, so one
+// pass reports every broken file rather than the first.
+type configProblems []string
+
+func (p *configProblems) add(err error) {
+	*p = append(*p, locateProblem(err.Error()))
+}
+
+// quotedName is the last 'name' an error message quotes, usually the ID
+// the problem is about.
+var quotedName = regexp.MustCompile(`'([^']+)'[^']*$`)
+
+// locateProblem adds the line to a "path: message" error that names
+// something quoted, by finding the first line of the file mentioning it.
+// Messages that already carry a line, such as YAML syntax errors, are left
+// alone.
+func locateProblem(msg string) string {
+	path, rest, ok := strings.Cut(msg, ": ")
+	if !ok || strings.Contains(rest, "line ") || !fileExists(path) {
+		return msg
+	}
+	m := quotedName.FindStringSubmatch(rest)
+	if m == nil {
+		return msg
+	}
+	if n := lineOf(path, m[1]); n > 0 {
+		return fmt.Sprintf("%s:%d: %s", path, n, rest)
+	}
+	return msg
+}
+
+// lineOf is the number of the first line of path containing s, or 0.
+func lineOf(path, s string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if strings.Contains(scanner.Text(), s) {
+			return n
+		}
+	}
+	return 0
+}
+
+// loadPromptsChecked merges the prompt files of dir into audits the way a
+// run does, but reports a prompt defined twice as a problem instead of
+// keeping the first definition. It returns the merged audits and the number
+// of files read.
+func loadPromptsChecked(audits []Audit, dir string, recursive bool, problems *configProblems) ([]Audit, int) {
+	files, err := PromptFilesInDir(dir, recursive)
+	if err != nil {
+		problems.add(err)
+		return audits, 0
+	}
+	for _, path := range files {
+		extra, skipped, err := LoadPromptFile(path)
+		if err != nil {
+			problems.add(err)
+			continue
+		}
+		for _, err := range skipped {
+			problems.add(err)
+		}
+		for i := range extra {
+			a := &extra[i]
+			existing := findAudit(audits, a.ID)
+			var kept []Prompt
+			for _, p := range a.Prompts {
+				if (existing != nil && a.Merge != MergeReplace && hasPrompt(existing.Prompts, p)) || hasPrompt(kept, p) {
+					problems.add(fmt.Errorf("%s: audit '%s' defines prompt '%s' again", path, a.ID, p.ID))
+					continue
+				}
+				kept = append(kept, p)
+			}
+			a.Prompts = kept
+		}
+		audits = MergeAudits(audits, path, extra)
+	}
+	return audits, len(files)
+}
+
+// runValidateConfigCommand loads the configuration, prompt files, policy and
+// templates a run would use and reports every problem, without touching the
+// network. It exits 0 when everything is valid and 1 otherwise.
+func runValidateConfigCommand(args []string) int {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to a treeko.yaml configuration file")
+	promptsDir := flags.String("prompts-dir", "", "Directory of custom prompt files")
+	promptsRecursive := flags.Bool("prompts-recursive", false, "Also load prompt files in subdirectories of -prompts-dir")
+	noUserConfig := flags.Bool("no-user-config", false, "Ignore the machine-wide config.yaml and prompts in $XDG_CONFIG_HOME/treeko (default ~/.config/treeko)")
+	policyPath := flags.String("policy", "", "Policy file to check")
+	notesPath := flags.String("notes", "", "Notes file to check")
+	reportTemplate := flags.String("report-template", "", "Report template to check")
+	openAISystem := flags.String("openai-system-prompt", "", "System prompt template to check")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: treeko validate-config [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	var problems configProblems
+	var loaded []string
+	// Flags given on the command line take precedence over the .treeko
+	// file, as in a run.
+	if path := FindDotFile("."); path != "" {
+		dot, err := LoadDotFile(path, nil)
+		if err == nil {
+			err = dot.Apply(flags)
+		}
+		if err != nil {
+			problems.add(err)
+		} else {
+			loaded = append(loaded, path)
+		}
+	}
+
+	audits := append([]Audit(nil), builtinAudits...)
+	if err := CompileLocalChecks(audits); err != nil {
+		problems.add(fmt.Errorf("built-in audits: %v", err))
+	}
+	promptFiles := 0
+	if *promptsDir != "" {
+		var n int
+		audits, n = loadPromptsChecked(audits, *promptsDir, *promptsRecursive, &problems)
+		promptFiles += n
+	}
+	var user *UserDefaults
+	if !*noUserConfig {
+		var err error
+		if user, err = LoadUserDefaults(UserConfigDir()); err != nil {
+			problems.add(err)
+		}
+	}
+	if user != nil && user.PromptsDir != "" {
+		var n int
+		audits, n = loadPromptsChecked(audits, user.PromptsDir, true, &problems)
+		promptFiles += n
+	}
+
+	var cfg *Config
+	if *configPath != "" {
+		var err error
+		if cfg, err = LoadConfig(*configPath); err == nil {
+			err = cfg.Validate(*configPath, audits)
+		}
+		if err != nil {
+			problems.add(err)
+			cfg = nil
+		} else {
+			loaded = append(loaded, *configPath)
+		}
+	}
+	if user != nil && user.Config != nil {
+		if err := user.Config.Validate(user.ConfigPath, audits); err != nil {
+			problems.add(err)
+		} else {
+			if cfg == nil {
+				cfg = &Config{}
+			}
+			cfg.Beneath(user.Config)
+			loaded = append(loaded, user.ConfigPath)
+		}
+	}
+	if cfg != nil {
+		if err := cfg.ResolveAliases(cfg.Codebases); err != nil {
+			problems.add(fmt.Errorf("%s: %v", *configPath, err))
+		}
+		// Commands run directly, so one that isn't installed fails the run.
+		for _, p := range cfg.Plugins {
+			if _, err := exec.LookPath(p.Command); err != nil {
+				problems.add(fmt.Errorf("plugin '%s': %v", p.Name, err))
+			}
+		}
+		for _, hooks := range [][]HookConfig{cfg.Hooks.PreRun, cfg.Hooks.PostRun, cfg.Hooks.OnFinding} {
+			for _, h := range hooks {
+				if _, err := exec.LookPath(h.Command); err != nil {
+					problems.add(fmt.Errorf("hook: %v", err))
+				}
+			}
+		}
+	}
+
+	var policy *Policy
+	if *policyPath != "" {
+		var err error
+		if policy, err = LoadPolicy(*policyPath, audits); err != nil {
+			problems.add(err)
+		} else {
+			policy.Evaluate(nil)
+			loaded = append(loaded, *policyPath)
+		}
+	}
+	var notes *NoteRules
+	if *notesPath != "" {
+		var err error
+		if notes, err = LoadNoteRules(*notesPath, audits); err != nil {
+			problems.add(err)
+		} else {
+			loaded = append(loaded, *notesPath)
+		}
+	}
+	if *reportTemplate != "" {
+		tmpl, err := LoadReportTemplate(*reportTemplate)
+		if err == nil {
+			// An empty report catches fields and functions the template
+			// misspells, which parsing alone doesn't.
+			err = tmpl.Execute(io.Discard, NewReport(RunMetadata{}))
+		}
+		if err != nil {
+			problems.add(fmt.Errorf("%s: %v", *reportTemplate, err))
+		} else {
+			loaded = append(loaded, *reportTemplate)
+		}
+	}
+	if *openAISystem != "" {
+		if _, err := LoadSystemPrompt(*openAISystem); err != nil {
+			problems.add(fmt.Errorf("%s: %v", *openAISystem, err))
+		} else {
+			loaded = append(loaded, *openAISystem)
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		fmt.Fprintf(os.Stderr, "Invalid: %d problem(s)\n", len(problems))
+		return 1
+	}
+	prompts, checks := 0, 0
+	for _, a := range audits {
+		prompts += len(a.Prompts)
+		checks += len(a.LocalChecks)
+	}
+	fmt.Println("OK")
+	if len(loaded) > 0 {
+		fmt.Printf("  Files:     %s\n", strings.Join(loaded, ", "))
+	}
+	fmt.Printf("  Audits:    %d, with %d prompts and %d local checks (%d prompt files)\n", len(audits), prompts, checks, promptFiles)
+	if cfg != nil {
+		fmt.Printf("  Config:    %d codebases, %d aliases, %d plugins, %d filter rules, %d severity overrides, %d redact patterns\n", len(cfg.Codebases), len(cfg.Aliases), len(cfg.Plugins), len(cfg.Filters), len(cfg.SeverityOverrides), len(cfg.Redact))
+	}
+	if policy != nil {
+		fmt.Printf("  Policy:    %d rules\n", len(policy.Rules))
+	}
+	if notes != nil {
+		fmt.Printf("  Notes:     %d rules\n", len(notes.Rules))
+	}
+	return ExitOK
+}
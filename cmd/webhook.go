@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook payloads.
+const (
+	WebhookReport  = "report"
+	WebhookSummary = "summary"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body, hex encoded
+// with a "sha256=" prefix, when -webhook-secret is set.
+const SignatureHeader = "X-Treeko-Signature"
+
+// webhookBackoff is the wait before the first retry; it doubles after each
+// failed attempt.
+var webhookBackoff = time.Second
+
+// headerFlags collects repeated "Name: value" flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return fmt.Errorf("expected 'Name: value', got '%s'", s)
+	}
+	*h = append(*h, s)
+	return nil
+}
+
+func (h headerFlags) header() http.Header {
+	header := make(http.Header)
+	for _, s := range h {
+		i := strings.Index(s, ":")
+		header.Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	}
+	return header
+}
+
+// Webhook posts the report, or just its summary, to an arbitrary URL once
+// the run completes.
+type Webhook struct {
+	URL     string
+	Header  http.Header
+	Secret  string
+	Payload string
+	// Attempts is how many times delivery is tried in total.
+	Attempts int
+}
+
+// webhookSummaryPayload is the body sent with -webhook-payload summary.
+type webhookSummaryPayload struct {
+	SchemaVersion string           `json:"schemaVersion"`
+	Metadata      RunMetadata      `json:"metadata"`
+	Summary       Summary          `json:"summary"`
+	Codebases     []CodebaseResult `json:"codebases"`
+	Policy        *PolicyResult    `json:"policy,omitempty"`
+}
+
+// Send delivers r, retrying network errors, 429 and 5xx responses with
+// exponential backoff. Other responses are not retried.
+func (h *Webhook) Send(r *Report) error {
+	var payload interface{} = r
+	if h.Payload == WebhookSummary {
+		payload = webhookSummaryPayload{
+			SchemaVersion: r.SchemaVersion,
+			Metadata:      r.Metadata,
+			Summary:       r.Summary,
+			Codebases:     r.Codebases,
+			Policy:        r.Policy,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	wait := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.Attempts {
+			return fmt.Errorf("webhook %s: %v", h.URL, err)
+		}
+		debugf("webhook attempt %d failed: %v; retrying in %s", attempt, err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (h *Webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range h.Header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+	return false, nil
+}
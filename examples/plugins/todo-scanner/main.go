@@ -0,0 +1,88 @@
+// Command todo-scanner is an example treeko plugin. It reports comments
+// marked TODO(security) or FIXME(security) in the local checkout.
+//
+// treeko writes the run context to stdin as JSON and reads findings from
+// stdout, one JSON object per line. Diagnostics go to stderr, which treeko
+// shows with -debug.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type runContext struct {
+	Codebase string `json:"codebase"`
+	RepoRoot string `json:"repoRoot"`
+}
+
+type location struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+}
+
+type finding struct {
+	Check     string     `json:"check"`
+	Title     string     `json:"title"`
+	Severity  string     `json:"severity"`
+	Message   string     `json:"message"`
+	Locations []location `json:"locations"`
+}
+
+var marker = regexp.MustCompile(`\b(TODO|FIXME)\(security\):?\s*(.*)`)
+
+func main() {
+	var ctx runContext
+	if err := json.NewDecoder(os.Stdin).Decode(&ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "reading run context: %v\n", err)
+		os.Exit(1)
+	}
+	if ctx.RepoRoot == "" {
+		fmt.Fprintln(os.Stderr, "no repoRoot in run context; nothing to scan")
+		return
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	err := filepath.WalkDir(ctx.RepoRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == "node_modules" || d.Name() == "vendor" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		rel, _ := filepath.Rel(ctx.RepoRoot, path)
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			m := marker.FindStringSubmatch(scanner.Text())
+			if m == nil {
+				continue
+			}
+			out.Encode(finding{
+				Check:     "security-todo",
+				Title:     "Outstanding security TODO",
+				Severity:  "low",
+				Message:   strings.TrimSpace(m[2]),
+				Locations: []location{{Path: filepath.ToSlash(rel), Line: n}},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scanning %s: %v\n", ctx.RepoRoot, err)
+		os.Exit(1)
+	}
+}